@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqlivemock/internal/dial"
+	"github.com/Eyevinn/moqlivemock/internal/load"
+	"github.com/Eyevinn/moqtransport"
+)
+
+const (
+	appName = "mlmload"
+)
+
+var usg = `%s simulates many MoQ subscribers in one process, to measure the capacity
+of a publisher (mlmpub) or relay. It opens -clients sessions, started evenly
+over -rampup, each subscribing to the tracks of a mix. Payloads are discarded.
+Progress is logged every -interval seconds, and when the test ends, the
+aggregate throughput, latency percentiles per track and failure counts are
+logged and written to -report.
+
+A mix is a list of subscriptions separated by ';', each ns=namespace,track=regexp
+subscribing to all tracks of the namespace matching the regular expression,
+optionally prefixed by a weight N*. Clients are assigned to the mixes in
+proportion to their weights, e.g.
+
+mlmload -clients 200 -rampup 20s -duration 60 \
+  -mix '3*ns=cmsf/clear,track=^video_400kbps_avc$;ns=cmsf/clear,track=^audio_monotonic_128kbps_aac$' \
+  -mix 'ns=msf/clear,track=^video_400kbps_avc$'
+
+Usage of %s:
+`
+
+// mixFlag is the repeatable -mix flag.
+type mixFlag []load.Mix
+
+func (f *mixFlag) String() string {
+	return fmt.Sprintf("%d mixes", len(*f))
+}
+
+func (f *mixFlag) Set(value string) error {
+	m, err := load.ParseMix(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, m)
+	return nil
+}
+
+type options struct {
+	addr        string
+	clients     int
+	rampUp      time.Duration
+	duration    int
+	mixes       mixFlag
+	draft       int
+	catalogMode string
+	interval    int
+	report      string
+	loglevel    string
+	version     bool
+}
+
+func parseOptions(fs *flag.FlagSet, args []string) (*options, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, usg, appName, appName)
+		fmt.Fprintf(os.Stderr, "%s [options]\n\noptions:\n", appName)
+		fs.PrintDefaults()
+	}
+
+	opts := options{}
+	fs.StringVar(&opts.addr, "addr", "localhost:4443", "connect address (use https:// for WebTransport)")
+	fs.IntVar(&opts.clients, "clients", 10, "Number of client sessions")
+	fs.DurationVar(&opts.rampUp, "rampup", 10*time.Second, "Time over which the clients are started")
+	fs.IntVar(&opts.duration, "duration", 0, "Duration of the test in seconds (0 means until interrupted)")
+	fs.Var(&opts.mixes, "mix", "Subscriptions of a client: [weight*]ns=namespace,track=regexp;... Can be repeated "+
+		"(default \""+load.DefaultMix+"\")")
+	fs.IntVar(&opts.draft, "draft", 14, "MoQ Transport draft version (14 or 16)")
+	fs.StringVar(&opts.catalogMode, "catalog-mode", "joining",
+		"Catalog retrieval: 'joining' (default), 'subscribe', or 'fetch'")
+	fs.IntVar(&opts.interval, "interval", 10, "Interval in seconds between progress logs (0 disables)")
+	fs.StringVar(&opts.report, "report", "", "Output file for the final JSON report or stdout (-)")
+	fs.StringVar(&opts.loglevel, "loglevel", "warning", "Log level of the clients: debug, info, warning, error")
+	fs.BoolVar(&opts.version, "version", false, fmt.Sprintf("Get %s version", appName))
+
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return &opts, err
+	}
+	if len(opts.mixes) == 0 {
+		err = opts.mixes.Set(load.DefaultMix)
+	}
+	return &opts, err
+}
+
+func main() {
+	fs := flag.NewFlagSet(appName, flag.ContinueOnError)
+	opts, err := parseOptions(fs, os.Args)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "Error parsing options: %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	if err := run(opts); err != nil {
+		slog.Error("error running application", "error", err)
+		os.Exit(1)
+	}
+}
+
+// parseLogLevel converts a string log level to slog.Level
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warning", "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown log level: %s, using 'warning'\n", level)
+		return slog.LevelWarn
+	}
+}
+
+func run(opts *options) error {
+	if opts.version {
+		fmt.Printf("%s %s\n", appName, internal.GetVersion())
+		return nil
+	}
+
+	// The clients log at -loglevel, the progress and report always.
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: parseLogLevel(opts.loglevel),
+	})))
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if opts.duration > 0 {
+		tctx, tcancel := context.WithTimeout(ctx, time.Duration(opts.duration)*time.Second)
+		defer tcancel()
+		ctx = tctx
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Fprintf(os.Stderr, "\nReceived signal, shutting down...\n")
+		cancel()
+	}()
+
+	alpn := "moq-00"
+	if opts.draft == 16 {
+		alpn = "moqt-16"
+	}
+	tester, err := load.NewTester(load.Config{
+		Clients: opts.clients,
+		RampUp:  opts.rampUp,
+		Mixes:   opts.mixes,
+		Dial: func(ctx context.Context) (moqtransport.Connection, error) {
+			return dial.Dial(ctx, opts.addr, alpn)
+		},
+		Protocols:   []string{alpn},
+		CatalogMode: opts.catalogMode,
+		Logger:      logger,
+	})
+	if err != nil {
+		return err
+	}
+	if opts.interval > 0 {
+		go logProgress(ctx, tester, time.Duration(opts.interval)*time.Second)
+	}
+	logger.Info("starting load test", "addr", opts.addr, "clients", opts.clients,
+		"rampup", opts.rampUp, "mixes", len(opts.mixes))
+	report := tester.Run(ctx)
+	report.Log(logger)
+	return writeReport(report, opts.report)
+}
+
+// logProgress logs the progress of tester every interval until ctx is done.
+func logProgress(ctx context.Context, tester *load.Tester, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tester.LogProgress()
+		}
+	}
+}
+
+// writeReport writes the report as JSON to out, or to stdout if out is "-".
+func writeReport(r load.Report, out string) error {
+	switch out {
+	case "":
+		return nil
+	case "-":
+		return r.WriteJSON(os.Stdout)
+	}
+	fh, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return r.WriteJSON(fh)
+}
@@ -6,7 +6,6 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -25,10 +24,12 @@ type server struct {
 	tlsConfig *tls.Config
 	handler   *pub.Handler
 	sidePort  int
+	admin     bool
+	clearKey  *pub.ClearKeyServer
 }
 
 func (s *server) runServer(ctx context.Context) error {
-	// Start HTTP side server for /fingerprint and /clearkey
+	// Start HTTP side server for /fingerprint, /clearkey, /clearkey/token, /metrics and /admin/
 	if s.sidePort > 0 {
 		go s.startSideServer()
 	}
@@ -107,7 +108,9 @@ func (s *server) startSideServer() {
 		return func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "*")
+			// The "*" wildcard does not cover Authorization, which the
+			// ClearKey license requests carry when a token is configured.
+			w.Header().Set("Access-Control-Allow-Headers", "*, Authorization")
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -127,52 +130,16 @@ func (s *server) startSideServer() {
 		slog.Debug("Served fingerprint", "fingerprint", fingerprint)
 	}))
 
-	mux.HandleFunc("/clearkey", withCORS(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		var req struct {
-			Kids []string `json:"kids"`
-		}
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil {
-			http.Error(w, "failed to decode request body", http.StatusBadRequest)
-			return
-		}
-
-		type keyInfo struct {
-			Kty string `json:"kty"`
-			K   string `json:"k"`
-			Kid string `json:"kid"`
-		}
-		type clearKeyResponse struct {
-			Keys []keyInfo `json:"keys"`
-			Type string    `json:"type"`
-		}
-
-		var keys []keyInfo
-		for _, kid := range req.Kids {
-			keys = append(keys, keyInfo{
-				Kty: "oct",
-				K:   kid,
-				Kid: kid,
-			})
-		}
+	mux.HandleFunc("/clearkey", withCORS(s.clearKey.ServeHTTP))
+	mux.HandleFunc("/clearkey/token", withCORS(s.clearKey.TokenHandler().ServeHTTP))
 
-		response := clearKeyResponse{
-			Keys: keys,
-			Type: "temporary",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(response)
-		if err != nil {
-			slog.Error("failed to encode ClearKey response", "error", err)
-			return
-		}
-		slog.Info("Served ClearKey license")
-	}))
+	if s.handler.Metrics != nil {
+		mux.Handle("/metrics", s.handler.Metrics)
+	}
+	if s.admin {
+		mux.Handle("/admin/", s.handler.AdminHandler())
+		slog.Warn("admin API enabled without authentication", "path", "/admin/")
+	}
 
 	addr := fmt.Sprintf(":%d", s.sidePort)
 	slog.Info("Starting HTTP side server", "addr", addr)
@@ -2,22 +2,12 @@ package main
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/sha256"
-	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/hex"
-	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
-	"math/big"
-	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -25,7 +15,9 @@ import (
 	"time"
 
 	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqlivemock/internal/cc608"
 	"github.com/Eyevinn/moqlivemock/internal/pub"
+	"github.com/Eyevinn/moqlivemock/internal/tlsconf"
 )
 
 const (
@@ -35,6 +27,8 @@ const (
 var usg = `%s acts as a MoQ server and publisher using MSF/CMSF to send
 mocked live video and audio tracks, synchronized with wall-clock time.
 It is intended to be a test-bed for MoQ and MSF/CMSF.
+With -relay, it instead connects to a relay, announces its namespaces there
+and serves the subscriptions forwarded by the relay.
 
 The qlog logs are currently massive, and written to
 
@@ -43,13 +37,63 @@ Usage of %s:
 
 const (
 	defaultQlogFileName = "mlmpub.log"
+	defaultAsset        = "../../assets/test10s"
 )
 
+// assetSpec is an asset to serve. The namespaces of a named asset end with
+// /name.
+type assetSpec struct {
+	name string
+	path string
+}
+
+// assetFlag is the repeatable -asset flag with values path or name=path.
+type assetFlag []assetSpec
+
+func (f *assetFlag) String() string {
+	parts := make([]string, 0, len(*f))
+	for _, a := range *f {
+		if a.name == "" {
+			parts = append(parts, a.path)
+			continue
+		}
+		parts = append(parts, a.name+"="+a.path)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *assetFlag) Set(value string) error {
+	spec := assetSpec{path: value}
+	if name, path, ok := strings.Cut(value, "="); ok {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("bad asset name %q", name)
+		}
+		spec = assetSpec{name: name, path: path}
+	}
+	*f = append(*f, spec)
+	return nil
+}
+
+// validate checks that several assets all have distinct names.
+func (f assetFlag) validate() error {
+	names := make(map[string]bool)
+	for _, a := range f {
+		if len(f) > 1 && a.name == "" {
+			return fmt.Errorf("asset %s needs a name=path, since several assets are served", a.path)
+		}
+		if names[a.name] {
+			return fmt.Errorf("duplicate asset name %s", a.name)
+		}
+		names[a.name] = true
+	}
+	return nil
+}
+
 type options struct {
 	certFile         string
 	keyFile          string
 	addr             string
-	asset            string
+	assets           assetFlag
 	qlogfile         string
 	audioSampleBatch int
 	videoSampleBatch int
@@ -60,8 +104,31 @@ type options struct {
 	iv               string
 	kid              string
 	scheme           string
+	videoPattern     string
+	audioPattern     string
 	laURL            string
 	drmConfigPath    string
+	keyTiers         string
+	keyRotation      int
+	rotationKeys     int
+	licenseToken     string
+	authzURL         string
+	authzSecret      string
+	licenseDelay     time.Duration
+	licenseFailRate  float64
+	licenseFailCode  int
+	cc608            bool
+	cc608Lang        string
+	catalogUpdate    time.Duration
+	catalogFull      time.Duration
+	fetchWindow      time.Duration
+	groupCacheMB     int
+	faults           string
+	datagrams        string
+	relay            string
+	admin            bool
+	announceOnReq    bool
+	draft            int
 	version          bool
 }
 
@@ -76,25 +143,79 @@ func parseOptions(fs *flag.FlagSet, args []string) (*options, error) {
 	fs.StringVar(&opts.certFile, "cert", "cert.pem", "TLS certificate file (only used for server)")
 	fs.StringVar(&opts.keyFile, "key", "key.pem", "TLS key file (only used for server)")
 	fs.StringVar(&opts.addr, "addr", "0.0.0.0:4443", "listen or connect address")
-	fs.StringVar(&opts.asset, "asset", "../../assets/test10s", "Asset to serve")
+	fs.Var(&opts.assets, "asset", "Asset directory, or asset manifest (.json, .yaml), to serve "+
+		"(default "+defaultAsset+"). Repeat as name=path to serve several assets in namespaces ending with /name")
 	fs.StringVar(&opts.qlogfile, "qlog", defaultQlogFileName, "qlog file to write to. Use '-' for stderr")
 	fs.IntVar(&opts.audioSampleBatch, "audiobatch", 1, "Nr audio samples per MoQ object/CMAF chunk")
 	fs.IntVar(&opts.videoSampleBatch, "videobatch", 1, "Nr video samples per MoQ object/CMAF chunk")
-	fs.IntVar(&opts.sidePort, "sideport", 0, "Port for HTTP side server serving /fingerprint and /clearkey (0 to disable)")
+	fs.IntVar(&opts.sidePort, "sideport", 0, "Port for HTTP side server serving /fingerprint, /clearkey, "+
+		"/clearkey/token, /metrics and, with -admin, /admin/ (0 to disable)")
 	fs.StringVar(&opts.subsWvttLangs, "subswvtt", "sv", "Comma-separated WVTT subtitle languages (e.g. 'en,sv')")
 	fs.StringVar(&opts.subsStppLangs, "subsstpp", "en", "Comma-separated STPP subtitle languages (e.g. 'en,sv')")
 	fs.StringVar(&opts.kid, "kid", "", "key id for CENC encryption (32 hex or 24 base64 chars)")
 	fs.StringVar(&opts.iv, "iv", "", "IV for CENC encryption (16 or 32 hex chars)")
 	fs.StringVar(&opts.cencKey, "cenckey", "", "Key for CENC encryption (32 hex or 24 base64 chars),"+
 		"if no key is specified the key id will be used as the key.")
-	fs.StringVar(&opts.scheme, "scheme", "cbcs", "Scheme for CENC encryption,"+
-		"either \"cenc\" or \"cbcs\"")
+	fs.StringVar(&opts.scheme, "scheme", "cbcs", "Scheme for CENC encryption: "+
+		"\"cenc\", \"cbc1\", \"cens\" or \"cbcs\"")
+	fs.StringVar(&opts.videoPattern, "videopattern", "",
+		"crypt:skip block pattern of video for the cens and cbcs schemes (default 1:9)")
+	fs.StringVar(&opts.audioPattern, "audiopattern", "",
+		"crypt:skip block pattern of audio for the cens and cbcs schemes (default 0:0, full encryption)")
 	fs.StringVar(&opts.laURL, "laurl", "", "ClearKey/ECCP license acquisition URL announced in catalog."+
 		" Falls back to http://localhost:{sideport}/clearkey if not set.")
 	fs.StringVar(&opts.drmConfigPath, "drmpath", "", "path to a drm config file")
+	fs.StringVar(&opts.keyTiers, "keytiers", "",
+		"Comma-separated track types with separate ClearKey/ECCP keys, e.g. 'audio,sd,hd' "+
+			"(audio, video, sd, hd, uhd, uhd1, uhd2); empty for one key")
+	fs.IntVar(&opts.keyRotation, "keyrotation", 0,
+		"Number of MoQ groups per key period for rotating keys on protected tracks; 0 to disable")
+	fs.IntVar(&opts.rotationKeys, "rotationkeys", 4,
+		"Number of ClearKey/ECCP keys generated from -kid/-cenckey for -keyrotation (DRM uses the CPIX keys)")
+	fs.StringVar(&opts.licenseToken, "licensetoken", "",
+		"Bearer token required by the /clearkey license server; empty for none")
+	fs.StringVar(&opts.authzURL, "authzurl", "",
+		"ClearKey/ECCP authorization URL announced in catalog, where players get the license token."+
+			" Falls back to http://localhost:{sideport}/clearkey/token if -authzsecret is set.")
+	fs.StringVar(&opts.authzSecret, "authzsecret", "",
+		"Shared secret required as bearer token by /clearkey/token to serve the license token; empty disables it")
+	fs.DurationVar(&opts.licenseDelay, "licensedelay", 0, "Delay added to every /clearkey license response")
+	fs.Float64Var(&opts.licenseFailRate, "licensefailrate", 0,
+		"Fraction of /clearkey license requests that fail with -licensefailstatus")
+	fs.IntVar(&opts.licenseFailCode, "licensefailstatus", http.StatusInternalServerError,
+		"HTTP status of failing /clearkey license requests")
+	fs.BoolVar(&opts.cc608, "cc608", false, "Splice CTA-608 closed captions (SEI) into AVC/HEVC video tracks")
+	fs.StringVar(&opts.cc608Lang, "cc608lang", "eng", "CTA-608 caption language advertised in the catalog")
+	fs.DurationVar(&opts.catalogUpdate, "catalogupdate", 0,
+		"Interval between live catalog track changes (remove, add, clone, remove clone); 0 to disable")
+	fs.DurationVar(&opts.catalogFull, "catalogfull", 0,
+		"Interval between new catalog groups starting with a full catalog; 0 to disable")
+	fs.DurationVar(&opts.fetchWindow, "fetchwindow", pub.DefaultFetchWindow,
+		"How far back in time media FETCH can reach")
+	fs.IntVar(&opts.groupCacheMB, "groupcache", internal.DefaultGroupCacheBytes>>20,
+		"Memory in MB of the cache of generated media groups shared by subscriptions and FETCH; 0 to disable")
+	fs.StringVar(&opts.faults, "faults", "",
+		"Fault injection for media, e.g. 'seed=42,drop=0.01,dropgroup=0.02,delay=0.05:300ms,reset=0.02,"+
+			"endgroup=0.02,stall=30s:2s'")
+	fs.StringVar(&opts.datagrams, "datagrams", "",
+		"Comma-separated media tracks sent as OBJECT_DATAGRAMs instead of on subgroup streams: "+
+			"'audio', 'subtitles' and track names; empty for none")
+	fs.StringVar(&opts.relay, "relay", "",
+		"Relay to connect to and publish through (moqt:// for QUIC, https:// for WebTransport) instead of listening")
+	fs.BoolVar(&opts.admin, "admin", false,
+		"Serve the admin API at /admin/ on the side server to inspect and reconfigure the publisher at runtime")
+	fs.BoolVar(&opts.announceOnReq, "announceonrequest", false,
+		"Only announce namespaces matching a SUBSCRIBE_NAMESPACE prefix of the peer, instead of all at session start")
+	fs.IntVar(&opts.draft, "draft", 14, "MoQ Transport draft version used with -relay (14 or 16)")
 	fs.BoolVar(&opts.version, "version", false, fmt.Sprintf("Get %s version", appName))
 	err := fs.Parse(args[1:])
-	return &opts, err
+	if err != nil {
+		return &opts, err
+	}
+	if len(opts.assets) == 0 {
+		opts.assets = assetFlag{{path: defaultAsset}}
+	}
+	return &opts, opts.assets.validate()
 }
 
 func main() {
@@ -137,14 +258,10 @@ func runServer(opts *options) error {
 		fmt.Fprintf(os.Stderr, "\nReceived signal, shutting down...\n")
 		cancel()
 	}()
-	tlsConfig, err := generateTLSConfigWithCertAndKey(opts.certFile, opts.keyFile)
+	tlsConfig, err := tlsconf.Server(opts.certFile, opts.keyFile)
 	if err != nil {
-		slog.Warn("failed to generate TLS config from cert file and key, generating in memory certs", "error", err)
-		tlsConfig, err = generateTLSConfig()
-		if err != nil {
-			slog.Error("failed to generate in-memory TLS config", "error", err)
-			return err
-		}
+		slog.Error("failed to generate in-memory TLS config", "error", err)
+		return err
 	}
 	// Parse commercial DRM config (CPIX)
 	var drm *internal.DRMInfo
@@ -166,12 +283,206 @@ func runServer(opts *options) error {
 		return err
 	}
 
-	asset, err := internal.LoadAssetWithProtection(opts.asset, opts.audioSampleBatch, opts.videoSampleBatch, drm, eccp)
+	for contentType, patternStr := range map[string]string{"video": opts.videoPattern, "audio": opts.audioPattern} {
+		if patternStr == "" {
+			continue
+		}
+		if eccp == nil {
+			return fmt.Errorf("-%spattern requires ClearKey/ECCP encryption", contentType)
+		}
+		pattern, err := internal.ParseEncryptionPattern(patternStr)
+		if err != nil {
+			return err
+		}
+		if err := eccp.SetEncryptionPattern(contentType, pattern); err != nil {
+			return err
+		}
+	}
+	authzURL := opts.authzURL
+	if authzURL == "" && opts.authzSecret != "" && opts.sidePort > 0 {
+		authzURL = fmt.Sprintf("http://localhost:%d/clearkey/token", opts.sidePort)
+	}
+	if authzURL != "" && eccp != nil {
+		eccp.SetAuthzURL(authzURL)
+	}
+	if opts.licenseFailRate < 0 || opts.licenseFailRate > 1 {
+		return fmt.Errorf("-licensefailrate must be between 0 and 1")
+	}
+
+	if opts.keyTiers != "" {
+		if eccp == nil {
+			return fmt.Errorf("-keytiers requires ClearKey/ECCP encryption")
+		}
+		if err := eccp.SetKeyTiers(strings.Split(opts.keyTiers, ",")); err != nil {
+			return err
+		}
+	}
+	if opts.keyRotation < 0 {
+		return fmt.Errorf("-keyrotation must be non-negative")
+	}
+	if opts.keyRotation > 0 {
+		if eccp != nil {
+			if err := eccp.GenerateRotationKeys(opts.rotationKeys); err != nil {
+				return err
+			}
+			if err := eccp.SetKeyRotation(uint64(opts.keyRotation)); err != nil {
+				return err
+			}
+		}
+		if drm != nil {
+			if err := drm.SetKeyRotation(uint64(opts.keyRotation)); err != nil {
+				return fmt.Errorf("key rotation with %s: %w", opts.drmConfigPath, err)
+			}
+			if drm.RotationGroups() == 0 {
+				slog.Warn("DRM config has only one content key, no key rotation", "path", opts.drmConfigPath)
+			}
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	var namespaces []pub.NamespaceEntry
+	var assets []*internal.Asset
+	// rotating maps the namespaces of protected tracks with key rotation to their keys
+	rotating := make(map[string]*internal.DRMInfo)
+	for _, spec := range opts.assets {
+		asset, assetNamespaces, err := loadAssetNamespaces(spec, opts, drm, eccp, now, rotating)
+		if err != nil {
+			return err
+		}
+		assets = append(assets, asset)
+		namespaces = append(namespaces, assetNamespaces...)
+	}
+
+	sched := pub.CatalogSchedule{UpdateInterval: opts.catalogUpdate, FullInterval: opts.catalogFull}
+	if sched.UpdateInterval > 0 || sched.FullInterval > 0 {
+		for i := range namespaces {
+			ns := &namespaces[i]
+			if ns.Catalog == nil {
+				continue
+			}
+			ns.Live, err = pub.NewLiveCatalog(ns.Catalog)
+			if err != nil {
+				return err
+			}
+			go pub.RunCatalogSchedule(ctx, ns.Live, sched)
+		}
+		slog.Info("live catalog updates enabled", "updateInterval", sched.UpdateInterval,
+			"fullInterval", sched.FullInterval)
+	}
+
+	// Key rotation changes the content protections, which is signaled by
+	// new catalog groups.
+	for i := range namespaces {
+		ns := &namespaces[i]
+		d, ok := rotating[ns.Namespace[0]]
+		if !ok {
+			continue
+		}
+		if ns.Live == nil {
+			ns.Live, err = pub.NewLiveCatalog(ns.Catalog)
+			if err != nil {
+				return err
+			}
+		}
+		go pub.RunKeyRotation(ctx, ns.Live, d)
+		slog.Info("key rotation enabled", "namespace", ns.Namespace, "groupsPerKey", d.RotationGroups())
+	}
+
+	for _, ns := range namespaces {
+		tracks := 0
+		if ns.Catalog != nil {
+			tracks = len(ns.Catalog.Tracks)
+		}
+		slog.Info("configured namespace", "namespace", ns.Namespace,
+			"packaging", ns.Packaging, "tracks", tracks,
+			"moqmiTracks", len(ns.MoqMITracks))
+	}
+
+	faults, err := pub.ParseFaultConfig(opts.faults)
 	if err != nil {
-		return err
+		return fmt.Errorf("parse faults: %w", err)
+	}
+	if faults != nil {
+		slog.Warn("fault injection enabled", "faults", faults.String())
+	}
+	datagrams, err := pub.ParseDatagramTracks(opts.datagrams)
+	if err != nil {
+		return fmt.Errorf("parse datagrams: %w", err)
+	}
+	if datagrams != nil {
+		slog.Info("datagram delivery enabled", "tracks", datagrams.String())
+	}
+	if opts.admin && opts.sidePort == 0 {
+		return fmt.Errorf("-admin requires -sideport")
+	}
+
+	var logfh io.Writer
+	if opts.qlogfile == "-" {
+		logfh = os.Stderr
+	} else {
+		fh, err := os.OpenFile(defaultQlogFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			slog.Error("failed to open log file", "error", err)
+		}
+		logfh = fh
+		defer fh.Close()
 	}
+	clearKey := pub.NewClearKeyServer(eccp, pub.ClearKeyPolicy{
+		Token:       opts.licenseToken,
+		AuthzSecret: opts.authzSecret,
+		Delay:       opts.licenseDelay,
+		FailRate:    opts.licenseFailRate,
+		FailStatus:  opts.licenseFailCode,
+	})
+	h := &pub.Handler{
+		Namespaces:  namespaces,
+		Asset:       assets[0],
+		Logfh:       logfh,
+		FetchWindow: opts.fetchWindow,
+		Faults:      faults,
+		Datagrams:   datagrams,
+		ClearKey:    clearKey,
+
+		AnnounceOnRequest: opts.announceOnReq,
+	}
+	if opts.groupCacheMB > 0 {
+		h.GroupCache = internal.NewGroupCache(opts.groupCacheMB << 20)
+	}
+	if opts.sidePort > 0 {
+		h.Metrics = pub.NewMetrics()
+		h.Metrics.GroupCache = h.GroupCache
+	}
+
+	s := &server{
+		addr:      opts.addr,
+		tlsConfig: tlsConfig,
+		handler:   h,
+		sidePort:  opts.sidePort,
+		admin:     opts.admin,
+		clearKey:  clearKey,
+	}
+
+	if opts.relay != "" {
+		alpn := "moq-00"
+		if opts.draft == 16 {
+			alpn = "moqt-16"
+		}
+		return s.runRelayClient(ctx, opts.relay, alpn)
+	}
+	return s.runServer(ctx)
+}
 
-	slog.Info("loaded asset", "path", opts.asset, "audioSampleBatch", opts.audioSampleBatch,
+// loadAssetNamespaces loads the asset of spec, with subtitles and captions,
+// and returns it with its namespaces. Namespaces with key rotation are added
+// to rotating.
+func loadAssetNamespaces(spec assetSpec, opts *options, drm, eccp *internal.DRMInfo, now int64,
+	rotating map[string]*internal.DRMInfo) (*internal.Asset, []pub.NamespaceEntry, error) {
+	asset, err := internal.LoadAssetWithProtection(spec.path, opts.audioSampleBatch, opts.videoSampleBatch, drm, eccp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slog.Info("loaded asset", "name", spec.name, "path", spec.path, "audioSampleBatch", opts.audioSampleBatch,
 		"videoSampleBatch", opts.videoSampleBatch)
 
 	// Parse subtitle languages and add tracks
@@ -179,21 +490,34 @@ func runServer(opts *options) error {
 	stppLangs := parseLanguages(opts.subsStppLangs)
 	err = asset.AddSubtitleTracks(wvttLangs, stppLangs)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	slog.Info("added subtitle tracks", "wvtt", wvttLangs, "stpp", stppLangs)
 
-	now := time.Now().UnixMilli()
+	if opts.cc608 {
+		gen := cc608.New(cc608.Config{Enabled: true, Lang: opts.cc608Lang})
+		nrTracks := asset.EnableCC608(gen)
+		slog.Info("enabled CTA-608 captions", "channel", gen.Channel(), "lang", gen.Lang(),
+			"videoTracks", nrTracks)
+	}
+
+	// nsName returns the namespace of the asset for a base namespace.
+	nsName := func(base string) string {
+		if spec.name == "" {
+			return base
+		}
+		return base + "/" + spec.name
+	}
 	var namespaces []pub.NamespaceEntry
 
 	// Always create the LOC/MSF namespace (AVC + AAC/Opus, clear only)
 	locCatalog, err := asset.GenLOCCatalogEntry(now)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if len(locCatalog.Tracks) > 0 {
 		namespaces = append(namespaces, pub.NamespaceEntry{
-			Namespace: []string{"msf/clear"},
+			Namespace: []string{nsName("msf/clear")},
 			Catalog:   locCatalog,
 			Packaging: "loc",
 		})
@@ -205,7 +529,7 @@ func runServer(opts *options) error {
 		slog.Info("skipping moq-mi namespace", "reason", mmErr)
 	} else {
 		namespaces = append(namespaces, pub.NamespaceEntry{
-			Namespace:   []string{"moq-mi/clear"},
+			Namespace:   []string{nsName("moq-mi/clear")},
 			Packaging:   "moqmi",
 			MoqMITracks: mmTracks,
 		})
@@ -217,77 +541,53 @@ func runServer(opts *options) error {
 	// NamespaceEntry.Packaging is informational only here.
 
 	// Always create the clear namespace
-	clearCatalog, err := asset.GenCMAFCatalogEntry("cmsf/clear", internal.ProtectionNone, now)
+	clearNS := nsName("cmsf/clear")
+	clearCatalog, err := asset.GenCMAFCatalogEntry(clearNS, internal.ProtectionNone, now)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	namespaces = append(namespaces, pub.NamespaceEntry{
-		Namespace: []string{"cmsf/clear"}, Catalog: clearCatalog, Packaging: "cmaf",
+		Namespace: []string{clearNS}, Catalog: clearCatalog, Packaging: "cmaf",
 	})
 
 	// Add commercial DRM namespace if configured
 	if drm != nil {
-		drmCatalog, err := asset.GenCMAFCatalogEntry(fmt.Sprintf("cmsf/drm-%s", opts.scheme),
-			internal.ProtectionDRM, now)
+		drmNS := nsName(fmt.Sprintf("cmsf/drm-%s", opts.scheme))
+		drmCatalog, err := asset.GenCMAFCatalogEntry(drmNS, internal.ProtectionDRM, now)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		namespaces = append(namespaces, pub.NamespaceEntry{
-			Namespace: []string{fmt.Sprintf("cmsf/drm-%s", opts.scheme)},
+			Namespace: []string{drmNS},
 			Catalog:   drmCatalog,
 			Packaging: "cmaf",
 		})
+		if drm.RotationGroups() > 0 {
+			rotating[drmNS] = drm
+		}
 	}
 
 	// Add ClearKey/ECCP namespace if configured
 	if eccp != nil {
-		eccpCatalog, err := asset.GenCMAFCatalogEntry(fmt.Sprintf("cmsf/eccp-%s", opts.scheme),
-			internal.ProtectionECCP, now)
+		eccpNS := nsName(fmt.Sprintf("cmsf/eccp-%s", opts.scheme))
+		eccpCatalog, err := asset.GenCMAFCatalogEntry(eccpNS, internal.ProtectionECCP, now)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		namespaces = append(namespaces, pub.NamespaceEntry{
-			Namespace: []string{fmt.Sprintf("cmsf/eccp-%s", opts.scheme)},
+			Namespace: []string{eccpNS},
 			Catalog:   eccpCatalog,
 			Packaging: "cmaf",
 		})
-	}
-
-	for _, ns := range namespaces {
-		tracks := 0
-		if ns.Catalog != nil {
-			tracks = len(ns.Catalog.Tracks)
+		if eccp.RotationGroups() > 0 {
+			rotating[eccpNS] = eccp
 		}
-		slog.Info("configured namespace", "namespace", ns.Namespace,
-			"packaging", ns.Packaging, "tracks", tracks,
-			"moqmiTracks", len(ns.MoqMITracks))
 	}
 
-	var logfh io.Writer
-	if opts.qlogfile == "-" {
-		logfh = os.Stderr
-	} else {
-		fh, err := os.OpenFile(defaultQlogFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			slog.Error("failed to open log file", "error", err)
-		}
-		logfh = fh
-		defer fh.Close()
+	for i := range namespaces {
+		namespaces[i].Asset = asset
 	}
-	h := &pub.Handler{
-		Namespaces: namespaces,
-		Asset:      asset,
-		Logfh:      logfh,
-	}
-
-	s := &server{
-		addr:      opts.addr,
-		tlsConfig: tlsConfig,
-		handler:   h,
-		sidePort:  opts.sidePort,
-	}
-
-	return s.runServer(ctx)
+	return asset, namespaces, nil
 }
 
 // parseLanguages parses a comma-separated string of language codes.
@@ -307,85 +607,3 @@ func parseLanguages(s string) []string {
 	}
 	return langs
 }
-
-func generateTLSConfigWithCertAndKey(certFile, keyFile string) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return nil, err
-	}
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		NextProtos:   []string{"moqt-16", "moq-00", "h3"},
-	}, nil
-}
-
-// Setup a bare-bones TLS config for the server
-// Generates a certificate that meets WebTransport fingerprint requirements
-func generateTLSConfig() (*tls.Config, error) {
-	// Generate ECDSA key (required for WebTransport fingerprints)
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, err
-	}
-
-	// Create certificate template with WebTransport-compatible settings
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			CommonName: "localhost",
-		},
-		Issuer: pkix.Name{
-			CommonName: "localhost", // Explicitly set issuer = subject for self-signed
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(14 * 24 * time.Hour), // 14 days max for WebTransport
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-		IsCA:                  true, // Self-signed CA
-		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
-		DNSNames:              []string{"localhost", "127.0.0.1"}, // Include IP as DNS too
-	}
-
-	// Create self-signed certificate
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
-	if err != nil {
-		return nil, err
-	}
-
-	// Encode key and certificate to PEM
-	keyBytes, err := x509.MarshalECPrivateKey(key)
-	if err != nil {
-		return nil, err
-	}
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-
-	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
-	if err != nil {
-		return nil, err
-	}
-
-	// Parse the generated certificate to get fingerprint
-	parsedCert, err := x509.ParseCertificate(tlsCert.Certificate[0])
-	if err == nil {
-		fingerprint := sha256.Sum256(parsedCert.Raw)
-		slog.Info("Generated WebTransport-compatible certificate",
-			"algorithm", "ECDSA",
-			"validity_days", 14,
-			"self_signed", true,
-			"fingerprint", hex.EncodeToString(fingerprint[:]),
-			"subject", parsedCert.Subject.String(),
-			"issuer", parsedCert.Issuer.String())
-	} else {
-		slog.Info("Generated WebTransport-compatible certificate",
-			"algorithm", "ECDSA",
-			"validity_days", 14,
-			"self_signed", true)
-	}
-
-	return &tls.Config{
-		Certificates: []tls.Certificate{tlsCert},
-		NextProtos:   []string{"moqt-16", "moq-00", "h3"},
-	}, nil
-}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal/dial"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// runRelayClient connects to the relay at relayURL, announces all namespaces
+// and serves the subscriptions forwarded by the relay. When the connection
+// fails or is closed, it reconnects with an increasing delay until ctx is
+// cancelled.
+func (s *server) runRelayClient(ctx context.Context, relayURL, alpn string) error {
+	if s.sidePort > 0 {
+		go s.startSideServer()
+	}
+	s.handler.Protocols = []string{alpn}
+	delay := minReconnectDelay
+	for {
+		slog.Info("connecting to relay", "relay", relayURL, "alpn", alpn)
+		conn, err := dial.Dial(ctx, relayURL, alpn)
+		if err != nil {
+			slog.Warn("failed to connect to relay", "relay", relayURL, "error", err, "retryIn", delay)
+		} else {
+			start := time.Now()
+			err := s.handler.Handle(ctx, conn)
+			_ = conn.CloseWithError(0, "")
+			if time.Since(start) > maxReconnectDelay {
+				delay = minReconnectDelay
+			}
+			slog.Warn("relay connection ended", "relay", relayURL, "error", err, "retryIn", delay)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqlivemock/internal/relay"
+	"github.com/Eyevinn/moqlivemock/internal/tlsconf"
+)
+
+const (
+	appName             = "mlmrelay"
+	defaultQlogFileName = "mlmrelay.log"
+)
+
+var usg = `%s acts as a MoQ relay between publishers and subscribers.
+Publishers (e.g. mlmpub) connect and announce their namespaces with PUBLISH_NAMESPACE.
+Subscribers (e.g. mlmsub) connect and subscribe to tracks in those namespaces.
+Each track is subscribed to once upstream and fanned out to all subscribers.
+The latest groups of each track are cached and used to serve FETCH; other
+FETCHes are forwarded to the publisher.
+
+Usage of %s:
+`
+
+type options struct {
+	certFile    string
+	keyFile     string
+	addr        string
+	qlogfile    string
+	cacheGroups int
+	version     bool
+}
+
+func parseOptions(fs *flag.FlagSet, args []string) (*options, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, usg, appName, appName)
+		fmt.Fprintf(os.Stderr, "%s [options]\n\noptions:\n", appName)
+		fs.PrintDefaults()
+	}
+
+	opts := options{}
+	fs.StringVar(&opts.certFile, "cert", "cert.pem", "TLS certificate file")
+	fs.StringVar(&opts.keyFile, "key", "key.pem", "TLS key file")
+	fs.StringVar(&opts.addr, "addr", "0.0.0.0:4444", "listen address")
+	fs.StringVar(&opts.qlogfile, "qlog", defaultQlogFileName, "qlog file to write to. Use '-' for stderr")
+	fs.IntVar(&opts.cacheGroups, "cachegroups", relay.DefaultCacheGroups, "Nr groups cached per track")
+	fs.BoolVar(&opts.version, "version", false, fmt.Sprintf("Get %s version", appName))
+	err := fs.Parse(args[1:])
+	return &opts, err
+}
+
+func main() {
+	// Initialize slog to log to stderr
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	slog.SetDefault(logger)
+
+	if err := run(os.Args); err != nil {
+		slog.Error("error running application", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet(appName, flag.ContinueOnError)
+	opts, err := parseOptions(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if opts.version {
+		fmt.Printf("%s %s\n", appName, internal.GetVersion())
+		return nil
+	}
+	if opts.cacheGroups < 1 {
+		return fmt.Errorf("cachegroups must be at least 1, got %d", opts.cacheGroups)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Fprintf(os.Stderr, "\nReceived signal, shutting down...\n")
+		cancel()
+	}()
+
+	tlsConfig, err := tlsconf.Server(opts.certFile, opts.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var logfh io.Writer
+	if opts.qlogfile == "-" {
+		logfh = os.Stderr
+	} else {
+		fh, err := os.OpenFile(opts.qlogfile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return fmt.Errorf("open qlog file: %w", err)
+		}
+		logfh = fh
+		defer fh.Close()
+	}
+
+	s := &server{
+		addr:      opts.addr,
+		tlsConfig: tlsConfig,
+		relay: &relay.Relay{
+			CacheGroups: opts.cacheGroups,
+			Logfh:       logfh,
+		},
+	}
+	return s.runServer(ctx)
+}
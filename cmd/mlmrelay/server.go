@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+
+	"github.com/Eyevinn/moqlivemock/internal/relay"
+	"github.com/Eyevinn/moqtransport/quicmoq"
+	"github.com/Eyevinn/moqtransport/webtransportmoq"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+type server struct {
+	addr      string
+	tlsConfig *tls.Config
+	relay     *relay.Relay
+}
+
+// runServer accepts raw QUIC (moqt-16, moq-00) and WebTransport (/moq)
+// connections and hands them to the relay.
+func (s *server) runServer(ctx context.Context) error {
+	slog.Info("Starting MoQ relay", "addr", s.addr)
+	quicConfig := &quic.Config{
+		EnableDatagrams:                  true,
+		EnableStreamResetPartialDelivery: true,
+	}
+	listener, err := quic.ListenAddr(s.addr, s.tlsConfig, quicConfig)
+	if err != nil {
+		return err
+	}
+	h3Server := &http3.Server{
+		Addr:       s.addr,
+		TLSConfig:  s.tlsConfig,
+		QUICConfig: quicConfig,
+	}
+	webtransport.ConfigureHTTP3Server(h3Server)
+	wt := webtransport.Server{
+		H3: h3Server,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+		ApplicationProtocols: []string{"moqt-16", "moq-00"},
+	}
+	http.HandleFunc("/moq", func(w http.ResponseWriter, r *http.Request) {
+		session, err := wt.Upgrade(w, r)
+		if err != nil {
+			slog.Error("upgrading to webtransport failed", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.relay.Handle(ctx, webtransportmoq.NewServer(session))
+	})
+	for {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		alpn := conn.ConnectionState().TLS.NegotiatedProtocol
+		switch alpn {
+		case "h3":
+			go func() {
+				if err := wt.ServeQUICConn(conn); err != nil {
+					slog.Error("failed to serve QUIC connection", "error", err)
+				}
+			}()
+		case "moq-00", "moqt-16":
+			go s.relay.Handle(ctx, quicmoq.NewServer(conn))
+		default:
+			slog.Warn("unknown ALPN, closing connection", "alpn", alpn)
+			_ = conn.CloseWithError(0, "unsupported protocol")
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -35,6 +36,75 @@ mlmsub -muxout - | ffplay -
 Usage of %s:
 `
 
+// subSpec is a subscription given with -sub, and its output.
+type subSpec struct {
+	sub sub.Subscription
+	out string
+}
+
+// subFlag is the repeatable -sub flag with values ns=namespace,track=regexp,out=file.
+type subFlag []subSpec
+
+func (f *subFlag) String() string {
+	parts := make([]string, 0, len(*f))
+	for _, s := range *f {
+		parts = append(parts, fmt.Sprintf("ns=%s,track=%s,out=%s", strings.Join(s.sub.Namespace, " "), s.sub.Track, s.out))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *subFlag) Set(value string) error {
+	s, out, err := sub.ParseSubscription(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, subSpec{sub: s, out: out})
+	return nil
+}
+
+// subscriptions returns the subscriptions of f. The outputs are created when
+// a track is matched, with {track} in out replaced by the track name. An
+// output file can only be used by one track. The returned function closes
+// the created files.
+func (f subFlag) subscriptions() ([]sub.Subscription, func()) {
+	var mu sync.Mutex
+	used := make(map[string]string) // track names keyed by output
+	var files []*os.File
+	subs := make([]sub.Subscription, 0, len(f))
+	for _, spec := range f {
+		s := spec.sub
+		if spec.out != "" {
+			s.Output = func(trackName string) (io.Writer, error) {
+				out := strings.ReplaceAll(spec.out, "{track}", trackName)
+				mu.Lock()
+				defer mu.Unlock()
+				if other, ok := used[out]; ok {
+					return nil, fmt.Errorf("output %s already used by track %s", out, other)
+				}
+				used[out] = trackName
+				if out == "-" {
+					return os.Stdout, nil
+				}
+				fh, err := os.Create(out)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, fh)
+				return fh, nil
+			}
+		}
+		subs = append(subs, s)
+	}
+	closeFiles := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, fh := range files {
+			fh.Close()
+		}
+	}
+	return subs, closeFiles
+}
+
 type options struct {
 	addr         string
 	trackname    string
@@ -55,7 +125,18 @@ type options struct {
 	catalogMode  string
 	acceptAny    bool
 	discover     bool
+	discoverPfx  string
+	discoverSel  bool
 	catalogTrack string
+	abr          bool
+	abrMaxLate   int
+	abrUpGroups  int
+	latency      bool
+	latencyIntvl int
+	latencyOut   string
+	licenseToken string
+	authzSecret  string
+	subs         subFlag
 	version      bool
 }
 
@@ -87,13 +168,48 @@ func parseOptions(fs *flag.FlagSet, args []string) (*options, error) {
 	fs.BoolVar(&opts.fetchCatalog, "fetchcatalog", false, "Deprecated: alias for -catalog-mode fetch")
 	fs.BoolVar(&opts.acceptAny, "accept-any", false, "Accept any announced namespace")
 	fs.BoolVar(&opts.discover, "discover", false, "Discovery mode: list announced namespaces and exit")
+	fs.StringVar(&opts.discoverPfx, "discover-prefix", "",
+		"Send SUBSCRIBE_NAMESPACE for this namespace prefix (e.g. 'cmsf') and list matching namespaces")
+	fs.BoolVar(&opts.discoverSel, "discover-select", false,
+		"With -discover-prefix: use the first matching namespace instead of -namespace")
 	fs.StringVar(&opts.catalogTrack, "catalog-track", "catalog", "Catalog track name (e.g. 'catalog' or 'catalog.json')")
 	fs.IntVar(&opts.draft, "draft", 14, "MoQ Transport draft version (14 or 16)")
+	fs.BoolVar(&opts.abr, "abr", false, "Switch video between the renditions of its altGroup based on throughput")
+	fs.IntVar(&opts.abrMaxLate, "abrmaxlate", int(sub.DefaultMaxLateness/time.Millisecond),
+		"ABR: maximum lateness in ms of a group before switching down")
+	fs.IntVar(&opts.abrUpGroups, "abrupgroups", sub.DefaultUpSwitchGroups,
+		"ABR: number of groups in time before switching up")
+	fs.BoolVar(&opts.latency, "latency", false, "Measure latency, jitter and A/V skew of received objects")
+	fs.IntVar(&opts.latencyIntvl, "latencyinterval", 10, "Interval in seconds between latency summaries (0 disables)")
+	fs.StringVar(&opts.latencyOut, "latencyreport", "",
+		"Output file for final latency report (CSV if ending with .csv, otherwise JSON). Implies -latency")
+	fs.StringVar(&opts.licenseToken, "licensetoken", "",
+		"Bearer token for ClearKey license requests (default: fetched from the catalog authzURL, if any)")
+	fs.StringVar(&opts.authzSecret, "authzsecret", "",
+		"Shared secret sent as bearer token to the catalog authzURL to get the license token")
+
+	fs.Var(&opts.subs, "sub", "Subscription ns=namespace,track=regexp,out=file, subscribing to all matching tracks "+
+		"of the namespace, each written to out with {track} replaced by the track name. Can be repeated. "+
+		"Replaces -namespace and the track and output selection")
 
 	err := fs.Parse(args[1:])
+	if err == nil && len(opts.subs) > 0 {
+		err = opts.checkSubs()
+	}
 	return &opts, err
 }
 
+// checkSubs checks that no options replaced by -sub are set.
+func (o *options) checkSubs() error {
+	if o.muxout != "" || o.videoOut != "" || o.audioOut != "" || o.subsOut != "" {
+		return fmt.Errorf("-sub cannot be combined with -muxout, -videoout, -audioout or -subsout")
+	}
+	if o.abr || o.discover || o.discoverPfx != "" {
+		return fmt.Errorf("-sub cannot be combined with -abr, -discover or -discover-prefix")
+	}
+	return nil
+}
+
 func main() {
 	// Parse command line arguments first to get the log level
 	fs := flag.NewFlagSet(appName, flag.ContinueOnError)
@@ -173,9 +289,6 @@ func runClient(ctx context.Context, opts *options) error {
 		defer fh.Close()
 	}
 
-	// Automatically use WebTransport if address starts with https://
-	useWebTransport := strings.HasPrefix(opts.addr, "https://")
-
 	namespace := strings.Fields(opts.namespace)
 	if len(namespace) == 0 {
 		namespace = []string{opts.namespace}
@@ -192,6 +305,35 @@ func runClient(ctx context.Context, opts *options) error {
 		AcceptAny:    opts.acceptAny,
 		Discover:     opts.discover,
 		CatalogTrack: opts.catalogTrack,
+		LicenseToken: opts.licenseToken,
+		AuthzSecret:  opts.authzSecret,
+
+		DiscoverPrefix: strings.Fields(opts.discoverPfx),
+		DiscoverSelect: opts.discoverSel,
+	}
+	if len(opts.subs) > 0 {
+		subs, closeFiles := opts.subs.subscriptions()
+		defer closeFiles()
+		h.Namespace = nil
+		h.Subscriptions = subs
+	}
+	if opts.abr {
+		h.ABR = &sub.ThroughputRule{
+			MaxLateness:    time.Duration(opts.abrMaxLate) * time.Millisecond,
+			UpSwitchGroups: opts.abrUpGroups,
+		}
+	}
+
+	if opts.latency || opts.latencyOut != "" {
+		h.Latency = sub.NewLatencyMeter()
+		if opts.latencyIntvl > 0 {
+			go logLatency(ctx, h.Latency, time.Duration(opts.latencyIntvl)*time.Second)
+		}
+		defer func() {
+			if err := writeLatencyReport(h.Latency.Report(), opts.latencyOut); err != nil {
+				slog.Error("failed to write latency report", "error", err)
+			}
+		}()
 	}
 
 	outs := make(map[string]io.Writer)
@@ -231,5 +373,50 @@ func runClient(ctx context.Context, opts *options) error {
 	// draft-14 over WebTransport if the peer omits the WT-Protocol header.
 	h.Protocols = []string{alpn}
 
-	return runClientWithDial(ctx, opts.addr, useWebTransport, alpn, h, outs)
+	return runClientWithDial(ctx, opts.addr, alpn, h, outs)
+}
+
+// logLatency logs a latency summary every interval until ctx is done.
+func logLatency(ctx context.Context, m *sub.LatencyMeter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.LogSummary()
+		}
+	}
+}
+
+// writeLatencyReport writes the final latency report to out, as CSV if out
+// ends with .csv and otherwise as JSON. If out is empty, the report is
+// logged instead.
+func writeLatencyReport(r sub.LatencyReport, out string) error {
+	if out == "" {
+		for _, t := range r.Tracks {
+			slog.Info("latency report", "track", t.Track, "objects", t.Objects,
+				"meanMS", t.Latency.Mean, "p95MS", t.Latency.P95, "maxMS", t.Latency.Max,
+				"jitterMS", t.JitterMS, "groupCompletionMaxMS", t.GroupCompletion.Max)
+		}
+		if r.AVSkew != nil {
+			slog.Info("latency report: A/V skew (video minus audio)",
+				"meanMS", r.AVSkew.Mean, "minMS", r.AVSkew.Min, "maxMS", r.AVSkew.Max)
+		}
+		return nil
+	}
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if strings.HasSuffix(out, ".csv") {
+		return r.WriteCSV(w)
+	}
+	return r.WriteJSON(w)
 }
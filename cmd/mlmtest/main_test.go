@@ -12,6 +12,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/Eyevinn/moqlivemock/internal/relay"
 	"github.com/Eyevinn/moqtransport"
 	"github.com/Eyevinn/moqtransport/quicmoq"
 	"github.com/quic-go/quic-go"
@@ -77,6 +78,40 @@ func serveInteropConn(ctx context.Context, conn *quic.Conn) {
 	s.Close()
 }
 
+// startRelay starts an mlmrelay relay on QUIC and returns its address and a
+// cancel function.
+func startRelay(t *testing.T) (addr string, cancel func()) {
+	t.Helper()
+	tlsConfig, err := generateTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := quic.ListenAddr("localhost:0", tlsConfig, &quic.Config{
+		EnableDatagrams: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	r := &relay.Relay{}
+	go func() {
+		for {
+			conn, err := listener.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go r.Handle(ctx, quicmoq.NewServer(conn))
+		}
+	}()
+
+	port := listener.Addr().(*net.UDPAddr).Port
+	return fmt.Sprintf("moqt://localhost:%d", port), func() {
+		ctxCancel()
+		_ = listener.Close()
+	}
+}
+
 func generateTLSConfig() (*tls.Config, error) {
 	key, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
@@ -115,3 +150,22 @@ func TestInteropTestCases(t *testing.T) {
 		}
 	}
 }
+
+// TestInteropTestCasesRelay runs the test cases against the relay, which
+// forwards subscriptions to the announcing publisher.
+func TestInteropTestCasesRelay(t *testing.T) {
+	addr, cancel := startRelay(t)
+	defer cancel()
+
+	for _, draft := range []int{14, 16} {
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("draft%d/%s", draft, tc.name), func(t *testing.T) {
+				ctx, ctxCancel := context.WithTimeout(context.Background(), defaultTimeout)
+				defer ctxCancel()
+				if err := tc.fn(ctx, addr, true, draft); err != nil {
+					t.Fatalf("%s (draft-%d): %v", tc.name, draft, err)
+				}
+			})
+		}
+	}
+}
@@ -10,6 +10,7 @@ require (
 	github.com/quic-go/quic-go v0.60.0
 	github.com/quic-go/webtransport-go v0.11.0
 	github.com/stretchr/testify v1.11.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/Eyevinn/go-608 v0.6.0
@@ -26,7 +27,9 @@ require (
 	golang.org/x/sync v0.21.0 // indirect
 	golang.org/x/sys v0.45.0 // indirect
 	golang.org/x/text v0.39.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 replace github.com/quic-go/webtransport-go => github.com/Eyevinn/webtransport-go v0.0.0-20260616094103-94b8f28c0917
+
+// Patched copy of moqtransport v0.9.0, see third_party/README.md.
+replace github.com/Eyevinn/moqtransport => ./third_party/moqtransport
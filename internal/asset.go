@@ -3,16 +3,23 @@ package internal
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"sync"
 
 	"github.com/Eyevinn/mp4ff/bits"
 	"github.com/Eyevinn/mp4ff/mp4"
 
 	"github.com/Eyevinn/locmaf"
+
+	"github.com/Eyevinn/moqlivemock/internal/cc608"
 )
 
 const (
@@ -44,27 +51,72 @@ const (
 )
 
 type ContentTrack struct {
-	Name                    string
-	ContentType             string
-	Language                string
-	SampleBitrate           uint32
-	TimeScale               uint32
-	Duration                uint32
-	GopLength               uint32
-	SampleDur               uint32
-	NrSamples               uint32
-	LoopDur                 uint32 // Loop duration in local timescale
-	SampleBatch             int
+	Name          string
+	ContentType   string
+	Language      string
+	Label         string // human-readable label for the catalog, if set
+	Role          string // catalog role if not the content type, e.g. "audiodescription"
+	trackID       uint32 // track ID in the source file
+	SampleBitrate uint32
+	TimeScale     uint32
+	Duration      uint32
+	GopLength     uint32
+	SampleDur     uint32
+	NrSamples     uint32
+	LoopDur       uint32 // Loop duration in local timescale
+	// SampleBatch is the number of samples per MoQ object at load time.
+	// Use SampleBatchAt while publishing, since it can be changed.
+	SampleBatch int
+	// batches, if set, records the changes of SampleBatch. It is shared by
+	// all copies of the track.
+	batches                 *batchHistory
 	Samples                 []mp4.FullSample
 	SpecData                CodecSpecificData
 	Protection              ProtectionType
 	contentProtectionRefIDs []string
 	cenc                    *CENCInfo
+	scheme                  string // protection scheme, may differ from ipd.Scheme
 	ipd                     *mp4.InitProtectData
-	// currentIV is the per-track running IV used for encrypting the next fragment.
-	// mp4.EncryptFragment chains IVs across fragments (incremented by the number of
-	// encrypted AES blocks) so that callers using the same key avoid IV reuse.
-	currentIV []byte
+	// ivPrefix distinguishes the IVs of the tracks sharing a key, see sampleIV.
+	ivPrefix uint16
+	// captions, when enabled, splices per-frame CTA-608 SEI into AVC/HEVC
+	// samples. ccCache caches the SEI schedules of the latest groups.
+	captions *cc608.Generator
+	ccCache  *captionCache
+}
+
+// captionCacheGroups is the number of groups whose caption SEI schedules
+// are cached per track, enough for the live edge and concurrent FETCHes.
+const captionCacheGroups = 4
+
+// captionCache caches the caption SEI schedules of a track by group number.
+// It is shared by the copies of the track and safe for concurrent use.
+type captionCache struct {
+	mu   sync.Mutex
+	seis map[uint64][][]byte
+}
+
+func newCaptionCache() *captionCache {
+	return &captionCache{seis: make(map[uint64][][]byte)}
+}
+
+// schedule returns the SEI schedule of group groupNr, building it with gen
+// if it is not cached. The oldest group is evicted when the cache is full.
+func (c *captionCache) schedule(groupNr uint64, gen func() [][]byte) [][]byte {
+	c.mu.Lock()
+	seis, ok := c.seis[groupNr]
+	c.mu.Unlock()
+	if ok {
+		return seis
+	}
+	seis = gen()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.seis) >= captionCacheGroups {
+		delete(c.seis, slices.Min(slices.Collect(maps.Keys(c.seis))))
+	}
+	c.seis[groupNr] = seis
+	return seis
 }
 
 type Asset struct {
@@ -100,6 +152,114 @@ func (a *Asset) GetTrackByName(name string) *ContentTrack {
 	return nil
 }
 
+// SetSampleBatch sets the number of samples per MoQ object of all tracks of
+// contentType ("video" or "audio") for group fromGroup and later groups.
+// It returns the number of tracks changed.
+func (a *Asset) SetSampleBatch(contentType string, sampleBatch int, fromGroup uint64) int {
+	n := 0
+	for _, group := range a.Groups {
+		for i := range group.Tracks {
+			if group.Tracks[i].ContentType == contentType && group.Tracks[i].SetSampleBatch(sampleBatch, fromGroup) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// CurrentSampleBatch returns the number of samples per MoQ object of the
+// latest groups, which is SampleBatch unless changed with SetSampleBatch.
+func (ct *ContentTrack) CurrentSampleBatch() int {
+	if n := ct.batches.latest(); n > 0 {
+		return n
+	}
+	return ct.SampleBatch
+}
+
+// SampleBatchAt returns the number of samples per MoQ object of group
+// groupNr, which is SampleBatch unless changed with SetSampleBatch for the
+// group or an earlier one.
+func (ct *ContentTrack) SampleBatchAt(groupNr uint64) int {
+	if n := ct.batches.at(groupNr); n > 0 {
+		return n
+	}
+	return ct.SampleBatch
+}
+
+// SetSampleBatch changes the number of samples per MoQ object for group
+// fromGroup and later groups. Earlier groups keep their batch, so that they
+// can be regenerated as they were published. It reports false if the track
+// does not support changing it.
+func (ct *ContentTrack) SetSampleBatch(sampleBatch int, fromGroup uint64) bool {
+	if ct.batches == nil {
+		return false
+	}
+	ct.batches.set(sampleBatch, fromGroup)
+	return true
+}
+
+// batchHistory records the sample batch changes of a track, each with the
+// first group it applies to.
+type batchHistory struct {
+	mu      sync.Mutex
+	changes []batchChange // in increasing group order
+}
+
+// batchChange is a sample batch that applies from group fromGroup on.
+type batchChange struct {
+	fromGroup   uint64
+	sampleBatch int
+}
+
+// set changes the sample batch from group fromGroup on, replacing earlier
+// changes of that group and later groups.
+func (h *batchHistory) set(sampleBatch int, fromGroup uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.changes = slices.DeleteFunc(h.changes, func(c batchChange) bool { return c.fromGroup >= fromGroup })
+	h.changes = append(h.changes, batchChange{fromGroup: fromGroup, sampleBatch: sampleBatch})
+}
+
+// at returns the sample batch of group groupNr, or 0 if it is unchanged.
+// A nil h has no changes.
+func (h *batchHistory) at(groupNr uint64) int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.changes) - 1; i >= 0; i-- {
+		if h.changes[i].fromGroup <= groupNr {
+			return h.changes[i].sampleBatch
+		}
+	}
+	return 0
+}
+
+// clone returns a copy of h with its own changes. A nil h clones to an
+// empty history.
+func (h *batchHistory) clone() *batchHistory {
+	if h == nil {
+		return &batchHistory{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return &batchHistory{changes: slices.Clone(h.changes)}
+}
+
+// latest returns the latest sample batch, or 0 if it is unchanged.
+func (h *batchHistory) latest() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.changes) == 0 {
+		return 0
+	}
+	return h.changes[len(h.changes)-1].sampleBatch
+}
+
 // GetSubtitleTrackByName returns a pointer to a SubtitleTrack with the given name, or nil if not found.
 func (a *Asset) GetSubtitleTrackByName(name string) *SubtitleTrack {
 	for _, st := range a.SubtitleTracks {
@@ -137,8 +297,33 @@ func (a *Asset) AddSubtitleTracks(wvttLangs, stppLangs []string) error {
 	return nil
 }
 
-// InitContentTrack initializes a ContentTrack from an io.Reader (expects a fragmented MP4).
-// The name is stripped of any extension.
+// EnableCC608 attaches the CTA-608 caption generator gen to all AVC and HEVC
+// video tracks (clear and protected), so that every frame served in CMAF,
+// LOCMAF, LOC and moq-mi carries a caption SEI NAL unit. It returns the number
+// of tracks that got captions. A nil or disabled gen removes captions.
+func (a *Asset) EnableCC608(gen *cc608.Generator) int {
+	nr := 0
+	for gi := range a.Groups {
+		for ti := range a.Groups[gi].Tracks {
+			ct := &a.Groups[gi].Tracks[ti]
+			ct.captions = nil
+			ct.ccCache = nil
+			if !gen.Enabled() || ct.ContentType != "video" {
+				continue
+			}
+			if _, ok := cc608.CodecFor(ct.SpecData.Codec()); !ok {
+				continue
+			}
+			ct.captions = gen
+			ct.ccCache = newCaptionCache()
+			nr++
+		}
+	}
+	return nr
+}
+
+// InitContentTrack initializes a ContentTrack from an io.Reader (expects a fragmented MP4
+// with one track). The name is stripped of any extension.
 func InitContentTrack(r io.Reader, name string, audioSampleBatch, videoSampleBatch int) (*ContentTrack, error) {
 	m, err := mp4.DecodeFile(r)
 	if err != nil {
@@ -150,16 +335,148 @@ func InitContentTrack(r io.Reader, name string, audioSampleBatch, videoSampleBat
 	if len(m.Moov.Traks) != 1 {
 		return nil, fmt.Errorf("file has not exactly one track")
 	}
-	init := m.Init
-	trak := init.Moov.Trak
-	mdia := trak.Mdia
+	samples, err := fragmentedSamples(m, m.Init.Moov.Trak.Tkhd.TrackID)
+	if err != nil {
+		return nil, err
+	}
+	return newContentTrack(m.Init, samples, trimExt(name), audioSampleBatch, videoSampleBatch)
+}
+
+// InitContentTracks initializes a ContentTrack for each track of an MP4 file.
+// The file may be fragmented or not, and non-fragmented tracks are fragmented
+// on load. The name is stripped of any extension, and if the file has more
+// than one track, the track ID is appended as <name>_<trackID>.
+func InitContentTracks(r io.Reader, name string, audioSampleBatch, videoSampleBatch int) ([]*ContentTrack, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file: %w", err)
+	}
+	m, err := mp4.DecodeFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode file: %w", err)
+	}
+	if m.Moov == nil || len(m.Moov.Traks) == 0 {
+		return nil, fmt.Errorf("file has no tracks")
+	}
+	name = trimExt(name)
+	cts := make([]*ContentTrack, 0, len(m.Moov.Traks))
+	for _, trak := range m.Moov.Traks {
+		trackID := trak.Tkhd.TrackID
+		var samples []mp4.FullSample
+		if m.IsFragmented() {
+			samples, err = fragmentedSamples(m, trackID)
+		} else {
+			samples, err = progressiveSamples(data, trak)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", trackID, err)
+		}
+		trackName := name
+		if len(m.Moov.Traks) > 1 {
+			trackName = fmt.Sprintf("%s_%d", name, trackID)
+		}
+		ct, err := newContentTrack(singleTrackInit(m.Moov, trak), samples, trackName,
+			audioSampleBatch, videoSampleBatch)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", trackID, err)
+		}
+		cts = append(cts, ct)
+	}
+	return cts, nil
+}
+
+// trimExt returns name stripped of any extension.
+func trimExt(name string) string {
 	if ext := filepath.Ext(name); ext != "" {
-		name = name[:len(name)-len(ext)]
+		return name[:len(name)-len(ext)]
 	}
+	return name
+}
+
+// singleTrackInit returns an init segment with trak as its only track.
+func singleTrackInit(moov *mp4.MoovBox, trak *mp4.TrakBox) *mp4.InitSegment {
+	init := mp4.NewMP4Init()
+	init.AddChild(mp4.CreateFtyp())
+	outMoov := mp4.NewMoovBox()
+	outMoov.AddChild(moov.Mvhd)
+	outMoov.AddChild(trak)
+	mvex := mp4.NewMvexBox()
+	mvex.AddChild(mp4.CreateTrex(trak.Tkhd.TrackID))
+	outMoov.AddChild(mvex)
+	init.AddChild(outMoov)
+	return init
+}
+
+// fragmentedSamples returns the samples of track trackID in the fragments of m.
+func fragmentedSamples(m *mp4.File, trackID uint32) ([]mp4.FullSample, error) {
+	trex, ok := m.Init.Moov.Mvex.GetTrex(trackID)
+	if !ok {
+		return nil, fmt.Errorf("no trex box for track %d", trackID)
+	}
+	var samples []mp4.FullSample
+	for _, seg := range m.Segments {
+		for _, frag := range seg.Fragments {
+			fs, err := frag.GetFullSamples(trex)
+			if err != nil {
+				return nil, fmt.Errorf("could not get full samples: %w", err)
+			}
+			samples = append(samples, fs...)
+		}
+	}
+	return samples, nil
+}
+
+// progressiveSamples returns the samples of trak in a non-fragmented file
+// with the bytes data. The sample data is sliced from data.
+func progressiveSamples(data []byte, trak *mp4.TrakBox) ([]mp4.FullSample, error) {
+	nrSamples := trak.GetNrSamples()
+	if nrSamples == 0 {
+		return nil, fmt.Errorf("track has no samples")
+	}
+	samples, err := trak.GetSampleData(1, nrSamples)
+	if err != nil {
+		return nil, fmt.Errorf("could not get sample data: %w", err)
+	}
+	// Without an stss box, all samples are sync samples.
+	allSync := trak.Mdia.Minf.Stbl.Stss == nil
+	fullSamples := make([]mp4.FullSample, len(samples))
+	decodeTime := uint64(0)
+	for i, s := range samples {
+		if allSync {
+			s.Flags = mp4.SyncSampleFlags
+		}
+		ranges, err := trak.GetRangesForSampleInterval(uint32(i+1), uint32(i+1))
+		if err != nil {
+			return nil, fmt.Errorf("could not get range of sample %d: %w", i+1, err)
+		}
+		start, end := ranges[0].Offset, ranges[0].Offset+ranges[0].Size
+		if end > uint64(len(data)) {
+			return nil, fmt.Errorf("sample %d beyond end of file", i+1)
+		}
+		// Cap the slice so that rewriting a sample cannot spill into the next.
+		fullSamples[i] = mp4.FullSample{
+			Sample:     s,
+			DecodeTime: decodeTime,
+			Data:       data[start:end:end],
+		}
+		decodeTime += uint64(s.Dur)
+	}
+	return fullSamples, nil
+}
+
+// newContentTrack initializes a ContentTrack from a single-track init segment
+// and the samples of the track.
+func newContentTrack(init *mp4.InitSegment, samples []mp4.FullSample, name string,
+	audioSampleBatch, videoSampleBatch int) (*ContentTrack, error) {
+	trak := init.Moov.Trak
+	mdia := trak.Mdia
 	ct := ContentTrack{
 		TimeScale: mdia.Mdhd.Timescale,
 		Language:  mdia.Mdhd.GetLanguage(),
 		Name:      name,
+		Samples:   samples,
+		trackID:   trak.Tkhd.TrackID,
+		batches:   &batchHistory{},
 	}
 	sampleDesc, err := mdia.Minf.Stbl.Stsd.GetSampleDescription(0)
 	if err != nil {
@@ -175,15 +492,8 @@ func InitContentTrack(r io.Reader, name string, audioSampleBatch, videoSampleBat
 	default:
 		return nil, fmt.Errorf("unsupported sample description type: %s", sampleDesc.Type())
 	}
-	trex := init.Moov.Mvex.Trex
-	for _, seg := range m.Segments {
-		for _, frag := range seg.Fragments {
-			fs, err := frag.GetFullSamples(trex)
-			if err != nil {
-				return nil, fmt.Errorf("could not get full samples: %w", err)
-			}
-			ct.Samples = append(ct.Samples, fs...)
-		}
+	if len(ct.Samples) == 0 {
+		return nil, fmt.Errorf("track has no samples")
 	}
 	for i, s := range ct.Samples {
 		if ct.SampleDur == 0 {
@@ -285,50 +595,112 @@ func InitContentTrack(r io.Reader, name string, audioSampleBatch, videoSampleBat
 	default:
 		return nil, fmt.Errorf("unknown sample description type: %s", sampleDesc.Type())
 	}
-	ct.Duration = uint32(len(ct.Samples)) * ct.SampleDur
-	ct.NrSamples = uint32(len(ct.Samples))
+	ct.setSampleStats()
+	return &ct, nil
+}
+
+// setSampleStats sets the duration, number of samples and sample bitrate
+// of the track from its samples.
+func (t *ContentTrack) setSampleStats() {
+	t.Duration = uint32(len(t.Samples)) * t.SampleDur
+	t.NrSamples = uint32(len(t.Samples))
 	// Calculate sampleBitrate (bits per second)
 	totalBytes := 0
-	for _, s := range ct.Samples {
+	for _, s := range t.Samples {
 		totalBytes += int(s.Size)
 	}
-	durationSeconds := float64(ct.Duration) / float64(ct.TimeScale)
+	durationSeconds := float64(t.Duration) / float64(t.TimeScale)
 	if durationSeconds > 0 {
-		ct.SampleBitrate = uint32(float64(totalBytes*8) / durationSeconds)
+		t.SampleBitrate = uint32(float64(totalBytes*8) / durationSeconds)
 	}
+}
 
-	return &ct, nil
+// clone returns an independent copy of t, with its own samples, codec data,
+// sample batch history and caption cache, for use as another track.
+func (t *ContentTrack) clone() (ContentTrack, error) {
+	c := *t
+	c.Samples = slices.Clone(t.Samples)
+	if t.SpecData != nil {
+		specData, err := cloneCodecSpecificData(t.SpecData)
+		if err != nil {
+			return ContentTrack{}, fmt.Errorf("clone track %s: %w", t.Name, err)
+		}
+		c.SpecData = specData
+	}
+	c.batches = t.batches.clone()
+	if t.ccCache != nil {
+		c.ccCache = newCaptionCache()
+	}
+	return c, nil
+}
+
+// trimToLoop drops the samples of the track beyond loopDurMS. The loop
+// must be a whole number of frames of a video track.
+func (t *ContentTrack) trimToLoop(loopDurMS uint32) error {
+	loopDur := uint64(loopDurMS) * uint64(t.TimeScale) / 1000
+	if uint64(t.Duration) < loopDur {
+		return fmt.Errorf("track %s is shorter than the loop duration %dms", t.Name, loopDurMS)
+	}
+	if t.ContentType == "video" && uint64(loopDurMS)*uint64(t.TimeScale)%(1000*uint64(t.SampleDur)) != 0 {
+		return fmt.Errorf("loop duration %dms is not a whole number of frames of track %s", loopDurMS, t.Name)
+	}
+	nrSamples := (loopDur + uint64(t.SampleDur) - 1) / uint64(t.SampleDur)
+	t.Samples = t.Samples[:nrSamples]
+	t.setSampleStats()
+	return nil
 }
 
 // LoadAsset opens a directory, reads all *.mp4 files, creates ContentTrack from each,
 // groups them by contentType, and returns a pointer to an Asset.
-func LoadAsset(dirPath string, audioSampleBatch, videoSampleBatch int) (*Asset, error) {
-	return LoadAssetWithProtection(dirPath, audioSampleBatch, videoSampleBatch, nil, nil)
+// path may also be an asset manifest (see ReadManifest).
+func LoadAsset(path string, audioSampleBatch, videoSampleBatch int) (*Asset, error) {
+	return LoadAssetWithProtection(path, audioSampleBatch, videoSampleBatch, nil, nil)
 }
 
 // LoadAssetWithDRM creates an asset with a single DRM config (backward compatibility).
-func LoadAssetWithDRM(dirPath string, audioSampleBatch, videoSampleBatch int, drm *DRMInfo) (*Asset, error) {
-	return LoadAssetWithProtection(dirPath, audioSampleBatch, videoSampleBatch, drm, nil)
+func LoadAssetWithDRM(path string, audioSampleBatch, videoSampleBatch int, drm *DRMInfo) (*Asset, error) {
+	return LoadAssetWithProtection(path, audioSampleBatch, videoSampleBatch, drm, nil)
 }
 
-// LoadAssetWithProtection creates an asset from the *.mp4 files in the specified dirPath.
+// LoadAssetWithProtection creates an asset from the *.mp4 files in the directory path,
+// or from the tracks listed in the asset manifest path.
 // If drm is not nil, protected tracks with "_drm" suffix are created (commercial DRM via CPIX).
 // If eccp is not nil, protected tracks with "_eccp" suffix are created (ClearKey/ECCP).
 // Both can be provided simultaneously to create two independent sets of encrypted tracks.
-func LoadAssetWithProtection(dirPath string, audioSampleBatch, videoSampleBatch int,
+func LoadAssetWithProtection(path string, audioSampleBatch, videoSampleBatch int,
 	drm, eccp *DRMInfo) (*Asset, error) {
-	tracksByType, err := parseTracks(dirPath, audioSampleBatch, videoSampleBatch)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse tracks: %w", err)
+	name := filepath.Base(path)
+	var tracksByType map[string][]ContentTrack
+	var loopDurMS uint32
+	if IsManifest(path) {
+		m, err := ReadManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		tracksByType, err = m.parseTracks(filepath.Dir(path), audioSampleBatch, videoSampleBatch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tracks: %w", err)
+		}
+		name = m.Name
+		if name == "" {
+			name = trimExt(filepath.Base(path))
+		}
+		loopDurMS = m.LoopDurationMS
+	} else {
+		var err error
+		tracksByType, err = parseTracks(path, audioSampleBatch, videoSampleBatch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tracks: %w", err)
+		}
 	}
 	if drm != nil {
-		err = createProtectedTracks(tracksByType, drm, "_drm", ProtectionDRM)
+		err := createProtectedTracks(tracksByType, name, drm, "_drm", ProtectionDRM)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create DRM protected tracks: %w", err)
 		}
 	}
 	if eccp != nil {
-		err = createProtectedTracks(tracksByType, eccp, "_eccp", ProtectionECCP)
+		err := createProtectedTracks(tracksByType, name, eccp, "_eccp", ProtectionECCP)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create ECCP protected tracks: %w", err)
 		}
@@ -338,12 +710,12 @@ func LoadAssetWithProtection(dirPath string, audioSampleBatch, videoSampleBatch
 		return nil, fmt.Errorf("failed to generate track groups: %w", err)
 	}
 	asset := &Asset{
-		Name:   filepath.Base(dirPath),
+		Name:   name,
 		Groups: trackGroups,
 		Drm:    drm,
 		Eccp:   eccp,
 	}
-	if err := asset.setLoopDuration(); err != nil {
+	if err := asset.setLoopDuration(loopDurMS); err != nil {
 		return nil, fmt.Errorf("could not set loop duration: %w", err)
 	}
 	return asset, nil
@@ -368,21 +740,23 @@ func parseTracks(dirPath string, audioSampleBatch, videoSampleBatch int) (map[st
 		if err != nil {
 			return nil, fmt.Errorf("could not open file %s: %w", filePath, err)
 		}
-		ct, err := InitContentTrack(fh, entry.Name(), audioSampleBatch, videoSampleBatch)
+		cts, err := InitContentTracks(fh, entry.Name(), audioSampleBatch, videoSampleBatch)
+		fh.Close()
 		if err != nil {
 			return nil, fmt.Errorf("could not create ContentTrack for %s: %w", filePath, err)
 		}
-		tracksByType[ct.ContentType] = append(tracksByType[ct.ContentType], *ct)
-		fh.Close()
+		for _, ct := range cts {
+			tracksByType[ct.ContentType] = append(tracksByType[ct.ContentType], *ct)
+		}
 	}
 	return tracksByType, nil
 }
 
 // createProtectedTracks creates duplicate protected versions of all existing clear tracks
-// and adds them to the map. The suffix (e.g. "_drm", "_eccp") and protectionType distinguish
-// different protection schemes.
-func createProtectedTracks(tracksByType map[string][]ContentTrack, drm *DRMInfo, suffix string,
-	prot ProtectionType) error {
+// of the asset assetName and adds them to the map. The suffix (e.g. "_drm", "_eccp") and
+// protectionType distinguish different protection schemes.
+func createProtectedTracks(tracksByType map[string][]ContentTrack, assetName string, drm *DRMInfo,
+	suffix string, prot ProtectionType) error {
 	types := []string{"video", "audio"}
 	for _, typ := range types {
 		orig, ok := tracksByType[typ]
@@ -395,10 +769,13 @@ func createProtectedTracks(tracksByType map[string][]ContentTrack, drm *DRMInfo,
 			if ct.Protection != ProtectionNone {
 				continue
 			}
-			protectedCt, err := addProtectionInfoToTrack(ct, drm, suffix, prot)
+			protectedCt, err := addProtectionInfoToTrack(ct, assetName, drm, suffix, prot)
 			if err != nil {
 				return err
 			}
+			if err := drm.claimIVPrefix(protectedCt.ivPrefix, assetName, protectedCt.Name); err != nil {
+				return err
+			}
 			added = append(added, protectedCt)
 		}
 		tracksByType[typ] = append(tracksByType[typ], added...)
@@ -406,8 +783,10 @@ func createProtectedTracks(tracksByType map[string][]ContentTrack, drm *DRMInfo,
 	return nil
 }
 
-// addProtectionInfoToTrack adds protection information to a track with the given suffix and type.
-func addProtectionInfoToTrack(ct ContentTrack, drm *DRMInfo, suffix string, prot ProtectionType) (ContentTrack, error) {
+// addProtectionInfoToTrack adds protection information to a track of the asset assetName
+// with the given suffix and type.
+func addProtectionInfoToTrack(ct ContentTrack, assetName string, drm *DRMInfo, suffix string,
+	prot ProtectionType) (ContentTrack, error) {
 	protectedCt := ct
 	protectedSpecData, err := cloneCodecSpecificData(ct.SpecData)
 	if err != nil {
@@ -415,23 +794,18 @@ func addProtectionInfoToTrack(ct ContentTrack, drm *DRMInfo, suffix string, prot
 	}
 	protectedCt.Name = ct.Name + suffix
 	protectedCt.Protection = prot
-	protectedCt.cenc = drm.cenc
-	// Each ContentTrack instance owns its IV state. Tracks copied from this one
-	// (e.g., via Asset.GetTrackByName for a new subscription) share the initial
-	// slice header until the first EncryptFragment call replaces it.
-	protectedCt.currentIV = append([]byte(nil), drm.cenc.iv...)
-	refIDs := make([]string, 0, len(drm.ContentProtections))
-	for _, cp := range drm.ContentProtections {
-		refIDs = append(refIDs, cp.RefID)
-	}
-	protectedCt.contentProtectionRefIDs = refIDs
-	protectedCt.SpecData = protectedSpecData
-	kid, err := mp4.NewUUIDFromString(drm.ContentProtections[0].DefaultKIDs[0])
+	tier, err := drm.tierFor(ct.ContentType, ct.height())
 	if err != nil {
-		return ContentTrack{}, fmt.Errorf("unable to parse UUID from string: %w", err)
+		return ContentTrack{}, fmt.Errorf("track %s: %w", ct.Name, err)
 	}
-	ipd, err := mp4.InitProtect(protectedCt.SpecData.GetInit(), []byte{},
-		drm.cenc.iv, drm.ContentProtections[0].Scheme, kid, nil)
+	protectedCt.cenc = tier.cenc
+	protectedCt.ivPrefix = trackIVPrefix(assetName, protectedCt.Name)
+	defaultKey := tier.cenc.defaultKey()
+	protectedCt.contentProtectionRefIDs = tier.refIDs
+	protectedCt.SpecData = protectedSpecData
+	protectedCt.scheme = drm.scheme()
+	ipd, err := initProtect(protectedCt.SpecData.GetInit(), defaultKey.iv, protectedCt.scheme,
+		defaultKey.kid, drm.encryptionPattern(ct.ContentType))
 	if err != nil {
 		return ContentTrack{}, fmt.Errorf("unable to add protection data to cloned init for track %s: %w", ct.Name, err)
 	}
@@ -439,6 +813,28 @@ func addProtectionInfoToTrack(ct ContentTrack, drm *DRMInfo, suffix string, prot
 	return protectedCt, nil
 }
 
+// catalogRole returns the catalog role of the track, which is the content
+// type unless another role is set.
+func (t *ContentTrack) catalogRole() string {
+	if t.Role != "" {
+		return t.Role
+	}
+	return t.ContentType
+}
+
+// height returns the height of a video track, or 0.
+func (t *ContentTrack) height() int {
+	switch sd := t.SpecData.(type) {
+	case *AVCData:
+		return int(sd.height)
+	case *HEVCData:
+		return int(sd.height)
+	case *AV1Data:
+		return int(sd.height)
+	}
+	return 0
+}
+
 func cloneCodecSpecificData(specData CodecSpecificData) (CodecSpecificData, error) {
 	if specData == nil {
 		return nil, fmt.Errorf("codec specific data is nil")
@@ -492,24 +888,24 @@ func generateTrackGroups(tracksByType map[string][]ContentTrack) ([]TrackGroup,
 	return groups, nil
 }
 
-// setLoopDuration set a loop duration for all tracks in the asset
-// based on the first track in the first group.
-// All the tracks in the first group must have durations that
-// are equal to the loopDuration in their timeScale.
-func (a *Asset) setLoopDuration() error {
+// setLoopDuration set a loop duration for all tracks in the asset.
+// If loopDurMS is zero, it is based on the first track in the first group,
+// and all the tracks in the first group must have durations that
+// are equal to the loopDuration in their timeScale. With a configured
+// loopDurMS, audio tracks in any group may be longer.
+// Every MoQ group of a video track must start with a sync sample.
+func (a *Asset) setLoopDuration(loopDurMS uint32) error {
 	if len(a.Groups) == 0 {
 		return fmt.Errorf("no tracks found")
 	}
-	loopDurMS := a.Groups[0].Tracks[0].Duration * 1000 / a.Groups[0].Tracks[0].TimeScale
+	configured := loopDurMS > 0
+	if !configured {
+		loopDurMS = a.Groups[0].Tracks[0].Duration * 1000 / a.Groups[0].Tracks[0].TimeScale
+	}
 	for gNr, group := range a.Groups {
 		for tNr, track := range group.Tracks {
 			switch {
-			case gNr == 0:
-				if track.Duration*1000 != loopDurMS*track.TimeScale {
-					return fmt.Errorf("group %d track %s not compatible with loop duration", gNr, track.Name)
-				}
-				group.Tracks[tNr].LoopDur = track.Duration
-			case gNr > 0 && track.ContentType == "audio":
+			case (gNr > 0 || configured) && track.ContentType == "audio":
 				if track.Duration*1000 < loopDurMS*track.TimeScale {
 					return fmt.Errorf("group %d audio track %s not compatible with loop duration", gNr, track.Name)
 				}
@@ -520,12 +916,31 @@ func (a *Asset) setLoopDuration() error {
 				}
 				group.Tracks[tNr].LoopDur = track.Duration
 			}
+			if track.ContentType == "video" {
+				if err := group.Tracks[tNr].checkGroupStarts(loopDurMS); err != nil {
+					return err
+				}
+			}
 		}
 	}
 	a.LoopDurMS = loopDurMS
 	return nil
 }
 
+// checkGroupStarts checks that all MoQ groups within the loop start with a
+// sync sample.
+func (t *ContentTrack) checkGroupStarts(loopDurMS uint32) error {
+	for groupNr := uint64(0); groupNr*MoqGroupDurMS < uint64(loopDurMS); groupNr++ {
+		startNr, _ := calcMoQGroup(t, groupNr, MoqGroupDurMS)
+		if startNr < uint64(len(t.Samples)) && !t.Samples[startNr].IsSync() {
+			return fmt.Errorf("track %s has no sync sample at the start of group %d: "+
+				"each %dms MoQ group must start with one, so GOPs longer than the group are not supported",
+				t.Name, groupNr, MoqGroupDurMS)
+		}
+	}
+	return nil
+}
+
 // LocmafTrackSuffix is appended to a CMAF track name to form the name of its
 // LOCMAF counterpart in a unified CMSF catalog. The publisher strips it
 // to resolve the underlying content track.
@@ -540,8 +955,9 @@ const LocmafTrackSuffix = "_locmaf"
 // <name> and a LOCMAF track named <name>_locmaf, as alternates in the
 // same altGroup. Because LOCMAF init data is the raw CMAF init segment,
 // both tracks reference a single shared entry in the catalog InitDataList via
-// initRef (draft-ietf-moq-msf-01 Section 5.1.7 / 5.2.13). Subtitle tracks are
-// CMAF only.
+// initRef (draft-ietf-moq-msf-01 Section 5.1.7 / 5.2.13). Subtitle tracks and
+// tracks with key rotation, whose key changes cannot be signaled in LOCMAF,
+// are CMAF only.
 //
 // The namespace parameter sets the Track.Namespace field in each catalog track entry.
 // The prot parameter selects which tracks to include: ProtectionNone for clear tracks,
@@ -583,10 +999,6 @@ func (a *Asset) GenCMAFCatalogEntry(namespace string, prot ProtectionType,
 			if err != nil {
 				return nil, fmt.Errorf("could not calculate CMAF bitrate for track %s: %w", ct.Name, err)
 			}
-			locmafBitrate, err := calcLocmafBitrate(&ct)
-			if err != nil {
-				return nil, fmt.Errorf("could not calculate LOCMAF bitrate for track %s: %w", ct.Name, err)
-			}
 
 			// Build the descriptive fields shared by both variants.
 			base := Track{
@@ -598,10 +1010,11 @@ func (a *Asset) GenCMAFCatalogEntry(namespace string, prot ProtectionType,
 				Codec:       ct.SpecData.Codec(),
 				Timescale:   Ptr(int(ct.TimeScale)),
 				Language:    ct.Language,
+				Role:        ct.catalogRole(),
+				Label:       ct.Label,
 			}
 			switch ct.ContentType {
 			case "video":
-				base.Role = "video"
 				base.Framerate = Ptr(frameRate)
 				switch sd := ct.SpecData.(type) {
 				case *AVCData:
@@ -627,7 +1040,6 @@ func (a *Asset) GenCMAFCatalogEntry(namespace string, prot ProtectionType,
 					}
 				}
 			case "audio":
-				base.Role = "audio"
 				switch sd := ct.SpecData.(type) {
 				case *AACData:
 					if sd.sampleRate != 0 {
@@ -655,6 +1067,7 @@ func (a *Asset) GenCMAFCatalogEntry(namespace string, prot ProtectionType,
 			if len(ct.contentProtectionRefIDs) > 0 {
 				base.ContentProtectionRefIDs = ct.contentProtectionRefIDs
 			}
+			base.Captions = ct.captionServices()
 
 			// CMAF variant.
 			cmafTrack := base
@@ -662,6 +1075,15 @@ func (a *Asset) GenCMAFCatalogEntry(namespace string, prot ProtectionType,
 			cmafTrack.Packaging = "cmaf"
 			cmafTrack.Bitrate = &cmafBitrate
 
+			tracks = append(tracks, cmafTrack)
+			if ct.rotatesKeys() {
+				continue
+			}
+			locmafBitrate, err := calcLocmafBitrate(&ct)
+			if err != nil {
+				return nil, fmt.Errorf("could not calculate LOCMAF bitrate for track %s: %w", ct.Name, err)
+			}
+
 			// LOCMAF variant (shares the same initRef).
 			locmafTrack := base
 			locmafTrack.Name = ct.Name + LocmafTrackSuffix
@@ -669,7 +1091,7 @@ func (a *Asset) GenCMAFCatalogEntry(namespace string, prot ProtectionType,
 			locmafTrack.LocmafVersion = locmaf.Version
 			locmafTrack.Bitrate = &locmafBitrate
 
-			tracks = append(tracks, cmafTrack, locmafTrack)
+			tracks = append(tracks, locmafTrack)
 		}
 	}
 
@@ -724,11 +1146,11 @@ func (a *Asset) GenCMAFCatalogEntry(namespace string, prot ProtectionType,
 	switch prot {
 	case ProtectionDRM:
 		if a.Drm != nil {
-			cat.ContentProtections = a.Drm.ContentProtections
+			cat.ContentProtections = a.Drm.ActiveContentProtections(uint64(generatedAtMS) / MoqGroupDurMS)
 		}
 	case ProtectionECCP:
 		if a.Eccp != nil {
-			cat.ContentProtections = a.Eccp.ContentProtections
+			cat.ContentProtections = a.Eccp.ActiveContentProtections(uint64(generatedAtMS) / MoqGroupDurMS)
 		}
 	}
 	return cat, nil
@@ -795,12 +1217,14 @@ func (a *Asset) GenLOCCatalogEntry(generatedAtMS int64) (*Catalog, error) {
 				Codec:       codec,
 				Bitrate:     Ptr(calcLOCBitrate(&ct)),
 				Language:    ct.Language,
+				Role:        ct.catalogRole(),
+				Label:       ct.Label,
 			}
 
 			switch ct.ContentType {
 			case "video":
-				track.Role = "video"
 				track.Framerate = Ptr(frameRate)
+				track.Captions = ct.captionServices()
 				switch sd := ct.SpecData.(type) {
 				case *AVCData:
 					if sd.width != 0 {
@@ -825,7 +1249,6 @@ func (a *Asset) GenLOCCatalogEntry(generatedAtMS int64) (*Catalog, error) {
 					}
 				}
 			case "audio":
-				track.Role = "audio"
 				switch sd := ct.SpecData.(type) {
 				case *AACData:
 					if sd.sampleRate != 0 {
@@ -991,7 +1414,7 @@ func (t *ContentTrack) GenCMAFChunk(chunkNr uint32, startNr, endNr uint64) ([]by
 	}
 
 	if len(t.contentProtectionRefIDs) > 0 {
-		encrypted, err := t.encryptFragment(sw.Bytes())
+		encrypted, err := t.encryptFragment(sw.Bytes(), startNr, true)
 		if err != nil {
 			return nil, err
 		}
@@ -1026,7 +1449,12 @@ func (t *ContentTrack) GenLocmafChunk(chunkNr uint32, startNr, endNr uint64,
 	}
 
 	if len(t.contentProtectionRefIDs) > 0 {
-		f, err = t.encryptFragment(sw.Bytes())
+		// LOCMAF headers cannot carry sample group boxes, so only the
+		// default key signaled in the init segment can be used.
+		if t.rotatesKeys() {
+			return nil, fmt.Errorf("track %s has key rotation, which LOCMAF cannot signal", t.Name)
+		}
+		f, err = t.encryptFragment(sw.Bytes(), startNr, false)
 		if err != nil {
 			return nil, err
 		}
@@ -1048,6 +1476,10 @@ func (t *ContentTrack) createFragment(chunkNr uint32, startNr, endNr uint64) (*m
 	for sampleNr := startNr; sampleNr < endNr; sampleNr++ {
 		startTime, origNr := t.CalcSample(uint64(sampleNr))
 		orig := t.Samples[origNr]
+		data, err := t.SampleData(sampleNr)
+		if err != nil {
+			return nil, err
+		}
 		// Use the source sample's actual duration. For uniform-duration
 		// sources this equals t.SampleDur; for a source with a short
 		// trailing sample (which we no longer ship, but defensively support)
@@ -1058,10 +1490,10 @@ func (t *ContentTrack) createFragment(chunkNr uint32, startNr, endNr uint64) (*m
 			Sample: mp4.Sample{
 				Flags: orig.Flags,
 				Dur:   orig.Dur,
-				Size:  uint32(len(orig.Data)),
+				Size:  uint32(len(data)),
 			},
 			DecodeTime: startTime,
-			Data:       orig.Data,
+			Data:       data,
 		}
 		f.AddFullSample(fs)
 	}
@@ -1091,10 +1523,77 @@ func (t *ContentTrack) CalcSample(nr uint64) (startTime, origNr uint64) {
 	return startTime, origNr
 }
 
+// captionServices returns the catalog caption entries for the track, or nil
+// if it carries no captions.
+func (t *ContentTrack) captionServices() []CaptionService {
+	if !t.captions.Enabled() {
+		return nil
+	}
+	return []CaptionService{{
+		Type:     "cta608",
+		Channel:  fmt.Sprintf("CC%d", t.captions.Channel()),
+		Language: t.captions.Lang(),
+	}}
+}
+
+// SampleData returns the payload of output sample nr: the looped source
+// sample, with a CTA-608 caption SEI spliced in front of its first VCL NAL
+// unit when captions are enabled for the track (see Asset.EnableCC608).
+func (t *ContentTrack) SampleData(nr uint64) ([]byte, error) {
+	_, origNr := t.CalcSample(nr)
+	data := t.Samples[origNr].Data
+	sei := t.captionSEI(nr)
+	if sei == nil {
+		return data, nil
+	}
+	codec, _ := cc608.CodecFor(t.SpecData.Codec())
+	spliced, err := cc608.SpliceSEIBeforeVCL(data, sei, codec)
+	if err != nil {
+		return nil, fmt.Errorf("splice caption SEI into sample %d of track %s: %w", nr, t.Name, err)
+	}
+	return spliced, nil
+}
+
+// captionSEI returns the caption SEI NAL unit for output sample nr, or nil if
+// the track has no captions. The SEI schedule is built once per MoQ group
+// (one pop-on caption per group) and cached on the track.
+func (t *ContentTrack) captionSEI(nr uint64) []byte {
+	if !t.captions.Enabled() || t.ccCache == nil || t.TimeScale == 0 || t.SampleDur == 0 {
+		return nil
+	}
+	codec, ok := cc608.CodecFor(t.SpecData.Codec())
+	if !ok {
+		return nil
+	}
+	groupNr := nr * uint64(t.SampleDur) * 1000 / (uint64(t.TimeScale) * MoqGroupDurMS)
+	startNr, endNr := calcMoQGroup(t, groupNr, MoqGroupDurMS)
+	// Sample boundaries need not coincide with group boundaries; move to the
+	// group whose sample range actually contains nr.
+	for nr < startNr && groupNr > 0 {
+		groupNr--
+		startNr, endNr = calcMoQGroup(t, groupNr, MoqGroupDurMS)
+	}
+	for nr >= endNr {
+		groupNr++
+		startNr, endNr = calcMoQGroup(t, groupNr, MoqGroupDurMS)
+	}
+	seis := t.ccCache.schedule(groupNr, func() [][]byte {
+		fps := float64(t.TimeScale) / float64(t.SampleDur)
+		return t.captions.SEISchedule(int64(groupNr), fps, int(endNr-startNr), codec)
+	})
+	idx := nr - startNr
+	if idx >= uint64(len(seis)) {
+		return nil
+	}
+	return seis[idx]
+}
+
 // encryptFragment encrypts an encoded fragment and returns the decoded fragment.
-// mp4.EncryptFragment returns the next IV to use; we store it on the track so consecutive
-// fragments chain without IV reuse (cenc) or carry the constant IV forward (cbcs).
-func (t *ContentTrack) encryptFragment(fragmentBytes []byte) (*mp4.Fragment, error) {
+// The IVs are derived from the sample numbers by sampleIV, or are the constant IV
+// of the key for cbcs.
+// If rotate is set and the key rotates, the key of the MoQ group of sample startNr
+// is used and signaled by a seig sample group in the traf box.
+func (t *ContentTrack) encryptFragment(fragmentBytes []byte, startNr uint64, rotate bool) (*mp4.Fragment, error) {
 	bytesReader := bytes.NewReader(fragmentBytes)
 	var pos uint64 = 0
 	moofBox, err := mp4.DecodeBox(pos, bytesReader)
@@ -1119,17 +1618,184 @@ func (t *ContentTrack) encryptFragment(fragmentBytes []byte) (*mp4.Fragment, err
 	decodedFrag.AddChild(moof)
 	decodedFrag.AddChild(mdat)
 
-	if t.currentIV == nil {
-		t.currentIV = append([]byte(nil), t.cenc.iv...)
+	rotate = rotate && t.cenc.rotates()
+	ck := t.cenc.defaultKey()
+	if rotate {
+		ck = t.cenc.keyForGroup(t.sampleGroupNr(startNr))
 	}
-	nextIV, err := mp4.EncryptFragment(decodedFrag, t.cenc.key, t.currentIV, t.ipd)
-	if err != nil {
+	iv := ck.iv // cbcs uses the constant IV of the key
+	if t.ipd.Scheme != "cbcs" {
+		iv = t.sampleIV(ck.iv, startNr)
+	}
+	var plain []byte
+	if !isMp4ffScheme(t.scheme) {
+		plain = append([]byte(nil), mdat.Data...)
+	}
+	if _, err := mp4.EncryptFragment(decodedFrag, ck.key, iv, t.ipd); err != nil {
 		return nil, fmt.Errorf("unable to encrypt fragment: %w", err)
 	}
-	t.currentIV = nextIV
+	if plain != nil {
+		if err := reencryptFragment(decodedFrag, plain, t.scheme, ck.key, t.ipd); err != nil {
+			return nil, fmt.Errorf("unable to encrypt fragment with %s: %w", t.scheme, err)
+		}
+	}
+	if rotate {
+		if err := addSeigSampleGroup(moof.Traf, t.ipd, ck); err != nil {
+			return nil, fmt.Errorf("unable to signal key of fragment: %w", err)
+		}
+	}
 	return decodedFrag, nil
 }
 
+// sampleIV returns the IV of sample nr encrypted with a key whose IV is
+// keyIV, for the schemes with per-sample IVs. The IV is derived from the
+// location only, so protected objects are identical whenever and wherever
+// they are generated. Its first 8 bytes are those of keyIV plus the IV
+// prefix of the asset and track in the top 16 bits and the sample number
+// in the low 48 bits, so no IV is repeated under a key shared by several
+// tracks, also of different assets, see DRMInfo.claimIVPrefix. A 16-byte
+// IV ends with 8 zero bytes, the block counter of the sample.
+//
+// mp4.EncryptFragment increments the IV of the first sample of a fragment
+// for the following samples: 8-byte IVs by one per sample, which gives them
+// the IVs of their sample numbers, and 16-byte IVs by the number of
+// encrypted blocks, which stays below the IV of the next fragment.
+func (t *ContentTrack) sampleIV(keyIV []byte, nr uint64) []byte {
+	loc := uint64(t.ivPrefix)<<48 | nr&(1<<48-1)
+	iv := make([]byte, len(keyIV))
+	binary.BigEndian.PutUint64(iv, binary.BigEndian.Uint64(keyIV)+loc)
+	return iv
+}
+
+// trackIVPrefix returns the IV prefix of the track name of the asset
+// assetName, see sampleIV.
+func trackIVPrefix(assetName, name string) uint16 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(assetName + "/" + name))
+	sum := h.Sum32()
+	return uint16(sum>>16) ^ uint16(sum)
+}
+
+// rotatesKeys reports whether the track is protected with rotating keys.
+func (t *ContentTrack) rotatesKeys() bool {
+	return t.cenc != nil && t.cenc.rotates()
+}
+
+// sampleGroupNr returns the MoQ group number of sample nr.
+func (t *ContentTrack) sampleGroupNr(nr uint64) uint64 {
+	return nr * uint64(t.SampleDur) * 1000 / uint64(t.TimeScale) / MoqGroupDurMS
+}
+
+// addSeigSampleGroup signals the key of all samples in traf with a
+// fragment-local seig sample group description.
+func addSeigSampleGroup(traf *mp4.TrafBox, ipd *mp4.InitProtectData, ck contentKey) error {
+	tenc := ipd.Tenc
+	seig := &mp4.SeigSampleGroupEntry{
+		CryptByteBlock:  tenc.DefaultCryptByteBlock,
+		SkipByteBlock:   tenc.DefaultSkipByteBlock,
+		IsProtected:     1,
+		PerSampleIVSize: tenc.DefaultPerSampleIVSize,
+		KID:             ck.kid,
+	}
+	if seig.PerSampleIVSize == 0 {
+		seig.ConstantIV = constantIV(ck.iv)
+	}
+	sgpd := &mp4.SgpdBox{
+		Version:            1,
+		GroupingType:       "seig",
+		DefaultLength:      uint32(seig.Size()),
+		SampleGroupEntries: []mp4.SampleGroupEntry{seig},
+	}
+	sbgp := &mp4.SbgpBox{
+		GroupingType:            "seig",
+		SampleCounts:            []uint32{traf.Trun.SampleCount()},
+		GroupDescriptionIndices: []uint32{seigFragmentLocalIndex},
+	}
+	// Appended after senc, so that the saio offset stays valid.
+	if err := traf.AddChild(sbgp); err != nil {
+		return fmt.Errorf("add sbgp: %w", err)
+	}
+	if err := traf.AddChild(sgpd); err != nil {
+		return fmt.Errorf("add sgpd: %w", err)
+	}
+	return nil
+}
+
+// seigFragmentLocalIndex refers to the first sample group description
+// in the traf box (ISO/IEC 14496-12 8.9.4).
+const seigFragmentLocalIndex = 0x10001
+
+// constantIV returns iv as a 16-byte constant IV, padding 8-byte IVs with zeros.
+func constantIV(iv []byte) []byte {
+	if len(iv) == 8 {
+		return append(append([]byte(nil), iv...), make([]byte, 8)...)
+	}
+	return iv
+}
+
+// FragmentKID returns the KID signaled by a seig sample group in the
+// traf box of a CMAF chunk, or false if there is none, in which case the
+// default KID of the init segment applies.
+func FragmentKID(payload []byte) (mp4.UUID, bool) {
+	moofBox, err := mp4.DecodeBox(0, bytes.NewReader(payload))
+	if err != nil {
+		return nil, false
+	}
+	moof, ok := moofBox.(*mp4.MoofBox)
+	if !ok || moof.Traf == nil {
+		return nil, false
+	}
+	seig := trafSeig(moof.Traf)
+	if seig == nil {
+		return nil, false
+	}
+	return seig.KID, true
+}
+
+// trafSeig returns the fragment-local seig sample group entry of traf, or nil.
+func trafSeig(traf *mp4.TrafBox) *mp4.SeigSampleGroupEntry {
+	if traf.Sgpd == nil || traf.Sgpd.GroupingType != "seig" || len(traf.Sgpd.SampleGroupEntries) == 0 {
+		return nil
+	}
+	seig, _ := traf.Sgpd.SampleGroupEntries[0].(*mp4.SeigSampleGroupEntry)
+	return seig
+}
+
+// applySeig returns decryptInfo with the tenc defaults of the tracks in moof
+// replaced by their seig sample group entries, so that fragments encrypted
+// with a rotated key and IV decrypt correctly.
+func applySeig(decryptInfo mp4.DecryptInfo, moof *mp4.MoofBox) mp4.DecryptInfo {
+	var trackInfos []mp4.DecryptTrackInfo
+	for _, traf := range moof.Trafs {
+		seig := trafSeig(traf)
+		if seig == nil {
+			continue
+		}
+		if trackInfos == nil {
+			trackInfos = append([]mp4.DecryptTrackInfo(nil), decryptInfo.TrackInfos...)
+		}
+		for i, ti := range trackInfos {
+			if ti.TrackID != traf.Tfhd.TrackID || ti.Sinf == nil || ti.Sinf.Schi == nil || ti.Sinf.Schi.Tenc == nil {
+				continue
+			}
+			sinf, schi, tenc := *ti.Sinf, *ti.Sinf.Schi, *ti.Sinf.Schi.Tenc
+			tenc.DefaultKID = seig.KID
+			tenc.DefaultPerSampleIVSize = seig.PerSampleIVSize
+			if seig.PerSampleIVSize == 0 {
+				tenc.DefaultConstantIV = seig.ConstantIV
+			}
+			schi.Tenc = &tenc
+			sinf.Schi = &schi
+			trackInfos[i].Sinf = &sinf
+		}
+	}
+	if trackInfos == nil {
+		return decryptInfo
+	}
+	decryptInfo.TrackInfos = trackInfos
+	return decryptInfo
+}
+
 // DecryptInit decrypts an encoded init segment
 // and returns the decrypted encoding, the KID and decryption decryption information..
 func DecryptInit(initData []byte) ([]byte, mp4.UUID, mp4.DecryptInfo, error) {
@@ -1141,10 +1807,25 @@ func DecryptInit(initData []byte) ([]byte, mp4.UUID, mp4.DecryptInfo, error) {
 	if f.Init == nil {
 		return nil, nil, mp4.DecryptInfo{}, fmt.Errorf("no init segment in initData")
 	}
+	// mp4ff only decrypts the cenc and cbcs schemes, so cens and cbc1 are
+	// presented to it as cenc and restored in the decryption information.
+	schemes := make(map[uint32]string)
+	for _, trak := range f.Init.Moov.Traks {
+		sinf := trakSinf(trak)
+		if sinf != nil && sinf.Schm != nil && !isMp4ffScheme(sinf.Schm.SchemeType) {
+			schemes[trak.Tkhd.TrackID] = sinf.Schm.SchemeType
+			sinf.Schm.SchemeType = "cenc"
+		}
+	}
 	decryptInfo, err := mp4.DecryptInit(f.Init)
 	if err != nil {
 		return nil, nil, mp4.DecryptInfo{}, fmt.Errorf("unable to decrypt init")
 	}
+	for _, ti := range decryptInfo.TrackInfos {
+		if scheme, ok := schemes[ti.TrackID]; ok {
+			ti.Sinf.Schm.SchemeType = scheme
+		}
+	}
 
 	kid := decryptInfo.TrackInfos[0].Sinf.Schi.Tenc.DefaultKID
 	sw := bits.NewFixedSliceWriter(int(f.Init.Size()))
@@ -1156,6 +1837,8 @@ func DecryptInit(initData []byte) ([]byte, mp4.UUID, mp4.DecryptInfo, error) {
 }
 
 // DecryptFragment decrypts an enocoded fragment (moof+mdat) and returns the unencrypted encoding.
+// A seig sample group in the fragment overrides the tenc defaults, and key must then be
+// the key of its KID.
 func DecryptFragment(payload []byte, decryptInfo mp4.DecryptInfo, key mp4.UUID) ([]byte, error) {
 	bytesReader := bytes.NewReader(payload)
 	var pos uint64 = 0
@@ -1181,7 +1864,12 @@ func DecryptFragment(payload []byte, decryptInfo mp4.DecryptInfo, key mp4.UUID)
 	decodedFrag.AddChild(moof)
 	decodedFrag.AddChild(mdat)
 
-	err = mp4.DecryptFragment(decodedFrag, decryptInfo, key)
+	decryptInfo = applySeig(decryptInfo, moof)
+	if mp4ffDecryptable(decryptInfo) {
+		err = mp4.DecryptFragment(decodedFrag, decryptInfo, key)
+	} else {
+		err = decryptFragment(decodedFrag, decryptInfo, key)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to decrypt fragment: %w", err)
 	}
@@ -2,15 +2,21 @@ package internal
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/Dash-Industry-Forum/livesim2/pkg/drm"
+	"github.com/Eyevinn/go-608/carriage"
 	"github.com/Eyevinn/locmaf"
 	"github.com/Eyevinn/mp4ff/bits"
 	"github.com/Eyevinn/mp4ff/mp4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/Eyevinn/moqlivemock/internal/cc608"
 )
 
 func TestPrepareTrack(t *testing.T) {
@@ -280,7 +286,7 @@ func TestCreateProtectedTracksDoesNotMutateOriginalTrackInit(t *testing.T) {
 	drm, err := ParseCENCflags("cenc", kidStr, keyStr, ivStr, "http://localhost:8081/clearkey")
 	require.NoError(t, err)
 
-	err = createProtectedTracks(tracksByType, drm, "_drm", ProtectionDRM)
+	err = createProtectedTracks(tracksByType, "test10s", drm, "_drm", ProtectionDRM)
 	require.NoError(t, err)
 
 	origVideoAfter := tracksByType["video"][0]
@@ -351,6 +357,98 @@ func TestUnifiedCatalogSharesInitData(t *testing.T) {
 	}
 }
 
+// TestEnableCC608 verifies that captions are attached to AVC/HEVC video only,
+// advertised in both catalog flavors, and spliced into the served samples.
+func TestEnableCC608(t *testing.T) {
+	asset, err := LoadAsset("../assets/test10s", 1, 1)
+	require.NoError(t, err)
+	gen := cc608.New(cc608.Config{Enabled: true})
+	require.Equal(t, 6, asset.EnableCC608(gen), "3 AVC + 3 HEVC tracks")
+
+	cmafCat, err := asset.GenCMAFCatalogEntry("cmsf/clear", ProtectionNone, 0)
+	require.NoError(t, err)
+	locCat, err := asset.GenLOCCatalogEntry(0)
+	require.NoError(t, err)
+	want := []CaptionService{{Type: "cta608", Channel: "CC1", Language: "eng"}}
+	for _, tr := range append(cmafCat.Tracks, locCat.Tracks...) {
+		_, isCaptionCodec := cc608.CodecFor(tr.Codec)
+		if tr.Role == "video" && isCaptionCodec {
+			assert.Equal(t, want, tr.Captions, "track %s", tr.Name)
+		} else {
+			assert.Nil(t, tr.Captions, "track %s", tr.Name)
+		}
+	}
+
+	ct := asset.GetTrackByName("video_400kbps_avc")
+	require.NotNil(t, ct)
+	const groupNr = 45296
+	startNr, endNr := calcMoQGroup(ct, groupNr, MoqGroupDurMS)
+	seis := gen.SEISchedule(groupNr, 25, int(endNr-startNr), carriage.CodecAVC)
+	require.Len(t, seis, int(endNr-startNr))
+	for nr := startNr; nr < endNr; nr++ {
+		_, origNr := ct.CalcSample(nr)
+		wantData, err := cc608.SpliceSEIBeforeVCL(ct.Samples[origNr].Data, seis[nr-startNr], carriage.CodecAVC)
+		require.NoError(t, err)
+		data, err := ct.SampleData(nr)
+		require.NoError(t, err)
+		require.Equal(t, wantData, data, "sample %d", nr)
+	}
+	_, origNr := ct.CalcSample(startNr)
+	orig := ct.Samples[origNr].Data
+	ct.Samples[origNr].Data = []byte{0, 0, 0, 9, 1}
+	_, err = ct.SampleData(startNr)
+	assert.Error(t, err, "NAL unit length beyond the sample")
+	ct.Samples[origNr].Data = orig
+
+	asset.EnableCC608(nil)
+	ct = asset.GetTrackByName("video_400kbps_avc")
+	data, err := ct.SampleData(startNr)
+	require.NoError(t, err)
+	require.Equal(t, ct.Samples[origNr].Data, data)
+}
+
+// TestCC608ConcurrentGroups generates two groups of a captioned track
+// concurrently, as live publishing and FETCH can, and checks that every
+// sample carries the captions of its own group.
+func TestCC608ConcurrentGroups(t *testing.T) {
+	asset, err := LoadAsset("../assets/test10s", 1, 1)
+	require.NoError(t, err)
+	gen := cc608.New(cc608.Config{Enabled: true})
+	asset.EnableCC608(gen)
+
+	want := make(map[uint64][]byte)
+	groupNrs := []uint64{45296, 45297}
+	for _, groupNr := range groupNrs {
+		ct := asset.GetTrackByName("video_400kbps_avc")
+		startNr, endNr := calcMoQGroup(ct, groupNr, MoqGroupDurMS)
+		seis := gen.SEISchedule(int64(groupNr), 25, int(endNr-startNr), carriage.CodecAVC)
+		for nr := startNr; nr < endNr; nr++ {
+			_, origNr := ct.CalcSample(nr)
+			want[nr], err = cc608.SpliceSEIBeforeVCL(ct.Samples[origNr].Data, seis[nr-startNr], carriage.CodecAVC)
+			require.NoError(t, err)
+		}
+	}
+
+	// The goroutines share a track, like the sources of FETCH do.
+	ct := asset.GetTrackByName("video_400kbps_avc")
+	var wg sync.WaitGroup
+	for _, groupNr := range groupNrs {
+		for range 4 {
+			wg.Go(func() {
+				startNr, endNr := calcMoQGroup(ct, groupNr, MoqGroupDurMS)
+				for range 10 {
+					for nr := startNr; nr < endNr; nr++ {
+						data, err := ct.SampleData(nr)
+						assert.NoError(t, err)
+						assert.Equal(t, want[nr], data, "sample %d", nr)
+					}
+				}
+			})
+		}
+	}
+	wg.Wait()
+}
+
 func TestGen20sCMAFStreams(t *testing.T) {
 	asset, err := LoadAsset("../assets/test10s", 1, 1)
 	require.NoError(t, err)
@@ -418,14 +516,300 @@ func TestClearKeyDecryptionMatchExcatly(t *testing.T) {
 	kidStr := "39112233445566778899aabbccddeeff"
 	keyStr := "40112233445566778899aabbccddeeff"
 	ivStr := "41112233445566778899aabbccddeeff"
-	schemes := []string{"cbcs", "cenc"}
-	for _, scheme := range schemes {
+	for _, scheme := range Schemes {
 		eccp, err := ParseCENCflags(scheme, kidStr, keyStr, ivStr, "http://localhost:8081/clearkey")
 		require.NoError(t, err)
 		checkDecryptedTracksMatchExactly(t, eccp, "_eccp")
 	}
 }
 
+func TestKeyRotationSchedule(t *testing.T) {
+	kidStr := "391122334455667788990000000000ff"
+	eccp, err := ParseCENCflags("cenc", kidStr, "", "41112233445566778899aabbccddeeff",
+		"http://localhost:8081/clearkey")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), eccp.RotationGroups())
+	require.NoError(t, eccp.GenerateRotationKeys(3))
+	require.Equal(t, uint64(0), eccp.RotationGroups(), "rotation must be enabled explicitly")
+	require.NoError(t, eccp.SetKeyRotation(2))
+	require.Equal(t, uint64(2), eccp.RotationGroups())
+
+	kids := []string{
+		"39112233-4455-6677-8899-0000000000ff",
+		"39112233-4455-6677-8899-000000000100",
+		"39112233-4455-6677-8899-000000000101",
+	}
+	for i, ck := range eccp.tiers[0].cenc.keys {
+		assert.Equal(t, kids[i], ck.kid.String())
+		assert.Equal(t, []byte(ck.kid), ck.key, "keys equal to their kids stay so")
+	}
+	for groupNr, want := range []int{0, 0, 1, 1, 2, 2, 0, 0} {
+		assert.Equal(t, want, eccp.tiers[0].cenc.keyIndex(uint64(groupNr)), "group %d", groupNr)
+	}
+
+	cps := eccp.ActiveContentProtections(1)
+	require.Len(t, cps, 1)
+	assert.Equal(t, kids[:2], cps[0].DefaultKIDs)
+	cps = eccp.ActiveContentProtections(5)
+	assert.Equal(t, []string{kids[2], kids[0]}, cps[0].DefaultKIDs)
+	assert.NotEqual(t, eccp.ContentProtections[0].DRMSystem.Pssh, cps[0].DRMSystem.Pssh)
+	assert.Equal(t, []string{kids[0]}, eccp.ContentProtections[0].DefaultKIDs, "configured protections are unchanged")
+
+	assert.Equal(t, []byte{0x01, 0x00, 0x00}, addToKey([]byte{0x00, 0xff, 0xff}, 1))
+	assert.Equal(t, []byte{0x00, 0x02, 0x01}, addToKey([]byte{0x00, 0x01, 0x02}, 0xff))
+}
+
+func TestKeyRotationDecryption(t *testing.T) {
+	kidStr := "39112233445566778899aabbccddeeff"
+	keyStr := "40112233445566778899aabbccddeeff"
+	ivStr := "41112233445566778899aabbccddeeff"
+	for _, scheme := range Schemes {
+		t.Run(scheme, func(t *testing.T) {
+			eccp, err := ParseCENCflags(scheme, kidStr, keyStr, ivStr, "http://localhost:8081/clearkey")
+			require.NoError(t, err)
+			require.NoError(t, eccp.GenerateRotationKeys(2))
+			// A rotated key may come with its own IV, as in CPIX.
+			eccp.tiers[0].cenc.keys[1].iv = addToKey(eccp.tiers[0].cenc.keys[1].iv, 1)
+			require.NoError(t, eccp.SetKeyRotation(1))
+			asset, err := LoadAssetWithProtection("../assets/test10s", 1, 1, nil, eccp)
+			require.NoError(t, err)
+
+			for _, name := range []string{"video_400kbps_avc", "audio_monotonic_128kbps_aac"} {
+				clear := asset.GetTrackByName(name)
+				protected := asset.GetTrackByName(name + "_eccp")
+				require.NotNil(t, clear)
+				require.NotNil(t, protected)
+				initData, err := protected.SpecData.GenCMAFInitData()
+				require.NoError(t, err)
+				_, defaultKID, ipd, err := DecryptInit(initData)
+				require.NoError(t, err)
+				require.Equal(t, eccp.tiers[0].cenc.keys[0].kid, defaultKID)
+
+				// The first sample of groups 0 and 1 are encrypted with key 0 and 1.
+				for groupNr, ck := range eccp.tiers[0].cenc.keys {
+					groupStart := uint64(groupNr) * MoqGroupDurMS * uint64(protected.TimeScale) / 1000
+					nr := (groupStart + uint64(protected.SampleDur) - 1) / uint64(protected.SampleDur)
+					want, err := clear.GenCMAFChunk(0, nr, nr+1)
+					require.NoError(t, err)
+					chunk, err := protected.GenCMAFChunk(0, nr, nr+1)
+					require.NoError(t, err)
+					kid, ok := FragmentKID(chunk)
+					require.True(t, ok)
+					require.Equal(t, ck.kid, kid)
+
+					dec, err := DecryptFragment(chunk, ipd, ck.key)
+					require.NoError(t, err)
+					require.Equal(t, mdatPayload(t, want), mdatPayload(t, dec), "%s group %d", name, groupNr)
+
+					otherKey := eccp.tiers[0].cenc.keys[1-groupNr].key
+					dec, err = DecryptFragment(chunk, ipd, otherKey)
+					require.NoError(t, err)
+					require.NotEqual(t, mdatPayload(t, want), mdatPayload(t, dec), "%s group %d", name, groupNr)
+				}
+			}
+
+			// LOCMAF cannot signal the key changes, so there are no LOCMAF variants.
+			cat, err := asset.GenCMAFCatalogEntry("cmsf/eccp-"+scheme, ProtectionECCP, 0)
+			require.NoError(t, err)
+			for _, tr := range cat.Tracks {
+				assert.NotEqual(t, "locmaf", tr.Packaging, tr.Name)
+			}
+			_, err = asset.GetTrackByName("video_400kbps_avc_eccp").GenLocmafChunk(0, 0, 1, locmaf.NewState())
+			require.ErrorContains(t, err, "key rotation")
+		})
+	}
+}
+
+func TestCPIXKeyRotation(t *testing.T) {
+	kid := func(i byte) mp4.UUID {
+		return mp4.UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, i}
+	}
+	pssh := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	widevine := drmSystemIDs["widevine"]
+	cpix := drm.CPIXData{
+		ContentKeys: []drm.ContentKey{
+			{KeyID: kid(1), Key: []byte("key-000000000001")},
+			{KeyID: kid(2), Key: []byte("key-000000000002")},
+		},
+		DRMSystems: []drm.DRMSystem{
+			{KeyID: kid(1), SystemID: widevine, PSSH: pssh("pssh1")},
+			{KeyID: kid(2), SystemID: widevine, PSSH: pssh("pssh2")},
+		},
+	}
+	tiers, err := cpixKeyTiers(cpix)
+	require.NoError(t, err)
+	tiers[0].refIDs = []string{"1"}
+	d := &DRMInfo{
+		ContentProtections: []ContentProtection{
+			{RefID: "1", DefaultKIDs: []string{kid(1).String()}, DRMSystem: &DRMSystem{SystemID: widevine}},
+		},
+		tiers: tiers,
+	}
+	require.NoError(t, d.SetKeyRotation(1))
+
+	// The PSSHs of the current and the next key are signaled.
+	cps := d.ActiveContentProtections(0)
+	require.Len(t, cps, 1)
+	assert.Equal(t, pssh("pssh1pssh2"), cps[0].DRMSystem.Pssh)
+	cps = d.ActiveContentProtections(1)
+	assert.Equal(t, pssh("pssh2pssh1"), cps[0].DRMSystem.Pssh)
+	assert.Equal(t, []string{kid(2).String(), kid(1).String()}, cps[0].DefaultKIDs)
+
+	// Keys without a PSSH cannot rotate.
+	delete(tiers[0].cenc.keys[1].psshs, widevine)
+	require.ErrorContains(t, d.SetKeyRotation(1), "no valid PSSH")
+}
+
+// mdatPayload returns the mdat payload of an encoded fragment.
+func mdatPayload(t *testing.T, fragment []byte) []byte {
+	t.Helper()
+	f, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(fragment))
+	require.NoError(t, err)
+	require.Len(t, f.Segments, 1)
+	return f.Segments[0].Fragments[0].Mdat.Data
+}
+
+func TestTrackTypeMatches(t *testing.T) {
+	cases := []struct {
+		trackType   string
+		contentType string
+		height      int
+		want        bool
+	}{
+		{"", "audio", 0, true},
+		{"audio", "audio", 0, true},
+		{"audio", "video", 720, false},
+		{"video", "video", 2160, true},
+		{"sd", "video", 576, true},
+		{"sd", "video", 720, false},
+		{"sd", "audio", 0, false},
+		{"hd", "video", 720, true},
+		{"hd", "video", 1080, true},
+		{"hd", "video", 1440, false},
+		{"uhd", "video", 1440, true},
+		{"uhd1", "video", 2160, true},
+		{"uhd1", "video", 4320, false},
+		{"uhd2", "video", 4320, true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, trackTypeMatches(c.trackType, c.contentType, c.height),
+			"%q %s %d", c.trackType, c.contentType, c.height)
+	}
+}
+
+func TestKeyTiers(t *testing.T) {
+	kidStr := "39112233445566778899aabbccddeeff"
+	keyStr := "40112233445566778899aabbccddeeff"
+	ivStr := "41112233445566778899aabbccddeeff"
+	eccp, err := ParseCENCflags("cenc", kidStr, keyStr, ivStr, "http://localhost:8081/clearkey")
+	require.NoError(t, err)
+	require.Error(t, eccp.SetKeyTiers([]string{"audio", "4k"}))
+	require.NoError(t, eccp.SetKeyTiers([]string{"audio", "sd", "hd"}))
+	require.Len(t, eccp.tiers, 3)
+	require.Len(t, eccp.ContentProtections, 3)
+	wantKIDs := []string{
+		"39112233-4455-6677-8899-aabbccddeeff",
+		"39112233-4455-6678-8899-aabbccddeeff",
+		"39112233-4455-6679-8899-aabbccddeeff",
+	}
+	for i, cp := range eccp.ContentProtections {
+		assert.Equal(t, []string{wantKIDs[i]}, cp.DefaultKIDs)
+		assert.Equal(t, []string{cp.RefID}, eccp.tiers[i].refIDs)
+	}
+	hdKey, ok := eccp.ClearKey(eccp.tiers[2].cenc.defaultKey().kid)
+	require.True(t, ok)
+	assert.Equal(t, "40112233445566798899aabbccddeeff", hex.EncodeToString(hdKey))
+	_, ok = eccp.ClearKey(mustUnpackKey(t, "00112233445566778899aabbccddeeff"))
+	assert.False(t, ok)
+
+	asset, err := LoadAssetWithProtection("../assets/test10s", 1, 1, nil, eccp)
+	require.NoError(t, err)
+	cat, err := asset.GenCMAFCatalogEntry("cmsf/eccp-cenc", ProtectionECCP, 0)
+	require.NoError(t, err)
+	assert.Equal(t, eccp.ContentProtections, cat.ContentProtections)
+	for _, tr := range cat.Tracks {
+		if len(tr.ContentProtectionRefIDs) == 0 {
+			continue
+		}
+		// All test10s video is 720p, so the sd tier is unused.
+		want := eccp.tiers[2].refIDs
+		if tr.Role == "audio" {
+			want = eccp.tiers[0].refIDs
+		}
+		assert.Equal(t, want, tr.ContentProtectionRefIDs, tr.Name)
+	}
+
+	for tierNr, name := range map[int]string{0: "audio_monotonic_128kbps_aac_eccp", 2: "video_400kbps_avc_eccp"} {
+		protected := asset.GetTrackByName(name)
+		require.NotNil(t, protected)
+		initData, err := protected.SpecData.GenCMAFInitData()
+		require.NoError(t, err)
+		_, kid, ipd, err := DecryptInit(initData)
+		require.NoError(t, err)
+		require.Equal(t, eccp.tiers[tierNr].cenc.defaultKey().kid, kid)
+		chunk, err := protected.GenCMAFChunk(0, 0, 1)
+		require.NoError(t, err)
+		want, err := asset.GetTrackByName(protected.Name[:len(protected.Name)-len("_eccp")]).GenCMAFChunk(0, 0, 1)
+		require.NoError(t, err)
+		dec, err := DecryptFragment(chunk, ipd, eccp.tiers[tierNr].cenc.defaultKey().key)
+		require.NoError(t, err)
+		require.Equal(t, mdatPayload(t, want), mdatPayload(t, dec), name)
+	}
+
+	// Tracks must match a tier.
+	eccp, err = ParseCENCflags("cenc", kidStr, keyStr, ivStr, "http://localhost:8081/clearkey")
+	require.NoError(t, err)
+	require.NoError(t, eccp.SetKeyTiers([]string{"video"}))
+	_, err = LoadAssetWithProtection("../assets/test10s", 1, 1, nil, eccp)
+	require.ErrorContains(t, err, "no content key for audio track")
+}
+
+func TestCPIXKeyTiers(t *testing.T) {
+	kid := func(i byte) mp4.UUID {
+		return mp4.UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, i}
+	}
+	cpix := drm.CPIXData{
+		ContentKeys: []drm.ContentKey{
+			{KeyID: kid(1), Key: []byte("audio-key-000001")},
+			{KeyID: kid(2), Key: []byte("hd-key-000000002")},
+			{KeyID: kid(3), Key: []byte("hd-key-000000003")},
+			{KeyID: kid(4), Key: []byte("unused-key-00004")},
+		},
+		UsageRules: []drm.ContentKeyUsageRule{
+			{KeyID: kid(2), IntendedTrackType: "HD"},
+			{KeyID: kid(1), IntendedTrackType: "AUDIO"},
+			{KeyID: kid(3), IntendedTrackType: "HD"},
+		},
+	}
+	tiers, err := cpixKeyTiers(cpix)
+	require.NoError(t, err)
+	require.Len(t, tiers, 2)
+	assert.Equal(t, "hd", tiers[0].trackType)
+	require.Len(t, tiers[0].cenc.keys, 2, "keys of one track type rotate")
+	assert.Equal(t, kid(3), tiers[0].cenc.keys[1].kid)
+	assert.Equal(t, "audio", tiers[1].trackType)
+	assert.Equal(t, []byte("audio-key-000001"), tiers[1].cenc.defaultKey().key)
+
+	cpix.UsageRules = append(cpix.UsageRules, drm.ContentKeyUsageRule{KeyID: kid(4), IntendedTrackType: "8K"})
+	_, err = cpixKeyTiers(cpix)
+	require.ErrorContains(t, err, "unsupported intended track type")
+
+	cpix.UsageRules = nil
+	tiers, err = cpixKeyTiers(cpix)
+	require.NoError(t, err)
+	require.Len(t, tiers, 1)
+	assert.Equal(t, "", tiers[0].trackType)
+	assert.Len(t, tiers[0].cenc.keys, 4)
+}
+
+// mustUnpackKey unpacks a hex or base64 key, failing the test on error.
+func mustUnpackKey(t *testing.T, key string) mp4.UUID {
+	t.Helper()
+	k, err := mp4.UnpackKey(key)
+	require.NoError(t, err)
+	return k
+}
+
 func TestCommercialDRMDecryptionMatchExactly(t *testing.T) {
 	drm, err := ConfigureDRMFromFile("../assets/testdrm/drm_config_test.json")
 	require.NoError(t, err)
@@ -566,7 +950,7 @@ func checkDecryptedTracksMatchExactly(t *testing.T, drm *DRMInfo, suffix string)
 							sw = bits.NewFixedSliceWriter(int(frag.Size()))
 							err = frag.EncodeSW(sw)
 							require.NoError(t, err)
-							decPayload, err := DecryptFragment(sw.Bytes(), ipd, drm.cenc.key)
+							decPayload, err := DecryptFragment(sw.Bytes(), ipd, tr.cenc.defaultKey().key)
 							require.NoError(t, err)
 
 							fsr := bits.NewFixedSliceReader(decPayload)
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -222,3 +223,30 @@ func TestGenCMAFChunkWithBatch(t *testing.T) {
 		})
 	}
 }
+
+func TestSetSampleBatch(t *testing.T) {
+	asset, err := LoadAsset("../assets/test10s", 2, 1)
+	require.NoError(t, err)
+	ct := asset.GetTrackByName("video_400kbps_avc")
+	require.NotNil(t, ct)
+	require.Equal(t, 1, ct.CurrentSampleBatch())
+
+	require.Equal(t, 9, asset.SetSampleBatch("video", 5, 10), "all video tracks")
+	// GetTrackByName returns a copy, which shares the batch history.
+	require.Equal(t, 5, ct.CurrentSampleBatch())
+	require.Equal(t, 1, ct.SampleBatch)
+	require.Equal(t, 2, asset.GetTrackByName("audio_monotonic_128kbps_aac").CurrentSampleBatch())
+
+	// Earlier groups keep the batch they were published with.
+	require.Equal(t, 1, ct.SampleBatchAt(9))
+	require.Equal(t, 5, ct.SampleBatchAt(10))
+	asset.SetSampleBatch("video", 25, 20)
+	asset.SetSampleBatch("video", 3, 15)
+	assert.Equal(t, []int{1, 5, 3, 3}, []int{ct.SampleBatchAt(9), ct.SampleBatchAt(14), ct.SampleBatchAt(15),
+		ct.SampleBatchAt(20)}, "a change replaces the changes of later groups")
+	assert.Equal(t, 3, ct.CurrentSampleBatch())
+
+	group, err := GenMoQGroup(ct, 10, ct.SampleBatchAt(10), MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	require.Len(t, group.MoQObjects, 5)
+}
@@ -69,6 +69,121 @@ type InitData struct {
 	Data string `json:"data"`
 }
 
+// Delta operation types (draft-ietf-moq-msf-01 Section 5.1.6).
+const (
+	DeltaOpAdd    = "add"
+	DeltaOpRemove = "remove"
+	DeltaOpClone  = "clone"
+)
+
+// IsDelta reports whether the catalog is a delta update rather than a full
+// catalog.
+func (c *Catalog) IsDelta() bool {
+	return len(c.DeltaUpdate) > 0
+}
+
+// ApplyDelta applies the operations of the delta update delta, in order, to
+// a copy of c and returns the resulting full catalog. c is not modified.
+// An "add" of an existing track name, a "remove" of a missing track and a
+// "clone" of a missing parent are errors. A cloned track is a copy of its
+// parent with the fields present in the clone entry overriding the parent's.
+func (c *Catalog) ApplyDelta(delta *Catalog) (*Catalog, error) {
+	if !delta.IsDelta() {
+		return nil, fmt.Errorf("catalog is not a delta update")
+	}
+	out := *c
+	out.DeltaUpdate = nil
+	out.Tracks = append([]Track(nil), c.Tracks...)
+	if delta.GeneratedAt != nil {
+		out.GeneratedAt = delta.GeneratedAt
+	}
+	if delta.IsComplete {
+		out.IsComplete = true
+	}
+	for _, op := range delta.DeltaUpdate {
+		for _, t := range op.Tracks {
+			switch op.Op {
+			case DeltaOpAdd:
+				if out.trackIndex(t.Name) >= 0 {
+					return nil, fmt.Errorf("add: track %q already exists", t.Name)
+				}
+				out.Tracks = append(out.Tracks, t)
+			case DeltaOpRemove:
+				idx := out.trackIndex(t.Name)
+				if idx < 0 {
+					return nil, fmt.Errorf("remove: track %q does not exist", t.Name)
+				}
+				out.Tracks = append(out.Tracks[:idx], out.Tracks[idx+1:]...)
+			case DeltaOpClone:
+				idx := out.trackIndex(t.ParentName)
+				if idx < 0 {
+					return nil, fmt.Errorf("clone: parent track %q does not exist", t.ParentName)
+				}
+				if out.trackIndex(t.Name) >= 0 {
+					return nil, fmt.Errorf("clone: track %q already exists", t.Name)
+				}
+				clone, err := cloneTrack(out.Tracks[idx], t)
+				if err != nil {
+					return nil, fmt.Errorf("clone: %w", err)
+				}
+				out.Tracks = append(out.Tracks, clone)
+			default:
+				return nil, fmt.Errorf("unknown delta operation %q", op.Op)
+			}
+		}
+	}
+	return &out, nil
+}
+
+// trackIndex returns the index of the track with the given name, or -1.
+func (c *Catalog) trackIndex(name string) int {
+	for i := range c.Tracks {
+		if c.Tracks[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// cloneTrack returns parent with every field set in the clone entry
+// overriding the parent's value. The merge is done on the JSON
+// representation so that only fields actually set in the entry count.
+func cloneTrack(parent, entry Track) (Track, error) {
+	fields := map[string]json.RawMessage{}
+	parentJSON, err := json.Marshal(parent)
+	if err != nil {
+		return Track{}, err
+	}
+	if err := json.Unmarshal(parentJSON, &fields); err != nil {
+		return Track{}, err
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return Track{}, err
+	}
+	overrides := map[string]json.RawMessage{}
+	if err := json.Unmarshal(entryJSON, &overrides); err != nil {
+		return Track{}, err
+	}
+	for k, v := range overrides {
+		// Track has a few non-omitempty fields (packaging, isLive); their
+		// zero values mean "not set" in a clone entry.
+		if k == "parentName" || string(v) == `""` || string(v) == "false" {
+			continue
+		}
+		fields[k] = v
+	}
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return Track{}, err
+	}
+	var clone Track
+	if err := json.Unmarshal(merged, &clone); err != nil {
+		return Track{}, err
+	}
+	return clone, nil
+}
+
 func (c *Catalog) GetTrackByName(name string) *Track {
 	for _, track := range c.Tracks {
 		if track.Name == name {
@@ -248,6 +363,22 @@ type Track struct {
 	// The ID is used as a key in the root-level field ContentProtection.
 	// Optional field at the track level, only used when the track is protected.
 	ContentProtectionRefIDs []string `json:"contentProtectionRefIDs,omitempty"`
+
+	// Captions lists closed-caption services carried in-band in the video
+	// bitstream (e.g. CTA-608 in SEI NAL units). Not defined by MSF draft-01;
+	// used by moqlivemock to advertise the caption channel and language.
+	// Optional field at the track level, only used for video tracks.
+	Captions []CaptionService `json:"captions,omitempty"`
+}
+
+// CaptionService describes one in-band closed-caption service of a video track.
+type CaptionService struct {
+	// Type is the caption format, currently only "cta608".
+	Type string `json:"type"`
+	// Channel is the caption channel, e.g. "CC1".
+	Channel string `json:"channel"`
+	// Language is the language of the caption service.
+	Language string `json:"lang,omitempty"`
 }
 
 // ContentProtection contains all information needed to create DRM request
@@ -69,3 +69,77 @@ func TestCatalogStringEmpty(t *testing.T) {
 	s := cat.String()
 	assert.True(t, strings.Contains(s, `"version": "draft-01"`))
 }
+
+func TestCatalogApplyDelta(t *testing.T) {
+	bitrate := 400000
+	cat := &Catalog{
+		Version: "draft-01",
+		Tracks: []Track{
+			{Name: "video_400kbps_avc", Role: "video", Codec: "avc1.64001e", Bitrate: &bitrate, Packaging: "cmaf"},
+			{Name: "audio_128kbps_aac", Role: "audio", Codec: "mp4a.40.2", Packaging: "cmaf"},
+		},
+	}
+
+	t.Run("add remove clone", func(t *testing.T) {
+		delta := &Catalog{
+			DeltaUpdate: []DeltaOperation{
+				{Op: DeltaOpRemove, Tracks: []Track{{Name: "audio_128kbps_aac"}}},
+				{Op: DeltaOpAdd, Tracks: []Track{{Name: "audio_64kbps_opus", Role: "audio", Codec: "opus"}}},
+				{Op: DeltaOpClone, Tracks: []Track{
+					{Name: "video_clone", ParentName: "video_400kbps_avc", Label: "clone"}}},
+			},
+		}
+		require.True(t, delta.IsDelta())
+		out, err := cat.ApplyDelta(delta)
+		require.NoError(t, err)
+		require.Len(t, out.Tracks, 3)
+		assert.Nil(t, out.GetTrackByName("audio_128kbps_aac"))
+		assert.NotNil(t, out.GetTrackByName("audio_64kbps_opus"))
+
+		clone := out.GetTrackByName("video_clone")
+		require.NotNil(t, clone)
+		assert.Equal(t, "clone", clone.Label)
+		assert.Equal(t, "avc1.64001e", clone.Codec)
+		assert.Equal(t, "cmaf", clone.Packaging)
+		require.NotNil(t, clone.Bitrate)
+		assert.Equal(t, bitrate, *clone.Bitrate)
+		assert.Empty(t, clone.ParentName)
+		assert.False(t, out.IsDelta())
+
+		// The original catalog is unchanged.
+		assert.Len(t, cat.Tracks, 2)
+		assert.NotNil(t, cat.GetTrackByName("audio_128kbps_aac"))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cases := []struct {
+			name string
+			op   DeltaOperation
+		}{
+			{"add existing", DeltaOperation{Op: DeltaOpAdd, Tracks: []Track{{Name: "video_400kbps_avc"}}}},
+			{"remove missing", DeltaOperation{Op: DeltaOpRemove, Tracks: []Track{{Name: "nonexistent"}}}},
+			{"clone missing parent", DeltaOperation{Op: DeltaOpClone,
+				Tracks: []Track{{Name: "x", ParentName: "nonexistent"}}}},
+			{"unknown op", DeltaOperation{Op: "replace", Tracks: []Track{{Name: "x"}}}},
+		}
+		for _, c := range cases {
+			_, err := cat.ApplyDelta(&Catalog{DeltaUpdate: []DeltaOperation{c.op}})
+			assert.Error(t, err, c.name)
+		}
+		_, err := cat.ApplyDelta(&Catalog{})
+		assert.Error(t, err, "full catalog is not a delta")
+	})
+
+	t.Run("json round trip", func(t *testing.T) {
+		data, err := json.Marshal(&Catalog{Version: "draft-01", DeltaUpdate: []DeltaOperation{
+			{Op: DeltaOpRemove, Tracks: []Track{{Name: "video_400kbps_avc"}}},
+		}})
+		require.NoError(t, err)
+		assert.True(t, strings.Contains(string(data), `"deltaUpdate"`))
+		var delta Catalog
+		require.NoError(t, json.Unmarshal(data, &delta))
+		out, err := cat.ApplyDelta(&delta)
+		require.NoError(t, err)
+		assert.Len(t, out.Tracks, 1)
+	})
+}
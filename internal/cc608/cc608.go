@@ -31,23 +31,19 @@ const (
 	captionRowGroup = 14 // yellow: "GRP <n>"
 )
 
-// defaultChannel is CC1 (the primary field-1 caption service). go-608's
-// BuildUnitCues always serializes CC1 on field 1, so this is currently a
-// descriptive setting (used by the catalog/consumer, not by SEISchedule).
-const defaultChannel = 1
+// channel is CC1 (the primary field-1 caption service). go-608's
+// BuildUnitCues always serializes CC1 on field 1, so it is the only channel
+// that can be advertised.
+const channel = 1
 
 // defaultLang is the BCP-47-ish language tag advertised for the caption service.
 const defaultLang = "eng"
 
 // Config configures a caption Generator. The zero value (Enabled false) yields a
-// disabled generator; Channel and Lang default to CC1/"eng" when left zero/empty,
-// and Content defaults to DefaultContent when nil.
+// disabled generator; Lang defaults to "eng" when left empty, and Content defaults to DefaultContent when nil.
 type Config struct {
 	// Enabled turns caption generation on. A disabled Generator returns no SEI.
 	Enabled bool
-	// Channel is the CEA-608 caption channel (1 == CC1). Informational: go-608
-	// emits CC1 on field 1 regardless.
-	Channel int
 	// Lang is the caption language tag advertised in the catalog (e.g. "eng").
 	Lang string
 	// Content formats each cue's lines. Nil selects DefaultContent.
@@ -59,29 +55,23 @@ type Config struct {
 // hold a possibly-nil *Generator to mean "captions off" without a branch.
 type Generator struct {
 	enabled bool
-	channel int
 	lang    string
 	content generate.CueContentFunc
 }
 
-// New returns a Generator from cfg, filling in the CC1/"eng"/DefaultContent
+// New returns a Generator from cfg, filling in the "eng"/DefaultContent
 // defaults for any zero-valued setting.
 func New(cfg Config) *Generator {
 	content := cfg.Content
 	if content == nil {
 		content = DefaultContent
 	}
-	channel := cfg.Channel
-	if channel == 0 {
-		channel = defaultChannel
-	}
 	lang := cfg.Lang
 	if lang == "" {
 		lang = defaultLang
 	}
 	return &Generator{
 		enabled: cfg.Enabled,
-		channel: channel,
 		lang:    lang,
 		content: content,
 	}
@@ -91,8 +81,8 @@ func New(cfg Config) *Generator {
 // disabled Generator.
 func (g *Generator) Enabled() bool { return g != nil && g.enabled }
 
-// Channel returns the CEA-608 caption channel (1 == CC1).
-func (g *Generator) Channel() int { return g.channel }
+// Channel returns the CEA-608 caption channel, which is always 1 (CC1).
+func (g *Generator) Channel() int { return channel }
 
 // Lang returns the advertised caption language tag.
 func (g *Generator) Lang() string { return g.lang }
@@ -137,7 +137,7 @@ func TestSEIScheduleDisabled(t *testing.T) {
 	}
 }
 
-// TestNewDefaults checks the CC1/"eng"/DefaultContent defaults and accessors.
+// TestNewDefaults checks the CC1 channel, the "eng"/DefaultContent defaults and accessors.
 func TestNewDefaults(t *testing.T) {
 	g := New(Config{Enabled: true})
 	if !g.Enabled() {
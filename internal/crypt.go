@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// Schemes are the supported common encryption schemes (ISO/IEC 23001-7).
+var Schemes = []string{"cenc", "cbc1", "cens", "cbcs"}
+
+// EncryptionPattern is the number of encrypted and skipped 16-byte blocks
+// of the pattern encryption of the cens and cbcs schemes. With no skipped
+// blocks, all blocks are encrypted. A pattern with no encrypted blocks is
+// only valid with no skipped blocks, and then means full-sample encryption.
+type EncryptionPattern struct {
+	Crypt uint8
+	Skip  uint8
+}
+
+func (p EncryptionPattern) String() string {
+	return fmt.Sprintf("%d:%d", p.Crypt, p.Skip)
+}
+
+// ParseEncryptionPattern parses a pattern on the form crypt:skip, e.g. 1:9.
+func ParseEncryptionPattern(s string) (EncryptionPattern, error) {
+	cryptStr, skipStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return EncryptionPattern{}, fmt.Errorf("pattern %q is not on the form crypt:skip", s)
+	}
+	crypt, err := strconv.ParseUint(cryptStr, 10, 4)
+	if err != nil {
+		return EncryptionPattern{}, fmt.Errorf("invalid crypt blocks in pattern %q: %w", s, err)
+	}
+	skip, err := strconv.ParseUint(skipStr, 10, 4)
+	if err != nil {
+		return EncryptionPattern{}, fmt.Errorf("invalid skip blocks in pattern %q: %w", s, err)
+	}
+	p := EncryptionPattern{Crypt: uint8(crypt), Skip: uint8(skip)}
+	if err := p.validate(); err != nil {
+		return EncryptionPattern{}, err
+	}
+	return p, nil
+}
+
+// validate returns an error if p encrypts no blocks.
+func (p EncryptionPattern) validate() error {
+	if p.Crypt == 0 && p.Skip != 0 {
+		return fmt.Errorf("pattern %s encrypts no blocks", p)
+	}
+	return nil
+}
+
+// defaultPatterns are the patterns of the cens and cbcs schemes by content
+// type, unless configured. Audio is fully encrypted.
+var defaultPatterns = map[string]EncryptionPattern{
+	"video": {Crypt: 1, Skip: 9},
+	"audio": {Crypt: 0, Skip: 0},
+}
+
+// isPatternScheme reports whether scheme uses pattern encryption.
+func isPatternScheme(scheme string) bool {
+	return scheme == "cens" || scheme == "cbcs"
+}
+
+// isMp4ffScheme reports whether mp4ff can encrypt and decrypt scheme.
+// The cens and cbc1 schemes have per-sample IVs and block-aligned
+// subsamples like cenc, so their tracks are protected as cenc by mp4ff,
+// and the samples are then re-encrypted by cryptSamples.
+func isMp4ffScheme(scheme string) bool {
+	return scheme == "cenc" || scheme == "cbcs"
+}
+
+// mp4ffDecryptable reports whether all protected tracks of di have a
+// scheme that mp4ff can decrypt.
+func mp4ffDecryptable(di mp4.DecryptInfo) bool {
+	for _, ti := range di.TrackInfos {
+		if ti.Sinf != nil && ti.Sinf.Schm != nil && !isMp4ffScheme(ti.Sinf.Schm.SchemeType) {
+			return false
+		}
+	}
+	return true
+}
+
+// initProtect adds protection information for scheme to init like
+// mp4.InitProtect, and returns what is needed to encrypt fragments.
+// pattern applies to the cens and cbcs schemes.
+func initProtect(init *mp4.InitSegment, iv []byte, scheme string, kid mp4.UUID,
+	pattern EncryptionPattern) (*mp4.InitProtectData, error) {
+	baseScheme := scheme
+	switch scheme {
+	case "cenc", "cbcs":
+	case "cens", "cbc1":
+		baseScheme = "cenc"
+	default:
+		return nil, fmt.Errorf("unknown protection scheme %s", scheme)
+	}
+	ipd, err := mp4.InitProtect(init, []byte{}, iv, baseScheme, kid, nil)
+	if err != nil {
+		return nil, err
+	}
+	if isPatternScheme(scheme) {
+		ipd.Tenc.Version = 1
+		ipd.Tenc.DefaultCryptByteBlock = pattern.Crypt
+		ipd.Tenc.DefaultSkipByteBlock = pattern.Skip
+	}
+	if scheme != baseScheme {
+		sinf := trakSinf(init.Moov.Trak)
+		if sinf == nil || sinf.Schm == nil {
+			return nil, fmt.Errorf("no schm box in protected init segment")
+		}
+		sinf.Schm.SchemeType = scheme
+	}
+	return ipd, nil
+}
+
+// trakSinf returns the sinf box of the sample entry of trak, or nil.
+func trakSinf(trak *mp4.TrakBox) *mp4.SinfBox {
+	for _, c := range trak.Mdia.Minf.Stbl.Stsd.Children {
+		switch se := c.(type) {
+		case *mp4.VisualSampleEntryBox:
+			return se.Sinf
+		case *mp4.AudioSampleEntryBox:
+			return se.Sinf
+		}
+	}
+	return nil
+}
+
+// reencryptFragment re-encrypts frag, encrypted with the cenc scheme by
+// mp4ff, with the cens or cbc1 scheme. The IVs and subsamples of the senc
+// box are kept. plain is the mdat payload before encryption.
+func reencryptFragment(frag *mp4.Fragment, plain []byte, scheme string, key []byte,
+	ipd *mp4.InitProtectData) error {
+	copy(frag.Mdat.Data, plain)
+	samples, err := frag.GetFullSamples(ipd.Trex)
+	if err != nil {
+		return fmt.Errorf("get full samples: %w", err)
+	}
+	return cryptSamples(scheme, false, samples, key, ipd.Tenc, frag.Moof.Traf.Senc)
+}
+
+// decryptFragment decrypts frag in place like mp4.DecryptFragment for
+// tracks with the cens and cbc1 schemes, which mp4ff does not support.
+func decryptFragment(frag *mp4.Fragment, di mp4.DecryptInfo, key []byte) error {
+	moof := frag.Moof
+	var nrBytesRemoved uint64
+	for _, traf := range moof.Trafs {
+		var ti mp4.DecryptTrackInfo
+		for _, t := range di.TrackInfos {
+			if t.TrackID == traf.Tfhd.TrackID {
+				ti = t
+			}
+		}
+		if ti.Sinf == nil {
+			continue
+		}
+		tenc := ti.Sinf.Schi.Tenc
+		hasSenc, isParsed := traf.ContainsSencBox()
+		if !hasSenc {
+			return fmt.Errorf("no senc box in traf")
+		}
+		if !isParsed {
+			if err := traf.ParseReadSenc(tenc.DefaultPerSampleIVSize, moof.StartPos); err != nil {
+				return fmt.Errorf("parseReadSenc: %w", err)
+			}
+		}
+		samples, err := frag.GetFullSamples(ti.Trex)
+		if err != nil {
+			return err
+		}
+		if err := cryptSamples(ti.Sinf.Schm.SchemeType, true, samples, key, tenc, traf.Senc); err != nil {
+			return err
+		}
+		nrBytesRemoved += traf.RemoveEncryptionBoxes()
+	}
+	for _, traf := range moof.Trafs {
+		for _, trun := range traf.Truns {
+			trun.DataOffset -= int32(nrBytesRemoved)
+		}
+	}
+	if frag.Mdat.StartPos > moof.StartPos {
+		frag.Mdat.StartPos -= nrBytesRemoved
+	}
+	return nil
+}
+
+// cryptSamples encrypts or decrypts samples in place with the cens or cbc1
+// scheme. The IVs and subsamples are taken from senc.
+func cryptSamples(scheme string, decrypt bool, samples []mp4.FullSample, key []byte,
+	tenc *mp4.TencBox, senc *mp4.SencBox) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	for i := range samples {
+		// 8-byte IVs are padded with zeros to 16 bytes.
+		iv := make([]byte, aes.BlockSize)
+		if senc != nil && i < len(senc.IVs) {
+			copy(iv, senc.IVs[i])
+		}
+		var subsamples []mp4.SubSamplePattern
+		if senc != nil && i < len(senc.SubSamples) {
+			subsamples = senc.SubSamples[i]
+		}
+		ranges := protectedRanges(samples[i].Data, subsamples)
+		switch scheme {
+		case "cens":
+			cryptCens(block, iv, ranges, int(tenc.DefaultCryptByteBlock), int(tenc.DefaultSkipByteBlock))
+		case "cbc1":
+			cryptCbc1(block, iv, ranges, decrypt)
+		default:
+			return fmt.Errorf("scheme %s not supported", scheme)
+		}
+	}
+	return nil
+}
+
+// protectedRanges returns the protected byte ranges of sample. Without
+// subsamples, the whole sample is protected.
+func protectedRanges(sample []byte, subsamples []mp4.SubSamplePattern) [][]byte {
+	if len(subsamples) == 0 {
+		return [][]byte{sample}
+	}
+	ranges := make([][]byte, 0, len(subsamples))
+	pos := uint32(0)
+	for _, ss := range subsamples {
+		pos += uint32(ss.BytesOfClearData)
+		if ss.BytesOfProtectedData > 0 {
+			ranges = append(ranges, sample[pos:pos+ss.BytesOfProtectedData])
+		}
+		pos += ss.BytesOfProtectedData
+	}
+	return ranges
+}
+
+// cryptCens applies AES-CTR with a crypt:skip pattern to the protected
+// ranges of a sample. The counter runs over the encrypted blocks only and
+// continues from one range to the next. A partial block at the end of a
+// range is left in the clear, unless all blocks are encrypted.
+func cryptCens(block cipher.Block, iv []byte, ranges [][]byte, crypt, skip int) {
+	stream := cipher.NewCTR(block, iv)
+	for _, r := range ranges {
+		if skip == 0 {
+			stream.XORKeyStream(r, r)
+			continue
+		}
+		for pos := 0; pos < len(r); pos += (crypt + skip) * aes.BlockSize {
+			n := min(crypt*aes.BlockSize, (len(r)-pos)&^(aes.BlockSize-1))
+			stream.XORKeyStream(r[pos:pos+n], r[pos:pos+n])
+		}
+	}
+}
+
+// cryptCbc1 applies AES-CBC to the protected ranges of a sample, chaining
+// from one range to the next. A partial block at the end of a range is
+// left in the clear.
+func cryptCbc1(block cipher.Block, iv []byte, ranges [][]byte, decrypt bool) {
+	var mode cipher.BlockMode
+	if decrypt {
+		mode = cipher.NewCBCDecrypter(block, iv)
+	} else {
+		mode = cipher.NewCBCEncrypter(block, iv)
+	}
+	for _, r := range ranges {
+		n := len(r) &^ (aes.BlockSize - 1)
+		mode.CryptBlocks(r[:n], r[:n])
+	}
+}
@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eyevinn/mp4ff/bits"
+	"github.com/Eyevinn/mp4ff/mp4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEncryptionPattern(t *testing.T) {
+	for s, want := range map[string]EncryptionPattern{
+		"1:9":  {Crypt: 1, Skip: 9},
+		"10:0": {Crypt: 10, Skip: 0},
+		"0:0":  {},
+	} {
+		p, err := ParseEncryptionPattern(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, p)
+		require.Equal(t, s, p.String())
+	}
+	for _, s := range []string{"1", "16:0", "1:-1", "a:b", "", "0:9", "0:1"} {
+		_, err := ParseEncryptionPattern(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestCryptCens(t *testing.T) {
+	key := bytes.Repeat([]byte{0x40}, 16)
+	iv := bytes.Repeat([]byte{0x41}, 16)
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	plain := make([]byte, 11*16+5)
+	for i := range plain {
+		plain[i] = byte(i)
+	}
+	keystream := make([]byte, 32)
+	cipher.NewCTR(block, iv).XORKeyStream(keystream, keystream)
+
+	// With 1:9, blocks 0 and 10 are encrypted with consecutive counters,
+	// and the partial block at the end is in the clear.
+	data := append([]byte(nil), plain...)
+	cryptCens(block, iv, [][]byte{data}, 1, 9)
+	want := append([]byte(nil), plain...)
+	for i := range 16 {
+		want[i] ^= keystream[i]
+		want[10*16+i] ^= keystream[16+i]
+	}
+	require.Equal(t, want, data)
+	cryptCens(block, iv, [][]byte{data}, 1, 9)
+	require.Equal(t, plain, data)
+
+	// Without skipped blocks, cens is cenc.
+	data = append([]byte(nil), plain...)
+	cryptCens(block, iv, [][]byte{data[:100], data[100:]}, 10, 0)
+	want = append([]byte(nil), plain...)
+	cipher.NewCTR(block, iv).XORKeyStream(want, want)
+	require.Equal(t, want, data)
+}
+
+func TestCryptCbc1(t *testing.T) {
+	key := bytes.Repeat([]byte{0x40}, 16)
+	iv := bytes.Repeat([]byte{0x41}, 16)
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	plain := make([]byte, 52)
+	for i := range plain {
+		plain[i] = byte(i)
+	}
+
+	// The chain continues from the first range to the second, whose
+	// partial block is in the clear.
+	data := append([]byte(nil), plain...)
+	cryptCbc1(block, iv, [][]byte{data[:32], data[32:]}, false)
+	want := append([]byte(nil), plain...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(want[:48], want[:48])
+	require.Equal(t, want, data)
+	cryptCbc1(block, iv, [][]byte{data[:32], data[32:]}, true)
+	require.Equal(t, plain, data)
+}
+
+func TestEncryptionSchemes(t *testing.T) {
+	kidStr := "39112233445566778899aabbccddeeff"
+	ivStr := "41112233445566778899aabbccddeeff"
+	patterns := map[string]EncryptionPattern{"video": {Crypt: 5, Skip: 5}, "audio": {Crypt: 10, Skip: 0}}
+	_, err := ParseCENCflags("cbc1", kidStr, "", "4111223344556677", "http://localhost:8081/clearkey")
+	require.Error(t, err, "cbc1 needs 16-byte IVs")
+	for _, scheme := range Schemes {
+		t.Run(scheme, func(t *testing.T) {
+			eccp, err := ParseCENCflags(scheme, kidStr, "", ivStr, "http://localhost:8081/clearkey")
+			require.NoError(t, err)
+			for contentType, pattern := range patterns {
+				err := eccp.SetEncryptionPattern(contentType, pattern)
+				if !isPatternScheme(scheme) {
+					require.Error(t, err)
+					continue
+				}
+				require.NoError(t, err)
+			}
+			if isPatternScheme(scheme) {
+				err := eccp.SetEncryptionPattern("video", EncryptionPattern{Crypt: 0, Skip: 9})
+				require.Error(t, err, "pattern encrypting no blocks")
+			}
+			asset, err := LoadAssetWithProtection("../assets/test10s", 1, 1, nil, eccp)
+			require.NoError(t, err)
+			for _, name := range []string{"video_400kbps_avc", "audio_monotonic_128kbps_aac"} {
+				clear := asset.GetTrackByName(name)
+				protected := asset.GetTrackByName(name + "_eccp")
+				initData, err := protected.SpecData.GenCMAFInitData()
+				require.NoError(t, err)
+				f, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(initData))
+				require.NoError(t, err)
+				sinf := trakSinf(f.Init.Moov.Trak)
+				require.NotNil(t, sinf)
+				require.Equal(t, scheme, sinf.Schm.SchemeType)
+				tenc := sinf.Schi.Tenc
+				if isPatternScheme(scheme) {
+					want := patterns[clear.ContentType]
+					require.Equal(t, byte(1), tenc.Version)
+					require.Equal(t, want, EncryptionPattern{Crypt: tenc.DefaultCryptByteBlock, Skip: tenc.DefaultSkipByteBlock})
+				} else {
+					require.Equal(t, byte(0), tenc.Version)
+				}
+
+				_, _, di, err := DecryptInit(initData)
+				require.NoError(t, err)
+				for nr := range uint64(3) {
+					want, err := clear.GenCMAFChunk(0, nr, nr+1)
+					require.NoError(t, err)
+					chunk, err := protected.GenCMAFChunk(0, nr, nr+1)
+					require.NoError(t, err)
+					require.NotEqual(t, mdatPayload(t, want), mdatPayload(t, chunk), "%s sample %d", name, nr)
+					dec, err := DecryptFragment(chunk, di, mustUnpackKey(t, kidStr))
+					require.NoError(t, err)
+					require.Equal(t, mdatPayload(t, want), mdatPayload(t, dec), "%s sample %d", name, nr)
+				}
+			}
+		})
+	}
+}
+
+func TestLocationIVs(t *testing.T) {
+	kidStr := "39112233445566778899aabbccddeeff"
+	tracks := []string{"video_400kbps_avc_eccp", "video_600kbps_avc_eccp", "audio_monotonic_128kbps_aac_eccp"}
+	for _, tc := range []struct {
+		scheme string
+		iv     string
+	}{
+		{"cenc", "41112233ffffffff"}, // the IV of the key wraps
+		{"cenc", "41112233445566778899aabbccddeeff"},
+		{"cbc1", "41112233445566778899aabbccddeeff"},
+		{"cens", "4111223344556677"},
+	} {
+		t.Run(tc.scheme+"/"+tc.iv, func(t *testing.T) {
+			load := func() *Asset {
+				eccp, err := ParseCENCflags(tc.scheme, kidStr, "", tc.iv, "http://localhost:8081/clearkey")
+				require.NoError(t, err)
+				asset, err := LoadAssetWithProtection("../assets/test10s", 1, 1, nil, eccp)
+				require.NoError(t, err)
+				return asset
+			}
+			asset, restarted := load(), load()
+			ivs := make(map[string]string) // locations by IV
+			for _, name := range tracks {
+				ct := asset.GetTrackByName(name)
+				require.NotNil(t, ct)
+				for groupNr := range uint64(3) {
+					mg, err := GenMoQGroup(ct, groupNr, 1, MoqGroupDurMS, "cmaf")
+					require.NoError(t, err)
+					// Another subscriber or origin generates the same group.
+					other, err := GenMoQGroup(restarted.GetTrackByName(name), groupNr, 1, MoqGroupDurMS, "cmaf")
+					require.NoError(t, err)
+					require.Equal(t, mg.MoQObjects, other.MoQObjects, "%s group %d", name, groupNr)
+					for objectID, o := range mg.MoQObjects {
+						f, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(o))
+						require.NoError(t, err)
+						senc := parsedSenc(t, f.Segments[0].Fragments[0].Moof, moovOf(ct))
+						require.NotNil(t, senc)
+						loc := fmt.Sprintf("%s %d/%d", name, groupNr, objectID)
+						for _, iv := range senc.IVs {
+							prev, ok := ivs[string(iv)]
+							require.False(t, ok, "IV of %s reused from %s", loc, prev)
+							ivs[string(iv)] = loc
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestIVsAcrossAssets loads two assets with the same track names and the
+// same keys, and checks that their IVs differ.
+func TestIVsAcrossAssets(t *testing.T) {
+	kidStr := "39112233445566778899aabbccddeeff"
+	ivStr := "41112233445566778899aabbccddeeff"
+	absAsset, err := filepath.Abs("../assets/test10s")
+	require.NoError(t, err)
+	manifestPath := filepath.Join(t.TempDir(), "other.json")
+	manifest := fmt.Sprintf(`{"name": "other", "tracks": [
+		{"file": "%s/video_400kbps_avc.mp4", "name": "video_400kbps_avc"},
+		{"file": "%s/audio_monotonic_128kbps_aac.mp4", "name": "audio_monotonic_128kbps_aac"}]}`,
+		absAsset, absAsset)
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o644))
+
+	for _, scheme := range []string{"cenc", "cens"} {
+		t.Run(scheme, func(t *testing.T) {
+			eccp, err := ParseCENCflags(scheme, kidStr, "", ivStr, "http://localhost:8081/clearkey")
+			require.NoError(t, err)
+			assets := make([]*Asset, 2)
+			assets[0], err = LoadAssetWithProtection(absAsset, 1, 1, nil, eccp)
+			require.NoError(t, err)
+			assets[1], err = LoadAssetWithProtection(manifestPath, 1, 1, nil, eccp)
+			require.NoError(t, err)
+
+			ivs := make(map[string]string) // locations by IV
+			for _, name := range []string{"video_400kbps_avc_eccp", "audio_monotonic_128kbps_aac_eccp"} {
+				for _, asset := range assets {
+					ct := asset.GetTrackByName(name)
+					require.NotNil(t, ct, "%s of %s", name, asset.Name)
+					mg, err := GenMoQGroup(ct, 0, 1, MoqGroupDurMS, "cmaf")
+					require.NoError(t, err)
+					for objectID, o := range mg.MoQObjects {
+						f, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(o))
+						require.NoError(t, err)
+						senc := parsedSenc(t, f.Segments[0].Fragments[0].Moof, moovOf(ct))
+						require.NotNil(t, senc)
+						loc := fmt.Sprintf("%s/%s 0/%d", asset.Name, name, objectID)
+						for _, iv := range senc.IVs {
+							prev, ok := ivs[string(iv)]
+							require.False(t, ok, "IV of %s reused from %s", loc, prev)
+							ivs[string(iv)] = loc
+						}
+					}
+				}
+			}
+
+			// An asset with the same name would reuse the IVs.
+			_, err = LoadAssetWithProtection(absAsset, 1, 1, nil, eccp)
+			require.ErrorContains(t, err, "same IV prefix")
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Package dial opens client MoQ connections over raw QUIC or WebTransport.
+package dial
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Eyevinn/moqtransport"
+	"github.com/Eyevinn/moqtransport/quicmoq"
+	"github.com/Eyevinn/moqtransport/webtransportmoq"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/webtransport-go"
+)
+
+const defaultPort = "443"
+
+// IsWebTransport returns true if addr is a WebTransport URL (https://).
+func IsWebTransport(addr string) bool {
+	return strings.HasPrefix(addr, "https://")
+}
+
+// Dial connects to addr offering alpn. An https:// URL is dialed with
+// WebTransport, anything else, e.g. host:port or moqt://host:port, with raw QUIC.
+func Dial(ctx context.Context, addr string, alpn string) (moqtransport.Connection, error) {
+	if IsWebTransport(addr) {
+		return WebTransport(ctx, addr, alpn)
+	}
+	return QUIC(ctx, strings.TrimPrefix(addr, "moqt://"), alpn)
+}
+
+// QUIC connects to the host:port addr with raw QUIC. The port defaults to 443.
+func QUIC(ctx context.Context, addr string, alpn string) (moqtransport.Connection, error) {
+	addr = ensurePort(addr, defaultPort)
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{alpn},
+	}, &quic.Config{
+		EnableDatagrams:                  true,
+		EnableStreamResetPartialDelivery: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quicmoq.NewClient(conn), nil
+}
+
+// WebTransport connects to the https:// URL addr with WebTransport. The port
+// defaults to 443.
+func WebTransport(ctx context.Context, addr string, alpn string) (moqtransport.Connection, error) {
+	// webtransport-go (Eyevinn fork on v0.11.0) sends and accepts the legacy
+	// WEBTRANSPORT_MAX_SESSIONS codepoint itself, so deployed web-transport-quinn
+	// relays (moq-rs / cdn.moq.dev, Cloudflare) interoperate without extra setup.
+	dialer := webtransport.Dialer{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		QUICConfig: &quic.Config{
+			EnableDatagrams:                  true,
+			EnableStreamResetPartialDelivery: true,
+		},
+		ApplicationProtocols: []string{alpn},
+	}
+	_, session, err := dialer.Dial(ctx, ensureURLPort(addr, defaultPort), nil)
+	if err != nil {
+		return nil, err
+	}
+	return webtransportmoq.NewClient(session), nil
+}
+
+func ensurePort(addr, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, defaultPort)
+	}
+	return addr
+}
+
+func ensureURLPort(rawURL, defaultPort string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPort)
+		return u.String()
+	}
+	return rawURL
+}
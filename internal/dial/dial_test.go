@@ -0,0 +1,21 @@
+package dial
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsurePort(t *testing.T) {
+	assert.Equal(t, "localhost:443", ensurePort("localhost", defaultPort))
+	assert.Equal(t, "localhost:4443", ensurePort("localhost:4443", defaultPort))
+	assert.Equal(t, "[::1]:443", ensurePort("::1", defaultPort))
+	assert.Equal(t, "https://example.com:443/moq", ensureURLPort("https://example.com/moq", defaultPort))
+	assert.Equal(t, "https://example.com:4443/moq", ensureURLPort("https://example.com:4443/moq", defaultPort))
+}
+
+func TestIsWebTransport(t *testing.T) {
+	assert.True(t, IsWebTransport("https://localhost:4443/moq"))
+	assert.False(t, IsWebTransport("moqt://localhost:4443"))
+	assert.False(t, IsWebTransport("localhost:4443"))
+}
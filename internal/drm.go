@@ -1,11 +1,14 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/Dash-Industry-Forum/livesim2/pkg/drm"
 	"github.com/Eyevinn/mp4ff/bits"
@@ -23,13 +26,129 @@ var drmSystemIDs = map[string]string{
 // DRMInfo keeps track of all information regarding DRM
 type DRMInfo struct {
 	ContentProtections []ContentProtection
-	cenc               *CENCInfo
+	tiers              []*keyTier
+	patterns           map[string]EncryptionPattern // keyed by content type
+
+	ivMu       sync.Mutex
+	ivPrefixes map[uint16]string // protected tracks of all assets by IV prefix, see sampleIV
+}
+
+// keyTier holds the keys of the tracks of one track type and the refIDs of
+// the content protections signaling them. Without multi-key encryption,
+// there is a single tier for all tracks.
+type keyTier struct {
+	trackType string // see trackTypeMatches
+	cenc      *CENCInfo
+	refIDs    []string
+}
+
+// trackTypes are the supported track types of key tiers, named as the
+// intended track types of CPIX content key usage rules.
+var trackTypes = []string{"audio", "video", "sd", "hd", "uhd", "uhd1", "uhd2"}
+
+// trackTypeMatches reports whether a track with contentType and height
+// belongs to trackType. The empty track type matches all tracks.
+func trackTypeMatches(trackType, contentType string, height int) bool {
+	switch trackType {
+	case "":
+		return true
+	case "audio", "video":
+		return contentType == trackType
+	}
+	if contentType != "video" {
+		return false
+	}
+	switch trackType {
+	case "sd":
+		return height < 720
+	case "hd":
+		return height >= 720 && height <= 1080
+	case "uhd":
+		return height > 1080
+	case "uhd1":
+		return height > 1080 && height <= 2160
+	case "uhd2":
+		return height > 2160
+	}
+	return false
 }
 
-// CENCInfo contains information unique to CENC and is not signaled in the catalog
+// tierFor returns the first key tier matching a track with contentType and height.
+func (d *DRMInfo) tierFor(contentType string, height int) (*keyTier, error) {
+	for _, tier := range d.tiers {
+		if trackTypeMatches(tier.trackType, contentType, height) {
+			return tier, nil
+		}
+	}
+	return nil, fmt.Errorf("no content key for %s track with height %d", contentType, height)
+}
+
+// claimIVPrefix reserves the IV prefix of the protected track name of
+// asset assetName. The keys of d are shared by all assets loaded with it,
+// so a prefix that is already used by a track of any of them would repeat
+// its IVs under the same key.
+func (d *DRMInfo) claimIVPrefix(prefix uint16, assetName, name string) error {
+	d.ivMu.Lock()
+	defer d.ivMu.Unlock()
+	track := assetName + "/" + name
+	if other, ok := d.ivPrefixes[prefix]; ok {
+		return fmt.Errorf("tracks %s and %s have the same IV prefix, rename one of the tracks or assets",
+			other, track)
+	}
+	if d.ivPrefixes == nil {
+		d.ivPrefixes = make(map[uint16]string)
+	}
+	d.ivPrefixes[prefix] = track
+	return nil
+}
+
+// contentProtection returns the content protection with refID.
+func (d *DRMInfo) contentProtection(refID string) (ContentProtection, bool) {
+	for _, cp := range d.ContentProtections {
+		if cp.RefID == refID {
+			return cp, true
+		}
+	}
+	return ContentProtection{}, false
+}
+
+// CENCInfo contains information unique to CENC and is not signaled in the catalog.
+// With key rotation, the keys are used in turn for rotationGroups MoQ groups
+// each, starting with the first key at group 0.
 type CENCInfo struct {
-	key []byte
-	iv  []byte
+	keys           []contentKey
+	rotationGroups uint64
+}
+
+// contentKey is a content key with its key ID and IV.
+type contentKey struct {
+	kid   mp4.UUID
+	key   []byte
+	iv    []byte
+	psshs map[string]string // base64 PSSH boxes of the CPIX data, keyed by DRM system ID
+}
+
+// defaultKey returns the key signaled in the tenc box of the init segment.
+func (c *CENCInfo) defaultKey() contentKey {
+	return c.keys[0]
+}
+
+// rotates reports whether the key changes over time.
+func (c *CENCInfo) rotates() bool {
+	return c.rotationGroups > 0 && len(c.keys) > 1
+}
+
+// keyIndex returns the index of the key used for MoQ group groupNr.
+func (c *CENCInfo) keyIndex(groupNr uint64) int {
+	if !c.rotates() {
+		return 0
+	}
+	return int((groupNr / c.rotationGroups) % uint64(len(c.keys)))
+}
+
+// keyForGroup returns the key used for MoQ group groupNr.
+func (c *CENCInfo) keyForGroup(groupNr uint64) contentKey {
+	return c.keys[c.keyIndex(groupNr)]
 }
 
 // ConfigureDRMFromFile reads a DRM config file and returns a *DRMInfo struct.
@@ -44,8 +163,11 @@ func ConfigureDRMFromFile(configpath string) (*DRMInfo, error) {
 	}
 	pack := drmConfig.Packages[0]
 	cpix := pack.CPIXData
-	contentKey := cpix.ContentKeys[0]
-	scheme := contentKey.CommonEncryptionScheme
+	if len(cpix.ContentKeys) == 0 {
+		return nil, fmt.Errorf("no content keys found in CPIX data")
+	}
+	firstKey := cpix.ContentKeys[0]
+	scheme := firstKey.CommonEncryptionScheme
 	var drmSystems []DRMSystem
 	for drmName, URL := range pack.URLs {
 		if drmName == "fairplay" && URL.CertificateURL == "" {
@@ -66,40 +188,123 @@ func ConfigureDRMFromFile(configpath string) (*DRMInfo, error) {
 			},
 		})
 	}
+	tiers, err := cpixKeyTiers(cpix)
+	if err != nil {
+		return nil, err
+	}
+	// With several content keys, the CPIX data lists each DRM system once
+	// per key. Each tier has one entry per system, with the PSSH of its
+	// first key.
+	var systemIDs []string
+	for _, ds := range cpix.DRMSystems {
+		if !slices.Contains(systemIDs, ds.SystemID) {
+			systemIDs = append(systemIDs, ds.SystemID)
+		}
+	}
 	var contentProtections []ContentProtection
 	const firstRefID = 1
 	refID := firstRefID
-	for _, ds := range cpix.DRMSystems {
-		var system DRMSystem
-		for _, catalogDS := range drmSystems {
-			if ds.SystemID == catalogDS.SystemID {
-				system = catalogDS
+	for _, tier := range tiers {
+		kid := tier.cenc.defaultKey().kid
+		for _, systemID := range systemIDs {
+			var system DRMSystem
+			for _, catalogDS := range drmSystems {
+				if systemID == catalogDS.SystemID {
+					system = catalogDS
+				}
 			}
+			if system == (DRMSystem{}) {
+				return nil, fmt.Errorf("couldn't find existing DRMSystem corresponding to systemID %s", systemID)
+			}
+			system.Pssh = cpixPssh(cpix, systemID, kid)
+			contentProtections = append(contentProtections, ContentProtection{
+				RefID:       strconv.Itoa(refID),
+				Scheme:      scheme,
+				DefaultKIDs: []string{kid.String()},
+				DRMSystem:   &system,
+			})
+			tier.refIDs = append(tier.refIDs, strconv.Itoa(refID))
+			refID += 1
 		}
-		if system == (DRMSystem{}) {
-			return nil, fmt.Errorf("couldn't find existing DRMSystem corresponding to systemID %s", ds.SystemID)
-		}
-		system.Pssh = strings.TrimSpace(ds.PSSH)
-		contentProtections = append(contentProtections, ContentProtection{
-			RefID:       strconv.Itoa(refID),
-			Scheme:      scheme,
-			DefaultKIDs: []string{contentKey.KeyID.String()},
-			DRMSystem:   &system,
-		})
-		refID += 1
 	}
 
-	cenc := &CENCInfo{
-		key: contentKey.Key,
-		iv:  contentKey.ExplicitIV,
-	}
 	return &DRMInfo{
 		ContentProtections: contentProtections,
-		cenc:               cenc,
+		tiers:              tiers,
 	}, nil
 
 }
 
+// cpixKeyTiers returns the key tiers of the CPIX data. Without usage rules,
+// all content keys are in one tier for all tracks. Otherwise, the keys are
+// grouped by the intended track types of their usage rules, in rule order.
+// A usage rule without intended track type applies to all tracks.
+func cpixKeyTiers(cpix drm.CPIXData) ([]*keyTier, error) {
+	toContentKey := func(ck drm.ContentKey) contentKey {
+		key := contentKey{kid: ck.KeyID, key: ck.Key, iv: ck.ExplicitIV}
+		for _, ds := range cpix.DRMSystems {
+			if bytes.Equal(ds.KeyID, ck.KeyID) && strings.TrimSpace(ds.PSSH) != "" {
+				if key.psshs == nil {
+					key.psshs = make(map[string]string)
+				}
+				key.psshs[ds.SystemID] = strings.TrimSpace(ds.PSSH)
+			}
+		}
+		return key
+	}
+	if len(cpix.UsageRules) == 0 {
+		tier := &keyTier{cenc: &CENCInfo{}}
+		for _, ck := range cpix.ContentKeys {
+			tier.cenc.keys = append(tier.cenc.keys, toContentKey(ck))
+		}
+		return []*keyTier{tier}, nil
+	}
+	var tiers []*keyTier
+	for _, rule := range cpix.UsageRules {
+		trackType := strings.ToLower(rule.IntendedTrackType)
+		if trackType != "" && !slices.Contains(trackTypes, trackType) {
+			return nil, fmt.Errorf("unsupported intended track type %q, supported %v",
+				rule.IntendedTrackType, trackTypes)
+		}
+		idx := slices.IndexFunc(cpix.ContentKeys, func(ck drm.ContentKey) bool {
+			return bytes.Equal(ck.KeyID, rule.KeyID)
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("no content key for usage rule kid %s", rule.KeyID)
+		}
+		var tier *keyTier
+		for _, t := range tiers {
+			if t.trackType == trackType {
+				tier = t
+			}
+		}
+		if tier == nil {
+			tier = &keyTier{trackType: trackType, cenc: &CENCInfo{}}
+			tiers = append(tiers, tier)
+		}
+		tier.cenc.keys = append(tier.cenc.keys, toContentKey(cpix.ContentKeys[idx]))
+	}
+	return tiers, nil
+}
+
+// cpixPssh returns the PSSH of systemID for kid in the CPIX data, or the
+// first PSSH of systemID if there is none for kid.
+func cpixPssh(cpix drm.CPIXData, systemID string, kid mp4.UUID) string {
+	pssh, found := "", false
+	for _, ds := range cpix.DRMSystems {
+		if ds.SystemID != systemID {
+			continue
+		}
+		if bytes.Equal(ds.KeyID, kid) {
+			return strings.TrimSpace(ds.PSSH)
+		}
+		if !found {
+			pssh, found = strings.TrimSpace(ds.PSSH), true
+		}
+	}
+	return pssh
+}
+
 // ParseCENCflags converts the string CENC-related parameters into a ClearKey-compliant *DRMInfo struct.
 // If all flags are empty (except scheme) nil is returned.
 // The laURL parameter is the license acquisition URL announced in the catalog.
@@ -121,13 +326,16 @@ func ParseCENCflags(scheme, kidStr, keyStr, ivStr, laURL string) (*DRMInfo, erro
 		return nil, fmt.Errorf("failed to convert kid hexstring to UUID: %w", err)
 	}
 
-	if scheme != "cenc" && scheme != "cbcs" {
-		return nil, fmt.Errorf("scheme must be cenc or cbcs: %s", scheme)
+	if !slices.Contains(Schemes, scheme) {
+		return nil, fmt.Errorf("scheme must be one of %s: %s", strings.Join(Schemes, ", "), scheme)
 	}
 
 	if len(ivStr) != 32 && len(ivStr) != 16 {
 		return nil, fmt.Errorf("hex iv must have length 16 or 32 chars; %d", len(ivStr))
 	}
+	if scheme == "cbc1" && len(ivStr) != 32 {
+		return nil, fmt.Errorf("hex iv must have length 32 chars for cbc1: %d", len(ivStr))
+	}
 
 	iv, err := hex.DecodeString(ivStr)
 	if err != nil {
@@ -147,28 +355,19 @@ func ParseCENCflags(scheme, kidStr, keyStr, ivStr, laURL string) (*DRMInfo, erro
 			return nil, fmt.Errorf("invalid key %s, %w", keyStr, err)
 		}
 	}
-	psshBox, err := createClearKeyPssh(kidUUID)
+	pssh, err := encodeClearKeyPssh(kidUUID)
 	if err != nil {
 		return nil, fmt.Errorf("could not create ClearKey PSSH: %w", err)
 	}
 
-	cenc := &CENCInfo{
-		key: key,
-		iv:  iv,
-	}
 	license := &DRMService{
 		URL:  laURL,
 		Type: "EME-1.0",
 	}
-	sw := bits.NewFixedSliceWriter(int(psshBox.Size()))
-	err = psshBox.EncodeSW(sw)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode pssh box: %w", err)
-	}
 	drmSystem := DRMSystem{
-		SystemID: psshBox.SystemID.String(),
+		SystemID: CommonSystemID,
 		LaURL:    license,
-		Pssh:     base64.RawStdEncoding.EncodeToString(sw.Bytes()),
+		Pssh:     pssh,
 	}
 	refID := "1"
 	var contentProtections []ContentProtection
@@ -179,14 +378,19 @@ func ParseCENCflags(scheme, kidStr, keyStr, ivStr, laURL string) (*DRMInfo, erro
 		DRMSystem:   &drmSystem,
 	})
 
+	tier := &keyTier{
+		cenc:   &CENCInfo{keys: []contentKey{{kid: kidUUID, key: key, iv: iv}}},
+		refIDs: []string{refID},
+	}
+
 	return &DRMInfo{
 		ContentProtections: contentProtections,
-		cenc:               cenc,
+		tiers:              []*keyTier{tier},
 	}, nil
 }
 
-// createClearKeyPssh creates a PsshBox using the provided key-id
-func createClearKeyPssh(kid mp4.UUID) (*mp4.PsshBox, error) {
+// createClearKeyPssh creates a PsshBox using the provided key-ids
+func createClearKeyPssh(kids ...mp4.UUID) (*mp4.PsshBox, error) {
 	systemID, err := mp4.NewUUIDFromString(CommonSystemID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid ClearKey system ID: %w", err)
@@ -196,9 +400,287 @@ func createClearKeyPssh(kid mp4.UUID) (*mp4.PsshBox, error) {
 		Version:  1,
 		Flags:    0,
 		SystemID: systemID,
-		KIDs:     []mp4.UUID{kid},
+		KIDs:     kids,
 		Data:     nil,
 	}
 
 	return psshBox, nil
 }
+
+// SetKeyTiers replaces the single key of d with one key per track type in
+// trackTypes, for multi-key encryption. A track uses the key of the first
+// track type it matches. The key IDs are generated by adding the index of
+// the track type to the upper half of the key ID, and so are the keys,
+// unless the key equals its key ID. Each track type gets its own ClearKey
+// content protection.
+func (d *DRMInfo) SetKeyTiers(types []string) error {
+	if len(d.tiers) != 1 || len(d.tiers[0].cenc.keys) != 1 {
+		return fmt.Errorf("key tiers can only be generated from one key")
+	}
+	base := d.tiers[0]
+	first := base.cenc.defaultKey()
+	keyIsKID := bytes.Equal(first.key, first.kid)
+	var tiers []*keyTier
+	var cps []ContentProtection
+	refID := 1
+	for i, trackType := range types {
+		trackType = strings.ToLower(strings.TrimSpace(trackType))
+		if !slices.Contains(trackTypes, trackType) {
+			return fmt.Errorf("unsupported track type %q, supported %v", trackType, trackTypes)
+		}
+		kid := addToKeyHigh(first.kid, uint64(i))
+		key := kid
+		if !keyIsKID {
+			key = addToKeyHigh(first.key, uint64(i))
+		}
+		tier := &keyTier{
+			trackType: trackType,
+			cenc:      &CENCInfo{keys: []contentKey{{kid: kid, key: key, iv: first.iv}}},
+		}
+		for _, baseRefID := range base.refIDs {
+			cp, ok := d.contentProtection(baseRefID)
+			if !ok {
+				return fmt.Errorf("no content protection with refID %s", baseRefID)
+			}
+			cp.RefID = strconv.Itoa(refID)
+			cp.DefaultKIDs = []string{mp4.UUID(kid).String()}
+			if cp.DRMSystem != nil && cp.DRMSystem.SystemID == CommonSystemID {
+				system := *cp.DRMSystem
+				pssh, err := encodeClearKeyPssh(kid)
+				if err != nil {
+					return fmt.Errorf("could not create ClearKey PSSH: %w", err)
+				}
+				system.Pssh = pssh
+				cp.DRMSystem = &system
+			}
+			cps = append(cps, cp)
+			tier.refIDs = append(tier.refIDs, cp.RefID)
+			refID++
+		}
+		tiers = append(tiers, tier)
+	}
+	d.tiers = tiers
+	d.ContentProtections = cps
+	return nil
+}
+
+// GenerateRotationKeys adds keys to the single key of each key tier of d,
+// so that there are nrKeys keys in total. The key IDs are generated by
+// incrementing the first key ID, and so are the keys, unless the first key
+// equals its key ID, in which case all keys equal their key IDs. All keys
+// share the first IV.
+func (d *DRMInfo) GenerateRotationKeys(nrKeys int) error {
+	for _, tier := range d.tiers {
+		if len(tier.cenc.keys) != 1 {
+			return fmt.Errorf("rotation keys can only be generated from one key, have %d", len(tier.cenc.keys))
+		}
+		first := tier.cenc.keys[0]
+		keyIsKID := bytes.Equal(first.key, first.kid)
+		for i := 1; i < nrKeys; i++ {
+			kid := addToKey(first.kid, uint64(i))
+			key := kid
+			if !keyIsKID {
+				key = addToKey(first.key, uint64(i))
+			}
+			tier.cenc.keys = append(tier.cenc.keys, contentKey{kid: kid, key: key, iv: first.iv})
+		}
+	}
+	return nil
+}
+
+// SetKeyRotation makes the protected tracks switch to the next key every
+// rotationGroups MoQ groups, cycling through all keys. Zero disables key
+// rotation, so that only the first key is used. The catalog signals the
+// PSSH of the current key for every DRM system other than ClearKey, so
+// each rotating key must have a PSSH for each of them in the CPIX data.
+func (d *DRMInfo) SetKeyRotation(rotationGroups uint64) error {
+	if rotationGroups > 0 {
+		for _, tier := range d.tiers {
+			if len(tier.cenc.keys) < 2 {
+				continue
+			}
+			for _, refID := range tier.refIDs {
+				cp, _ := d.contentProtection(refID)
+				if cp.DRMSystem == nil || cp.DRMSystem.SystemID == CommonSystemID {
+					continue
+				}
+				systemID := cp.DRMSystem.SystemID
+				for _, ck := range tier.cenc.keys {
+					pssh := ck.psshs[systemID]
+					if _, err := base64.StdEncoding.DecodeString(pssh); pssh == "" || err != nil {
+						return fmt.Errorf("no valid PSSH of DRM system %s for rotating key %s", systemID, ck.kid)
+					}
+				}
+			}
+		}
+	}
+	for _, tier := range d.tiers {
+		tier.cenc.rotationGroups = rotationGroups
+	}
+	return nil
+}
+
+// RotationGroups returns the number of MoQ groups per key period, or 0 if
+// no key rotates.
+func (d *DRMInfo) RotationGroups() uint64 {
+	for _, tier := range d.tiers {
+		if tier.cenc.rotates() {
+			return tier.cenc.rotationGroups
+		}
+	}
+	return 0
+}
+
+// ClearKey returns the key with key ID kid.
+func (d *DRMInfo) ClearKey(kid []byte) ([]byte, bool) {
+	for _, tier := range d.tiers {
+		for _, ck := range tier.cenc.keys {
+			if bytes.Equal(ck.kid, kid) {
+				return ck.key, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// scheme returns the protection scheme of d.
+func (d *DRMInfo) scheme() string {
+	if len(d.ContentProtections) == 0 {
+		return ""
+	}
+	return d.ContentProtections[0].Scheme
+}
+
+// SetEncryptionPattern sets the crypt:skip pattern of the contentType
+// tracks. Only the cens and cbcs schemes use patterns.
+func (d *DRMInfo) SetEncryptionPattern(contentType string, pattern EncryptionPattern) error {
+	if !isPatternScheme(d.scheme()) {
+		return fmt.Errorf("scheme %s has no encryption pattern", d.scheme())
+	}
+	if _, ok := defaultPatterns[contentType]; !ok {
+		return fmt.Errorf("no encryption pattern for content type %s", contentType)
+	}
+	if err := pattern.validate(); err != nil {
+		return err
+	}
+	if d.patterns == nil {
+		d.patterns = make(map[string]EncryptionPattern)
+	}
+	d.patterns[contentType] = pattern
+	return nil
+}
+
+// encryptionPattern returns the crypt:skip pattern of the contentType tracks.
+func (d *DRMInfo) encryptionPattern(contentType string) EncryptionPattern {
+	if pattern, ok := d.patterns[contentType]; ok {
+		return pattern
+	}
+	return defaultPatterns[contentType]
+}
+
+// SetAuthzURL announces url as the authorization service of the ClearKey
+// DRM systems. A player gets a bearer token there for its license requests.
+func (d *DRMInfo) SetAuthzURL(url string) {
+	for i, cp := range d.ContentProtections {
+		if cp.DRMSystem == nil || cp.DRMSystem.SystemID != CommonSystemID {
+			continue
+		}
+		system := *cp.DRMSystem
+		system.AuthzURL = &DRMService{URL: url, Type: "Bearer"}
+		d.ContentProtections[i].DRMSystem = &system
+	}
+}
+
+// ActiveContentProtections returns the content protections to signal in the
+// catalog at MoQ group groupNr. With key rotation, their default KIDs are
+// the KIDs of the current and the next key period, so that players can
+// request licenses ahead of the key change.
+func (d *DRMInfo) ActiveContentProtections(groupNr uint64) []ContentProtection {
+	if d.RotationGroups() == 0 {
+		return d.ContentProtections
+	}
+	cps := make([]ContentProtection, 0, len(d.ContentProtections))
+	for _, tier := range d.tiers {
+		if !tier.cenc.rotates() {
+			for _, refID := range tier.refIDs {
+				cp, _ := d.contentProtection(refID)
+				cps = append(cps, cp)
+			}
+			continue
+		}
+		current := tier.cenc.keyForGroup(groupNr)
+		next := tier.cenc.keyForGroup(groupNr + tier.cenc.rotationGroups)
+		kids := []mp4.UUID{current.kid}
+		if !bytes.Equal(next.kid, current.kid) {
+			kids = append(kids, next.kid)
+		}
+		kidStrs := make([]string, 0, len(kids))
+		for _, kid := range kids {
+			kidStrs = append(kidStrs, kid.String())
+		}
+		for _, refID := range tier.refIDs {
+			cp, _ := d.contentProtection(refID)
+			cp.DefaultKIDs = kidStrs
+			if cp.DRMSystem != nil {
+				system := *cp.DRMSystem
+				if system.SystemID == CommonSystemID {
+					if pssh, err := encodeClearKeyPssh(kids...); err == nil {
+						system.Pssh = pssh
+					}
+				} else {
+					system.Pssh = rotationPssh(system.SystemID, current, next)
+				}
+				cp.DRMSystem = &system
+			}
+			cps = append(cps, cp)
+		}
+	}
+	return cps
+}
+
+// rotationPssh returns the CPIX PSSH boxes of systemID for the current and
+// the next key, concatenated as in EME cenc initialization data.
+func rotationPssh(systemID string, current, next contentKey) string {
+	var boxes []byte
+	for _, ck := range []contentKey{current, next} {
+		box, err := base64.StdEncoding.DecodeString(ck.psshs[systemID])
+		if err != nil {
+			continue
+		}
+		boxes = append(boxes, box...)
+		if bytes.Equal(next.kid, current.kid) {
+			break
+		}
+	}
+	return base64.StdEncoding.EncodeToString(boxes)
+}
+
+// encodeClearKeyPssh returns a base64-encoded ClearKey PSSH box for kids.
+func encodeClearKeyPssh(kids ...mp4.UUID) (string, error) {
+	psshBox, err := createClearKeyPssh(kids...)
+	if err != nil {
+		return "", err
+	}
+	sw := bits.NewFixedSliceWriter(int(psshBox.Size()))
+	if err := psshBox.EncodeSW(sw); err != nil {
+		return "", fmt.Errorf("failed to encode pssh box: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(sw.Bytes()), nil
+}
+
+// addToKeyHigh returns key with n added to the big-endian number in its upper half.
+func addToKeyHigh(key []byte, n uint64) []byte {
+	half := len(key) / 2
+	return append(addToKey(key[:half], n), key[half:]...)
+}
+
+// addToKey returns the big-endian number key plus n.
+func addToKey(key []byte, n uint64) []byte {
+	out := append([]byte(nil), key...)
+	for i := len(out) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(out[i]) + n&0xff
+		out[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	return out
+}
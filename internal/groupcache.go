@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultGroupCacheBytes is the default memory bound of a GroupCache.
+const DefaultGroupCacheBytes = 64 << 20
+
+// GroupCache caches generated MoQGroups, so that the subscriptions and
+// FETCHes of a track share the work of generating, and for protected tracks
+// encrypting, each group. Concurrent requests for a group that is not cached
+// wait for a single generation. When the cached groups exceed the memory
+// bound, the least recently used groups are evicted. Groups requested with
+// GenUncachedMoQGroup, such as those of a wide FETCH, are taken from the
+// cache but not added to it, so that they do not evict the live edge.
+//
+// The cached groups are shared and must not be modified. A nil *GroupCache
+// generates every group.
+type GroupCache struct {
+	maxBytes int
+
+	mu      sync.Mutex
+	entries map[groupKey]*list.Element
+	lru     *list.List // of *groupEntry, most recently used first
+	size    int        // payload bytes of the cached groups
+	stats   GroupCacheStats
+}
+
+// groupKey identifies a generated group. Tracks are identified by asset and
+// name, since GetTrackByName returns copies.
+type groupKey struct {
+	asset         *Asset
+	track         string
+	packaging     string
+	groupNr       uint64
+	sampleBatch   int
+	constantDurMS uint32
+}
+
+// groupEntry is a cached group, or one being generated until ready is closed.
+type groupEntry struct {
+	key   groupKey
+	ready chan struct{}
+	mg    *MoQGroup
+	err   error
+	size  int
+}
+
+// GroupCacheStats are the counters of a GroupCache.
+type GroupCacheStats struct {
+	Hits      uint64 // groups found in the cache, or being generated
+	Misses    uint64 // groups generated for the cache
+	Evictions uint64 // groups evicted to stay within the memory bound
+	Groups    int    // groups cached
+	Bytes     int    // payload bytes of the cached groups
+}
+
+// NewGroupCache returns a GroupCache holding at most maxBytes of object
+// payload.
+func NewGroupCache(maxBytes int) *GroupCache {
+	return &GroupCache{
+		maxBytes: maxBytes,
+		entries:  make(map[groupKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// GenMoQGroup returns the MoQGroup of track of asset as generated by
+// GenMoQGroup, from the cache if possible.
+func (c *GroupCache) GenMoQGroup(asset *Asset, track *ContentTrack, groupNr uint64, sampleBatch int,
+	constantDurMS uint32, packaging string) (*MoQGroup, error) {
+	if c == nil {
+		return GenMoQGroup(track, groupNr, sampleBatch, constantDurMS, packaging)
+	}
+	key := newGroupKey(asset, track, groupNr, sampleBatch, constantDurMS, packaging)
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+		e := elem.Value.(*groupEntry)
+		c.mu.Unlock()
+		<-e.ready
+		return e.mg, e.err
+	}
+	e := &groupEntry{key: key, ready: make(chan struct{})}
+	elem := c.lru.PushFront(e)
+	c.entries[key] = elem
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	e.mg, e.err = GenMoQGroup(track, groupNr, sampleBatch, constantDurMS, packaging)
+	close(e.ready)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[key] != elem {
+		return e.mg, e.err // evicted while generated
+	}
+	if e.err != nil {
+		c.remove(elem)
+		return e.mg, e.err
+	}
+	for _, o := range e.mg.MoQObjects {
+		e.size += len(o)
+	}
+	c.size += e.size
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return e.mg, e.err
+}
+
+// GenUncachedMoQGroup returns the MoQGroup of track of asset from the cache
+// if it is cached or being generated, and otherwise generates it without
+// adding it to the cache.
+func (c *GroupCache) GenUncachedMoQGroup(asset *Asset, track *ContentTrack, groupNr uint64, sampleBatch int,
+	constantDurMS uint32, packaging string) (*MoQGroup, error) {
+	if c == nil {
+		return GenMoQGroup(track, groupNr, sampleBatch, constantDurMS, packaging)
+	}
+	key := newGroupKey(asset, track, groupNr, sampleBatch, constantDurMS, packaging)
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+	}
+	c.mu.Unlock()
+	if !ok {
+		return GenMoQGroup(track, groupNr, sampleBatch, constantDurMS, packaging)
+	}
+	e := elem.Value.(*groupEntry)
+	<-e.ready
+	return e.mg, e.err
+}
+
+func newGroupKey(asset *Asset, track *ContentTrack, groupNr uint64, sampleBatch int, constantDurMS uint32,
+	packaging string) groupKey {
+	return groupKey{
+		asset:         asset,
+		track:         track.Name,
+		packaging:     packaging,
+		groupNr:       groupNr,
+		sampleBatch:   sampleBatch,
+		constantDurMS: constantDurMS,
+	}
+}
+
+// remove removes elem from the cache. The mutex must be held.
+func (c *GroupCache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*groupEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// Stats returns the counters of the cache.
+func (c *GroupCache) Stats() GroupCacheStats {
+	if c == nil {
+		return GroupCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Groups = c.lru.Len()
+	s.Bytes = c.size
+	return s
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCache(t *testing.T) {
+	asset, err := LoadAsset("../assets/test10s", 1, 1)
+	require.NoError(t, err)
+	video := asset.GetTrackByName("video_400kbps_avc")
+	audio := asset.GetTrackByName("audio_monotonic_128kbps_aac")
+	require.NotNil(t, video)
+	require.NotNil(t, audio)
+
+	var nilCache *GroupCache
+	mg, err := nilCache.GenMoQGroup(asset, video, 1, 1, MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	assert.Equal(t, GroupCacheStats{}, nilCache.Stats())
+
+	c := NewGroupCache(DefaultGroupCacheBytes)
+	cached, err := c.GenMoQGroup(asset, video, 1, 1, MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	assert.Equal(t, mg.MoQObjects, cached.MoQObjects, "cached groups are generated by GenMoQGroup")
+	again, err := c.GenMoQGroup(asset, asset.GetTrackByName("video_400kbps_avc"), 1, 1, MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	assert.Same(t, cached, again, "copies of a track share the cached groups")
+	for _, other := range []struct {
+		track       *ContentTrack
+		groupNr     uint64
+		sampleBatch int
+		packaging   string
+	}{
+		{video, 2, 1, "cmaf"},
+		{video, 1, 2, "cmaf"},
+		{video, 1, 1, "locmaf"},
+		{audio, 1, 1, "cmaf"},
+	} {
+		mg, err := c.GenMoQGroup(asset, other.track, other.groupNr, other.sampleBatch, MoqGroupDurMS, other.packaging)
+		require.NoError(t, err)
+		assert.NotSame(t, cached, mg)
+	}
+	s := c.Stats()
+	assert.Equal(t, uint64(1), s.Hits)
+	assert.Equal(t, uint64(5), s.Misses)
+	assert.Equal(t, 5, s.Groups)
+	assert.Equal(t, uint64(0), s.Evictions)
+
+	// Concurrent requests for a group share one generation.
+	c = NewGroupCache(DefaultGroupCacheBytes)
+	var wg sync.WaitGroup
+	groups := make([]*MoQGroup, 10)
+	for i := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			groups[i], _ = c.GenMoQGroup(asset, video, 3, 1, MoqGroupDurMS, "cmaf")
+		}()
+	}
+	wg.Wait()
+	for _, mg := range groups {
+		assert.Same(t, groups[0], mg)
+	}
+	assert.Equal(t, uint64(1), c.Stats().Misses)
+
+	// The least recently used groups are evicted.
+	size := 0
+	for _, o := range mg.MoQObjects {
+		size += len(o)
+	}
+	c = NewGroupCache(2*size + size/2)
+	for _, groupNr := range []uint64{1, 2, 1, 3} {
+		_, err := c.GenMoQGroup(asset, video, groupNr, 1, MoqGroupDurMS, "cmaf")
+		require.NoError(t, err)
+	}
+	s = c.Stats()
+	assert.Equal(t, uint64(1), s.Evictions)
+	assert.Equal(t, 2, s.Groups)
+	assert.LessOrEqual(t, s.Bytes, 2*size+size/2)
+	_, err = c.GenMoQGroup(asset, video, 1, 1, MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), c.Stats().Hits, "group 1 was used more recently than group 2")
+
+	// Uncached groups are taken from the cache, but not added to it.
+	c = NewGroupCache(DefaultGroupCacheBytes)
+	cached, err = c.GenMoQGroup(asset, video, 1, 1, MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	again, err = c.GenUncachedMoQGroup(asset, video, 1, 1, MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	assert.Same(t, cached, again)
+	uncached, err := c.GenUncachedMoQGroup(asset, video, 2, 1, MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	mg, err = GenMoQGroup(video, 2, 1, MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	assert.Equal(t, mg.MoQObjects, uncached.MoQObjects)
+	s = c.Stats()
+	assert.Equal(t, uint64(1), s.Hits)
+	assert.Equal(t, uint64(1), s.Misses)
+	assert.Equal(t, 1, s.Groups)
+}
+
+// BenchmarkGroupSubscribers measures the CPU time per subscriber of
+// generating each group of a track for a number of subscribers, with and
+// without a GroupCache.
+func BenchmarkGroupSubscribers(b *testing.B) {
+	eccp, err := ParseCENCflags("cbcs", "39112233445566778899aabbccddeeff", "",
+		"41112233445566778899aabbccddeeff", "http://localhost:8081/clearkey")
+	require.NoError(b, err)
+	asset, err := LoadAssetWithProtection("../assets/test10s", 1, 1, nil, eccp)
+	require.NoError(b, err)
+	for _, track := range []string{"video_400kbps_avc", "video_400kbps_avc_eccp"} {
+		for _, packaging := range []string{"cmaf", "locmaf"} {
+			for _, subscribers := range []int{1, 10, 100} {
+				for _, cached := range []bool{false, true} {
+					name := fmt.Sprintf("%s/%s/subscribers=%d/cached=%t", track, packaging, subscribers, cached)
+					b.Run(name, func(b *testing.B) {
+						benchmarkGroupSubscribers(b, asset, track, packaging, subscribers, cached)
+					})
+				}
+			}
+		}
+	}
+}
+
+func benchmarkGroupSubscribers(b *testing.B, asset *Asset, trackName, packaging string, subscribers int,
+	cached bool) {
+	var c *GroupCache
+	if cached {
+		c = NewGroupCache(DefaultGroupCacheBytes)
+	}
+	tracks := make([]*ContentTrack, subscribers)
+	for i := range tracks {
+		tracks[i] = asset.GetTrackByName(trackName)
+	}
+	b.ReportAllocs()
+	groupNr := uint64(0)
+	for b.Loop() {
+		for _, ct := range tracks {
+			if _, err := c.GenMoQGroup(asset, ct, groupNr, 1, MoqGroupDurMS, packaging); err != nil {
+				b.Fatal(err)
+			}
+		}
+		groupNr++
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(groupNr)/float64(subscribers), "ns/subscriber-group")
+}
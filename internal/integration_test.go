@@ -2,16 +2,28 @@ package internal_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/synctest"
 	"time"
 
 	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqlivemock/internal/load"
 	"github.com/Eyevinn/moqlivemock/internal/pub"
+	"github.com/Eyevinn/moqlivemock/internal/relay"
 	"github.com/Eyevinn/moqlivemock/internal/sub"
+	"github.com/Eyevinn/moqtransport"
 	"github.com/Eyevinn/mp4ff/bits"
 	"github.com/Eyevinn/mp4ff/mp4"
 	"github.com/stretchr/testify/assert"
@@ -294,6 +306,90 @@ func TestSubtitleReceive(t *testing.T) {
 	})
 }
 
+// TestDatagramReceive publishes the audio and subtitle tracks as object
+// datagrams and checks that the subscriber receives every audio object of
+// the groups sent as datagrams.
+func TestDatagramReceive(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+
+		ph := newPubHandler(asset, catalog)
+		ph.Datagrams = &pub.DatagramTracks{Audio: true, Subtitles: true}
+		go ph.Handle(t.Context(), sConn)
+
+		audioBuf := newSyncBuffer()
+		subsBuf := newSyncBuffer()
+		sh := newSubHandler(map[string]io.Writer{"audio": audioBuf, "subs": subsBuf})
+		sh.VideoName = "NONE"
+		sh.SubsName = "wvtt"
+		go func() { _ = sh.RunWithConn(t.Context(), cConn) }()
+
+		// The first group of each subscription is sent on a stream.
+		const nrGroups = 4
+		time.Sleep(nrGroups * time.Duration(internal.MoqGroupDurMS) * time.Millisecond)
+		shutdown(sConn, cConn)
+
+		assert.Greater(t, sConn.sentDgrams.Load(), int64(nrGroups), "objects should be sent as datagrams")
+		assert.Greater(t, subsBuf.Len(), 0, "should have received subtitle data")
+		f, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(audioBuf.Bytes()))
+		require.NoError(t, err, "audio output should be valid MP4")
+		require.NotNil(t, f.Init)
+		// Every fragment starts where the previous one ended.
+		nrObjects := 0
+		var nextTime uint64
+		for _, seg := range f.Segments {
+			for _, frag := range seg.Fragments {
+				samples, err := frag.GetFullSamples(f.Init.Moov.Mvex.Trex)
+				require.NoError(t, err)
+				if nrObjects > 0 {
+					require.Equal(t, nextTime, samples[0].DecodeTime, "no audio object should be missing")
+				}
+				last := samples[len(samples)-1]
+				nextTime = last.DecodeTime + uint64(last.Dur)
+				nrObjects++
+			}
+		}
+		ct := asset.GetTrackByName("audio_monotonic_128kbps_aac")
+		require.NotNil(t, ct)
+		require.Greater(t, nrObjects, (nrGroups-2)*len(internal.MoQObjectTimesMS(ct, 0, ct.SampleBatch,
+			internal.MoqGroupDurMS)), "should have received the audio objects of the groups")
+	})
+}
+
+// TestDatagramBeforeSubscribeOk delays the control messages to the
+// subscriber, so that the first datagrams of a subscription arrive before its
+// SUBSCRIBE_OK, and checks that they are dropped and the session survives.
+func TestDatagramBeforeSubscribeOk(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		ph := newPubHandler(asset, catalog)
+		ph.Datagrams = &pub.DatagramTracks{Audio: true}
+		go ph.Handle(t.Context(), sConn)
+		session := newClientSession(t, cConn)
+
+		cConn.controlDelay.Store(int64(200 * time.Millisecond))
+		rs, err := session.Subscribe(t.Context(), []string{testNamespace}, "audio_monotonic_128kbps_aac")
+		require.NoError(t, err)
+		cConn.controlDelay.Store(0)
+		subscribed := time.Now().UnixMilli()
+		require.Greater(t, sConn.sentDgrams.Load(), int64(0), "objects should be sent as datagrams")
+		assert.Less(t, sConn.firstDgram.Load(), subscribed, "datagrams should overtake the SUBSCRIBE_OK")
+		obj, err := rs.ReadObject(t.Context())
+		require.NoError(t, err)
+		firstGroup := obj.GroupID
+		for obj.GroupID < firstGroup+2 {
+			obj, err = rs.ReadObject(t.Context())
+			require.NoError(t, err, "session should survive")
+		}
+		require.NoError(t, rs.Close())
+		shutdown(sConn, cConn)
+	})
+}
+
 func TestMuxedOutput(t *testing.T) {
 	asset, catalog := loadTestAsset(t)
 
@@ -319,3 +415,1277 @@ func TestMuxedOutput(t *testing.T) {
 		assert.Equal(t, 2, len(f.Init.Moov.Traks), "should have 2 tracks (video + audio)")
 	})
 }
+
+// TestLiveCatalogUpdate removes the subscribed video track from the live
+// catalog mid-session and checks that the subscriber applies the delta and
+// switches to another matching video track.
+func TestLiveCatalogUpdate(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+
+		lc, err := pub.NewLiveCatalog(catalog)
+		require.NoError(t, err)
+		ph := newPubHandler(asset, catalog)
+		ph.Namespaces[0].Live = lc
+		go ph.Handle(t.Context(), sConn)
+
+		catalogBuf := newSyncBuffer()
+		videoBuf := newSyncBuffer()
+		sh := newSubHandler(map[string]io.Writer{"catalog": catalogBuf, "video": videoBuf})
+		go func() { _ = sh.RunWithConn(t.Context(), cConn) }()
+
+		videoBuf.WaitForLen(1)
+		synctest.Wait()
+
+		var selected string
+		for _, track := range catalog.Tracks {
+			if track.Role == "video" && strings.Contains(track.Name, "_avc") {
+				selected = track.Name
+				break
+			}
+		}
+		require.NotEmpty(t, selected)
+		require.NoError(t, lc.RemoveTracks(selected))
+		synctest.Wait()
+
+		out := catalogBuf.String()
+		require.Equal(t, 2, strings.Count(out, `"tracks"`), "full catalog followed by the updated catalog")
+		updated := out[strings.LastIndex(out, `"tracks"`):]
+		assert.NotContains(t, updated, `"`+selected+`"`, "removed track should be gone")
+
+		// Video continues on the next matching track.
+		n := videoBuf.Len()
+		time.Sleep(2 * time.Second)
+		synctest.Wait()
+		assert.Greater(t, videoBuf.Len(), n, "video should continue after track removal")
+
+		shutdown(sConn, cConn)
+	})
+}
+
+// newClientSession runs a bare MoQ client session on conn that accepts all
+// announcements.
+func newClientSession(t *testing.T, conn *memConn) *moqtransport.Session {
+	t.Helper()
+	session := &moqtransport.Session{
+		Handler: moqtransport.HandlerFunc(func(w moqtransport.ResponseWriter, r *moqtransport.Message) {
+			if r.Method == moqtransport.MessageAnnounce {
+				_ = w.Accept()
+			}
+		}),
+		InitialMaxRequestID: 100,
+	}
+	require.NoError(t, session.Run(conn))
+	return session
+}
+
+// readFetchObjects reads n objects from a FETCH and returns their locations.
+func readFetchObjects(t *testing.T, rt *moqtransport.RemoteTrack, n int) []moqtransport.Location {
+	t.Helper()
+	locs := make([]moqtransport.Location, 0, n)
+	for range n {
+		o, err := rt.ReadObject(t.Context())
+		require.NoError(t, err)
+		require.NotEmpty(t, o.Payload)
+		locs = append(locs, moqtransport.Location{Group: o.GroupID, Object: o.ObjectID})
+	}
+	return locs
+}
+
+// TestMediaFetch fetches past groups of a video and a subtitle track with
+// standalone FETCHes, and the live edge of a video subscription with a
+// relative joining FETCH.
+func TestMediaFetch(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+	const videoTrack = "video_400kbps_avc"
+	ct := asset.GetTrackByName(videoTrack)
+	require.NotNil(t, ct)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+
+		ph := newPubHandler(asset, catalog)
+		go ph.Handle(t.Context(), sConn)
+		session := newClientSession(t, cConn)
+		ns := []string{testNamespace}
+
+		// Run for a while so that there is some history.
+		time.Sleep(10*time.Second + 500*time.Millisecond)
+		currGroup := uint64(time.Now().UnixMilli()) / uint64(internal.MoqGroupDurMS)
+		objectsPerGroup := func(groupNr uint64) int {
+			return len(internal.MoQObjectTimesMS(ct, groupNr, ct.SampleBatch, internal.MoqGroupDurMS))
+		}
+
+		// Standalone FETCH of three past video groups.
+		{
+			start := moqtransport.Location{Group: currGroup - 5}
+			end := moqtransport.Location{Group: currGroup - 3} // object 0: whole group
+			rt, err := session.Fetch(t.Context(), ns, videoTrack,
+				moqtransport.WithFetchStartLocation(start), moqtransport.WithFetchEndLocation(end))
+			require.NoError(t, err)
+			n := objectsPerGroup(currGroup-5) + objectsPerGroup(currGroup-4) + objectsPerGroup(currGroup-3)
+			locs := readFetchObjects(t, rt, n)
+			assert.Equal(t, start, locs[0])
+			assert.Equal(t, moqtransport.Location{Group: currGroup - 3, Object: uint64(objectsPerGroup(currGroup-3) - 1)},
+				locs[n-1])
+			require.NoError(t, rt.Close())
+		}
+
+		// Standalone FETCH of subtitle groups up to the current one.
+		{
+			rt, err := session.Fetch(t.Context(), ns, "subs_wvtt_en",
+				moqtransport.WithFetchStartLocation(moqtransport.Location{Group: currGroup - 2}),
+				moqtransport.WithFetchEndLocation(moqtransport.Location{Group: currGroup}))
+			require.NoError(t, err)
+			locs := readFetchObjects(t, rt, 3)
+			assert.Equal(t, moqtransport.Location{Group: currGroup}, locs[2])
+			require.NoError(t, rt.Close())
+		}
+
+		// Relative joining FETCH of the previous group up to the live edge.
+		{
+			rs, err := session.Subscribe(t.Context(), ns, videoTrack)
+			require.NoError(t, err)
+			largest, ok := rs.LargestLocation()
+			require.True(t, ok, "media subscription should report its largest location")
+			assert.Equal(t, currGroup, largest.Group)
+
+			rt, err := session.Fetch(t.Context(), nil, "", moqtransport.WithJoiningFetchRelative(rs.RequestID(), 1))
+			require.NoError(t, err)
+			n := objectsPerGroup(currGroup-1) + int(largest.Object) + 1
+			locs := readFetchObjects(t, rt, n)
+			assert.Equal(t, moqtransport.Location{Group: currGroup - 1}, locs[0])
+			assert.Equal(t, largest, locs[n-1])
+			require.NoError(t, rt.Close())
+			require.NoError(t, rs.Close())
+		}
+
+		shutdown(sConn, cConn)
+	})
+}
+
+// TestSubscribeFilters subscribes to a video track with each filter type over
+// draft-16, where the filter locations are carried in the SUBSCRIPTION_FILTER
+// parameter, and checks where publishing starts and that an absolute range
+// ends with PUBLISH_DONE.
+func TestSubscribeFilters(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+	const videoTrack = "video_400kbps_avc"
+	ct := asset.GetTrackByName(videoTrack)
+	require.NotNil(t, ct)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		sConn.alpn, cConn.alpn = "moqt-16", "moqt-16"
+
+		ph := newPubHandler(asset, catalog)
+		go ph.Handle(t.Context(), sConn)
+		session := newClientSession(t, cConn)
+		ns := []string{testNamespace}
+
+		// Start in the middle of a group.
+		time.Sleep(2*time.Second + 500*time.Millisecond)
+		currGroup := uint64(time.Now().UnixMilli()) / uint64(internal.MoqGroupDurMS)
+		objectsPerGroup := func(groupNr uint64) int {
+			return len(internal.MoQObjectTimesMS(ct, groupNr, ct.SampleBatch, internal.MoqGroupDurMS))
+		}
+		readObject := func(rs *moqtransport.RemoteTrack) moqtransport.Location {
+			t.Helper()
+			o, err := rs.ReadObject(t.Context())
+			require.NoError(t, err)
+			return moqtransport.Location{Group: o.GroupID, Object: o.ObjectID}
+		}
+
+		// Largest Object starts mid-group with the object after the largest.
+		{
+			rs, err := session.Subscribe(t.Context(), ns, videoTrack)
+			require.NoError(t, err)
+			largest, ok := rs.LargestLocation()
+			require.True(t, ok)
+			assert.Equal(t, currGroup, largest.Group)
+			require.Positive(t, largest.Object, "largest object should be mid-group")
+			assert.Equal(t, moqtransport.Location{Group: largest.Group, Object: largest.Object + 1}, readObject(rs))
+			require.NoError(t, rs.Close())
+		}
+
+		// Next Group Start starts with object 0 of the next group.
+		{
+			rs, err := session.Subscribe(t.Context(), ns, videoTrack,
+				moqtransport.WithFilterType(moqtransport.FilterTypeNextGroupStart))
+			require.NoError(t, err)
+			got := readObject(rs)
+			assert.Equal(t, uint64(0), got.Object)
+			assert.Equal(t, uint64(time.Now().UnixMilli())/uint64(internal.MoqGroupDurMS), got.Group)
+			require.NoError(t, rs.Close())
+		}
+
+		// Absolute Range from mid-group ends after its end group with
+		// PUBLISH_DONE status SUBSCRIPTION_ENDED.
+		{
+			currGroup = uint64(time.Now().UnixMilli()) / uint64(internal.MoqGroupDurMS)
+			start := moqtransport.Location{Group: currGroup + 1, Object: 2}
+			endGroup := currGroup + 2
+			rs, err := session.Subscribe(t.Context(), ns, videoTrack,
+				moqtransport.WithFilterType(moqtransport.FilterTypeAbsoluteRange),
+				moqtransport.WithStartLocation(start), moqtransport.WithEndGroup(endGroup))
+			require.NoError(t, err)
+			var locs []moqtransport.Location
+			for {
+				// Objects received before the PUBLISH_DONE come with an error.
+				o, err := rs.ReadObject(t.Context())
+				if err != nil && o == nil {
+					var done *moqtransport.ErrSubscribeDone
+					require.ErrorAs(t, err, &done)
+					assert.Equal(t, uint64(moqtransport.ErrorCodeSubscribeDoneSubscriptionEnded), done.Status)
+					break
+				}
+				locs = append(locs, moqtransport.Location{Group: o.GroupID, Object: o.ObjectID})
+			}
+			require.NotEmpty(t, locs)
+			assert.Equal(t, start, locs[0])
+			// The end of the range may race with its last object, but all
+			// earlier objects of the end group are received.
+			assert.GreaterOrEqual(t, len(locs), objectsPerGroup(start.Group)-int(start.Object)+objectsPerGroup(endGroup)-1)
+			for _, loc := range locs {
+				assert.LessOrEqual(t, loc.Group, endGroup)
+			}
+		}
+
+		// An Absolute Range that has already been published is rejected.
+		{
+			_, err := session.Subscribe(t.Context(), ns, videoTrack,
+				moqtransport.WithFilterType(moqtransport.FilterTypeAbsoluteRange),
+				moqtransport.WithStartLocation(moqtransport.Location{Group: currGroup - 2}),
+				moqtransport.WithEndGroup(currGroup-1))
+			require.Error(t, err)
+		}
+
+		shutdown(sConn, cConn)
+	})
+}
+
+// receivedGroups records the object IDs of a subscription per group.
+type receivedGroups map[uint64][]uint64
+
+// readFaultyVideo subscribes to a video track from a publisher with faults
+// and returns the objects received in the first nrGroups groups and the
+// number of subgroup streams reset by the publisher.
+func readFaultyVideo(t *testing.T, faults *pub.FaultConfig, nrGroups uint64) (receivedGroups, int64) {
+	asset, catalog := loadTestAsset(t)
+	got := receivedGroups{}
+	var resets int64
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		ph := newPubHandler(asset, catalog)
+		ph.Faults = faults
+		go ph.Handle(t.Context(), sConn)
+		session := newClientSession(t, cConn)
+
+		rs, err := session.Subscribe(t.Context(), []string{testNamespace}, "video_400kbps_avc",
+			moqtransport.WithFilterType(moqtransport.FilterTypeNextGroupStart))
+		require.NoError(t, err)
+		firstGroup := uint64(time.Now().UnixMilli())/uint64(internal.MoqGroupDurMS) + 1
+		// The last object of a group is sent at the end of the group.
+		ctx, cancel := context.WithTimeout(t.Context(),
+			time.Duration(nrGroups+1)*time.Duration(internal.MoqGroupDurMS)*time.Millisecond+500*time.Millisecond)
+		defer cancel()
+		for {
+			o, err := rs.ReadObject(ctx)
+			if err != nil {
+				break
+			}
+			if o.GroupID >= firstGroup+nrGroups {
+				continue
+			}
+			got[o.GroupID-firstGroup] = append(got[o.GroupID-firstGroup], o.ObjectID)
+		}
+		resets = sConn.resets.Load()
+		shutdown(sConn, cConn)
+	})
+	return got, resets
+}
+
+// TestFaultInjection checks that faults are injected into the groups of a
+// video subscription and that the same seed gives the same faults.
+func TestFaultInjection(t *testing.T) {
+	const nrGroups = 20
+	const objectsPerGroup = 25 // 25 fps video, one object per frame
+	faults := &pub.FaultConfig{Seed: 42, DropObject: 0.02, DropGroup: 0.2, ResetStream: 0.2, EndGroup: 0.2}
+	got, resets := readFaultyVideo(t, faults, nrGroups)
+
+	var dropped, truncated int
+	for groupNr := range uint64(nrGroups) {
+		ids := got[groupNr]
+		if len(ids) == 0 {
+			dropped++
+			continue
+		}
+		for i := 1; i < len(ids); i++ {
+			assert.Greater(t, ids[i], ids[i-1], "objects in order in group %d", groupNr)
+		}
+		if ids[len(ids)-1] < objectsPerGroup-1 {
+			truncated++
+		}
+	}
+	// Groups are truncated by a reset of their stream or by ending early,
+	// which closes the stream.
+	assert.Positive(t, dropped, "some groups should be dropped")
+	assert.Positive(t, resets, "some groups should be reset")
+	assert.Greater(t, int64(truncated), resets, "some groups should end early")
+	assert.Less(t, dropped+truncated, nrGroups, "some groups should be complete")
+
+	// The same seed gives the same faults, another seed different ones.
+	again, _ := readFaultyVideo(t, faults, nrGroups)
+	assert.Equal(t, got, again)
+	other := *faults
+	other.Seed = 43
+	otherGot, _ := readFaultyVideo(t, &other, nrGroups)
+	assert.NotEqual(t, got, otherGot)
+}
+
+// TestRelay runs the publisher → relay → subscriber chain. The publisher
+// announces its namespace to the relay, two subscribers receive video and
+// audio through it, and FETCHes are served from the relay's cache or
+// forwarded to the publisher.
+func TestRelay(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+	const videoTrack = "video_400kbps_avc"
+	ct := asset.GetTrackByName(videoTrack)
+	require.NotNil(t, ct)
+
+	synctest.Test(t, func(t *testing.T) {
+		r := &relay.Relay{CacheGroups: 3, Logfh: io.Discard}
+
+		// The publisher connects to the relay and announces its namespaces.
+		rpConn, pConn := memConnPair()
+		go r.Handle(t.Context(), rpConn)
+		go newPubHandler(asset, catalog).Handle(t.Context(), pConn)
+		synctest.Wait()
+
+		var bufs []*syncBuffer
+		conns := []*memConn{rpConn, pConn}
+		for range 2 {
+			rsConn, sConn := memConnPair()
+			conns = append(conns, rsConn, sConn)
+			go r.Handle(t.Context(), rsConn)
+			videoBuf, audioBuf := newSyncBuffer(), newSyncBuffer()
+			sh := newSubHandler(map[string]io.Writer{"video": videoBuf, "audio": audioBuf})
+			go func() { _ = sh.RunWithConn(t.Context(), sConn) }()
+			bufs = append(bufs, videoBuf, audioBuf)
+		}
+		for _, buf := range bufs {
+			buf.WaitForLen(1)
+		}
+
+		rcConn, cConn := memConnPair()
+		conns = append(conns, rcConn, cConn)
+		go r.Handle(t.Context(), rcConn)
+		session := newClientSession(t, cConn)
+		ns := []string{testNamespace}
+
+		_, err := session.Subscribe(t.Context(), []string{"nonexistent"}, videoTrack)
+		assert.Error(t, err, "subscription to a namespace that was not announced")
+
+		// Let the relay cache a few groups of the video track.
+		time.Sleep(3*time.Second + 500*time.Millisecond)
+		currGroup := uint64(time.Now().UnixMilli()) / uint64(internal.MoqGroupDurMS)
+		objectsPerGroup := func(groupNr uint64) int {
+			return len(internal.MoQObjectTimesMS(ct, groupNr, ct.SampleBatch, internal.MoqGroupDurMS))
+		}
+		for _, groupNr := range []uint64{currGroup - 1, currGroup - 6} { // cached, forwarded
+			rt, err := session.Fetch(t.Context(), ns, videoTrack,
+				moqtransport.WithFetchStartLocation(moqtransport.Location{Group: groupNr}),
+				moqtransport.WithFetchEndLocation(moqtransport.Location{Group: groupNr}))
+			require.NoError(t, err)
+			n := objectsPerGroup(groupNr)
+			locs := readFetchObjects(t, rt, n)
+			assert.Equal(t, moqtransport.Location{Group: groupNr}, locs[0])
+			assert.Equal(t, moqtransport.Location{Group: groupNr, Object: uint64(n - 1)}, locs[n-1])
+			require.NoError(t, rt.Close())
+		}
+
+		for i := 0; i < len(conns); i += 2 {
+			shutdown(conns[i], conns[i+1])
+		}
+	})
+}
+
+// TestRelaySelfSubscribe subscribes through the relay to a namespace that
+// the same session announced. The relay's upstream SUBSCRIBE then goes back
+// over the session whose control stream carried the subscription.
+func TestRelaySelfSubscribe(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		r := &relay.Relay{CacheGroups: 3, Logfh: io.Discard}
+		rConn, cConn := memConnPair()
+		go r.Handle(t.Context(), rConn)
+
+		publishers := make(chan *moqtransport.SubscribeResponseWriter, 1)
+		session := &moqtransport.Session{
+			SubscribeHandler: moqtransport.SubscribeHandlerFunc(
+				func(w *moqtransport.SubscribeResponseWriter, m *moqtransport.SubscribeMessage) {
+					if err := w.Accept(); err == nil {
+						publishers <- w
+					}
+				}),
+			InitialMaxRequestID: 100,
+		}
+		require.NoError(t, session.Run(cConn))
+		ns := []string{testNamespace}
+		require.NoError(t, session.Announce(t.Context(), ns))
+
+		start := time.Now()
+		rt, err := session.Subscribe(t.Context(), ns, "video")
+		require.NoError(t, err)
+		assert.Zero(t, time.Since(start), "subscription waited for a timeout")
+
+		w := <-publishers
+		sg, err := w.OpenSubgroup(0, 0, 0)
+		require.NoError(t, err)
+		_, err = sg.WriteObject(0, []byte("object"))
+		require.NoError(t, err)
+		o, err := rt.ReadObject(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []byte("object"), o.Payload)
+		require.NoError(t, sg.Close())
+
+		shutdown(rConn, cConn)
+	})
+}
+
+func TestPublisherRelayReconnect(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		r := &relay.Relay{CacheGroups: 3, Logfh: io.Discard}
+		h := newPubHandler(asset, catalog)
+
+		rpConn, pConn := memConnPair()
+		go r.Handle(t.Context(), rpConn)
+		done := make(chan struct{})
+		go func() {
+			h.Handle(t.Context(), pConn)
+			close(done)
+		}()
+		synctest.Wait()
+
+		// The relay goes away, which ends the publisher session.
+		_ = rpConn.CloseWithError(0, "")
+		<-done
+
+		// The publisher connects again and announces its namespaces anew.
+		rpConn, pConn = memConnPair()
+		go r.Handle(t.Context(), rpConn)
+		go h.Handle(t.Context(), pConn)
+		synctest.Wait()
+
+		rsConn, sConn := memConnPair()
+		go r.Handle(t.Context(), rsConn)
+		videoBuf := newSyncBuffer()
+		sh := newSubHandler(map[string]io.Writer{"video": videoBuf})
+		go func() { _ = sh.RunWithConn(t.Context(), sConn) }()
+		videoBuf.WaitForLen(1)
+
+		shutdown(rsConn, sConn)
+		shutdown(rpConn, pConn)
+	})
+}
+
+func TestPublisherAnnounceRejected(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		// The publisher dials the relay, which rejects its namespace.
+		rConn, pConn := memConnPair()
+		errc := make(chan error, 1)
+		go func() { errc <- newPubHandler(asset, catalog).Handle(t.Context(), pConn) }()
+		session := &moqtransport.Session{
+			Handler: moqtransport.HandlerFunc(func(w moqtransport.ResponseWriter, r *moqtransport.Message) {
+				if r.Method == moqtransport.MessageAnnounce {
+					_ = w.Reject(0, "not accepted")
+				}
+			}),
+			InitialMaxRequestID: 100,
+		}
+		require.NoError(t, session.Run(rConn))
+
+		// The publisher gives up the session, so a relay client reconnects.
+		assert.Error(t, <-errc)
+		assert.Error(t, pConn.Context().Err(), "connection closed")
+		shutdown(rConn, pConn)
+	})
+}
+
+// abrFragment is a video fragment in muxed ABR output.
+type abrFragment struct {
+	sampleDescriptionIndex uint32
+	start, end             uint64
+}
+
+// receiveABR receives video and audio with ABR, starting with videoName, for
+// dur and returns the video fragments of the muxed output and the number of
+// video sample entries in its init segment.
+func receiveABR(t *testing.T, videoName string, faults *pub.FaultConfig, rule sub.ABRRule,
+	dur time.Duration) ([]abrFragment, int) {
+	asset, catalog := loadTestAsset(t)
+	var frags []abrFragment
+	var nrEntries int
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		ph := newPubHandler(asset, catalog)
+		ph.Faults = faults
+		go ph.Handle(t.Context(), sConn)
+
+		muxBuf := newSyncBuffer()
+		sh := newSubHandler(map[string]io.Writer{"mux": muxBuf})
+		sh.VideoName = videoName
+		sh.ABR = rule
+		go func() { _ = sh.RunWithConn(t.Context(), cConn) }()
+		time.Sleep(dur)
+		shutdown(sConn, cConn)
+
+		f, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(muxBuf.Bytes()))
+		require.NoError(t, err, "muxed output should be valid MP4")
+		require.NotNil(t, f.Init)
+		for _, trak := range f.Init.Moov.Traks {
+			if trak.Tkhd.TrackID == 1 {
+				nrEntries = len(trak.Mdia.Minf.Stbl.Stsd.Children)
+			}
+		}
+		for _, seg := range f.Segments {
+			for _, frag := range seg.Fragments {
+				traf := frag.Moof.Traf
+				if traf.Tfhd.TrackID != 1 {
+					continue
+				}
+				start := traf.Tfdt.BaseMediaDecodeTime()
+				frags = append(frags, abrFragment{
+					sampleDescriptionIndex: traf.Tfhd.SampleDescriptionIndex,
+					start:                  start,
+					end:                    start + traf.Trun.Duration(traf.Tfhd.DefaultSampleDuration),
+				})
+			}
+		}
+	})
+	return frags, nrEntries
+}
+
+// sampleDescriptionIndexes returns the sample description indexes of the
+// video fragments in order of use.
+func sampleDescriptionIndexes(frags []abrFragment) []uint32 {
+	var indexes []uint32
+	for _, f := range frags {
+		if len(indexes) == 0 || f.sampleDescriptionIndex != indexes[len(indexes)-1] {
+			indexes = append(indexes, f.sampleDescriptionIndex)
+		}
+	}
+	return indexes
+}
+
+// TestABR switches the video track of a muxed output up through the AVC
+// ladder while groups arrive in time, and down when the publisher stalls.
+func TestABR(t *testing.T) {
+	frags, nrEntries := receiveABR(t, "_avc", nil, &sub.ThroughputRule{UpSwitchGroups: 2}, 10*time.Second)
+	assert.Equal(t, 3, nrEntries, "one sample entry per AVC rendition")
+	assert.Equal(t, []uint32{1, 2, 3}, sampleDescriptionIndexes(frags))
+	require.NotEmpty(t, frags)
+	for i := 1; i < len(frags); i++ {
+		assert.Equal(t, frags[i-1].end, frags[i].start, "fragment %d continues the previous one", i)
+	}
+
+	// Objects held back by a stall are sent in a burst and may arrive out of
+	// order, so only the switches are checked.
+	stalls := &pub.FaultConfig{StallEvery: 3 * time.Second, StallFor: 1500 * time.Millisecond}
+	frags, _ = receiveABR(t, "900kbps_avc", stalls, &sub.ThroughputRule{UpSwitchGroups: 100}, 9*time.Second)
+	indexes := sampleDescriptionIndexes(frags)
+	require.Greater(t, len(indexes), 1, "stalls should make ABR switch down")
+	assert.Equal(t, uint32(3), indexes[0])
+	for i := 1; i < len(indexes); i++ {
+		assert.Less(t, indexes[i], indexes[i-1])
+	}
+}
+
+// TestLatencyReport measures the latency of received video and audio. With
+// synthetic time, each object is received when its last sample ends, so the
+// latency is the media duration of the object.
+func TestLatencyReport(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		ph := newPubHandler(asset, catalog)
+		go ph.Handle(t.Context(), sConn)
+
+		sh := newSubHandler(map[string]io.Writer{})
+		sh.Latency = sub.NewLatencyMeter()
+		go func() { _ = sh.RunWithConn(t.Context(), cConn) }()
+		time.Sleep(3500 * time.Millisecond)
+		shutdown(sConn, cConn)
+
+		r := sh.Latency.Report()
+		require.Len(t, r.Tracks, 2)
+		for _, tr := range r.Tracks {
+			assert.Greater(t, tr.Objects, 25, "track %s", tr.Track)
+			assert.GreaterOrEqual(t, tr.Groups, 2, "track %s", tr.Track)
+			assert.Greater(t, tr.Latency.Min, 0.0, "track %s", tr.Track)
+			assert.Less(t, tr.Latency.Max, 100.0, "track %s", tr.Track)
+			assert.Less(t, tr.JitterMS, 50.0, "track %s", tr.Track)
+			assert.InDelta(t, 1000, tr.GroupCompletion.Max, 100, "track %s", tr.Track)
+		}
+		require.NotNil(t, r.AVSkew)
+		assert.Less(t, r.AVSkew.Max, 100.0)
+		assert.Greater(t, r.AVSkew.Min, -100.0)
+	})
+}
+
+// metricValue returns the value of the metric sample line starting with
+// prefix in out.
+func metricValue(t *testing.T, out, prefix string) string {
+	t.Helper()
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, prefix+" ") {
+			return strings.TrimPrefix(line, prefix+" ")
+		}
+	}
+	t.Fatalf("no metric %s", prefix)
+	return ""
+}
+
+// TestPublisherMetrics checks the publisher metrics during and after a
+// session with video and audio subscriptions and a catalog FETCH.
+func TestPublisherMetrics(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		ph := newPubHandler(asset, catalog)
+		ph.Metrics = pub.NewMetrics()
+		go ph.Handle(t.Context(), sConn)
+
+		sh := newSubHandler(map[string]io.Writer{})
+		sh.CatalogMode = "fetch"
+		go func() { _ = sh.RunWithConn(t.Context(), cConn) }()
+		time.Sleep(3500 * time.Millisecond)
+
+		var buf bytes.Buffer
+		require.NoError(t, ph.Metrics.Write(&buf))
+		out := buf.String()
+		video := `{namespace="cmsf/clear",track="video_400kbps_avc",packaging="cmaf"}`
+		assert.Equal(t, "1", metricValue(t, out, "mlmpub_sessions_active"))
+		assert.Equal(t, "1", metricValue(t, out, "mlmpub_subscriptions_active"+video))
+		assert.Equal(t, "1", metricValue(t, out,
+			`mlmpub_subscriptions_active{namespace="cmsf/clear",track="audio_monotonic_128kbps_aac",packaging="cmaf"}`))
+		assert.Equal(t, "87", metricValue(t, out, "mlmpub_objects_written_total"+video),
+			"3 groups of 25 objects and 12 objects sent by 3.5s")
+		assert.NotEqual(t, "0", metricValue(t, out, "mlmpub_bytes_written_total"+video))
+		assert.Equal(t, "0", metricValue(t, out, "mlmpub_groups_late_total"+video))
+		assert.Equal(t, "0", metricValue(t, out, "mlmpub_write_errors_total"+video))
+		assert.Equal(t, "1", metricValue(t, out, `mlmpub_fetches_total{namespace="cmsf/clear",track="catalog"}`))
+
+		shutdown(sConn, cConn)
+		buf.Reset()
+		require.NoError(t, ph.Metrics.Write(&buf))
+		out = buf.String()
+		assert.Equal(t, "0", metricValue(t, out, "mlmpub_sessions_active"))
+		assert.Equal(t, "1", metricValue(t, out, "mlmpub_sessions_total"))
+		assert.Equal(t, "0", metricValue(t, out, "mlmpub_subscriptions_active"+video))
+	})
+}
+
+// adminRequest sends a request to the admin API of h and returns the
+// response status and body.
+func adminRequest(t *testing.T, h http.Handler, method, path, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+// TestAdminAPI inspects and reconfigures a publisher with a subscriber
+// through the admin API.
+func TestAdminAPI(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		ph := newPubHandler(asset, catalog)
+		ph.Metrics = pub.NewMetrics()
+		admin := ph.AdminHandler()
+		go ph.Handle(t.Context(), sConn)
+
+		sh := newSubHandler(map[string]io.Writer{})
+		go func() { _ = sh.RunWithConn(t.Context(), cConn) }()
+		time.Sleep(1500 * time.Millisecond)
+
+		code, body := adminRequest(t, admin, "GET", "/admin/sessions", "")
+		require.Equal(t, http.StatusOK, code)
+		var sessions []pub.SessionStatus
+		require.NoError(t, json.Unmarshal([]byte(body), &sessions))
+		require.Len(t, sessions, 1)
+		assert.Equal(t, [][]string{{testNamespace}}, sessions[0].Namespaces)
+		require.Len(t, sessions[0].Subscriptions, 2)
+		tracks := []string{sessions[0].Subscriptions[0].Track, sessions[0].Subscriptions[1].Track}
+		assert.ElementsMatch(t, []string{"video_400kbps_avc", "audio_monotonic_128kbps_aac"}, tracks)
+
+		// Batch 5 video samples per object from the next group on.
+		code, body = adminRequest(t, admin, "PUT", "/admin/batching", `{"video": 5}`)
+		require.Equal(t, http.StatusOK, code, body)
+		assert.JSONEq(t, `{"video": 5, "audio": 2}`, body)
+		code, _ = adminRequest(t, admin, "PUT", "/admin/batching", `{"subtitle": 5}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		time.Sleep(2000 * time.Millisecond)
+		var buf bytes.Buffer
+		require.NoError(t, ph.Metrics.Write(&buf))
+		video := `{namespace="cmsf/clear",track="video_400kbps_avc",packaging="cmaf"}`
+		assert.Equal(t, "57", metricValue(t, buf.String(), "mlmpub_objects_written_total"+video),
+			"2 groups of 25 objects, 1 group of 5 objects and 2 objects sent by 3.5s")
+		_, _ = adminRequest(t, admin, "PUT", "/admin/batching", `{"video": 1}`)
+
+		code, body = adminRequest(t, admin, "PUT", "/admin/faults", `{"faults": "seed=1,drop=0.5"}`)
+		require.Equal(t, http.StatusOK, code, body)
+		assert.JSONEq(t, `{"faults": "seed=1,drop=0.5"}`, body)
+		require.NotNil(t, ph.CurrentFaults())
+		code, _ = adminRequest(t, admin, "PUT", "/admin/faults", `{"faults": "drop=2"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = adminRequest(t, admin, "PUT", "/admin/faults", `{"faults": ""}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Nil(t, ph.CurrentFaults())
+
+		// A disabled namespace is withdrawn, but its subscriptions continue.
+		code, body = adminRequest(t, admin, "PUT", "/admin/namespaces",
+			`{"namespace": ["cmsf/clear"], "enabled": false}`)
+		require.Equal(t, http.StatusOK, code, body)
+		assert.JSONEq(t, `[{"namespace": ["cmsf/clear"], "packaging": "", "enabled": false}]`, body)
+		time.Sleep(100 * time.Millisecond)
+		sessions = ph.Sessions()
+		require.Len(t, sessions, 1)
+		assert.Empty(t, sessions[0].Namespaces)
+		assert.Len(t, sessions[0].Subscriptions, 2)
+		code, _ = adminRequest(t, admin, "PUT", "/admin/namespaces", `{"namespace": ["other"], "enabled": true}`)
+		assert.Equal(t, http.StatusNotFound, code)
+		require.NoError(t, ph.SetNamespaceEnabled([]string{testNamespace}, true))
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, [][]string{{testNamespace}}, ph.Sessions()[0].Namespaces)
+
+		// The license policy is changed field by field.
+		code, _ = adminRequest(t, admin, "GET", "/admin/clearkey", "")
+		assert.Equal(t, http.StatusNotFound, code)
+		ph.ClearKey = pub.NewClearKeyServer(nil, pub.ClearKeyPolicy{Token: "token"})
+		code, body = adminRequest(t, admin, "PUT", "/admin/clearkey", `{"delay": "500ms", "failRate": 0.5}`)
+		require.Equal(t, http.StatusOK, code, body)
+		assert.JSONEq(t, `{"tokenSet": true, "authzSecretSet": false, "delay": "500ms", "failRate": 0.5, "failStatus": 0}`,
+			body)
+		assert.Equal(t, 500*time.Millisecond, ph.ClearKey.Policy().Delay)
+		assert.Equal(t, "token", ph.ClearKey.Policy().Token, "the token is kept")
+		// The token and the shared secret are write-only.
+		code, body = adminRequest(t, admin, "PUT", "/admin/clearkey", `{"authzSecret": "shared"}`)
+		require.Equal(t, http.StatusOK, code, body)
+		assert.NotContains(t, body, "shared")
+		assert.Contains(t, body, `"authzSecretSet":true`)
+		assert.Equal(t, "shared", ph.ClearKey.Policy().AuthzSecret)
+		code, _ = adminRequest(t, admin, "PUT", "/admin/clearkey", `{"failStatus": 200}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, body = adminRequest(t, admin, "GET", "/admin/clearkey", "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, `"failRate":0.5`)
+
+		code, _ = adminRequest(t, admin, "DELETE", "/admin/sessions/2", "")
+		assert.Equal(t, http.StatusNotFound, code)
+		code, _ = adminRequest(t, admin, "DELETE", fmt.Sprintf("/admin/sessions/%d", sessions[0].ID), "")
+		assert.Equal(t, http.StatusNoContent, code)
+		time.Sleep(100 * time.Millisecond)
+		assert.Empty(t, ph.Sessions())
+
+		shutdown(sConn, cConn)
+	})
+}
+
+// TestMultipleAssets serves two assets in namespaces of their own, and
+// checks that each namespace serves the tracks of its asset only.
+func TestMultipleAssets(t *testing.T) {
+	assetA, catalogA := loadTestAsset(t)
+	dir := t.TempDir()
+	absAsset, err := filepath.Abs(testAssetDir)
+	require.NoError(t, err)
+	manifest := fmt.Sprintf(`{"tracks": [
+		{"file": "%s/video_600kbps_avc.mp4", "name": "b_video"},
+		{"file": "%s/audio_scale_128kbps_aac.mp4", "name": "b_audio"}]}`, absAsset, absAsset)
+	manifestPath := filepath.Join(dir, "b.json")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o644))
+	assetB, err := internal.LoadAsset(manifestPath, 1, 1)
+	require.NoError(t, err)
+	catalogB, err := assetB.GenCMAFCatalogEntry("cmsf/clear/b", internal.ProtectionNone, time.Now().UnixMilli())
+	require.NoError(t, err)
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		ph := &pub.Handler{
+			Namespaces: []pub.NamespaceEntry{
+				{Namespace: []string{"cmsf/clear/a"}, Catalog: catalogA, Asset: assetA},
+				{Namespace: []string{"cmsf/clear/b"}, Catalog: catalogB, Asset: assetB},
+			},
+			Logfh: io.Discard,
+		}
+		go ph.Handle(t.Context(), sConn)
+		session := newClientSession(t, cConn)
+		nsA, nsB := []string{"cmsf/clear/a"}, []string{"cmsf/clear/b"}
+
+		for _, s := range []struct {
+			ns    []string
+			track string
+		}{{nsA, "video_400kbps_avc"}, {nsA, "subs_wvtt_en"}, {nsB, "b_video"}, {nsB, "b_audio_locmaf"}} {
+			rs, err := session.Subscribe(t.Context(), s.ns, s.track)
+			require.NoError(t, err, "%v %s", s.ns, s.track)
+			o, err := rs.ReadObject(t.Context())
+			require.NoError(t, err)
+			assert.NotEmpty(t, o.Payload)
+			require.NoError(t, rs.Close())
+		}
+		for _, s := range []struct {
+			ns    []string
+			track string
+		}{{nsA, "b_video"}, {nsB, "video_400kbps_avc"}, {nsB, "subs_wvtt_en"}} {
+			_, err := session.Subscribe(t.Context(), s.ns, s.track)
+			assert.Error(t, err, "%v %s", s.ns, s.track)
+		}
+
+		shutdown(sConn, cConn)
+	})
+}
+
+// TestSubscribeNamespace checks that a publisher announcing on request
+// announces only the namespaces matching a SUBSCRIBE_NAMESPACE prefix, and
+// that the subscriber can select a namespace by prefix.
+func TestSubscribeNamespace(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+	namespaces := []pub.NamespaceEntry{
+		{Namespace: []string{"msf/clear"}, Catalog: catalog},
+		{Namespace: []string{"cmsf/clear"}, Catalog: catalog},
+		{Namespace: []string{"cmsf/other"}, Catalog: catalog},
+	}
+
+	t.Run("announce on request", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			sConn, cConn := memConnPair()
+			ph := &pub.Handler{Namespaces: namespaces, Asset: asset, Logfh: io.Discard, AnnounceOnRequest: true}
+			go ph.Handle(t.Context(), sConn)
+			var mu sync.Mutex
+			var announced [][]string
+			session := &moqtransport.Session{
+				Handler: moqtransport.HandlerFunc(func(w moqtransport.ResponseWriter, r *moqtransport.Message) {
+					if r.Method == moqtransport.MessageAnnounce {
+						mu.Lock()
+						announced = append(announced, r.Namespace)
+						mu.Unlock()
+						_ = w.Accept()
+					}
+				}),
+				InitialMaxRequestID: 100,
+			}
+			require.NoError(t, session.Run(cConn))
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			assert.Empty(t, announced, "nothing announced before SUBSCRIBE_NAMESPACE")
+			mu.Unlock()
+
+			require.NoError(t, session.SubscribeAnnouncements(t.Context(), []string{"cmsf/clear"}))
+			require.NoError(t, session.SubscribeAnnouncements(t.Context(), []string{"cmsf"}))
+			assert.Error(t, session.SubscribeAnnouncements(t.Context(), []string{"cms"}))
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			assert.Equal(t, [][]string{{"cmsf/clear"}, {"cmsf/other"}}, announced)
+			mu.Unlock()
+			sessions := ph.Sessions()
+			require.Len(t, sessions, 1)
+			assert.Equal(t, [][]string{{"cmsf/clear"}, {"cmsf"}}, sessions[0].Prefixes)
+
+			// Re-enabled namespaces are only announced if matching a remaining prefix.
+			require.NoError(t, ph.SetNamespaceEnabled([]string{"cmsf/other"}, false))
+			require.NoError(t, ph.SetNamespaceEnabled([]string{"msf/clear"}, false))
+			require.NoError(t, session.UnsubscribeAnnouncements(t.Context(), []string{"cmsf"}))
+			time.Sleep(100 * time.Millisecond)
+			require.NoError(t, ph.SetNamespaceEnabled([]string{"cmsf/other"}, true))
+			require.NoError(t, ph.SetNamespaceEnabled([]string{"msf/clear"}, true))
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			assert.Len(t, announced, 2)
+			mu.Unlock()
+			assert.Equal(t, [][]string{{"cmsf/clear"}}, ph.Sessions()[0].Namespaces)
+
+			shutdown(sConn, cConn)
+		})
+	})
+
+	t.Run("rejected and disabled namespaces", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			sConn, cConn := memConnPair()
+			ph := &pub.Handler{Namespaces: namespaces, Asset: asset, Logfh: io.Discard, AnnounceOnRequest: true}
+			go ph.Handle(t.Context(), sConn)
+			var mu sync.Mutex
+			var announced [][]string
+			session := &moqtransport.Session{
+				Handler: moqtransport.HandlerFunc(func(w moqtransport.ResponseWriter, r *moqtransport.Message) {
+					if r.Method != moqtransport.MessageAnnounce {
+						return
+					}
+					mu.Lock()
+					announced = append(announced, r.Namespace)
+					mu.Unlock()
+					if r.Namespace[0] == "cmsf/other" {
+						_ = w.Reject(0, "not wanted")
+						return
+					}
+					_ = w.Accept()
+				}),
+				InitialMaxRequestID: 100,
+			}
+			require.NoError(t, session.Run(cConn))
+
+			// A rejected namespace is not recorded as announced, so it is
+			// announced again for the next matching prefix.
+			require.NoError(t, session.SubscribeAnnouncements(t.Context(), []string{"cmsf"}))
+			time.Sleep(100 * time.Millisecond)
+			assert.Equal(t, [][]string{{"cmsf/clear"}}, ph.Sessions()[0].Namespaces)
+			require.NoError(t, session.SubscribeAnnouncements(t.Context(), []string{"cmsf/other"}))
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			assert.Equal(t, [][]string{{"cmsf/clear"}, {"cmsf/other"}, {"cmsf/other"}}, announced)
+			mu.Unlock()
+
+			// A prefix matching disabled namespaces only is rejected.
+			require.NoError(t, ph.SetNamespaceEnabled([]string{"msf/clear"}, false))
+			assert.Error(t, session.SubscribeAnnouncements(t.Context(), []string{"msf"}))
+
+			shutdown(sConn, cConn)
+		})
+	})
+
+	t.Run("discover prefix and select", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			sConn, cConn := memConnPair()
+			ph := &pub.Handler{Namespaces: namespaces, Asset: asset, Logfh: io.Discard}
+			go ph.Handle(t.Context(), sConn)
+
+			videoBuf := newSyncBuffer()
+			sh := newSubHandler(map[string]io.Writer{"video": videoBuf})
+			sh.Namespace = nil
+			sh.DiscoverPrefix = []string{"cmsf"}
+			sh.DiscoverSelect = true
+			go func() { _ = sh.RunWithConn(t.Context(), cConn) }()
+
+			videoBuf.WaitForLen(1)
+			time.Sleep(1500 * time.Millisecond)
+			assert.Equal(t, []string{"cmsf/clear"}, sh.Namespace)
+			sessions := ph.Sessions()
+			require.Len(t, sessions, 1)
+			require.NotEmpty(t, sessions[0].Subscriptions)
+			assert.Equal(t, []string{"cmsf/clear"}, sessions[0].Subscriptions[0].Namespace)
+
+			shutdown(sConn, cConn)
+		})
+	})
+}
+
+// TestSubscriptions subscribes to all matching tracks of several
+// namespaces, each written to an output of its own.
+func TestSubscriptions(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+	var videoTracks []string
+	for _, tr := range catalog.Tracks {
+		if tr.Role == "video" {
+			videoTracks = append(videoTracks, tr.Name)
+		}
+	}
+
+	synctest.Test(t, func(t *testing.T) {
+		sConn, cConn := memConnPair()
+		ph := newPubHandler(asset, catalog)
+		ph.Namespaces = append(ph.Namespaces, pub.NamespaceEntry{Namespace: []string{"cmsf/clear/b"}, Catalog: catalog})
+		go ph.Handle(t.Context(), sConn)
+
+		var mu sync.Mutex
+		outs := make(map[string]*syncBuffer)
+		output := func(prefix string) func(string) (io.Writer, error) {
+			return func(trackName string) (io.Writer, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				buf := newSyncBuffer()
+				outs[prefix+trackName] = buf
+				return buf, nil
+			}
+		}
+		sh := &sub.Handler{
+			Logfh: io.Discard,
+			Subscriptions: []sub.Subscription{
+				{Namespace: []string{testNamespace}, Track: "^video_", Output: output("")},
+				{Namespace: []string{"cmsf/clear/b"}, Track: "^audio_monotonic_128kbps_aac$", Output: output("b/")},
+				{Namespace: []string{"cmsf/clear/b"}, Track: "^subs_wvtt_en$"},
+				{Namespace: []string{"cmsf/none"}, Track: "^video_", Output: output("none/")},
+			},
+		}
+		go func() { _ = sh.RunWithConn(t.Context(), cConn) }()
+		time.Sleep(1500 * time.Millisecond)
+
+		mu.Lock()
+		names := make([]string, 0, len(outs))
+		for name, buf := range outs {
+			names = append(names, name)
+			assert.Greater(t, buf.Len(), 0, name)
+		}
+		mu.Unlock()
+		assert.ElementsMatch(t, append(videoTracks, "b/audio_monotonic_128kbps_aac"), names)
+		sessions := ph.Sessions()
+		require.Len(t, sessions, 1)
+		assert.Len(t, sessions[0].Subscriptions, len(videoTracks)+2)
+
+		shutdown(sConn, cConn)
+	})
+}
+
+// TestLoad runs a load test with clients of two mixes, one of which has no
+// matching namespace, and a client that fails to connect.
+func TestLoad(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+
+	synctest.Test(t, func(t *testing.T) {
+		ph := newPubHandler(asset, catalog)
+		good, err := load.ParseMix("3*" + load.DefaultMix)
+		require.NoError(t, err)
+		bad, err := load.ParseMix("ns=cmsf/none,track=^video_")
+		require.NoError(t, err)
+
+		var dials atomic.Int64
+		tester, err := load.NewTester(load.Config{
+			Clients: 6,
+			RampUp:  time.Second,
+			Mixes:   []load.Mix{good, bad},
+			Dial: func(ctx context.Context) (moqtransport.Connection, error) {
+				if dials.Add(1) == 6 {
+					return nil, fmt.Errorf("dial failed")
+				}
+				sConn, cConn := memConnPair()
+				go ph.Handle(ctx, sConn)
+				return cConn, nil
+			},
+			Logger: slog.New(slog.DiscardHandler),
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(t.Context(), 3*time.Second)
+		defer cancel()
+		report := tester.Run(ctx)
+		time.Sleep(time.Millisecond)
+
+		assert.Equal(t, 6, report.Clients)
+		assert.Equal(t, 5, report.Connected)
+		assert.Equal(t, 1, report.DialErrors)
+		assert.Equal(t, 1, report.SessionErrors)
+		assert.Equal(t, 8, report.Subscriptions)
+		assert.Equal(t, 0, report.SubscribeErrors)
+		assert.Greater(t, report.Objects, 0)
+		assert.Greater(t, report.ThroughputMbps, 0.0)
+		require.Len(t, report.Tracks, 2)
+	})
+}
+
+// readUntilDone reads objects from rs until the subscription ends and
+// returns the PUBLISH_DONE.
+func readUntilDone(t *testing.T, rs *moqtransport.RemoteTrack) *moqtransport.ErrSubscribeDone {
+	t.Helper()
+	for {
+		// Objects received before the PUBLISH_DONE come with an error.
+		o, err := rs.ReadObject(t.Context())
+		if err != nil && o == nil {
+			var done *moqtransport.ErrSubscribeDone
+			require.ErrorAs(t, err, &done)
+			return done
+		}
+	}
+}
+
+// TestSubscriptionLifecycle checks that a subscription stops publishing and
+// ends with PUBLISH_DONE with the status of its cause: UNSUBSCRIBE, removal
+// of the track from the catalog, and the end of the publisher.
+func TestSubscriptionLifecycle(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+	const videoTrack = "video_400kbps_avc"
+	ns := []string{testNamespace}
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			sConn, cConn := memConnPair()
+			ph := newPubHandler(asset, catalog)
+			go ph.Handle(t.Context(), sConn)
+			session := newClientSession(t, cConn)
+
+			rs, err := session.Subscribe(t.Context(), ns, videoTrack)
+			require.NoError(t, err)
+			_, err = rs.ReadObject(t.Context())
+			require.NoError(t, err)
+			start := time.Now()
+			require.NoError(t, rs.Close())
+			done := readUntilDone(t, rs)
+			assert.Equal(t, uint64(moqtransport.ErrorCodeSubscribeDoneSubscriptionEnded), done.Status)
+			assert.Contains(t, done.Reason, "unsubscribed, last object")
+			assert.Zero(t, time.Since(start), "publishing stops without waiting for the next group")
+			synctest.Wait()
+			require.Len(t, ph.Sessions(), 1)
+			assert.Empty(t, ph.Sessions()[0].Subscriptions)
+
+			shutdown(sConn, cConn)
+		})
+	})
+
+	t.Run("track ended", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			sConn, cConn := memConnPair()
+			lc, err := pub.NewLiveCatalog(catalog)
+			require.NoError(t, err)
+			ph := newPubHandler(asset, catalog)
+			ph.Namespaces[0].Live = lc
+			go ph.Handle(t.Context(), sConn)
+			session := newClientSession(t, cConn)
+
+			rs, err := session.Subscribe(t.Context(), ns, videoTrack)
+			require.NoError(t, err)
+			_, err = rs.ReadObject(t.Context())
+			require.NoError(t, err)
+			start := time.Now()
+			require.NoError(t, lc.RemoveTracks(videoTrack))
+			done := readUntilDone(t, rs)
+			assert.Equal(t, uint64(moqtransport.ErrorCodeSubscribeDoneTrackEnded), done.Status)
+			assert.Zero(t, time.Since(start), "PUBLISH_DONE is not delayed")
+			synctest.Wait()
+			assert.Empty(t, ph.Sessions()[0].Subscriptions)
+
+			// The session continues, also after an UNSUBSCRIBE that arrives
+			// after the PUBLISH_DONE.
+			require.NoError(t, rs.Close())
+			rs, err = session.Subscribe(t.Context(), ns, "audio_monotonic_128kbps_aac")
+			require.NoError(t, err)
+			_, err = rs.ReadObject(t.Context())
+			require.NoError(t, err)
+
+			shutdown(sConn, cConn)
+		})
+	})
+
+	t.Run("publisher going away", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			sConn, cConn := memConnPair()
+			ph := newPubHandler(asset, catalog)
+			ctx, cancel := context.WithCancel(t.Context())
+			go ph.Handle(ctx, sConn)
+			session := newClientSession(t, cConn)
+
+			rs, err := session.Subscribe(t.Context(), ns, videoTrack)
+			require.NoError(t, err)
+			_, err = rs.ReadObject(t.Context())
+			require.NoError(t, err)
+			cancel()
+			done := readUntilDone(t, rs)
+			assert.Equal(t, uint64(moqtransport.ErrorCodeSubscribeDoneGoingAway), done.Status)
+
+			shutdown(sConn, cConn)
+		})
+	})
+}
+
+// TestSharedTrackFeed checks that the subscriptions of sessions to the same
+// track are published by one track feed, so that only the writer goroutines
+// grow with the subscriptions, and that the feed stops with the last
+// subscription.
+func TestSharedTrackFeed(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+	const videoTrack = "video_400kbps_avc"
+
+	synctest.Test(t, func(t *testing.T) {
+		ph := newPubHandler(asset, catalog)
+		ph.Scheduler = pub.NewScheduler()
+		var tracks []*moqtransport.RemoteTrack
+		var goroutines []int
+		for _, subscribers := range []int{1, 5, 20} {
+			for len(tracks) < subscribers {
+				sConn, cConn := memConnPair()
+				defer shutdown(sConn, cConn)
+				go ph.Handle(t.Context(), sConn)
+				session := newClientSession(t, cConn)
+				rs, err := session.Subscribe(t.Context(), []string{testNamespace}, videoTrack)
+				require.NoError(t, err)
+				tracks = append(tracks, rs)
+			}
+			for _, rs := range tracks {
+				_, err := rs.ReadObject(t.Context())
+				require.NoError(t, err)
+			}
+			synctest.Wait()
+			shared, writers := publishingGoroutines()
+			goroutines = append(goroutines, shared)
+			assert.Equal(t, subscribers, writers, "one writer per subscription")
+			assert.Equal(t, 1, ph.Scheduler.Stats().Feeds, "subscribers=%d", subscribers)
+		}
+		assert.Equal(t, []int{goroutines[0], goroutines[0], goroutines[0]}, goroutines,
+			"shared publishing goroutines should not grow with the subscriptions")
+
+		// The other subscriptions continue after an UNSUBSCRIBE.
+		require.NoError(t, tracks[0].Close())
+		readUntilDone(t, tracks[0])
+		for _, rs := range tracks[1:] {
+			_, err := rs.ReadObject(t.Context())
+			require.NoError(t, err)
+		}
+		for _, rs := range tracks[1:] {
+			require.NoError(t, rs.Close())
+		}
+		for _, rs := range tracks[1:] {
+			readUntilDone(t, rs)
+		}
+		synctest.Wait()
+		assert.Equal(t, 0, ph.Scheduler.Stats().Feeds)
+	})
+}
+
+// TestStalledSubscriber checks that a subscriber that stops reading, so
+// that writes to its streams block, does not hold back the other
+// subscriptions of the shared track feed.
+func TestStalledSubscriber(t *testing.T) {
+	asset, catalog := loadTestAsset(t)
+	const videoTrack = "video_400kbps_avc"
+
+	synctest.Test(t, func(t *testing.T) {
+		ph := newPubHandler(asset, catalog)
+		ph.Scheduler = pub.NewScheduler()
+		var sConns []*memConn
+		var tracks []*moqtransport.RemoteTrack
+		for range 3 {
+			sConn, cConn := memConnPair()
+			defer shutdown(sConn, cConn)
+			go ph.Handle(t.Context(), sConn)
+			session := newClientSession(t, cConn)
+			rs, err := session.Subscribe(t.Context(), []string{testNamespace}, videoTrack)
+			require.NoError(t, err)
+			sConns = append(sConns, sConn)
+			tracks = append(tracks, rs)
+		}
+		for _, rs := range tracks {
+			_, err := rs.ReadObject(t.Context())
+			require.NoError(t, err)
+		}
+
+		sConns[0].stalled.Store(true)
+		// Long enough for the queue of the stalled subscription to overflow.
+		end := time.Now().Add(10 * time.Second)
+		for time.Now().Before(end) {
+			for i, rs := range tracks[1:] {
+				ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+				o, err := rs.ReadObject(ctx)
+				cancel()
+				require.NoError(t, err, "subscriber %d should keep receiving", i+1)
+				require.NotEmpty(t, o.Payload)
+			}
+		}
+		assert.Equal(t, 1, ph.Scheduler.Stats().Feeds)
+	})
+}
@@ -0,0 +1,303 @@
+// Package load simulates many subscribers in one process, to measure the
+// capacity of a publisher or relay.
+package load
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal/sub"
+	"github.com/Eyevinn/moqtransport"
+)
+
+// Mix is the set of subscriptions of a client, and its weight among the
+// mixes of a load test.
+type Mix struct {
+	Weight        int
+	Subscriptions []sub.Subscription
+}
+
+// DefaultMix subscribes to one video and one audio track of cmsf/clear.
+const DefaultMix = "ns=cmsf/clear,track=^video_400kbps_avc$;ns=cmsf/clear,track=^audio_monotonic_128kbps_aac$"
+
+// ParseMix parses a mix given as [weight*]subscription;subscription;...
+// where each subscription is ns=namespace,track=regexp as parsed by
+// sub.ParseSubscription. The weight defaults to 1. Outputs are not allowed,
+// since the objects are discarded.
+func ParseMix(value string) (Mix, error) {
+	m := Mix{Weight: 1}
+	if w, rest, ok := strings.Cut(value, "*"); ok && !strings.Contains(w, "=") {
+		weight, err := strconv.Atoi(w)
+		if err != nil || weight < 1 {
+			return m, fmt.Errorf("bad mix weight %q", w)
+		}
+		m.Weight = weight
+		value = rest
+	}
+	for _, part := range strings.Split(value, ";") {
+		s, out, err := sub.ParseSubscription(part)
+		if err != nil {
+			return m, err
+		}
+		if out != "" {
+			return m, fmt.Errorf("subscription %q: out not allowed", part)
+		}
+		m.Subscriptions = append(m.Subscriptions, s)
+	}
+	return m, nil
+}
+
+// Config configures a load test.
+type Config struct {
+	// Clients is the number of client sessions.
+	Clients int
+	// RampUp is the time over which the clients are started evenly.
+	RampUp time.Duration
+	// Mixes are the subscriptions of the clients. Clients are assigned to
+	// the mixes in turn, in proportion to their weights.
+	Mixes []Mix
+	// Dial opens the connection of a client.
+	Dial func(ctx context.Context) (moqtransport.Connection, error)
+	// Protocols are the application protocols offered by the clients.
+	Protocols []string
+	// CatalogMode is the catalog retrieval mode of the clients, see
+	// sub.Handler.
+	CatalogMode string
+	// Logger, if set, logs the progress and client failures of the test,
+	// e.g. at another level than the clients, which log to slog.Default.
+	Logger *slog.Logger
+}
+
+// Report is the aggregate result of a load test.
+type Report struct {
+	Start     time.Time `json:"start"`
+	DurationS float64   `json:"durationS"`
+	Clients   int       `json:"clients"`
+	// Connected is the number of clients that connected.
+	Connected int `json:"connected"`
+	// DialErrors is the number of clients that failed to connect.
+	DialErrors int `json:"dialErrors"`
+	// SessionErrors is the number of sessions closed before the end of the
+	// test, e.g. since the setup failed or no tracks matched.
+	SessionErrors int `json:"sessionErrors"`
+	// Subscriptions is the number of successful track subscriptions.
+	Subscriptions   int `json:"subscriptions"`
+	SubscribeErrors int `json:"subscribeErrors"`
+	Objects         int `json:"objects"`
+	Bytes           int `json:"bytes"`
+	// ThroughputMbps is the received bitrate of all clients.
+	ThroughputMbps float64 `json:"throughputMbps"`
+	// Tracks are the latencies and group completion times per track over
+	// all clients, and the mean of the jitter of the clients.
+	Tracks []sub.TrackLatencyReport `json:"tracks"`
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Log logs the report to logger, with one line per track.
+func (r Report) Log(logger *slog.Logger) {
+	logger.Info("load report", "clients", r.Clients, "connected", r.Connected,
+		"dialErrors", r.DialErrors, "sessionErrors", r.SessionErrors,
+		"subscriptions", r.Subscriptions, "subscribeErrors", r.SubscribeErrors,
+		"objects", r.Objects, "throughputMbps", r.ThroughputMbps)
+	for _, t := range r.Tracks {
+		logger.Info("load report", "track", t.Track, "objects", t.Objects,
+			"meanMS", t.Latency.Mean, "p50MS", t.Latency.P50, "p95MS", t.Latency.P95, "maxMS", t.Latency.Max)
+	}
+}
+
+// Tester runs a load test. Its counters can be read while the test runs.
+// Every client has a LatencyMeter of its own, so that the clients do not
+// contend for a lock, and the meters are merged for reports.
+type Tester struct {
+	cfg   Config
+	start time.Time
+
+	mu     sync.Mutex
+	meters []*sub.LatencyMeter // latency meters of the clients
+
+	connected       atomic.Int64
+	dialErrors      atomic.Int64
+	sessionErrors   atomic.Int64
+	subscriptions   atomic.Int64
+	subscribeErrors atomic.Int64
+
+	// progress state of LogProgress
+	lastProgress time.Time
+	lastBytes    int
+}
+
+// NewTester returns a Tester for cfg.
+func NewTester(cfg Config) (*Tester, error) {
+	if cfg.Clients < 1 {
+		return nil, fmt.Errorf("need at least one client")
+	}
+	if len(cfg.Mixes) == 0 {
+		return nil, fmt.Errorf("need at least one mix")
+	}
+	if cfg.Dial == nil {
+		return nil, fmt.Errorf("no dial function")
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	now := time.Now()
+	return &Tester{cfg: cfg, start: now, lastProgress: now}, nil
+}
+
+// newMeter returns a LatencyMeter for a client.
+func (t *Tester) newMeter() *sub.LatencyMeter {
+	m := sub.NewLatencyMeter()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.meters = append(t.meters, m)
+	return m
+}
+
+// clientMeters returns the LatencyMeters of the clients started so far.
+func (t *Tester) clientMeters() []*sub.LatencyMeter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.meters)
+}
+
+// Run starts the clients over the ramp-up time and runs them until ctx is
+// done, then closes them and returns the report.
+func (t *Tester) Run(ctx context.Context) Report {
+	mixes := t.mixOrder()
+	var wg sync.WaitGroup
+	start := time.Now()
+clients:
+	for i := range t.cfg.Clients {
+		delay := t.cfg.RampUp * time.Duration(i) / time.Duration(t.cfg.Clients)
+		select {
+		case <-ctx.Done():
+			break clients
+		case <-time.After(time.Until(start.Add(delay))):
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.runClient(ctx, i, mixes[i%len(mixes)])
+		}()
+	}
+	<-ctx.Done()
+	wg.Wait()
+	return t.Report()
+}
+
+// mixOrder returns the mixes repeated by their weights, the order in which
+// they are assigned to the clients.
+func (t *Tester) mixOrder() []Mix {
+	var order []Mix
+	for _, m := range t.cfg.Mixes {
+		for range max(m.Weight, 1) {
+			order = append(order, m)
+		}
+	}
+	return order
+}
+
+// runClient runs client i with mix until ctx is done.
+func (t *Tester) runClient(ctx context.Context, i int, mix Mix) {
+	conn, err := t.cfg.Dial(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			t.dialErrors.Add(1)
+			t.cfg.Logger.Warn("client failed to connect", "client", i, "error", err)
+		}
+		return
+	}
+	t.connected.Add(1)
+	h := &sub.Handler{
+		Logfh:         io.Discard,
+		CatalogMode:   t.cfg.CatalogMode,
+		Protocols:     t.cfg.Protocols,
+		Subscriptions: mix.Subscriptions,
+		Latency:       t.newMeter(),
+		OnSubscribe: func(namespace []string, track string, err error) {
+			if err != nil {
+				t.subscribeErrors.Add(1)
+				t.cfg.Logger.Warn("client failed to subscribe", "client", i, "namespace", namespace,
+					"track", track, "error", err)
+				return
+			}
+			t.subscriptions.Add(1)
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = h.RunWithConn(ctx, conn)
+	}()
+	select {
+	case <-ctx.Done():
+	case <-conn.Context().Done():
+		if ctx.Err() == nil {
+			t.sessionErrors.Add(1)
+			t.cfg.Logger.Warn("client session closed", "client", i, "cause", context.Cause(conn.Context()))
+		}
+	}
+	<-done
+	_ = conn.CloseWithError(0, "load test done")
+}
+
+// LogProgress logs the client counters, and the received objects and
+// throughput since the previous call. It must not be called concurrently.
+func (t *Tester) LogProgress() {
+	var objects, bytes int
+	for _, m := range t.clientMeters() {
+		o, b := m.Totals()
+		objects += o
+		bytes += b
+	}
+	now := time.Now()
+	var mbps float64
+	if d := now.Sub(t.lastProgress).Seconds(); d > 0 {
+		mbps = math.Round(float64(bytes-t.lastBytes)*8/d/1e3) / 1e3
+	}
+	t.lastProgress, t.lastBytes = now, bytes
+	t.cfg.Logger.Info("load", "connected", t.connected.Load(), "dialErrors", t.dialErrors.Load(),
+		"sessionErrors", t.sessionErrors.Load(), "subscriptions", t.subscriptions.Load(),
+		"subscribeErrors", t.subscribeErrors.Load(), "objects", objects, "throughputMbps", mbps)
+}
+
+// Report returns the report of the load test so far.
+func (t *Tester) Report() Report {
+	lr := sub.MergedReport(t.clientMeters())
+	durationS := time.Since(t.start).Seconds()
+	r := Report{
+		Start:           t.start,
+		DurationS:       math.Round(durationS*1000) / 1000,
+		Clients:         t.cfg.Clients,
+		Connected:       int(t.connected.Load()),
+		DialErrors:      int(t.dialErrors.Load()),
+		SessionErrors:   int(t.sessionErrors.Load()),
+		Subscriptions:   int(t.subscriptions.Load()),
+		SubscribeErrors: int(t.subscribeErrors.Load()),
+		Tracks:          lr.Tracks,
+	}
+	for _, tr := range lr.Tracks {
+		r.Objects += tr.Objects
+		r.Bytes += tr.Bytes
+	}
+	if durationS > 0 {
+		r.ThroughputMbps = math.Round(float64(r.Bytes)*8/durationS/1e3) / 1e3
+	}
+	return r
+}
@@ -0,0 +1,46 @@
+package load
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMix(t *testing.T) {
+	cases := []struct {
+		desc    string
+		value   string
+		weight  int
+		subs    int
+		wantErr bool
+	}{
+		{desc: "default", value: DefaultMix, weight: 1, subs: 2},
+		{desc: "weighted", value: "3*ns=cmsf/clear,track=^video_", weight: 3, subs: 1},
+		{desc: "star in track", value: "ns=cmsf/clear,track=^video_.*$", weight: 1, subs: 1},
+		{desc: "quantifier in track", value: "ns=cmsf/clear,track=^video_{1,3}", weight: 1, subs: 1},
+		{desc: "bad weight", value: "0*ns=cmsf/clear", wantErr: true},
+		{desc: "no namespace", value: "track=^video_", wantErr: true},
+		{desc: "output", value: "ns=cmsf/clear,out=video.mp4", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			m, err := ParseMix(c.value)
+			if c.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.weight, m.Weight)
+			assert.Len(t, m.Subscriptions, c.subs)
+		})
+	}
+}
+
+func TestMixOrder(t *testing.T) {
+	a := Mix{Weight: 2}
+	b := Mix{Weight: 1}
+	tester := &Tester{cfg: Config{Mixes: []Mix{a, b}}}
+	order := tester.mixOrder()
+	assert.Equal(t, []Mix{a, a, b}, order)
+}
@@ -216,7 +216,7 @@ func encryptViaTrack(ct *ContentTrack, frag *mp4.Fragment) (*mp4.Fragment, error
 	if err := frag.Encode(w); err != nil {
 		return nil, err
 	}
-	return ct.encryptFragment(w.Bytes())
+	return ct.encryptFragment(w.Bytes(), 0, false)
 }
 
 // firstProtectedVideoTrack returns the first encrypted video track in
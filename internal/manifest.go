@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest describes an asset made of MP4 files that do not follow the
+// conventions of a test asset directory. The files may be fragmented or
+// not, and may have several tracks. It is read from JSON or YAML.
+type Manifest struct {
+	// Name is the asset name. It defaults to the manifest file name.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// LoopDurationMS is the loop duration in milliseconds, which must be a
+	// whole number of MoQ groups. Longer tracks are cut to it. If zero, the
+	// loop is the duration of the first video track.
+	LoopDurationMS uint32          `json:"loopDurationMS,omitempty" yaml:"loopDurationMS,omitempty"`
+	Tracks         []ManifestTrack `json:"tracks" yaml:"tracks"`
+}
+
+// ManifestTrack selects one or all tracks of an MP4 file, and sets their
+// catalog properties.
+type ManifestTrack struct {
+	// File is the path to the MP4 file, relative to the manifest.
+	File string `json:"file" yaml:"file"`
+	// TrackID selects a track of the file. If zero, all tracks are used.
+	TrackID uint32 `json:"trackID,omitempty" yaml:"trackID,omitempty"`
+	// Name is the track name. It defaults to the file name, with the track
+	// ID appended for files with several tracks.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Language overrides the language of the file.
+	Language string `json:"language,omitempty" yaml:"language,omitempty"`
+	// Label is a human-readable label announced in the catalog.
+	Label string `json:"label,omitempty" yaml:"label,omitempty"`
+	// Role is the catalog role, if not the content type "video" or "audio".
+	Role string `json:"role,omitempty" yaml:"role,omitempty"`
+}
+
+// manifestRoles are the catalog roles allowed per content type.
+var manifestRoles = map[string][]string{
+	"video": {"video", "signlanguage"},
+	"audio": {"audio", "audiodescription"},
+}
+
+// IsManifest reports whether path has the extension of an asset manifest,
+// i.e. .json, .yaml or .yml.
+func IsManifest(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// ReadManifest reads an asset manifest in JSON or YAML, depending on the
+// file extension. Unknown fields are rejected.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest: %w", err)
+	}
+	var m Manifest
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&m)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode manifest %s: %w", path, err)
+	}
+	if len(m.Tracks) == 0 {
+		return nil, fmt.Errorf("manifest %s has no tracks", path)
+	}
+	if m.LoopDurationMS%MoqGroupDurMS != 0 {
+		return nil, fmt.Errorf("loop duration %dms is not a multiple of the group duration %dms",
+			m.LoopDurationMS, MoqGroupDurMS)
+	}
+	return &m, nil
+}
+
+// parseTracks loads the tracks of the manifest, with files relative to dir,
+// cuts them to the loop duration, and groups them by contentType.
+func (m *Manifest) parseTracks(dir string, audioSampleBatch, videoSampleBatch int) (map[string][]ContentTrack, error) {
+	files := make(map[string][]*ContentTrack)
+	names := make(map[string]bool)
+	tracksByType := make(map[string][]ContentTrack)
+	for i, mt := range m.Tracks {
+		if mt.File == "" {
+			return nil, fmt.Errorf("manifest track %d has no file", i)
+		}
+		filePath := mt.File
+		if !filepath.IsAbs(filePath) {
+			filePath = filepath.Join(dir, filePath)
+		}
+		cts, ok := files[filePath]
+		if !ok {
+			fh, err := os.Open(filePath)
+			if err != nil {
+				return nil, fmt.Errorf("could not open file %s: %w", filePath, err)
+			}
+			cts, err = InitContentTracks(fh, filepath.Base(filePath), audioSampleBatch, videoSampleBatch)
+			fh.Close()
+			if err != nil {
+				return nil, fmt.Errorf("could not create ContentTrack for %s: %w", filePath, err)
+			}
+			files[filePath] = cts
+		}
+		var selected []*ContentTrack
+		for _, ct := range cts {
+			if mt.TrackID == 0 || ct.trackID == mt.TrackID {
+				selected = append(selected, ct)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("no track %d in %s", mt.TrackID, filePath)
+		}
+		if mt.Name != "" && len(selected) > 1 {
+			return nil, fmt.Errorf("name %s set for the %d tracks of %s", mt.Name, len(selected), filePath)
+		}
+		for _, sel := range selected {
+			// A file track may be selected by several manifest tracks.
+			ct, err := sel.clone()
+			if err != nil {
+				return nil, err
+			}
+			if err := mt.apply(&ct); err != nil {
+				return nil, fmt.Errorf("manifest track %d: %w", i, err)
+			}
+			if names[ct.Name] {
+				return nil, fmt.Errorf("duplicate track name %s", ct.Name)
+			}
+			names[ct.Name] = true
+			if m.LoopDurationMS > 0 {
+				if err := ct.trimToLoop(m.LoopDurationMS); err != nil {
+					return nil, err
+				}
+			}
+			tracksByType[ct.ContentType] = append(tracksByType[ct.ContentType], ct)
+		}
+	}
+	return tracksByType, nil
+}
+
+// apply sets the name and catalog properties of mt on ct.
+func (mt ManifestTrack) apply(ct *ContentTrack) error {
+	if mt.Name != "" {
+		ct.Name = mt.Name
+	}
+	if mt.Language != "" {
+		ct.Language = mt.Language
+	}
+	ct.Label = mt.Label
+	if mt.Role != "" {
+		allowed := manifestRoles[ct.ContentType]
+		if !slices.Contains(allowed, mt.Role) {
+			return fmt.Errorf("role %s not allowed for %s track %s, use one of %v",
+				mt.Role, ct.ContentType, ct.Name, allowed)
+		}
+		if mt.Role != ct.ContentType {
+			ct.Role = mt.Role
+		}
+	}
+	return nil
+}
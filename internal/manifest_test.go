@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+	"github.com/stretchr/testify/require"
+)
+
+// readTestTrack decodes a single-track fragmented test file and returns its
+// trak and samples.
+func readTestTrack(t *testing.T, path string) (*mp4.MoovBox, []mp4.FullSample) {
+	t.Helper()
+	fh, err := os.Open(path)
+	require.NoError(t, err)
+	defer fh.Close()
+	m, err := mp4.DecodeFile(fh)
+	require.NoError(t, err)
+	samples, err := fragmentedSamples(m, m.Init.Moov.Trak.Tkhd.TrackID)
+	require.NoError(t, err)
+	return m.Init.Moov, samples
+}
+
+// progressiveMP4 remuxes a single-track fragmented test file into a
+// non-fragmented file with all samples in one chunk.
+func progressiveMP4(t *testing.T, path string) []byte {
+	t.Helper()
+	inMoov, samples := readTestTrack(t, path)
+	trak := inMoov.Trak
+	stbl := trak.Mdia.Minf.Stbl
+	var syncs []uint32
+	hasCto := false
+	mdat := &mp4.MdatBox{}
+	for i, s := range samples {
+		stbl.Stts.SampleCount = append(stbl.Stts.SampleCount, 1)
+		stbl.Stts.SampleTimeDelta = append(stbl.Stts.SampleTimeDelta, s.Dur)
+		stbl.Stsz.SampleSize = append(stbl.Stsz.SampleSize, s.Size)
+		if s.IsSync() {
+			syncs = append(syncs, uint32(i+1))
+		}
+		hasCto = hasCto || s.CompositionTimeOffset != 0
+		mdat.AddSampleData(s.Data)
+	}
+	stbl.Stsz.SampleNumber = uint32(len(samples))
+	require.NoError(t, stbl.Stsc.AddEntry(1, uint32(len(samples)), 1))
+	if len(syncs) < len(samples) {
+		stbl.AddChild(&mp4.StssBox{SampleNumber: syncs})
+	}
+	if hasCto {
+		ctts := &mp4.CttsBox{}
+		for _, s := range samples {
+			require.NoError(t, ctts.AddSampleCountsAndOffset([]uint32{1}, []int32{s.CompositionTimeOffset}))
+		}
+		stbl.AddChild(ctts)
+	}
+	moov := mp4.NewMoovBox()
+	moov.AddChild(inMoov.Mvhd)
+	moov.AddChild(trak)
+	ftyp := mp4.CreateFtyp()
+	stbl.Stco.ChunkOffset = []uint32{0} // size the box before setting the offset
+	stbl.Stco.ChunkOffset[0] = uint32(ftyp.Size() + moov.Size() + 8)
+
+	buf := bytes.Buffer{}
+	for _, b := range []mp4.Box{ftyp, moov, mdat} {
+		require.NoError(t, b.Encode(&buf))
+	}
+	return buf.Bytes()
+}
+
+// multiTrackMP4 muxes single-track fragmented test files into one fragmented
+// file with track IDs 1, 2, ... in a single fragment.
+func multiTrackMP4(t *testing.T, paths ...string) []byte {
+	t.Helper()
+	init := mp4.CreateEmptyInit()
+	var trackIDs []uint32
+	var trackSamples [][]mp4.FullSample
+	for i, path := range paths {
+		inMoov, samples := readTestTrack(t, path)
+		trackID := uint32(i + 1)
+		inMoov.Trak.Tkhd.TrackID = trackID
+		init.Moov.AddChild(inMoov.Trak)
+		init.Moov.Mvex.AddChild(mp4.CreateTrex(trackID))
+		trackIDs = append(trackIDs, trackID)
+		trackSamples = append(trackSamples, samples)
+	}
+	frag, err := mp4.CreateMultiTrackFragment(1, trackIDs)
+	require.NoError(t, err)
+	for i, samples := range trackSamples {
+		for _, s := range samples {
+			require.NoError(t, frag.AddFullSampleToTrack(s, trackIDs[i]))
+		}
+	}
+	buf := bytes.Buffer{}
+	require.NoError(t, init.Encode(&buf))
+	require.NoError(t, frag.Encode(&buf))
+	return buf.Bytes()
+}
+
+func TestInitContentTracks(t *testing.T) {
+	videoPath := "../assets/test10s/video_400kbps_avc.mp4"
+	audioPath := "../assets/test10s/audio_monotonic_128kbps_aac.mp4"
+	ref := func(path string) *ContentTrack {
+		fh, err := os.Open(path)
+		require.NoError(t, err)
+		defer fh.Close()
+		ct, err := InitContentTrack(fh, filepath.Base(path), 1, 1)
+		require.NoError(t, err)
+		return ct
+	}
+	requireSameTrack := func(t *testing.T, want, got *ContentTrack) {
+		require.Equal(t, want.ContentType, got.ContentType)
+		require.Equal(t, want.TimeScale, got.TimeScale)
+		require.Equal(t, want.SampleDur, got.SampleDur)
+		require.Equal(t, want.NrSamples, got.NrSamples)
+		require.Equal(t, want.Duration, got.Duration)
+		require.Equal(t, want.GopLength, got.GopLength)
+		require.Equal(t, want.SpecData.Codec(), got.SpecData.Codec())
+		for i := range want.Samples {
+			require.Equal(t, want.Samples[i].Data, got.Samples[i].Data, "sample %d", i)
+			require.Equal(t, want.Samples[i].DecodeTime, got.Samples[i].DecodeTime, "sample %d", i)
+			require.Equal(t, want.Samples[i].IsSync(), got.Samples[i].IsSync(), "sample %d", i)
+		}
+	}
+
+	t.Run("progressive", func(t *testing.T) {
+		for _, path := range []string{videoPath, audioPath} {
+			cts, err := InitContentTracks(bytes.NewReader(progressiveMP4(t, path)), "prog.mp4", 1, 1)
+			require.NoError(t, err)
+			require.Len(t, cts, 1)
+			require.Equal(t, "prog", cts[0].Name)
+			requireSameTrack(t, ref(path), cts[0])
+		}
+	})
+
+	t.Run("multi-track fragmented", func(t *testing.T) {
+		cts, err := InitContentTracks(bytes.NewReader(multiTrackMP4(t, videoPath, audioPath)), "av.mp4", 1, 1)
+		require.NoError(t, err)
+		require.Len(t, cts, 2)
+		require.Equal(t, "av_1", cts[0].Name)
+		require.Equal(t, "av_2", cts[1].Name)
+		requireSameTrack(t, ref(videoPath), cts[0])
+		requireSameTrack(t, ref(audioPath), cts[1])
+	})
+
+	t.Run("InitContentTrack rejects non-fragmented", func(t *testing.T) {
+		_, err := InitContentTrack(bytes.NewReader(progressiveMP4(t, videoPath)), "prog", 1, 1)
+		require.ErrorContains(t, err, "not fragmented")
+	})
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+		return path
+	}
+	writeFile("clip.mp4", progressiveMP4(t, "../assets/test10s/video_600kbps_avc.mp4"))
+	writeFile("av.mp4", multiTrackMP4(t, "../assets/test10s/video_400kbps_avc.mp4",
+		"../assets/test10s/audio_monotonic_128kbps_aac.mp4"))
+	audioPath, err := filepath.Abs("../assets/test10s/audio_scale_128kbps_aac.mp4")
+	require.NoError(t, err)
+
+	yamlManifest := writeFile("clip.yaml", []byte(`
+name: myclip
+loopDurationMS: 4000
+tracks:
+  - file: clip.mp4
+    name: video_hi
+    label: High quality
+  - file: av.mp4
+  - file: `+audioPath+`
+    name: audio_ad
+    language: swe
+    label: Syntolkning
+    role: audiodescription
+`))
+
+	asset, err := LoadAsset(yamlManifest, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, "myclip", asset.Name)
+	require.Equal(t, uint32(4000), asset.LoopDurMS)
+	require.Len(t, asset.Groups, 2)
+	videos, audios := asset.Groups[0].Tracks, asset.Groups[1].Tracks
+	require.Len(t, videos, 2)
+	require.Len(t, audios, 2)
+	for _, ct := range append(videos, audios...) {
+		require.Equal(t, 4000*ct.TimeScale/1000, ct.LoopDur, ct.Name)
+	}
+	video := asset.GetTrackByName("video_hi")
+	require.NotNil(t, video)
+	require.Equal(t, uint32(100), video.NrSamples, "trimmed to the loop")
+	require.NotNil(t, asset.GetTrackByName("av_1"))
+	require.NotNil(t, asset.GetTrackByName("av_2"))
+
+	cat, err := asset.GenCMAFCatalogEntry("ns", ProtectionNone, 0)
+	require.NoError(t, err)
+	roles := make(map[string]Track)
+	for _, tr := range cat.Tracks {
+		roles[tr.Name] = tr
+	}
+	require.Equal(t, "video", roles["video_hi"].Role)
+	require.Equal(t, "High quality", roles["video_hi"].Label)
+	require.Equal(t, "audio", roles["av_2_locmaf"].Role)
+	require.Equal(t, "audiodescription", roles["audio_ad"].Role)
+	require.Equal(t, "Syntolkning", roles["audio_ad"].Label)
+	require.Equal(t, "swe", roles["audio_ad"].Language)
+
+	jsonManifest := writeFile("clip.json", []byte(
+		`{"tracks": [{"file": "av.mp4", "trackID": 2}, {"file": "av.mp4", "trackID": 1, "name": "video"}]}`))
+	asset, err = LoadAsset(jsonManifest, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, "clip", asset.Name)
+	require.Equal(t, uint32(10000), asset.LoopDurMS)
+	require.NotNil(t, asset.GetTrackByName("video"))
+	require.NotNil(t, asset.GetTrackByName("av_2"))
+
+	// A file track selected twice gives independent tracks.
+	twiceManifest := writeFile("twice.json", []byte(`{"tracks": [{"file": "av.mp4", "trackID": 1, "name": "v1"},
+		{"file": "av.mp4", "trackID": 1, "name": "v2"}, {"file": "av.mp4", "trackID": 2}]}`))
+	asset, err = LoadAsset(twiceManifest, 1, 1)
+	require.NoError(t, err)
+	v1, v2 := asset.GetTrackByName("v1"), asset.GetTrackByName("v2")
+	require.NotNil(t, v1)
+	require.NotNil(t, v2)
+	require.NotSame(t, &v1.Samples[0], &v2.Samples[0])
+	require.NotSame(t, v1.batches, v2.batches)
+	require.True(t, v1.SetSampleBatch(5, 0))
+	require.Equal(t, 5, v1.SampleBatchAt(0))
+	require.Equal(t, 1, v2.SampleBatchAt(0))
+
+	errCases := []struct {
+		desc     string
+		manifest string
+		errMsg   string
+	}{
+		{"unknown field", `{"tracks": [{"file": "av.mp4", "lang": "swe"}]}`, "unknown field"},
+		{"no tracks", `{"name": "empty"}`, "no tracks"},
+		{"missing track", `{"tracks": [{"file": "av.mp4", "trackID": 3}]}`, "no track 3"},
+		{"bad role", `{"tracks": [{"file": "av.mp4", "trackID": 2, "role": "signlanguage"}]}`, "not allowed"},
+		{"duplicate name", `{"tracks": [{"file": "av.mp4"}, {"file": "av.mp4", "trackID": 1}]}`, "duplicate"},
+		{"name for several tracks", `{"tracks": [{"file": "av.mp4", "name": "x"}]}`, "name x set for the 2 tracks"},
+		{"loop not whole groups", `{"loopDurationMS": 2500, "tracks": [{"file": "av.mp4"}]}`, "not a multiple"},
+		{"loop too long", `{"loopDurationMS": 20000, "tracks": [{"file": "av.mp4"}]}`, "shorter than the loop"},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := LoadAsset(writeFile("bad.json", []byte(tc.manifest)), 1, 1)
+			require.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
@@ -36,6 +36,7 @@ import (
 	"io"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Eyevinn/moqtransport"
 )
@@ -126,6 +127,7 @@ func memConnPair() (*memConn, *memConn) {
 		cancelPeer:  clientCancel,
 		biAccept:    make(chan moqtransport.Stream, 16),
 		uniAccept:   make(chan moqtransport.ReceiveStream, 16),
+		dgrams:      make(chan []byte, 256),
 	}
 	client := &memConn{
 		perspective: moqtransport.PerspectiveClient,
@@ -134,6 +136,7 @@ func memConnPair() (*memConn, *memConn) {
 		cancelPeer:  serverCancel,
 		biAccept:    make(chan moqtransport.Stream, 16),
 		uniAccept:   make(chan moqtransport.ReceiveStream, 16),
+		dgrams:      make(chan []byte, 256),
 	}
 
 	server.peer = client
@@ -151,7 +154,19 @@ type memConn struct {
 	peer        *memConn
 	biAccept    chan moqtransport.Stream        // peer-opened bidirectional streams
 	uniAccept   chan moqtransport.ReceiveStream // peer-opened unidirectional streams
+	dgrams      chan []byte                     // datagrams sent by the peer
 	streamID    atomic.Uint64
+	sentDgrams  atomic.Int64 // datagrams sent
+	firstDgram  atomic.Int64 // Unix time in ms of the first datagram sent
+	// controlDelay delays what is read from bidirectional streams, so that
+	// datagrams overtake the control messages.
+	controlDelay atomic.Int64
+	resets      atomic.Int64 // unidirectional streams reset
+	// stalled makes writes to unidirectional streams block until the
+	// connection is closed, like streams of a peer that stopped reading.
+	stalled atomic.Bool
+
+	alpn string // negotiated ALPN; empty means draft-14
 
 	mu     sync.Mutex
 	pipes  []*asyncPipe // tracked for cleanup on close
@@ -197,8 +212,8 @@ func (c *memConn) OpenStreamSync(ctx context.Context) (moqtransport.Stream, erro
 	c.peer.trackPipe(pipeAtoB)
 	c.peer.trackPipe(pipeBtoA)
 
-	local := &memStream{id: id, r: pipeBtoA, w: pipeAtoB}
-	remote := &memStream{id: id, r: pipeAtoB, w: pipeBtoA}
+	local := &memStream{id: id, r: pipeBtoA, w: pipeAtoB, conn: c}
+	remote := &memStream{id: id, r: pipeAtoB, w: pipeBtoA, conn: c.peer}
 
 	select {
 	case <-ctx.Done():
@@ -223,7 +238,7 @@ func (c *memConn) OpenUniStreamSync(ctx context.Context) (moqtransport.SendStrea
 	c.trackPipe(pipe)
 	c.peer.trackPipe(pipe)
 
-	local := &memSendStream{id: id, w: pipe}
+	local := &memSendStream{id: id, w: pipe, conn: c}
 	remote := &memReceiveStream{id: id, r: pipe}
 
 	select {
@@ -236,16 +251,28 @@ func (c *memConn) OpenUniStreamSync(ctx context.Context) (moqtransport.SendStrea
 	}
 }
 
-// SendDatagram is a no-op; MoQ does not use datagrams in these tests.
-func (c *memConn) SendDatagram([]byte) error {
+// SendDatagram delivers a copy of b to the peer. Like a datagram on the
+// network, it is dropped if the peer's queue is full.
+func (c *memConn) SendDatagram(b []byte) error {
+	select {
+	case c.peer.dgrams <- bytes.Clone(b):
+		c.sentDgrams.Add(1)
+		c.firstDgram.CompareAndSwap(0, time.Now().UnixMilli())
+	default:
+	}
 	return nil
 }
 
-// ReceiveDatagram blocks until the context is cancelled. Returning an error
-// immediately would kill the MoQ session's errgroup, so we block instead.
+// ReceiveDatagram blocks until a datagram arrives or the context is
+// cancelled. It does not fail when the connection is closed, since an error
+// would kill the MoQ session's errgroup.
 func (c *memConn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
-	<-ctx.Done()
-	return nil, ctx.Err()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case b := <-c.dgrams:
+		return b, nil
+	}
 }
 
 func (c *memConn) trackPipe(p *asyncPipe) {
@@ -283,33 +310,56 @@ func (c *memConn) Perspective() moqtransport.Perspective {
 }
 
 func (c *memConn) NegotiatedALPN() string {
-	return "moq-00" // in-memory connections use draft-14 negotiation
+	if c.alpn != "" {
+		return c.alpn
+	}
+	return "moq-00" // in-memory connections use draft-14 negotiation by default
 }
 
 // memStream implements moqtransport.Stream (bidirectional).
 type memStream struct {
-	id uint64
-	r  *asyncPipe // read from peer
-	w  *asyncPipe // write to peer
+	id   uint64
+	r    *asyncPipe // read from peer
+	w    *asyncPipe // write to peer
+	conn *memConn   // local end
 }
 
-func (s *memStream) Read(p []byte) (int, error)  { return s.r.Read(p) }
 func (s *memStream) Write(p []byte) (int, error) { return s.w.Write(p) }
 func (s *memStream) Close() error                { return s.w.Close() }
 func (s *memStream) Stop(uint32)                 { _ = s.r.CloseWithError(io.EOF) }
 func (s *memStream) Reset(uint32)                { _ = s.w.CloseWithError(io.ErrClosedPipe) }
 func (s *memStream) StreamID() uint64            { return s.id }
 
+func (s *memStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if d := time.Duration(s.conn.controlDelay.Load()); d > 0 {
+		time.Sleep(d)
+	}
+	return n, err
+}
+
 // memSendStream implements moqtransport.SendStream (write-only).
 type memSendStream struct {
-	id uint64
-	w  *asyncPipe
+	id   uint64
+	w    *asyncPipe
+	conn *memConn
+}
+
+func (s *memSendStream) Close() error     { return s.w.Close() }
+func (s *memSendStream) StreamID() uint64 { return s.id }
+
+func (s *memSendStream) Write(p []byte) (int, error) {
+	if s.conn.stalled.Load() {
+		<-s.conn.ctx.Done()
+		return 0, io.ErrClosedPipe
+	}
+	return s.w.Write(p)
 }
 
-func (s *memSendStream) Write(p []byte) (int, error) { return s.w.Write(p) }
-func (s *memSendStream) Close() error                { return s.w.Close() }
-func (s *memSendStream) Reset(uint32)                { _ = s.w.CloseWithError(io.ErrClosedPipe) }
-func (s *memSendStream) StreamID() uint64            { return s.id }
+func (s *memSendStream) Reset(uint32) {
+	s.conn.resets.Add(1)
+	_ = s.w.CloseWithError(io.ErrClosedPipe)
+}
 
 // memReceiveStream implements moqtransport.ReceiveStream (read-only).
 type memReceiveStream struct {
@@ -15,12 +15,13 @@ type ObjectWriter func(objectID uint64, data []byte) (n int, err error)
 // time interval of audio frames
 
 type MoQGroup struct {
-	id         uint32
-	startTime  uint64
-	endTime    uint64
-	startNr    uint64
-	endNr      uint64
-	MoQObjects []MoQObject
+	id          uint32
+	startTime   uint64
+	endTime     uint64
+	startNr     uint64
+	endNr       uint64
+	sampleBatch int
+	MoQObjects  []MoQObject
 }
 
 type MoQObject []byte
@@ -35,12 +36,13 @@ func GenMoQGroup(track *ContentTrack, groupNr uint64, sampleBatch int,
 	startTime := startNr * uint64(track.SampleDur)
 	endTime := endNr * uint64(track.SampleDur)
 	mq := &MoQGroup{
-		id:         uint32(groupNr),
-		startTime:  startTime,
-		endTime:    endTime,
-		startNr:    startNr,
-		endNr:      endNr,
-		MoQObjects: make([]MoQObject, 0, endNr-startNr),
+		id:          uint32(groupNr),
+		startTime:   startTime,
+		endTime:     endTime,
+		startNr:     startNr,
+		endNr:       endNr,
+		sampleBatch: sampleBatch,
+		MoQObjects:  make([]MoQObject, 0, endNr-startNr),
 	}
 	v02State := locmaf.NewState()
 	for i := startNr; i < endNr; i += uint64(sampleBatch) {
@@ -94,34 +96,59 @@ func CurrMoQGroupNr(track *ContentTrack, nowMS uint64, constantDurMS uint32) uin
 	return nowMS / uint64(constantDurMS)
 }
 
+// MoQObjectTimesMS returns the wall-clock times in milliseconds at which the
+// objects of group groupNr are complete and sent by WriteMoQGroup.
+func MoQObjectTimesMS(track *ContentTrack, groupNr uint64, sampleBatch int, constantDurMS uint32) []int64 {
+	startNr, endNr := calcMoQGroup(track, groupNr, constantDurMS)
+	batch := uint64(sampleBatch)
+	nrObjects := (endNr - startNr + batch - 1) / batch
+	times := make([]int64, nrObjects)
+	for nr := range times {
+		times[nr] = moqObjectTimeMS(track, startNr*uint64(track.SampleDur), nr, sampleBatch)
+	}
+	return times
+}
+
+// moqObjectTimeMS returns the wall-clock time in milliseconds when object nr of
+// a group starting at startTime is complete.
+func moqObjectTimeMS(track *ContentTrack, startTime uint64, nr, sampleBatch int) int64 {
+	factorMS := 1000 / float64(track.TimeScale)
+	objTime := startTime + uint64(nr+1)*uint64(track.SampleDur)*uint64(sampleBatch)
+	return int64(float64(objTime) * factorMS)
+}
+
+// WaitFunc waits until the wall-clock time atMS in milliseconds since the
+// epoch, and returns the error of ctx if it is done first.
+type WaitFunc func(ctx context.Context, atMS int64) error
+
+// WaitUntil is a WaitFunc with a timer of its own.
+func WaitUntil(ctx context.Context, atMS int64) error {
+	waitMS := atMS - time.Now().UnixMilli()
+	if waitMS <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(waitMS) * time.Millisecond):
+		return nil
+	}
+}
+
 // WriteMoQGroup write all MoQGroup objects to a MoQWriter.
-// The MoQGroup is sent in the correct time order and at appropriate times if ongoing session.
+// The MoQGroup is sent in the correct time order, each object when wait
+// returns for the time it is complete if ongoing session.
 // If the context is done, the function returns the error from the context.
-func WriteMoQGroup(ctx context.Context, track *ContentTrack, moq *MoQGroup, cb ObjectWriter) error {
-	factorMS := 1000 / float64(track.TimeScale)
+func WriteMoQGroup(ctx context.Context, track *ContentTrack, moq *MoQGroup, wait WaitFunc, cb ObjectWriter) error {
 	for nr, moqObj := range moq.MoQObjects {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		now := time.Now().UnixMilli()
-		objTime := moq.startTime + uint64(nr+1)*uint64(track.SampleDur)*uint64(track.SampleBatch)
-		objTimeMS := int64(float64(objTime) * factorMS)
-		waitTime := objTimeMS - now
-		if waitTime <= 0 {
-			_, err := cb(uint64(nr), moqObj)
-			if err != nil {
-				return err
-			}
-			continue
+		if err := wait(ctx, moqObjectTimeMS(track, moq.startTime, nr, moq.sampleBatch)); err != nil {
+			return err
 		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(time.Duration(waitTime) * time.Millisecond):
-			_, err := cb(uint64(nr), moqObj)
-			if err != nil {
-				return err
-			}
+		if _, err := cb(uint64(nr), moqObj); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -157,7 +157,7 @@ func TestWriteMoQGroupLive(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to generate MoQ group: %v", err)
 		}
-		err = WriteMoQGroup(context.Background(), ct, mg, cb)
+		err = WriteMoQGroup(context.Background(), ct, mg, WaitUntil, cb)
 		if err != nil {
 			log.Printf("failed to write MoQ group: %v", err)
 			return
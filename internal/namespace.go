@@ -0,0 +1,24 @@
+package internal
+
+import "strings"
+
+// NamespaceHasPrefix reports whether the namespace tuple ns matches the
+// SUBSCRIBE_NAMESPACE prefix tuple. All elements of prefix but the last
+// must be equal to those of ns. The last may also be a "/"-separated path
+// prefix of the element, so that "cmsf" matches "cmsf/clear", since the
+// namespaces of mlmpub are single elements like that.
+func NamespaceHasPrefix(ns, prefix []string) bool {
+	if len(prefix) == 0 {
+		return true
+	}
+	if len(prefix) > len(ns) {
+		return false
+	}
+	last := len(prefix) - 1
+	for i := 0; i < last; i++ {
+		if ns[i] != prefix[i] {
+			return false
+		}
+	}
+	return ns[last] == prefix[last] || strings.HasPrefix(ns[last], prefix[last]+"/")
+}
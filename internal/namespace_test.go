@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceHasPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		ns     []string
+		prefix []string
+		want   bool
+	}{
+		{"empty prefix", []string{"cmsf/clear"}, nil, true},
+		{"equal", []string{"cmsf/clear"}, []string{"cmsf/clear"}, true},
+		{"path prefix", []string{"cmsf/clear"}, []string{"cmsf"}, true},
+		{"deeper path prefix", []string{"cmsf/clear/b"}, []string{"cmsf/clear"}, true},
+		{"partial path element", []string{"cmsf/clear"}, []string{"cms"}, false},
+		{"tuple prefix", []string{"moq-test", "interop"}, []string{"moq-test"}, true},
+		{"path prefix of last element", []string{"a", "b/c"}, []string{"a", "b"}, true},
+		{"path prefix of inner element", []string{"a/x", "b"}, []string{"a", "b"}, false},
+		{"longer prefix", []string{"cmsf"}, []string{"cmsf", "clear"}, false},
+		{"other namespace", []string{"msf/clear"}, []string{"cmsf"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NamespaceHasPrefix(tt.ns, tt.prefix))
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package internal_test
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqlivemock/internal/pub"
+	"github.com/Eyevinn/moqtransport"
+	"github.com/stretchr/testify/require"
+)
+
+// BenchmarkPacedSubscribers runs sessions subscribing to a video track over
+// in-memory connections, and reads an object of every subscription per
+// iteration. It reports the wake-ups of the publisher's Scheduler per
+// second and the waits released per wake-up, which show that the pacing
+// cost follows the number of tracks rather than of subscriptions, the
+// goroutines per subscriber, and the shared publishing goroutines and track
+// feeds, which stay the same however many subscribers there are. The in-memory
+// connections never apply backpressure, see TestStalledSubscriber for that.
+func BenchmarkPacedSubscribers(b *testing.B) {
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	b.Cleanup(func() { slog.SetDefault(logger) })
+	asset, err := internal.LoadAsset(testAssetDir, 2, 1)
+	require.NoError(b, err)
+	catalog, err := asset.GenCMAFCatalogEntry(testNamespace, internal.ProtectionNone, 0)
+	require.NoError(b, err)
+	for _, subscribers := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("subscribers=%d", subscribers), func(b *testing.B) {
+			benchmarkPacedSubscribers(b, asset, catalog, subscribers)
+		})
+	}
+}
+
+func benchmarkPacedSubscribers(b *testing.B, asset *internal.Asset, catalog *internal.Catalog, subscribers int) {
+	const videoTrack = "video_400kbps_avc"
+	ph := newPubHandler(asset, catalog)
+	ph.Scheduler = pub.NewScheduler()
+	ph.GroupCache = internal.NewGroupCache(internal.DefaultGroupCacheBytes)
+	goroutines := runtime.NumGoroutine()
+	tracks := make([]*moqtransport.RemoteTrack, subscribers)
+	for i := range tracks {
+		sConn, cConn := memConnPair()
+		b.Cleanup(func() { shutdown(sConn, cConn) })
+		go ph.Handle(b.Context(), sConn)
+		session := &moqtransport.Session{
+			Handler: moqtransport.HandlerFunc(func(w moqtransport.ResponseWriter, r *moqtransport.Message) {
+				if r.Method == moqtransport.MessageAnnounce {
+					_ = w.Accept()
+				}
+			}),
+			InitialMaxRequestID: 100,
+		}
+		require.NoError(b, session.Run(cConn))
+		rs, err := session.Subscribe(b.Context(), []string{testNamespace}, videoTrack)
+		require.NoError(b, err)
+		tracks[i] = rs
+	}
+	goroutines = runtime.NumGoroutine() - goroutines
+	for _, rs := range tracks {
+		if _, err := rs.ReadObject(b.Context()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	publishing, _ := publishingGoroutines()
+
+	start := ph.Scheduler.Stats()
+	for b.Loop() {
+		for _, rs := range tracks {
+			if _, err := rs.ReadObject(b.Context()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	stats := ph.Scheduler.Stats()
+	wakeups := stats.Wakeups - start.Wakeups
+	b.ReportMetric(float64(wakeups)/b.Elapsed().Seconds(), "wakeups/s")
+	if wakeups > 0 {
+		b.ReportMetric(float64(stats.Waits-start.Waits)/float64(wakeups), "waits/wakeup")
+	}
+	b.ReportMetric(float64(goroutines)/float64(subscribers), "goroutines/subscriber")
+	b.ReportMetric(float64(publishing), "publishing-goroutines")
+	b.ReportMetric(float64(stats.Feeds), "feeds")
+}
+
+// publishingGoroutines returns the number of goroutines that run code of
+// package pub, apart from the Handler.Handle of each session, split into
+// the shared ones and the writers of the subscriptions.
+func publishingGoroutines() (shared, writers int) {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		switch {
+		case !strings.Contains(g, "/internal/pub."), strings.Contains(g, "/internal/pub.(*Handler).Handle("):
+		case strings.Contains(g, "/internal/pub.(*trackFeed).write("):
+			writers++
+		default:
+			shared++
+		}
+	}
+	return shared, writers
+}
@@ -0,0 +1,605 @@
+package pub
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+)
+
+// ErrUnknownSession is returned for a session ID that is not active.
+var ErrUnknownSession = errors.New("unknown session")
+
+// ErrUnknownNamespace is returned for a namespace that is not published.
+var ErrUnknownNamespace = errors.New("unknown namespace")
+
+// sessionInfo is the state of an active session, as seen by the admin API.
+// Its fields are protected by the mutex of the Handler.
+type sessionInfo struct {
+	id            uint64
+	ctx           context.Context
+	conn          moqtransport.Connection
+	session       *moqtransport.Session
+	started       time.Time
+	announced     map[string]bool // announced namespaces keyed by namespaceKey
+	announcing    map[string]bool // namespaces waiting for the announce response
+	prefixes      [][]string      // namespace prefixes subscribed to with SUBSCRIBE_NAMESPACE
+	subscriptions map[uint64]*SubscriptionStatus
+	lastSubID     uint64
+}
+
+// SessionStatus is the status of an active session.
+type SessionStatus struct {
+	ID            uint64               `json:"id"`
+	Protocol      string               `json:"protocol"`
+	ALPN          string               `json:"alpn,omitempty"`
+	Perspective   string               `json:"perspective"`
+	Started       time.Time            `json:"started"`
+	Namespaces    [][]string           `json:"namespaces"`
+	Prefixes      [][]string           `json:"prefixes,omitempty"`
+	Subscriptions []SubscriptionStatus `json:"subscriptions"`
+}
+
+// SubscriptionStatus is the status of an active media subscription.
+type SubscriptionStatus struct {
+	ID        uint64                `json:"id"`
+	Namespace []string              `json:"namespace"`
+	Track     string                `json:"track"`
+	Packaging string                `json:"packaging"`
+	Start     moqtransport.Location `json:"start"`
+	Started   time.Time             `json:"started"`
+}
+
+// NamespaceStatus is the status of a published namespace.
+type NamespaceStatus struct {
+	Namespace []string `json:"namespace"`
+	Packaging string   `json:"packaging"`
+	Enabled   bool     `json:"enabled"`
+}
+
+// namespaceKey returns the key of a namespace tuple in maps.
+func namespaceKey(ns []string) string {
+	return strings.Join(ns, "\x00")
+}
+
+// addSession registers a session on conn that runs until ctx is done.
+func (h *Handler) addSession(ctx context.Context, conn moqtransport.Connection) *sessionInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions == nil {
+		h.sessions = make(map[uint64]*sessionInfo)
+	}
+	h.lastSessionID++
+	si := &sessionInfo{
+		id:            h.lastSessionID,
+		ctx:           ctx,
+		conn:          conn,
+		started:       time.Now(),
+		announced:     make(map[string]bool),
+		announcing:    make(map[string]bool),
+		subscriptions: make(map[uint64]*SubscriptionStatus),
+	}
+	h.sessions[si.id] = si
+	return si
+}
+
+func (h *Handler) removeSession(si *sessionInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, si.id)
+}
+
+// announce announces ns on the session of si unless it is disabled,
+// announced or being announced. It is only recorded as announced once the
+// peer accepted it, so that a rejected namespace can be announced again. A
+// namespace disabled while being announced is withdrawn.
+func (h *Handler) announce(si *sessionInfo, ns []string) error {
+	key := namespaceKey(ns)
+	h.mu.Lock()
+	if h.disabled[key] || si.announced[key] || si.announcing[key] {
+		h.mu.Unlock()
+		return nil
+	}
+	si.announcing[key] = true
+	h.mu.Unlock()
+	slog.Info("announcing namespace", "session", si.id, "namespace", ns)
+	err := si.session.Announce(si.ctx, ns)
+	h.mu.Lock()
+	delete(si.announcing, key)
+	withdraw := err == nil && h.disabled[key]
+	if err == nil && !withdraw {
+		si.announced[key] = true
+	}
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if withdraw {
+		slog.Info("namespace disabled while announcing", "session", si.id, "namespace", ns)
+		return si.session.Unannounce(si.ctx, ns)
+	}
+	slog.Info("namespace announced successfully", "session", si.id, "namespace", ns)
+	return nil
+}
+
+// wantsNamespace reports whether ns is to be announced to si. Unless
+// AnnounceOnRequest is set, all namespaces are. The mutex must be held.
+func (h *Handler) wantsNamespace(si *sessionInfo, ns []string) bool {
+	if !h.AnnounceOnRequest {
+		return true
+	}
+	return slices.ContainsFunc(si.prefixes, func(prefix []string) bool {
+		return internal.NamespaceHasPrefix(ns, prefix)
+	})
+}
+
+// announceWanted announces the enabled namespaces wanted by si, in
+// configuration order. A subscriber that connected to the publisher may
+// reject the namespaces it does not want, but a rejection by a relay that
+// the publisher connected to fails like any other error.
+func (h *Handler) announceWanted(si *sessionInfo) error {
+	for _, ns := range h.Namespaces {
+		h.mu.Lock()
+		want := h.wantsNamespace(si, ns.Namespace)
+		h.mu.Unlock()
+		if !want {
+			continue
+		}
+		err := h.announce(si, ns.Namespace)
+		var rejected moqtransport.ProtocolError
+		if errors.As(err, &rejected) && si.conn.Perspective() == moqtransport.PerspectiveServer {
+			slog.Info("namespace rejected by subscriber", "session", si.id, "namespace", ns.Namespace,
+				"reason", rejected.Error())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("announce %v: %w", ns.Namespace, err)
+		}
+	}
+	return nil
+}
+
+// subscribeNamespace answers a SUBSCRIBE_NAMESPACE for prefix. It is
+// rejected if no enabled namespace matches. Otherwise, the matching enabled
+// namespaces are announced after the response, unless already announced.
+func (h *Handler) subscribeNamespace(w moqtransport.ResponseWriter, si *sessionInfo, prefix []string) {
+	matches := 0
+	for _, ns := range h.Namespaces {
+		if internal.NamespaceHasPrefix(ns.Namespace, prefix) && h.namespaceEnabled(ns.Namespace) {
+			matches++
+		}
+	}
+	if matches == 0 {
+		slog.Warn("no namespace matches SUBSCRIBE_NAMESPACE prefix", "session", si.id, "prefix", prefix)
+		err := w.Reject(uint64(moqtransport.ErrorCodeSubscribeAnnouncesNamespacePrefixUnknown),
+			"no namespace matches the prefix")
+		if err != nil {
+			slog.Error("failed to reject SUBSCRIBE_NAMESPACE", "error", err)
+		}
+		return
+	}
+	h.mu.Lock()
+	si.prefixes = append(si.prefixes, prefix)
+	started := si.session != nil
+	h.mu.Unlock()
+	slog.Info("accepting SUBSCRIBE_NAMESPACE", "session", si.id, "prefix", prefix, "matches", matches)
+	if err := w.Accept(); err != nil {
+		slog.Error("failed to accept SUBSCRIBE_NAMESPACE", "error", err)
+		return
+	}
+	if !started {
+		// Handle announces the wanted namespaces once the session is set.
+		return
+	}
+	// Announcing blocks until the response is received by the session,
+	// which calls this handler, so it cannot be done inline.
+	go func() {
+		if err := h.announceWanted(si); err != nil {
+			slog.Error("failed to announce namespace", "session", si.id, "error", err)
+		}
+	}()
+}
+
+// unsubscribeNamespace removes prefix from the SUBSCRIBE_NAMESPACE prefixes
+// of si. Namespaces already announced are not withdrawn.
+func (h *Handler) unsubscribeNamespace(si *sessionInfo, prefix []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	si.prefixes = slices.DeleteFunc(si.prefixes, func(p []string) bool {
+		return tupleEqual(p, prefix)
+	})
+	slog.Info("UNSUBSCRIBE_NAMESPACE", "session", si.id, "prefix", prefix)
+}
+
+// addSubscription registers a media subscription of si and returns a
+// function that removes it.
+func (h *Handler) addSubscription(si *sessionInfo, m *moqtransport.SubscribeMessage, packaging string,
+	start moqtransport.Location) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	si.lastSubID++
+	id := si.lastSubID
+	si.subscriptions[id] = &SubscriptionStatus{
+		ID:        id,
+		Namespace: m.Namespace,
+		Track:     m.Track,
+		Packaging: packaging,
+		Start:     start,
+		Started:   time.Now(),
+	}
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(si.subscriptions, id)
+	}
+}
+
+// Sessions returns the active sessions ordered by ID.
+func (h *Handler) Sessions() []SessionStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	statuses := make([]SessionStatus, 0, len(h.sessions))
+	for _, si := range h.sessions {
+		st := SessionStatus{
+			ID:            si.id,
+			Protocol:      si.conn.Protocol().String(),
+			ALPN:          si.conn.NegotiatedALPN(),
+			Perspective:   si.conn.Perspective().String(),
+			Started:       si.started,
+			Namespaces:    [][]string{},
+			Prefixes:      slices.Clone(si.prefixes),
+			Subscriptions: []SubscriptionStatus{},
+		}
+		for _, ns := range h.Namespaces {
+			if si.announced[namespaceKey(ns.Namespace)] {
+				st.Namespaces = append(st.Namespaces, ns.Namespace)
+			}
+		}
+		for _, sub := range si.subscriptions {
+			st.Subscriptions = append(st.Subscriptions, *sub)
+		}
+		slices.SortFunc(st.Subscriptions, func(a, b SubscriptionStatus) int { return cmp.Compare(a.ID, b.ID) })
+		statuses = append(statuses, st)
+	}
+	slices.SortFunc(statuses, func(a, b SessionStatus) int { return cmp.Compare(a.ID, b.ID) })
+	return statuses
+}
+
+// CloseSession closes the connection of the session with the given ID.
+func (h *Handler) CloseSession(id uint64) error {
+	h.mu.Lock()
+	si, ok := h.sessions[id]
+	h.mu.Unlock()
+	if !ok {
+		return ErrUnknownSession
+	}
+	slog.Info("closing session", "session", id)
+	return si.conn.CloseWithError(0, "closed by admin")
+}
+
+// currentFaults returns the faults to inject into new groups.
+func (h *Handler) currentFaults() *FaultConfig {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.faultsSet {
+		return h.faults
+	}
+	return h.Faults
+}
+
+// CurrentFaults returns the faults injected into new groups, or nil if
+// there are none.
+func (h *Handler) CurrentFaults() *FaultConfig {
+	return h.currentFaults()
+}
+
+// SetFaults changes the faults injected into groups started from now on,
+// in all sessions. nil stops injecting faults.
+func (h *Handler) SetFaults(fc *FaultConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.faults, h.faultsSet = fc, true
+}
+
+// NamespaceStatuses returns the status of all namespaces in configuration order.
+func (h *Handler) NamespaceStatuses() []NamespaceStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	statuses := make([]NamespaceStatus, 0, len(h.Namespaces))
+	for _, ns := range h.Namespaces {
+		statuses = append(statuses, NamespaceStatus{
+			Namespace: ns.Namespace,
+			Packaging: ns.Packaging,
+			Enabled:   !h.disabled[namespaceKey(ns.Namespace)],
+		})
+	}
+	return statuses
+}
+
+// namespaceEnabled reports whether ns has not been disabled.
+func (h *Handler) namespaceEnabled(ns []string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.disabled[namespaceKey(ns)]
+}
+
+// SetNamespaceEnabled enables or disables the namespace ns. A disabled
+// namespace is withdrawn with PUBLISH_NAMESPACE_DONE in all sessions, and
+// new subscriptions and FETCHes to it are rejected. Existing subscriptions
+// continue. An enabled namespace is announced in all sessions that want it.
+func (h *Handler) SetNamespaceEnabled(ns []string, enabled bool) error {
+	if h.findConfiguredNamespace(ns) == nil {
+		return ErrUnknownNamespace
+	}
+	key := namespaceKey(ns)
+	h.mu.Lock()
+	if h.disabled == nil {
+		h.disabled = make(map[string]bool)
+	}
+	if h.disabled[key] == !enabled {
+		h.mu.Unlock()
+		return nil
+	}
+	h.disabled[key] = !enabled
+	sessions := make([]*sessionInfo, 0, len(h.sessions))
+	for _, si := range h.sessions {
+		if si.session == nil || si.announced[key] == enabled {
+			continue
+		}
+		if enabled && !h.wantsNamespace(si, ns) {
+			continue
+		}
+		if !enabled {
+			delete(si.announced, key)
+		}
+		sessions = append(sessions, si)
+	}
+	h.mu.Unlock()
+	slog.Info("namespace state changed", "namespace", ns, "enabled", enabled, "sessions", len(sessions))
+	for _, si := range sessions {
+		if enabled {
+			go func() {
+				if err := h.announce(si, ns); err != nil {
+					slog.Error("failed to announce namespace", "session", si.id, "namespace", ns, "error", err)
+				}
+			}()
+			continue
+		}
+		if err := si.session.Unannounce(si.ctx, ns); err != nil {
+			slog.Error("failed to send PUBLISH_NAMESPACE_DONE", "session", si.id, "namespace", ns, "error", err)
+		}
+	}
+	return nil
+}
+
+// AdminHandler returns an http.Handler serving a JSON API to inspect and
+// reconfigure the publisher at runtime:
+//
+//	GET    /admin/sessions       list sessions and their subscriptions
+//	DELETE /admin/sessions/{id}  close a session
+//	GET    /admin/faults         get the injected faults
+//	PUT    /admin/faults         set the injected faults, {"faults": "drop=0.01,..."}
+//	GET    /admin/namespaces     list namespaces
+//	PUT    /admin/namespaces     enable or disable a namespace, {"namespace": [...], "enabled": false}
+//	GET    /admin/batching       get the samples per object of video and audio
+//	PUT    /admin/batching       set the samples per object for future groups, {"video": 2, "audio": 4}
+//	GET    /admin/clearkey       get the ClearKey license server policy
+//	PUT    /admin/clearkey       change the policy, {"token": "t", "delay": "500ms", "failRate": 0.1, ...}
+func (h *Handler) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.Sessions())
+	})
+	mux.HandleFunc("DELETE /admin/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bad session id: %w", err))
+			return
+		}
+		if err := h.CloseSession(id); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /admin/faults", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, faultsBody(h.CurrentFaults()))
+	})
+	mux.HandleFunc("PUT /admin/faults", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Faults string `json:"faults"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		fc, err := ParseFaultConfig(body.Faults)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		h.SetFaults(fc)
+		slog.Info("faults changed", "faults", body.Faults)
+		writeJSON(w, http.StatusOK, faultsBody(fc))
+	})
+	mux.HandleFunc("GET /admin/namespaces", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.NamespaceStatuses())
+	})
+	mux.HandleFunc("PUT /admin/namespaces", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Namespace []string `json:"namespace"`
+			Enabled   *bool    `json:"enabled"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		if body.Enabled == nil {
+			writeError(w, http.StatusBadRequest, errors.New("missing enabled"))
+			return
+		}
+		if err := h.SetNamespaceEnabled(body.Namespace, *body.Enabled); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, h.NamespaceStatuses())
+	})
+	mux.HandleFunc("GET /admin/batching", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.batching())
+	})
+	mux.HandleFunc("PUT /admin/batching", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]int
+		if !readJSON(w, r, &body) {
+			return
+		}
+		for contentType, n := range body {
+			if contentType != "video" && contentType != "audio" || n < 1 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("bad batching %s=%d", contentType, n))
+				return
+			}
+		}
+		// The change applies from the next group, since subscriptions may
+		// already have generated the current one.
+		fromGroup := uint64(time.Now().UnixMilli())/uint64(internal.MoqGroupDurMS) + 1
+		for contentType, n := range body {
+			for _, a := range h.assets() {
+				a.SetSampleBatch(contentType, n, fromGroup)
+			}
+			slog.Info("sample batch changed", "contentType", contentType, "sampleBatch", n, "fromGroup", fromGroup)
+		}
+		writeJSON(w, http.StatusOK, h.batching())
+	})
+	mux.HandleFunc("GET /admin/clearkey", func(w http.ResponseWriter, r *http.Request) {
+		if h.ClearKey == nil {
+			writeError(w, http.StatusNotFound, errNoClearKey)
+			return
+		}
+		writeJSON(w, http.StatusOK, newClearKeyBody(h.ClearKey.Policy()))
+	})
+	mux.HandleFunc("PUT /admin/clearkey", func(w http.ResponseWriter, r *http.Request) {
+		if h.ClearKey == nil {
+			writeError(w, http.StatusNotFound, errNoClearKey)
+			return
+		}
+		// Fields missing in the body keep their current values.
+		current := h.ClearKey.Policy()
+		body := newClearKeyBody(current)
+		if !readJSON(w, r, &body) {
+			return
+		}
+		policy, err := body.policy(current)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		h.ClearKey.SetPolicy(policy)
+		slog.Info("ClearKey policy changed", "delay", policy.Delay, "failRate", policy.FailRate,
+			"failStatus", policy.FailStatus, "token", policy.Token != "", "authzSecret", policy.AuthzSecret != "")
+		writeJSON(w, http.StatusOK, newClearKeyBody(policy))
+	})
+	return mux
+}
+
+var errNoClearKey = errors.New("no ClearKey license server")
+
+// clearKeyBody is a ClearKeyPolicy in the admin API, with a readable delay.
+// The token and the shared secret are write-only, since the admin API is
+// not authenticated: responses only report whether they are set.
+type clearKeyBody struct {
+	Token          *string `json:"token,omitempty"`
+	AuthzSecret    *string `json:"authzSecret,omitempty"`
+	TokenSet       bool    `json:"tokenSet"`
+	AuthzSecretSet bool    `json:"authzSecretSet"`
+	Delay          string  `json:"delay"`
+	FailRate       float64 `json:"failRate"`
+	FailStatus     int     `json:"failStatus"`
+}
+
+func newClearKeyBody(p ClearKeyPolicy) clearKeyBody {
+	return clearKeyBody{
+		TokenSet:       p.Token != "",
+		AuthzSecretSet: p.AuthzSecret != "",
+		Delay:          p.Delay.String(),
+		FailRate:       p.FailRate,
+		FailStatus:     p.FailStatus,
+	}
+}
+
+// policy returns the ClearKeyPolicy of b, or an error if it is invalid.
+// The token and the shared secret of current are kept unless b sets them.
+func (b clearKeyBody) policy(current ClearKeyPolicy) (ClearKeyPolicy, error) {
+	delay, err := time.ParseDuration(b.Delay)
+	if err != nil || delay < 0 {
+		return ClearKeyPolicy{}, fmt.Errorf("bad delay %q", b.Delay)
+	}
+	if b.FailRate < 0 || b.FailRate > 1 {
+		return ClearKeyPolicy{}, fmt.Errorf("failRate must be between 0 and 1: %g", b.FailRate)
+	}
+	if b.FailStatus != 0 && (b.FailStatus < 400 || b.FailStatus > 599) {
+		return ClearKeyPolicy{}, fmt.Errorf("failStatus must be an HTTP error status: %d", b.FailStatus)
+	}
+	token, authzSecret := current.Token, current.AuthzSecret
+	if b.Token != nil {
+		token = *b.Token
+	}
+	if b.AuthzSecret != nil {
+		authzSecret = *b.AuthzSecret
+	}
+	return ClearKeyPolicy{
+		Token:       token,
+		AuthzSecret: authzSecret,
+		Delay:       delay,
+		FailRate:    b.FailRate,
+		FailStatus:  b.FailStatus,
+	}, nil
+}
+
+// batching returns the current samples per object of video and audio.
+func (h *Handler) batching() map[string]int {
+	b := make(map[string]int)
+	for _, a := range h.assets() {
+		for _, group := range a.Groups {
+			for _, ct := range group.Tracks {
+				if _, ok := b[ct.ContentType]; !ok {
+					b[ct.ContentType] = ct.CurrentSampleBatch()
+				}
+			}
+		}
+	}
+	return b
+}
+
+func faultsBody(fc *FaultConfig) map[string]string {
+	if fc == nil {
+		return map[string]string{"faults": ""}
+	}
+	return map[string]string{"faults": fc.String()}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode admin response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
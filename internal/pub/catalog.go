@@ -0,0 +1,337 @@
+package pub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+)
+
+// cloneSuffix is appended to the name of a track cloned by the catalog schedule.
+const cloneSuffix = "_clone"
+
+// LiveCatalog is the publisher-side state of a live MSF catalog track
+// (draft-ietf-moq-msf-01 Section 5.3). Every group starts with a full
+// catalog as object 0, followed by delta updates as objects 1, 2, ....
+// Update appends a delta object to the current group, and NewGroup starts a
+// new group with a full catalog. Only the current group is retained.
+//
+// A LiveCatalog is safe for concurrent use and shared by all sessions.
+type LiveCatalog struct {
+	mu      sync.Mutex
+	current *internal.Catalog
+	groupID uint64
+	objects [][]byte          // objects of the current group
+	origins map[string]string // cloned track name -> parent track name
+	changed chan struct{}     // closed and replaced on every change
+}
+
+// NewLiveCatalog returns a LiveCatalog whose group 0 holds cat as the full catalog.
+func NewLiveCatalog(cat *internal.Catalog) (*LiveCatalog, error) {
+	full, err := json.Marshal(cat)
+	if err != nil {
+		return nil, fmt.Errorf("marshal catalog: %w", err)
+	}
+	return &LiveCatalog{
+		current: cat,
+		objects: [][]byte{full},
+		origins: make(map[string]string),
+		changed: make(chan struct{}),
+	}, nil
+}
+
+// Catalog returns the current full catalog. It must not be modified.
+func (lc *LiveCatalog) Catalog() *internal.Catalog {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.current
+}
+
+// hasTrack reports whether the current catalog has the track name, and
+// returns a channel that is closed on the next change.
+func (lc *LiveCatalog) hasTrack(name string) (bool, <-chan struct{}) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.current.GetTrackByName(name) != nil, lc.changed
+}
+
+// Largest returns the location of the latest catalog object.
+func (lc *LiveCatalog) Largest() moqtransport.Location {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return moqtransport.Location{Group: lc.groupID, Object: uint64(len(lc.objects) - 1)}
+}
+
+// ContentTrackName returns the name of the track that trackName was
+// (possibly transitively) cloned from, or trackName itself if it is not a
+// clone. The publisher serves a clone with its parent's media.
+func (lc *LiveCatalog) ContentTrackName(trackName string) string {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	for {
+		parent, ok := lc.origins[trackName]
+		if !ok {
+			return trackName
+		}
+		trackName = parent
+	}
+}
+
+// Update applies the delta operations ops to the catalog and publishes them
+// as one delta object in the current group.
+func (lc *LiveCatalog) Update(ops ...internal.DeltaOperation) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	generatedAt := time.Now().UnixMilli()
+	delta := &internal.Catalog{
+		Version:     lc.current.Version,
+		GeneratedAt: &generatedAt,
+		DeltaUpdate: ops,
+	}
+	next, err := lc.current.ApplyDelta(delta)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("marshal delta: %w", err)
+	}
+	for _, op := range ops {
+		for _, t := range op.Tracks {
+			switch op.Op {
+			case internal.DeltaOpClone:
+				lc.origins[t.Name] = t.ParentName
+			case internal.DeltaOpRemove:
+				delete(lc.origins, t.Name)
+			}
+		}
+	}
+	lc.current = next
+	lc.objects = append(lc.objects, payload)
+	lc.notify()
+	return nil
+}
+
+// AddTracks adds tracks to the catalog with an "add" delta.
+func (lc *LiveCatalog) AddTracks(tracks ...internal.Track) error {
+	return lc.Update(internal.DeltaOperation{Op: internal.DeltaOpAdd, Tracks: tracks})
+}
+
+// RemoveTracks removes the named tracks from the catalog with a "remove" delta.
+func (lc *LiveCatalog) RemoveTracks(names ...string) error {
+	tracks := make([]internal.Track, 0, len(names))
+	for _, name := range names {
+		tracks = append(tracks, internal.Track{Name: name})
+	}
+	return lc.Update(internal.DeltaOperation{Op: internal.DeltaOpRemove, Tracks: tracks})
+}
+
+// CloneTrack adds clone, a copy of parentName with the fields set in clone
+// overriding the parent's, with a "clone" delta.
+func (lc *LiveCatalog) CloneTrack(parentName string, clone internal.Track) error {
+	clone.ParentName = parentName
+	return lc.Update(internal.DeltaOperation{Op: internal.DeltaOpClone, Tracks: []internal.Track{clone}})
+}
+
+// NewGroup starts a new catalog group whose object 0 is the current full catalog.
+func (lc *LiveCatalog) NewGroup() error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	cat := *lc.current
+	return lc.newGroup(&cat)
+}
+
+// SetContentProtections replaces the content protections of the catalog.
+// Delta updates can only change tracks, so a new group with the full
+// catalog is started.
+func (lc *LiveCatalog) SetContentProtections(cps []internal.ContentProtection) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	cat := *lc.current
+	cat.ContentProtections = cps
+	return lc.newGroup(&cat)
+}
+
+// newGroup starts a new catalog group whose object 0 is cat. lc.mu must be held.
+func (lc *LiveCatalog) newGroup(cat *internal.Catalog) error {
+	generatedAt := time.Now().UnixMilli()
+	cat.GeneratedAt = &generatedAt
+	full, err := json.Marshal(cat)
+	if err != nil {
+		return fmt.Errorf("marshal catalog: %w", err)
+	}
+	lc.current = cat
+	lc.groupID++
+	lc.objects = [][]byte{full}
+	lc.notify()
+	return nil
+}
+
+// notify wakes up everyone waiting for a change. lc.mu must be held.
+func (lc *LiveCatalog) notify() {
+	close(lc.changed)
+	lc.changed = make(chan struct{})
+}
+
+// snapshot returns the current group, its objects, and a channel that is
+// closed on the next change.
+func (lc *LiveCatalog) snapshot() (groupID uint64, objects [][]byte, changed <-chan struct{}) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.groupID, lc.objects, lc.changed
+}
+
+// PublishCatalog writes the catalog track to a subscriber: all objects of the
+// current group, and then every update as it happens, opening a new subgroup
+// whenever a new catalog group starts. It returns when ctx is done or a write
+// fails.
+func PublishCatalog(ctx context.Context, publisher moqtransport.Publisher, lc *LiveCatalog) {
+	groupID, objects, changed := lc.snapshot()
+	sg, err := publisher.OpenSubgroup(groupID, 0, 0)
+	if err != nil {
+		slog.Error("failed to open catalog subgroup", "error", err)
+		return
+	}
+	next := 0
+	for {
+		for ; next < len(objects); next++ {
+			if _, err := sg.WriteObject(uint64(next), objects[next]); err != nil {
+				slog.Error("failed to write catalog object", "group", groupID, "object", next, "error", err)
+				_ = sg.Close()
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			_ = sg.Close()
+			return
+		case <-changed:
+		}
+		var g uint64
+		g, objects, changed = lc.snapshot()
+		if g != groupID {
+			if err := sg.Close(); err != nil {
+				slog.Error("failed to close catalog subgroup", "error", err)
+				return
+			}
+			groupID = g
+			next = 0
+			sg, err = publisher.OpenSubgroup(groupID, 0, 0)
+			if err != nil {
+				slog.Error("failed to open catalog subgroup", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// CatalogSchedule configures scheduled live catalog changes.
+type CatalogSchedule struct {
+	// UpdateInterval is the time between track changes. Each change is the
+	// next step of the cycle: remove a track, add it back, clone it, remove
+	// the clone. Zero disables track changes.
+	UpdateInterval time.Duration
+	// FullInterval is the time between new catalog groups starting with a
+	// full catalog. Zero disables periodic full catalogs.
+	FullInterval time.Duration
+}
+
+// RunCatalogSchedule applies the scheduled changes of sched to lc until ctx
+// is done. The changing track is the last video track of the catalog.
+func RunCatalogSchedule(ctx context.Context, lc *LiveCatalog, sched CatalogSchedule) {
+	var updateC, fullC <-chan time.Time
+	if sched.UpdateInterval > 0 {
+		t := time.NewTicker(sched.UpdateInterval)
+		defer t.Stop()
+		updateC = t.C
+	}
+	if sched.FullInterval > 0 {
+		t := time.NewTicker(sched.FullInterval)
+		defer t.Stop()
+		fullC = t.C
+	}
+	if updateC == nil && fullC == nil {
+		return
+	}
+	var victim *internal.Track
+	cat := lc.Catalog()
+	for i := range cat.Tracks {
+		if cat.Tracks[i].Role == "video" {
+			victim = &cat.Tracks[i]
+		}
+	}
+	step := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-fullC:
+			if err := lc.NewGroup(); err != nil {
+				slog.Error("failed to start new catalog group", "error", err)
+				continue
+			}
+			slog.Info("published full catalog", "location", lc.Largest())
+		case <-updateC:
+			if victim == nil {
+				continue
+			}
+			var err error
+			op, name := internal.DeltaOpRemove, victim.Name
+			switch step % 4 {
+			case 0:
+				err = lc.RemoveTracks(name)
+			case 1:
+				op = internal.DeltaOpAdd
+				err = lc.AddTracks(*victim)
+			case 2:
+				op, name = internal.DeltaOpClone, victim.Name+cloneSuffix
+				err = lc.CloneTrack(victim.Name, internal.Track{Name: name, Label: "clone of " + victim.Name})
+			case 3:
+				name = victim.Name + cloneSuffix
+				err = lc.RemoveTracks(name)
+			}
+			step++
+			if err != nil {
+				slog.Error("failed to update catalog", "op", op, "track", name, "error", err)
+				continue
+			}
+			slog.Info("published catalog delta", "op", op, "track", name, "location", lc.Largest())
+		}
+	}
+}
+
+// RunKeyRotation publishes the active content protections of drm in lc at
+// every key period boundary until ctx is done, so that the catalog always
+// lists the KIDs of the current and the next key period.
+func RunKeyRotation(ctx context.Context, lc *LiveCatalog, drm *internal.DRMInfo) {
+	period := drm.RotationGroups()
+	if period == 0 {
+		return
+	}
+	for {
+		nowMS := uint64(time.Now().UnixMilli())
+		next := (nowMS/internal.MoqGroupDurMS/period + 1) * period
+		timer := time.NewTimer(time.Duration(next*internal.MoqGroupDurMS-nowMS) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		cps := drm.ActiveContentProtections(next)
+		if err := lc.SetContentProtections(cps); err != nil {
+			slog.Error("failed to publish key rotation", "error", err)
+			continue
+		}
+		var kids []string
+		if len(cps) > 0 {
+			kids = cps[0].DefaultKIDs
+		}
+		slog.Info("published key rotation", "group", next, "kids", kids, "location", lc.Largest())
+	}
+}
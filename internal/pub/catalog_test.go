@@ -0,0 +1,77 @@
+package pub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveCatalog(t *testing.T) {
+	cat := &internal.Catalog{
+		Version: "draft-01",
+		Tracks: []internal.Track{
+			{Name: "video_400kbps_avc", Role: "video", Codec: "avc1.64001e"},
+			{Name: "audio_128kbps_aac", Role: "audio", Codec: "mp4a.40.2"},
+		},
+	}
+	lc, err := NewLiveCatalog(cat)
+	require.NoError(t, err)
+	assert.Equal(t, moqtransport.Location{Group: 0, Object: 0}, lc.Largest())
+
+	require.NoError(t, lc.CloneTrack("video_400kbps_avc", internal.Track{Name: "video_clone"}))
+	require.NoError(t, lc.RemoveTracks("audio_128kbps_aac"))
+	assert.Equal(t, moqtransport.Location{Group: 0, Object: 2}, lc.Largest())
+	assert.Len(t, lc.Catalog().Tracks, 2)
+	assert.Equal(t, "video_400kbps_avc", lc.ContentTrackName("video_clone"))
+	assert.Equal(t, "audio_128kbps_aac", lc.ContentTrackName("audio_128kbps_aac"))
+
+	// Invalid updates are rejected without publishing an object.
+	assert.Error(t, lc.RemoveTracks("audio_128kbps_aac"))
+	assert.Equal(t, moqtransport.Location{Group: 0, Object: 2}, lc.Largest())
+
+	groupID, objects, _ := lc.snapshot()
+	assert.Equal(t, uint64(0), groupID)
+	require.Len(t, objects, 3)
+	var delta internal.Catalog
+	require.NoError(t, json.Unmarshal(objects[2], &delta))
+	require.True(t, delta.IsDelta())
+	assert.Equal(t, internal.DeltaOpRemove, delta.DeltaUpdate[0].Op)
+	assert.Nil(t, delta.Tracks)
+
+	// A new group starts with the current full catalog.
+	require.NoError(t, lc.NewGroup())
+	assert.Equal(t, moqtransport.Location{Group: 1, Object: 0}, lc.Largest())
+	_, objects, _ = lc.snapshot()
+	require.Len(t, objects, 1)
+	var full internal.Catalog
+	require.NoError(t, json.Unmarshal(objects[0], &full))
+	assert.False(t, full.IsDelta())
+	assert.Len(t, full.Tracks, 2)
+	assert.NotNil(t, full.GetTrackByName("video_clone"))
+}
+
+func TestLiveCatalogSetContentProtections(t *testing.T) {
+	cat := &internal.Catalog{
+		Version:            "draft-01",
+		Tracks:             []internal.Track{{Name: "video_400kbps_avc_eccp", Role: "video"}},
+		ContentProtections: []internal.ContentProtection{{RefID: "1", DefaultKIDs: []string{"kid0"}}},
+	}
+	lc, err := NewLiveCatalog(cat)
+	require.NoError(t, err)
+
+	// Content protections cannot change with a delta, so a new group starts.
+	cps := []internal.ContentProtection{{RefID: "1", DefaultKIDs: []string{"kid1", "kid2"}}}
+	require.NoError(t, lc.SetContentProtections(cps))
+	assert.Equal(t, moqtransport.Location{Group: 1, Object: 0}, lc.Largest())
+	_, objects, _ := lc.snapshot()
+	var full internal.Catalog
+	require.NoError(t, json.Unmarshal(objects[0], &full))
+	assert.False(t, full.IsDelta())
+	assert.Equal(t, cps, full.ContentProtections)
+	assert.Len(t, full.Tracks, 1)
+	assert.Equal(t, []string{"kid0"}, cat.ContentProtections[0].DefaultKIDs, "original catalog is unchanged")
+}
@@ -0,0 +1,180 @@
+package pub
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+)
+
+// ClearKeyPolicy configures the authorization and the negative testing
+// behavior of a ClearKeyServer.
+type ClearKeyPolicy struct {
+	// Token, if set, is the bearer token required in license requests.
+	Token string `json:"token,omitempty"`
+	// AuthzSecret is the shared secret that clients send as bearer token
+	// to get Token from the TokenHandler. Without it, Token is not served.
+	AuthzSecret string `json:"authzSecret,omitempty"`
+	// Delay is added before every license response.
+	Delay time.Duration `json:"delay,omitempty"`
+	// FailRate is the fraction of license requests that fail with FailStatus.
+	FailRate float64 `json:"failRate,omitempty"`
+	// FailStatus is the HTTP status of failing requests. Zero means 500.
+	FailStatus int `json:"failStatus,omitempty"`
+}
+
+// ClearKeyServer is a ClearKey license server (W3C EME, Section 9.1.4)
+// serving the keys of a DRMInfo. A license request for an unknown KID is
+// rejected.
+type ClearKeyServer struct {
+	drm *internal.DRMInfo
+
+	mu     sync.Mutex
+	policy ClearKeyPolicy
+}
+
+// NewClearKeyServer returns a license server for the keys of drm.
+func NewClearKeyServer(drm *internal.DRMInfo, policy ClearKeyPolicy) *ClearKeyServer {
+	return &ClearKeyServer{drm: drm, policy: policy}
+}
+
+// Policy returns the current policy.
+func (s *ClearKeyServer) Policy() ClearKeyPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy
+}
+
+// SetPolicy replaces the policy.
+func (s *ClearKeyServer) SetPolicy(policy ClearKeyPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = policy
+}
+
+// ClearKeyRequest is the body of a ClearKey license request.
+type ClearKeyRequest struct {
+	Kids []string `json:"kids"`
+	Type string   `json:"type,omitempty"`
+}
+
+// ClearKeyKey is a key in a ClearKey license, with base64url-encoded KID and key.
+type ClearKeyKey struct {
+	Kty string `json:"kty"`
+	K   string `json:"k"`
+	Kid string `json:"kid"`
+}
+
+// ClearKeyResponse is the body of a ClearKey license.
+type ClearKeyResponse struct {
+	Keys []ClearKeyKey `json:"keys"`
+	Type string        `json:"type"`
+}
+
+// ServeHTTP serves POST license requests.
+func (s *ClearKeyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	policy := s.Policy()
+	if policy.Delay > 0 {
+		select {
+		case <-time.After(policy.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if policy.Token != "" &&
+		subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+policy.Token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="clearkey"`)
+		http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+		slog.Warn("rejected ClearKey license request", "reason", "unauthorized")
+		return
+	}
+	if policy.FailRate > 0 && rand.Float64() < policy.FailRate {
+		status := policy.FailStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, "injected license failure", status)
+		slog.Warn("injected ClearKey license failure", "status", status)
+		return
+	}
+
+	var req ClearKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Kids) == 0 {
+		http.Error(w, "no kids in request", http.StatusBadRequest)
+		return
+	}
+	resp := ClearKeyResponse{Type: "temporary"}
+	for _, kid := range req.Kids {
+		// Some clients pad base64url; the EME format does not.
+		kidBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(kid, "="))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode kid %q", kid), http.StatusBadRequest)
+			return
+		}
+		var key []byte
+		ok := false
+		if s.drm != nil {
+			key, ok = s.drm.ClearKey(kidBytes)
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown kid %s", hex.EncodeToString(kidBytes)), http.StatusNotFound)
+			slog.Warn("rejected ClearKey license request", "reason", "unknown kid", "kid", hex.EncodeToString(kidBytes))
+			return
+		}
+		resp.Keys = append(resp.Keys, ClearKeyKey{
+			Kty: "oct",
+			K:   base64.RawURLEncoding.EncodeToString(key),
+			Kid: base64.RawURLEncoding.EncodeToString(kidBytes),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to encode ClearKey response", "error", err)
+		return
+	}
+	slog.Info("Served ClearKey license", "nrKeys", len(resp.Keys))
+}
+
+// TokenHandler serves the bearer token for license requests as text on GET,
+// to clients sending the AuthzSecret of the policy as bearer token. The token
+// is empty if license requests need none. Its URL is announced as the
+// authzURL of the ClearKey DRM system.
+func (s *ClearKeyServer) TokenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		policy := s.Policy()
+		if policy.AuthzSecret == "" {
+			http.Error(w, "no authorization secret configured", http.StatusForbidden)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+policy.AuthzSecret)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="clearkey"`)
+			http.Error(w, "missing or invalid authorization secret", http.StatusUnauthorized)
+			slog.Warn("rejected license token request", "reason", "unauthorized")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, policy.Token)
+	})
+}
@@ -0,0 +1,113 @@
+package pub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/stretchr/testify/require"
+)
+
+// licenseRequest posts a ClearKey license request for kids to s.
+func licenseRequest(t *testing.T, s http.Handler, token string, kids ...[]byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := ClearKeyRequest{Type: "temporary"}
+	for _, kid := range kids {
+		req.Kids = append(req.Kids, base64.RawURLEncoding.EncodeToString(kid))
+	}
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/clearkey", strings.NewReader(string(body)))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestClearKeyServer(t *testing.T) {
+	eccp, err := internal.ParseCENCflags("cbcs", "39112233445566778899aabbccddeeff",
+		"40112233445566778899aabbccddeeff", "41112233445566778899aabbccddeeff", "http://localhost/clearkey")
+	require.NoError(t, err)
+	require.NoError(t, eccp.SetKeyTiers([]string{"audio", "video"}))
+	audioKID := []byte{0x39, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	videoKID := []byte{0x39, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x78, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	_, ok := eccp.ClearKey(videoKID)
+	require.True(t, ok, "video tier KID")
+
+	s := NewClearKeyServer(eccp, ClearKeyPolicy{})
+
+	t.Run("known kids", func(t *testing.T) {
+		w := licenseRequest(t, s, "", audioKID, videoKID)
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp ClearKeyResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Equal(t, "temporary", resp.Type)
+		require.Len(t, resp.Keys, 2)
+		for i, kid := range [][]byte{audioKID, videoKID} {
+			wantKey, _ := eccp.ClearKey(kid)
+			require.Equal(t, "oct", resp.Keys[i].Kty)
+			require.Equal(t, base64.RawURLEncoding.EncodeToString(kid), resp.Keys[i].Kid)
+			require.Equal(t, base64.RawURLEncoding.EncodeToString(wantKey), resp.Keys[i].K)
+			require.NotEqual(t, resp.Keys[i].Kid, resp.Keys[i].K, "key is not the KID")
+		}
+	})
+	t.Run("unknown kid", func(t *testing.T) {
+		unknown := make([]byte, 16)
+		w := licenseRequest(t, s, "", audioKID, unknown)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("bad request", func(t *testing.T) {
+		w := licenseRequest(t, s, "")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		r := httptest.NewRequest(http.MethodGet, "/clearkey", nil)
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+	t.Run("token", func(t *testing.T) {
+		s.SetPolicy(ClearKeyPolicy{Token: "secret"})
+		defer s.SetPolicy(ClearKeyPolicy{})
+		require.Equal(t, http.StatusUnauthorized, licenseRequest(t, s, "", audioKID).Code)
+		require.Equal(t, http.StatusUnauthorized, licenseRequest(t, s, "wrong", audioKID).Code)
+		require.Equal(t, http.StatusOK, licenseRequest(t, s, "secret", audioKID).Code)
+
+		// The token is only served to clients knowing the shared secret.
+		tokenRequest := func(auth string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "/clearkey/token", nil)
+			if auth != "" {
+				r.Header.Set("Authorization", "Bearer "+auth)
+			}
+			w := httptest.NewRecorder()
+			s.TokenHandler().ServeHTTP(w, r)
+			return w
+		}
+		require.Equal(t, http.StatusForbidden, tokenRequest("").Code, "no shared secret configured")
+		s.SetPolicy(ClearKeyPolicy{Token: "secret", AuthzSecret: "shared"})
+		require.Equal(t, http.StatusUnauthorized, tokenRequest("").Code)
+		require.Equal(t, http.StatusUnauthorized, tokenRequest("secret").Code)
+		w := tokenRequest("shared")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "secret", w.Body.String())
+	})
+	t.Run("failure", func(t *testing.T) {
+		s.SetPolicy(ClearKeyPolicy{FailRate: 1, FailStatus: http.StatusServiceUnavailable})
+		defer s.SetPolicy(ClearKeyPolicy{})
+		require.Equal(t, http.StatusServiceUnavailable, licenseRequest(t, s, "", audioKID).Code)
+		s.SetPolicy(ClearKeyPolicy{FailRate: 1})
+		require.Equal(t, http.StatusInternalServerError, licenseRequest(t, s, "", audioKID).Code)
+	})
+	t.Run("delay", func(t *testing.T) {
+		s.SetPolicy(ClearKeyPolicy{Delay: 50 * time.Millisecond})
+		defer s.SetPolicy(ClearKeyPolicy{})
+		start := time.Now()
+		require.Equal(t, http.StatusOK, licenseRequest(t, s, "", audioKID).Code)
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+}
@@ -0,0 +1,140 @@
+package pub
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Eyevinn/moqtransport"
+)
+
+// maxDatagramPayload is the largest object payload sent as an
+// OBJECT_DATAGRAM. It leaves room for the object header and the QUIC and
+// WebTransport overhead within the minimum QUIC datagram size.
+const maxDatagramPayload = 1100
+
+// DatagramTracks selects the media tracks whose objects are sent as
+// OBJECT_DATAGRAMs instead of on subgroup streams. It is meant for tracks
+// with small objects, such as single-frame audio and subtitles.
+type DatagramTracks struct {
+	Audio     bool            // all audio tracks
+	Subtitles bool            // all subtitle tracks
+	Tracks    map[string]bool // tracks by name
+}
+
+// ParseDatagramTracks parses a comma-separated list of "audio",
+// "subtitles" and track names, such as
+//
+//	audio,subtitles,video_400kbps_avc
+//
+// It returns nil for an empty list.
+func ParseDatagramTracks(spec string) (*DatagramTracks, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var dt DatagramTracks
+	for _, part := range strings.Split(spec, ",") {
+		switch name := strings.TrimSpace(part); name {
+		case "":
+			return nil, fmt.Errorf("datagram tracks %q: empty track name", spec)
+		case "audio":
+			dt.Audio = true
+		case "subtitles":
+			dt.Subtitles = true
+		default:
+			if dt.Tracks == nil {
+				dt.Tracks = make(map[string]bool)
+			}
+			dt.Tracks[name] = true
+		}
+	}
+	return &dt, nil
+}
+
+// String returns the selection in the format of ParseDatagramTracks.
+func (dt *DatagramTracks) String() string {
+	names := slices.Sorted(maps.Keys(dt.Tracks))
+	if dt.Subtitles {
+		names = append([]string{"subtitles"}, names...)
+	}
+	if dt.Audio {
+		names = append([]string{"audio"}, names...)
+	}
+	return strings.Join(names, ",")
+}
+
+// selects reports whether the track trackName with content type
+// contentType ("audio", "video" or "subtitle") is sent as datagrams.
+func (dt *DatagramTracks) selects(trackName, contentType string) bool {
+	if dt == nil {
+		return false
+	}
+	switch {
+	case dt.Tracks[trackName]:
+		return true
+	case contentType == "audio":
+		return dt.Audio
+	case contentType == "subtitle":
+		return dt.Subtitles
+	}
+	return false
+}
+
+// datagramPublisher is a Publisher whose media groups are sent as
+// OBJECT_DATAGRAMs. Datagrams that arrive before the SUBSCRIBE_OK with their
+// track alias are dropped by the subscriber. Faults are not injected into
+// datagrams.
+type datagramPublisher struct {
+	moqtransport.Publisher
+}
+
+// openGroup opens a group sent as datagrams.
+func (p *datagramPublisher) openGroup(groupNr uint64) (groupWriter, error) {
+	return &datagramGroup{p: p, groupNr: groupNr}, nil
+}
+
+// datagramGroup sends the objects of a group as datagrams. Objects larger
+// than maxDatagramPayload are sent on a subgroup stream of the group, which
+// is opened on the first such object.
+type datagramGroup struct {
+	p       *datagramPublisher
+	groupNr uint64
+	sg      *moqtransport.Subgroup
+}
+
+func (g *datagramGroup) WriteObject(objectID uint64, payload []byte) (int, error) {
+	return g.WriteObjectWithHeaders(objectID, nil, payload)
+}
+
+func (g *datagramGroup) WriteObjectWithHeaders(objectID uint64, headers moqtransport.KVPList,
+	payload []byte) (int, error) {
+	if len(payload) > maxDatagramPayload {
+		if g.sg == nil {
+			sg, err := g.p.Publisher.OpenSubgroup(g.groupNr, 0, MediaPriority)
+			if err != nil {
+				return 0, err
+			}
+			g.sg = sg
+		}
+		return g.sg.WriteObjectWithHeaders(objectID, headers, payload)
+	}
+	err := g.p.SendDatagram(moqtransport.Object{
+		GroupID:              g.groupNr,
+		ObjectID:             objectID,
+		ForwardingPreference: moqtransport.ObjectForwardingPreferenceDatagram,
+		ExtensionHeaders:     headers,
+		Payload:              payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+func (g *datagramGroup) Close() error {
+	if g.sg == nil {
+		return nil
+	}
+	return g.sg.Close()
+}
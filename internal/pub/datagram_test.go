@@ -0,0 +1,48 @@
+package pub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDatagramTracks(t *testing.T) {
+	dt, err := ParseDatagramTracks("")
+	require.NoError(t, err)
+	assert.Nil(t, dt, "empty spec disables datagrams")
+	assert.False(t, dt.selects("audio_monotonic_128kbps_aac", "audio"))
+
+	dt, err = ParseDatagramTracks("subtitles, video_400kbps_avc,audio")
+	require.NoError(t, err)
+	assert.Equal(t, &DatagramTracks{
+		Audio:     true,
+		Subtitles: true,
+		Tracks:    map[string]bool{"video_400kbps_avc": true},
+	}, dt)
+	assert.Equal(t, "audio,subtitles,video_400kbps_avc", dt.String())
+	again, err := ParseDatagramTracks(dt.String())
+	require.NoError(t, err)
+	assert.Equal(t, dt, again, "String should round-trip")
+
+	_, err = ParseDatagramTracks("audio,,subtitles")
+	assert.Error(t, err)
+
+	dt, err = ParseDatagramTracks("audio0,subs_wvtt_sv")
+	require.NoError(t, err)
+	for _, c := range []struct {
+		track, contentType string
+		want               bool
+	}{
+		{"audio0", "audio", true},
+		{"audio_monotonic_128kbps_aac", "audio", false},
+		{"subs_wvtt_sv", "subtitle", true},
+		{"subs_stpp_en", "subtitle", false},
+		{"video_400kbps_avc", "video", false},
+	} {
+		assert.Equal(t, c.want, dt.selects(c.track, c.contentType), c.track)
+	}
+	dt.Audio = true
+	assert.True(t, dt.selects("audio_monotonic_128kbps_aac", "audio"))
+	assert.False(t, dt.selects("video_400kbps_avc", "video"))
+}
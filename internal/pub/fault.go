@@ -0,0 +1,323 @@
+package pub
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Eyevinn/moqtransport"
+)
+
+// faultResetCode is the application error code of subgroup streams reset by
+// fault injection.
+const faultResetCode = 0x1
+
+// FaultConfig configures fault injection in the publisher, to emulate a bad
+// network for subscribers and relays. All random decisions are derived from
+// Seed, the track name and the object location, so a run with the same
+// configuration and subscriptions injects the same faults.
+type FaultConfig struct {
+	// Seed seeds all random decisions.
+	Seed uint64
+	// DropObject is the probability that an object is dropped.
+	DropObject float64
+	// DropGroup is the probability that a whole group is dropped.
+	DropGroup float64
+	// DelayObject is the probability that an object is delayed by Delay.
+	// Later objects of the track are held back behind it.
+	DelayObject float64
+	Delay       time.Duration
+	// ResetStream is the probability that the subgroup stream of a group is
+	// reset in the middle of the group.
+	ResetStream float64
+	// EndGroup is the probability that a group ends early in the middle of
+	// the group, with a subgroup stream that is marked as containing the end
+	// of the group and closed after the last sent object.
+	EndGroup float64
+	// StallEvery and StallFor stall every track for StallFor once every
+	// StallEvery. Objects are held back during a stall and sent in a burst
+	// afterwards.
+	StallEvery time.Duration
+	StallFor   time.Duration
+}
+
+// ParseFaultConfig parses a comma-separated fault specification such as
+//
+//	seed=42,drop=0.01,dropgroup=0.02,delay=0.05:300ms,reset=0.02,endgroup=0.02,stall=30s:2s
+//
+// It returns nil for an empty specification.
+func ParseFaultConfig(spec string) (*FaultConfig, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var fc FaultConfig
+	for _, part := range strings.Split(spec, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("fault %q: missing value", part)
+		}
+		var err error
+		switch key {
+		case "seed":
+			fc.Seed, err = strconv.ParseUint(val, 10, 64)
+		case "drop":
+			fc.DropObject, err = parseProbability(val)
+		case "dropgroup":
+			fc.DropGroup, err = parseProbability(val)
+		case "delay":
+			p, d, found := strings.Cut(val, ":")
+			if !found {
+				return nil, fmt.Errorf("fault %q: want delay=<probability>:<duration>", part)
+			}
+			if fc.DelayObject, err = parseProbability(p); err == nil {
+				fc.Delay, err = time.ParseDuration(d)
+			}
+		case "reset":
+			fc.ResetStream, err = parseProbability(val)
+		case "endgroup":
+			fc.EndGroup, err = parseProbability(val)
+		case "stall":
+			every, dur, found := strings.Cut(val, ":")
+			if !found {
+				return nil, fmt.Errorf("fault %q: want stall=<interval>:<duration>", part)
+			}
+			if fc.StallEvery, err = time.ParseDuration(every); err == nil {
+				fc.StallFor, err = time.ParseDuration(dur)
+			}
+			if err == nil && fc.StallFor >= fc.StallEvery {
+				err = fmt.Errorf("stall duration must be shorter than interval")
+			}
+		default:
+			return nil, fmt.Errorf("unknown fault %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fault %q: %w", part, err)
+		}
+	}
+	return &fc, nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("probability %v not in [0, 1]", p)
+	}
+	return p, nil
+}
+
+// String returns the configuration in the format of ParseFaultConfig.
+func (fc *FaultConfig) String() string {
+	parts := []string{fmt.Sprintf("seed=%d", fc.Seed)}
+	if fc.DropObject > 0 {
+		parts = append(parts, fmt.Sprintf("drop=%g", fc.DropObject))
+	}
+	if fc.DropGroup > 0 {
+		parts = append(parts, fmt.Sprintf("dropgroup=%g", fc.DropGroup))
+	}
+	if fc.DelayObject > 0 {
+		parts = append(parts, fmt.Sprintf("delay=%g:%s", fc.DelayObject, fc.Delay))
+	}
+	if fc.ResetStream > 0 {
+		parts = append(parts, fmt.Sprintf("reset=%g", fc.ResetStream))
+	}
+	if fc.EndGroup > 0 {
+		parts = append(parts, fmt.Sprintf("endgroup=%g", fc.EndGroup))
+	}
+	if fc.StallEvery > 0 {
+		parts = append(parts, fmt.Sprintf("stall=%s:%s", fc.StallEvery, fc.StallFor))
+	}
+	return strings.Join(parts, ",")
+}
+
+// random returns a number in [0, 1) that is uniquely determined by the seed,
+// the kind of decision, the track and the location.
+func (fc *FaultConfig) random(kind, trackName string, groupNr, objectID uint64) float64 {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], fc.Seed)
+	_, _ = h.Write(b[:])
+	_, _ = h.Write([]byte(kind))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(trackName))
+	binary.BigEndian.PutUint64(b[:], groupNr)
+	_, _ = h.Write(b[:])
+	binary.BigEndian.PutUint64(b[:], objectID)
+	_, _ = h.Write(b[:])
+	// splitmix64 finalizer, since FNV mixes the last bytes poorly.
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// cutPoint returns the number of objects, between 1 and nrObjects-1, that a
+// group of nrObjects objects is cut after for a fault of the given kind, or 0
+// if the group is not cut.
+func (fc *FaultConfig) cutPoint(kind string, prob float64, trackName string, groupNr uint64, nrObjects int) uint64 {
+	if prob == 0 || nrObjects < 2 || fc.random(kind, trackName, groupNr, 0) >= prob {
+		return 0
+	}
+	return 1 + uint64(fc.random(kind+"-at", trackName, groupNr, 0)*float64(nrObjects-1))
+}
+
+// stallEnd returns the end of the stall of the track that now is in, or the
+// zero time if the track is not stalled. Every track stalls at its own
+// offset within StallEvery.
+func (fc *FaultConfig) stallEnd(trackName string, now time.Time) time.Time {
+	if fc.StallEvery <= 0 || fc.StallFor <= 0 {
+		return time.Time{}
+	}
+	every := fc.StallEvery.Milliseconds()
+	offset := int64(fc.random("stall", trackName, 0, 0) * float64(every))
+	pos := (now.UnixMilli() - offset) % every
+	if pos < 0 {
+		pos += every
+	}
+	if pos >= fc.StallFor.Milliseconds() {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(fc.StallFor.Milliseconds()-pos) * time.Millisecond)
+}
+
+// groupWriter writes the objects of a group. It is implemented by
+// moqtransport.Subgroup, faultSubgroup and datagramGroup.
+type groupWriter interface {
+	WriteObject(objectID uint64, payload []byte) (int, error)
+	WriteObjectWithHeaders(objectID uint64, headers moqtransport.KVPList, payload []byte) (int, error)
+	Close() error
+}
+
+// openGroup opens subgroup 0 of group groupNr with nrObjects objects of a
+// track, where startMS is the wall-clock start time of the group. The group
+// is metered if publisher is a meteredPublisher, and faults are injected
+// into it if publisher is or wraps a faultPublisher. It is sent as datagrams
+// if publisher is or wraps a datagramPublisher. The objects are recorded if
+// publisher is a subscription.
+func openGroup(publisher moqtransport.Publisher, trackName string, groupNr uint64, nrObjects int,
+	startMS int64) (groupWriter, error) {
+	if s, ok := publisher.(*subscription); ok {
+		return s.openGroup(trackName, groupNr, nrObjects, startMS)
+	}
+	if mp, ok := publisher.(*meteredPublisher); ok {
+		return mp.openGroup(trackName, groupNr, nrObjects, startMS)
+	}
+	if fp, ok := publisher.(*faultPublisher); ok {
+		return fp.openGroup(trackName, groupNr, nrObjects)
+	}
+	if dp, ok := publisher.(*datagramPublisher); ok {
+		return dp.openGroup(groupNr)
+	}
+	return publisher.OpenSubgroup(groupNr, 0, MediaPriority)
+}
+
+// faultPublisher is a Publisher whose media groups get faults injected.
+// The fault configuration is read when each group is opened, so that it
+// can be changed while publishing, and only groups opened while there is
+// one are wrapped. Stalls and delays hold back the whole track, and are
+// applied by its trackFeed.
+type faultPublisher struct {
+	moqtransport.Publisher
+	faults func() *FaultConfig
+}
+
+// openGroup opens a group with the current faults, or a plain subgroup if
+// there are none.
+func (p *faultPublisher) openGroup(trackName string, groupNr uint64, nrObjects int) (groupWriter, error) {
+	cfg := p.faults()
+	if cfg == nil {
+		return p.Publisher.OpenSubgroup(groupNr, 0, MediaPriority)
+	}
+	fs := &faultSubgroup{p: p, cfg: cfg, trackName: trackName, groupNr: groupNr}
+	if cfg.DropGroup > 0 && cfg.random("dropgroup", trackName, groupNr, 0) < cfg.DropGroup {
+		slog.Info("fault: dropping group", "track", trackName, "group", groupNr)
+		fs.dropped = true
+		return fs, nil
+	}
+	fs.resetAfter = cfg.cutPoint("reset", cfg.ResetStream, trackName, groupNr, nrObjects)
+	if fs.resetAfter == 0 {
+		fs.endAfter = cfg.cutPoint("endgroup", cfg.EndGroup, trackName, groupNr, nrObjects)
+	}
+	return fs, nil
+}
+
+// faultSubgroup is a group with faults injected. Subgroup 0 is opened on
+// the first object that is not dropped, so a dropped group opens no stream.
+// A group that ends early is opened as containing the end of the group and
+// closed after its last object. Dropped objects are reported as written with
+// 0 bytes.
+type faultSubgroup struct {
+	p          *faultPublisher
+	cfg        *FaultConfig
+	trackName  string
+	groupNr    uint64
+	sg         *moqtransport.Subgroup
+	dropped    bool   // the rest of the group is not sent
+	closed     bool   // the stream has been reset or closed
+	resetAfter uint64 // reset the stream after this many objects if > 0
+	endAfter   uint64 // end the group after this many objects if > 0
+	written    uint64
+}
+
+func (fs *faultSubgroup) WriteObject(objectID uint64, payload []byte) (int, error) {
+	return fs.WriteObjectWithHeaders(objectID, nil, payload)
+}
+
+func (fs *faultSubgroup) WriteObjectWithHeaders(objectID uint64, headers moqtransport.KVPList,
+	payload []byte) (int, error) {
+	if fs.dropped {
+		return 0, nil
+	}
+	cfg := fs.cfg
+	if cfg.DropObject > 0 && cfg.random("drop", fs.trackName, fs.groupNr, objectID) < cfg.DropObject {
+		slog.Info("fault: dropping object", "track", fs.trackName, "group", fs.groupNr, "object", objectID)
+		return 0, nil
+	}
+	if fs.sg == nil {
+		var opts []moqtransport.SubgroupOption
+		if fs.endAfter > 0 {
+			opts = append(opts, moqtransport.WithEndOfGroup())
+		}
+		sg, err := fs.p.Publisher.OpenSubgroup(fs.groupNr, 0, MediaPriority, opts...)
+		if err != nil {
+			return 0, err
+		}
+		fs.sg = sg
+	}
+	n, err := fs.sg.WriteObjectWithHeaders(objectID, headers, payload)
+	if err != nil {
+		return n, err
+	}
+	fs.written++
+	switch {
+	case fs.written == fs.resetAfter:
+		slog.Info("fault: resetting subgroup stream", "track", fs.trackName, "group", fs.groupNr,
+			"afterObject", objectID)
+		fs.sg.Reset(faultResetCode)
+		fs.dropped, fs.closed = true, true
+	case fs.written == fs.endAfter:
+		slog.Info("fault: ending group early", "track", fs.trackName, "group", fs.groupNr,
+			"afterObject", objectID)
+		fs.dropped, fs.closed = true, true
+		if err := fs.sg.Close(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close closes the subgroup stream unless it was already closed or reset,
+// or never opened.
+func (fs *faultSubgroup) Close() error {
+	if fs.sg == nil || fs.closed {
+		return nil
+	}
+	return fs.sg.Close()
+}
@@ -0,0 +1,97 @@
+package pub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFaultConfig(t *testing.T) {
+	fc, err := ParseFaultConfig("")
+	require.NoError(t, err)
+	assert.Nil(t, fc, "empty spec disables faults")
+
+	spec := "seed=42,drop=0.01,dropgroup=0.02,delay=0.05:300ms,reset=0.03,endgroup=0.04,stall=30s:2s"
+	fc, err = ParseFaultConfig(spec)
+	require.NoError(t, err)
+	assert.Equal(t, &FaultConfig{
+		Seed:        42,
+		DropObject:  0.01,
+		DropGroup:   0.02,
+		DelayObject: 0.05,
+		Delay:       300 * time.Millisecond,
+		ResetStream: 0.03,
+		EndGroup:    0.04,
+		StallEvery:  30 * time.Second,
+		StallFor:    2 * time.Second,
+	}, fc)
+	again, err := ParseFaultConfig(fc.String())
+	require.NoError(t, err)
+	assert.Equal(t, fc, again, "String should round-trip")
+
+	for _, bad := range []string{
+		"drop",
+		"drop=1.5",
+		"drop=x",
+		"delay=0.1",
+		"stall=2s:30s",
+		"seed=-1",
+		"jitter=0.1",
+	} {
+		_, err := ParseFaultConfig(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestFaultRandom(t *testing.T) {
+	fc := &FaultConfig{Seed: 7}
+	assert.Equal(t, fc.random("drop", "video", 10, 3), fc.random("drop", "video", 10, 3), "decisions are repeatable")
+	assert.NotEqual(t, fc.random("drop", "video", 10, 3), fc.random("drop", "video", 10, 4))
+	assert.NotEqual(t, fc.random("drop", "video", 10, 3), fc.random("drop", "audio", 10, 3))
+	assert.NotEqual(t, fc.random("drop", "video", 10, 3), (&FaultConfig{Seed: 8}).random("drop", "video", 10, 3))
+
+	// Consecutive locations give the configured probability.
+	const n = 10000
+	hits := 0
+	for nr := range uint64(n) {
+		r := fc.random("drop", "video", 1745255189, nr)
+		require.GreaterOrEqual(t, r, 0.0)
+		require.Less(t, r, 1.0)
+		if r < 0.1 {
+			hits++
+		}
+	}
+	assert.InDelta(t, 0.1, float64(hits)/n, 0.02)
+}
+
+func TestFaultCutPoint(t *testing.T) {
+	fc := &FaultConfig{Seed: 7}
+	assert.Equal(t, uint64(0), fc.cutPoint("reset", 0, "video", 10, 25), "zero probability never cuts")
+	assert.Equal(t, uint64(0), fc.cutPoint("reset", 1, "video", 10, 1), "single-object groups are never cut")
+	for groupNr := range uint64(100) {
+		cut := fc.cutPoint("reset", 1, "video", groupNr, 25)
+		assert.GreaterOrEqual(t, cut, uint64(1))
+		assert.LessOrEqual(t, cut, uint64(24))
+	}
+}
+
+func TestFaultStallEnd(t *testing.T) {
+	fc := &FaultConfig{Seed: 7, StallEvery: 10 * time.Second, StallFor: 2 * time.Second}
+	start := time.UnixMilli(1745255180_000)
+	stalled := 0
+	for ms := int64(0); ms < 10_000; ms += 100 {
+		now := start.Add(time.Duration(ms) * time.Millisecond)
+		end := fc.stallEnd("video", now)
+		if end.IsZero() {
+			continue
+		}
+		stalled++
+		assert.True(t, end.After(now))
+		assert.LessOrEqual(t, end.Sub(now), fc.StallFor)
+		assert.True(t, fc.stallEnd("video", end).IsZero(), "the stall is over at its end")
+	}
+	assert.Equal(t, 20, stalled, "stalled for 2s out of every 10s")
+	assert.True(t, (&FaultConfig{}).stallEnd("video", start).IsZero())
+}
@@ -0,0 +1,451 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/Eyevinn/moqtransport"
+)
+
+// subscriptionQueue is the number of objects queued for the writer of a
+// subscription, a few groups of video. A subscription that falls further
+// behind has the rest of its current group cut off, see feedSubscription.
+const subscriptionQueue = 128
+
+// trackFeed publishes the objects of a media track to all subscriptions of
+// the track. Its goroutine is woken by the Scheduler when the next object is
+// due, generates the group of the object once, and then queues the due
+// objects for the writer goroutine of every subscription, so a track takes
+// one pending tick however many subscriptions it has. The goroutine never
+// waits for a writer, so a subscriber that does not read does not hold back
+// the others. It runs while the track has subscriptions.
+type trackFeed struct {
+	s    *Scheduler
+	key  string // key in Scheduler.feeds, or empty for a feed of its own
+	name string // name of the track in logs and fault decisions
+	src  mediaSource
+	// faults returns the faults that stall the track and delay its objects,
+	// if set.
+	faults func() *FaultConfig
+	// lc ends the subscriptions when the track is removed from it, if set.
+	lc *LiveCatalog
+
+	subs []*feedSubscription // guarded by Scheduler.feedMu
+	wake chan struct{}       // signals added and ended subscriptions
+
+	// The fields below are owned by the goroutine.
+	next   moqtransport.Location // first object that is not yet due
+	groups map[uint64]*feedGroup // groups by number, from the earliest one waited for
+}
+
+// feedGroup is a group of a trackFeed. Its objects are generated when the
+// first of them is due for a subscription.
+type feedGroup struct {
+	times   []int64 // wall-clock times of the objects in milliseconds
+	objects []mediaObject
+	err     error // error generating the objects
+}
+
+// feedSubscription is a subscription published by a trackFeed. Its objects
+// are written by a goroutine of its own from a queue of subscriptionQueue
+// objects. When the queue is full, the feed cuts off the group of the
+// object: the writer closes the group after the objects it has written, drops
+// the queued objects up to the end of the group, and the feed continues with
+// the next group.
+type feedSubscription struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc // ends the subscription with a cause
+	p      moqtransport.Publisher
+	rng    SubscribeRange
+	// stopped is called by the writer goroutine after it has stopped and
+	// closed its open group, since the context is done, the range has been
+	// published or publishing failed. It must not block.
+	stopped func()
+
+	queue  chan feedObject // closed by the feed when it removes the subscription
+	exited chan struct{}   // closed by the writer goroutine when it stops
+	// skipBelow is the group from which the queued objects are written. The
+	// earlier groups have been cut off.
+	skipBelow atomic.Uint64
+
+	// The fields below are owned by the goroutine of the feed.
+	next     moqtransport.Location // next object to queue
+	done     bool                  // removed from the feed
+	rangeEnd bool                  // the range has been queued, set before queue is closed
+}
+
+// feedObject is an object queued for the writer of a subscription.
+type feedObject struct {
+	loc moqtransport.Location
+	g   *feedGroup
+}
+
+// publish publishes src to sub from sub.rng.Start, paced to wall-clock
+// time, until the context of sub is done or its range has been published.
+// It starts the writer goroutine of sub.
+// Subscriptions with the same non-empty key share a trackFeed named name,
+// and must then be published the same objects with the same faults and
+// lc. It does not block.
+func (s *Scheduler) publish(key, name string, src mediaSource, faults func() *FaultConfig, lc *LiveCatalog,
+	sub *feedSubscription) {
+	sub.next = sub.rng.Start
+	sub.queue = make(chan feedObject, subscriptionQueue)
+	sub.exited = make(chan struct{})
+	s.feedMu.Lock()
+	defer s.feedMu.Unlock()
+	f := s.feeds[key]
+	if f == nil || key == "" {
+		f = &trackFeed{s: s, key: key, name: name, src: src, faults: faults, lc: lc,
+			wake: make(chan struct{}, 1), groups: make(map[uint64]*feedGroup)}
+		if key != "" {
+			s.feeds[key] = f
+		}
+		go f.run()
+	}
+	f.subs = append(f.subs, sub)
+	f.signal()
+	context.AfterFunc(sub.ctx, f.signal)
+	go f.write(sub)
+}
+
+// signal wakes the goroutine of f up.
+func (f *trackFeed) signal() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run publishes the due objects to the subscriptions of f, until it has
+// none left.
+func (f *trackFeed) run() {
+	slog.Info("publishing track", "track", f.name)
+	for {
+		subs := f.subscriptions()
+		if len(subs) == 0 {
+			slog.Info("stopped publishing track", "track", f.name)
+			return
+		}
+		var changed <-chan struct{}
+		if f.lc != nil {
+			var exists bool
+			exists, changed = f.lc.hasTrack(f.name)
+			if !exists {
+				slog.Info("subscribed track removed from catalog", "track", f.name)
+				for _, sub := range subs {
+					sub.cancel(errTrackEnded)
+				}
+				continue
+			}
+		}
+		f.release(subs)
+		f.deliver(subs)
+
+		t := f.s.at(f.group(f.next.Group).times[f.next.Object])
+		if t == nil {
+			continue // due while delivering
+		}
+		select {
+		case <-t.ch:
+		case <-f.wake:
+			f.s.cancel(t)
+		case <-changed:
+			f.s.cancel(t)
+		}
+	}
+}
+
+// subscriptions removes the subscriptions whose context is done or whose
+// writer has stopped, and returns the others. f is removed from the
+// Scheduler if there are none.
+func (f *trackFeed) subscriptions() []*feedSubscription {
+	f.s.feedMu.Lock()
+	var ended []*feedSubscription
+	for _, sub := range f.subs {
+		if sub.stopping() {
+			ended = append(ended, sub)
+		}
+	}
+	subs := slices.Clone(f.subs)
+	if len(subs) == len(ended) && f.key != "" {
+		delete(f.s.feeds, f.key)
+	}
+	f.s.feedMu.Unlock()
+	for _, sub := range ended {
+		f.remove(sub)
+	}
+	return slices.DeleteFunc(subs, func(sub *feedSubscription) bool { return sub.done })
+}
+
+// stopping reports whether the context of sub is done or its writer has
+// stopped.
+func (sub *feedSubscription) stopping() bool {
+	select {
+	case <-sub.exited:
+		return true
+	default:
+		return sub.ctx.Err() != nil
+	}
+}
+
+// remove removes sub from f, and lets its writer stop after the queued
+// objects.
+func (f *trackFeed) remove(sub *feedSubscription) {
+	f.s.feedMu.Lock()
+	f.subs = slices.DeleteFunc(f.subs, func(s *feedSubscription) bool { return s == sub })
+	f.s.feedMu.Unlock()
+	sub.done = true
+	close(sub.queue)
+}
+
+// release moves f.next past the objects that are due, skipping ahead to the
+// first object that a subscription waits for, and forgets the groups before
+// it.
+func (f *trackFeed) release(subs []*feedSubscription) {
+	first := f.normalize(subs[0].next)
+	for _, sub := range subs {
+		sub.next = f.normalize(sub.next)
+		if LocationLess(sub.next, first) {
+			first = sub.next
+		}
+	}
+	if LocationLess(f.next, first) {
+		f.next = first
+	}
+	for groupNr := range f.groups {
+		if groupNr < first.Group {
+			delete(f.groups, groupNr)
+		}
+	}
+	nowMS := time.Now().UnixMilli()
+	for {
+		f.next = f.normalize(f.next)
+		if f.group(f.next.Group).times[f.next.Object] > nowMS {
+			return
+		}
+		f.next.Object++
+	}
+}
+
+// normalize returns loc, or the first object of the next group with
+// objects if loc is past the end of its group.
+func (f *trackFeed) normalize(loc moqtransport.Location) moqtransport.Location {
+	for loc.Object >= uint64(len(f.group(loc.Group).times)) {
+		loc = moqtransport.Location{Group: loc.Group + 1}
+	}
+	return loc
+}
+
+// group returns group groupNr of f.
+func (f *trackFeed) group(groupNr uint64) *feedGroup {
+	g, ok := f.groups[groupNr]
+	if !ok {
+		g = &feedGroup{times: f.src.objectTimesMS(groupNr)}
+		f.groups[groupNr] = g
+	}
+	return g
+}
+
+// deliver queues the due objects for the subscriptions, in the order of
+// their locations. Each object is held back by the faults of the track
+// once, and then queued for all subscriptions waiting for it.
+func (f *trackFeed) deliver(subs []*feedSubscription) {
+	for {
+		var loc moqtransport.Location
+		found := false
+		for _, sub := range subs {
+			if !sub.done && LocationLess(sub.next, f.next) && (!found || LocationLess(sub.next, loc)) {
+				loc, found = sub.next, true
+			}
+		}
+		if !found {
+			return
+		}
+		f.holdBack(loc)
+		g := f.generate(loc.Group)
+		for _, sub := range subs {
+			if !sub.done && sub.next == loc {
+				f.queue(sub, loc, g)
+			}
+		}
+	}
+}
+
+// holdBack stalls the track and delays the object at loc as configured by
+// the faults of the track.
+func (f *trackFeed) holdBack(loc moqtransport.Location) {
+	if f.faults == nil {
+		return
+	}
+	cfg := f.faults()
+	if cfg == nil {
+		return
+	}
+	if end := cfg.stallEnd(f.name, time.Now()); !end.IsZero() {
+		slog.Info("fault: stalling track", "track", f.name, "group", loc.Group, "object", loc.Object,
+			"until", end)
+		f.sleep(time.Until(end))
+	}
+	if cfg.DelayObject > 0 && cfg.random("delay", f.name, loc.Group, loc.Object) < cfg.DelayObject {
+		slog.Info("fault: delaying object", "track", f.name, "group", loc.Group, "object", loc.Object,
+			"delay", cfg.Delay)
+		f.sleep(cfg.Delay)
+	}
+}
+
+// sleep sleeps for d, or until the contexts of all subscriptions of f are
+// done.
+func (f *trackFeed) sleep(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	// Keep the signals for run, which looks for new subscriptions.
+	woken := false
+	defer func() {
+		if woken {
+			f.signal()
+		}
+	}()
+	for {
+		select {
+		case <-t.C:
+			return
+		case <-f.wake:
+			woken = true
+			if f.ended() {
+				return
+			}
+		}
+	}
+}
+
+// ended reports whether the contexts of all subscriptions of f are done.
+func (f *trackFeed) ended() bool {
+	f.s.feedMu.Lock()
+	defer f.s.feedMu.Unlock()
+	return !slices.ContainsFunc(f.subs, func(sub *feedSubscription) bool { return sub.ctx.Err() == nil })
+}
+
+// generate returns group groupNr of f with its objects, which are
+// generated the first time.
+func (f *trackFeed) generate(groupNr uint64) *feedGroup {
+	g := f.group(groupNr)
+	if g.objects == nil && g.err == nil {
+		g.objects, g.err = f.src.genGroup(groupNr)
+		if g.err == nil && len(g.objects) != len(g.times) {
+			g.err = fmt.Errorf("%d objects with %d object times", len(g.objects), len(g.times))
+		}
+		if g.err != nil {
+			slog.Error("failed to generate MoQ group", "track", f.name, "group", groupNr, "error", g.err)
+		}
+	}
+	return g
+}
+
+// queue queues the object at loc of group g for sub, which waits for it,
+// and moves sub on to the next object. If the queue of sub is full, the
+// rest of the group is cut off. sub is removed when it is stopping, its
+// range has been queued or the group could not be generated.
+func (f *trackFeed) queue(sub *feedSubscription, loc moqtransport.Location, g *feedGroup) {
+	if sub.stopping() || g.err != nil {
+		f.remove(sub)
+		return
+	}
+	select {
+	case sub.queue <- feedObject{loc: loc, g: g}:
+		sub.next.Object++
+		if sub.next.Object < uint64(len(g.objects)) {
+			return
+		}
+	default:
+		slog.Warn("subscriber too slow, cutting off group", "track", f.name, "group", loc.Group,
+			"object", loc.Object)
+		sub.skipBelow.Store(loc.Group + 1)
+	}
+	sub.next = f.normalize(moqtransport.Location{Group: loc.Group + 1})
+	if sub.rng.pastEnd(sub.next.Group) {
+		sub.rangeEnd = true
+		f.remove(sub)
+	}
+}
+
+// write writes the objects queued for sub until its context is done, the
+// queue is closed or writing fails. The group of an object is opened with
+// its first written object and closed after its last one, or when the
+// group is cut off. It ends a bounded subscription whose range has been
+// written with PUBLISH_DONE, and then calls sub.stopped.
+func (f *trackFeed) write(sub *feedSubscription) {
+	var sg groupWriter // open group
+	var sgGroup uint64 // number of the open group
+	closeGroup := func() error {
+		if sg == nil {
+			return nil
+		}
+		err := sg.Close()
+		sg = nil
+		return err
+	}
+	defer func() {
+		_ = closeGroup()
+		close(sub.exited)
+		f.signal()
+		sub.stopped()
+	}()
+	for {
+		var o feedObject
+		var ok bool
+		select {
+		case <-sub.ctx.Done():
+			return
+		case o, ok = <-sub.queue:
+		}
+		if !ok {
+			if sub.rangeEnd {
+				_ = closeGroup()
+				endSubscription(sub.p, f.name, sub.rng)
+			}
+			return
+		}
+		if sub.ctx.Err() != nil {
+			return
+		}
+		skipBelow := sub.skipBelow.Load()
+		if sg != nil && sgGroup < skipBelow {
+			slog.Debug("closing cut off group", "track", f.name, "group", sgGroup)
+			if err := closeGroup(); err != nil {
+				slog.Error("failed to close subgroup", "track", f.name, "group", sgGroup, "error", err)
+				return
+			}
+		}
+		if o.loc.Group < skipBelow {
+			continue
+		}
+		g := o.g
+		if sg == nil {
+			var err error
+			sg, err = openGroup(sub.p, f.name, o.loc.Group, len(g.objects), g.times[0])
+			if err != nil {
+				slog.Error("failed to open subgroup", "track", f.name, "group", o.loc.Group, "error", err)
+				return
+			}
+			sgGroup = o.loc.Group
+		}
+		obj := g.objects[o.loc.Object]
+		if _, err := sg.WriteObjectWithHeaders(o.loc.Object, obj.headers, obj.payload); err != nil {
+			slog.Error("failed to write object", "track", f.name, "group", o.loc.Group, "object", o.loc.Object,
+				"error", err)
+			return
+		}
+		if o.loc.Object+1 < uint64(len(g.objects)) {
+			continue
+		}
+		if err := closeGroup(); err != nil {
+			slog.Error("failed to close subgroup", "track", f.name, "group", o.loc.Group, "error", err)
+			return
+		}
+		slog.Debug("published MoQ group", "track", f.name, "group", o.loc.Group, "objects", len(g.objects))
+	}
+}
@@ -0,0 +1,242 @@
+package pub
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+)
+
+// DefaultFetchWindow is how far back in time media FETCH can reach if
+// Handler.FetchWindow is not set.
+const DefaultFetchWindow = 10 * time.Minute
+
+// mediaObject is a generated media object and its extension headers.
+type mediaObject struct {
+	headers moqtransport.KVPList
+	payload []byte
+}
+
+// mediaSource generates the objects of a media track group by group. Since all
+// content is derived from wall-clock time, any past group can be regenerated
+// exactly as it was published live.
+type mediaSource interface {
+	// objectTimesMS returns the wall-clock times in milliseconds at which the
+	// objects of group groupNr are published.
+	objectTimesMS(groupNr uint64) []int64
+	// genGroup generates the objects of group groupNr.
+	genGroup(groupNr uint64) ([]mediaObject, error)
+}
+
+// cmafSource generates CMAF or LOCMAF groups as sent by PublishTrack, from
+// cache if set. The groups of an uncached source are taken from the cache
+// but not added to it, see fetchSource.
+type cmafSource struct {
+	asset     *internal.Asset
+	ct        *internal.ContentTrack
+	packaging string
+	cache     *internal.GroupCache
+	uncached  bool
+}
+
+func (s cmafSource) objectTimesMS(groupNr uint64) []int64 {
+	return internal.MoQObjectTimesMS(s.ct, groupNr, s.ct.SampleBatchAt(groupNr), internal.MoqGroupDurMS)
+}
+
+func (s cmafSource) genGroup(groupNr uint64) ([]mediaObject, error) {
+	genGroup := s.cache.GenMoQGroup
+	if s.uncached {
+		genGroup = s.cache.GenUncachedMoQGroup
+	}
+	mg, err := genGroup(s.asset, s.ct, groupNr, s.ct.SampleBatchAt(groupNr), internal.MoqGroupDurMS, s.packaging)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]mediaObject, len(mg.MoQObjects))
+	for i, o := range mg.MoQObjects {
+		objects[i] = mediaObject{payload: o}
+	}
+	return objects, nil
+}
+
+// locSource generates LOC groups as sent by PublishLOCTrack.
+type locSource struct {
+	ct          *internal.ContentTrack
+	videoConfig []byte
+}
+
+func (s locSource) objectTimesMS(groupNr uint64) []int64 {
+	startNr, endNr := internal.CalcLOCGroupRange(s.ct, groupNr, internal.MoqGroupDurMS)
+	times := make([]int64, 0, endNr-startNr)
+	for sampleNr := startNr; sampleNr < endNr; sampleNr++ {
+		times = append(times, int64(sampleNr*uint64(s.ct.SampleDur)*1000/uint64(s.ct.TimeScale)))
+	}
+	return times
+}
+
+func (s locSource) genGroup(groupNr uint64) ([]mediaObject, error) {
+	startNr, endNr := internal.CalcLOCGroupRange(s.ct, groupNr, internal.MoqGroupDurMS)
+	objects := make([]mediaObject, 0, endNr-startNr)
+	for sampleNr := startNr; sampleNr < endNr; sampleNr++ {
+		headers, payload, err := locObject(s.ct, s.videoConfig, sampleNr)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, mediaObject{headers: headers, payload: payload})
+	}
+	return objects, nil
+}
+
+// subtitleSource generates subtitle groups as sent by PublishSubtitleTrack.
+type subtitleSource struct {
+	st *internal.SubtitleTrack
+}
+
+func (s subtitleSource) objectTimesMS(groupNr uint64) []int64 {
+	return []int64{int64(groupNr * uint64(internal.MoqGroupDurMS))}
+}
+
+func (s subtitleSource) genGroup(groupNr uint64) ([]mediaObject, error) {
+	mg, err := internal.GenSubtitleGroup(s.st, groupNr, internal.MoqGroupDurMS)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]mediaObject, len(mg.MoQObjects))
+	for i, o := range mg.MoQObjects {
+		objects[i] = mediaObject{payload: o}
+	}
+	return objects, nil
+}
+
+// mediaSourceFor returns the source of the named media track in the namespace,
+// or nil if there is no such track. Cloned catalog tracks use their parent's
+// media.
+func (h *Handler) mediaSourceFor(nsEntry *NamespaceEntry, lc *LiveCatalog, trackName string) mediaSource {
+	asset := h.assetOf(nsEntry)
+	if st := asset.GetSubtitleTrackByName(trackName); st != nil {
+		return subtitleSource{st: st}
+	}
+	track := lc.Catalog().GetTrackByName(trackName)
+	if track == nil {
+		return nil
+	}
+	contentName := lc.ContentTrackName(trackName)
+	if nsEntry.Packaging == "loc" {
+		ct := asset.GetTrackByName(contentName)
+		if ct == nil {
+			return nil
+		}
+		return locSource{ct: ct, videoConfig: locVideoConfig(ct)}
+	}
+	ct := asset.GetTrackByName(strings.TrimSuffix(contentName, internal.LocmafTrackSuffix))
+	if ct == nil {
+		return nil
+	}
+	return cmafSource{asset: asset, ct: ct, packaging: track.Packaging, cache: h.GroupCache}
+}
+
+// fetchSource returns src for serving a FETCH. A FETCH may span many past
+// groups, so its groups are not added to the group cache, where they would
+// evict the groups of the live edge that the subscriptions share.
+func fetchSource(src mediaSource) mediaSource {
+	if cs, ok := src.(cmafSource); ok {
+		cs.uncached = true
+		return cs
+	}
+	return src
+}
+
+// largestLocation returns the location of the latest object of src published
+// at nowMS, or false if there is none.
+func largestLocation(src mediaSource, nowMS int64) (moqtransport.Location, bool) {
+	currGroupNr := uint64(nowMS) / uint64(internal.MoqGroupDurMS)
+	// The latest object is in the current group or, early in it, in the
+	// previous one.
+	groupNrs := []uint64{currGroupNr}
+	if currGroupNr > 0 {
+		groupNrs = append(groupNrs, currGroupNr-1)
+	}
+	for _, groupNr := range groupNrs {
+		times := src.objectTimesMS(groupNr)
+		for i := len(times) - 1; i >= 0; i-- {
+			if times[i] <= nowMS {
+				return moqtransport.Location{Group: groupNr, Object: uint64(i)}, true
+			}
+		}
+	}
+	return moqtransport.Location{}, false
+}
+
+// fetchWindow returns the configured FETCH window.
+func (h *Handler) fetchWindow() time.Duration {
+	if h.FetchWindow > 0 {
+		return h.FetchWindow
+	}
+	return DefaultFetchWindow
+}
+
+// fetchGroupRange returns the groups [first, last] of a FETCH from start to
+// end, limited to groups within window before nowMS. ok is false if no
+// published object is in the range.
+func fetchGroupRange(src mediaSource, start, end moqtransport.Location, nowMS int64,
+	window time.Duration) (first, last uint64, ok bool) {
+	largest, ok := largestLocation(src, nowMS)
+	if !ok || LocationLess(largest, start) {
+		return 0, 0, false
+	}
+	first = start.Group
+	if oldest := uint64(nowMS-window.Milliseconds()) / uint64(internal.MoqGroupDurMS); first < oldest {
+		first = oldest
+	}
+	last = min(end.Group, largest.Group)
+	if first > last {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// serveMediaFetch writes the published objects of src in the group range
+// [first, last] that fall within the FETCH's [start, end) range, in
+// ascending order, and closes the fetch stream.
+func serveMediaFetch(ctx context.Context, fs *moqtransport.FetchStream, src mediaSource, trackName string,
+	first, last uint64, start, end moqtransport.Location) {
+	nowMS := time.Now().UnixMilli()
+	served := 0
+	defer func() {
+		slog.Info("served media FETCH", "track", trackName, "firstGroup", first, "lastGroup", last,
+			"objects", served)
+		if err := fs.Close(); err != nil {
+			slog.Error("failed to close fetch stream", "error", err)
+		}
+	}()
+	for groupNr := first; groupNr <= last; groupNr++ {
+		if ctx.Err() != nil {
+			return
+		}
+		times := src.objectTimesMS(groupNr)
+		objects, err := src.genGroup(groupNr)
+		if err != nil {
+			slog.Error("failed to generate group for fetch", "track", trackName, "group", groupNr, "error", err)
+			return
+		}
+		for objectID, o := range objects {
+			if times[objectID] > nowMS {
+				return // live edge
+			}
+			loc := moqtransport.Location{Group: groupNr, Object: uint64(objectID)}
+			if !locationInFetchRange(loc, start, end) {
+				continue
+			}
+			_, err := fs.WriteObjectWithHeaders(groupNr, 0, uint64(objectID), MediaPriority, o.headers, o.payload)
+			if err != nil {
+				slog.Error("failed to write fetch object", "track", trackName, "group", groupNr,
+					"object", objectID, "error", err)
+				return
+			}
+			served++
+		}
+	}
+}
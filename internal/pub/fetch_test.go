@@ -0,0 +1,106 @@
+package pub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaSources(t *testing.T) {
+	asset, err := internal.LoadAsset("../../assets/test10s", 2, 1)
+	require.NoError(t, err)
+	require.NoError(t, asset.AddSubtitleTracks([]string{"en"}, nil))
+	video := asset.GetTrackByName("video_400kbps_avc")
+	require.NotNil(t, video)
+	audio := asset.GetTrackByName("audio_monotonic_128kbps_aac")
+	require.NotNil(t, audio)
+
+	const groupNr = 1745255189
+	sources := map[string]mediaSource{
+		"cmaf video":   cmafSource{ct: video, packaging: "cmaf"},
+		"cmaf audio":   cmafSource{ct: audio, packaging: "cmaf"},
+		"locmaf video": cmafSource{ct: video, packaging: "locmaf"},
+		"loc video":    locSource{ct: video, videoConfig: locVideoConfig(video)},
+		"loc audio":    locSource{ct: audio},
+		"subtitles":    subtitleSource{st: asset.SubtitleTracks[0]},
+	}
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			times := src.objectTimesMS(groupNr)
+			objects, err := src.genGroup(groupNr)
+			require.NoError(t, err)
+			require.Len(t, objects, len(times), "one publish time per object")
+			for i := 1; i < len(times); i++ {
+				assert.LessOrEqual(t, times[i-1], times[i])
+			}
+
+			// Just after the last object of the group, it is the largest.
+			largest, ok := largestLocation(src, times[len(times)-1])
+			require.True(t, ok)
+			assert.Equal(t, moqtransport.Location{Group: groupNr, Object: uint64(len(times) - 1)}, largest)
+
+			// Just before the first object of the next group, the
+			// group's last object is still the largest.
+			next := src.objectTimesMS(groupNr + 1)
+			largest, ok = largestLocation(src, next[0]-1)
+			require.True(t, ok)
+			assert.Equal(t, uint64(groupNr), largest.Group)
+		})
+	}
+}
+
+func TestFetchGroupRange(t *testing.T) {
+	asset, err := internal.LoadAsset("../../assets/test10s", 1, 1)
+	require.NoError(t, err)
+	src := cmafSource{ct: asset.GetTrackByName("video_400kbps_avc"), packaging: "cmaf"}
+	loc := func(g, o uint64) moqtransport.Location { return moqtransport.Location{Group: g, Object: o} }
+	const currGroup = 1745255189
+	nowMS := int64(currGroup*1000 + 500)
+	window := 10 * time.Second
+
+	cases := []struct {
+		name        string
+		start, end  moqtransport.Location
+		wantOK      bool
+		first, last uint64
+	}{
+		{"past groups", loc(currGroup-5, 0), loc(currGroup-3, 0), true, currGroup - 5, currGroup - 3},
+		{"up to the live edge", loc(currGroup-1, 0), loc(currGroup+3, 0), true, currGroup - 1, currGroup},
+		{"clamped to window", loc(0, 0), loc(currGroup-8, 0), true, currGroup - 10, currGroup - 8},
+		{"before window", loc(0, 0), loc(100, 0), false, 0, 0},
+		{"future groups", loc(currGroup+1, 0), loc(currGroup+2, 0), false, 0, 0},
+		{"future object", loc(currGroup, 20), loc(currGroup, 22), false, 0, 0},
+	}
+	for _, c := range cases {
+		first, last, ok := fetchGroupRange(src, c.start, c.end, nowMS, window)
+		require.Equal(t, c.wantOK, ok, c.name)
+		if ok {
+			assert.Equal(t, c.first, first, c.name)
+			assert.Equal(t, c.last, last, c.name)
+		}
+	}
+}
+
+func TestCMAFSourceBatchChange(t *testing.T) {
+	asset, err := internal.LoadAsset("../../assets/test10s", 1, 1)
+	require.NoError(t, err)
+	const changeGroup = 1745255189
+	asset.SetSampleBatch("video", 5, changeGroup)
+	src := cmafSource{ct: asset.GetTrackByName("video_400kbps_avc"), packaging: "cmaf"}
+
+	// Groups before the change are fetched as they were published.
+	for groupNr, want := range map[uint64]int{changeGroup - 1: 25, changeGroup: 5} {
+		assert.Len(t, src.objectTimesMS(groupNr), want, "group %d", groupNr)
+		objects, err := src.genGroup(groupNr)
+		require.NoError(t, err)
+		assert.Len(t, objects, want, "group %d", groupNr)
+	}
+	times := src.objectTimesMS(changeGroup - 1)
+	largest, ok := largestLocation(src, times[len(times)-1])
+	require.True(t, ok)
+	assert.Equal(t, moqtransport.Location{Group: changeGroup - 1, Object: 24}, largest)
+}
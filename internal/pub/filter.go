@@ -0,0 +1,193 @@
+package pub
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// subscriptionFilterParamKey is the SUBSCRIPTION_FILTER parameter that carries
+// the filter of a SUBSCRIBE in draft-16 and later.
+const subscriptionFilterParamKey = 0x21
+
+// errInvalidRange is returned for a subscription filter whose range is empty
+// or has already been published completely.
+var errInvalidRange = errors.New("invalid subscription range")
+
+// SubscribeRange is the range of objects that is published for a subscription.
+type SubscribeRange struct {
+	// Start is the location of the first object to publish.
+	Start moqtransport.Location
+	// EndGroup is the last group to publish if Bounded is set.
+	EndGroup uint64
+	// Bounded is set if the subscription ends after EndGroup.
+	Bounded bool
+}
+
+// pastEnd returns true if groupNr is after the end of the range.
+func (r SubscribeRange) pastEnd(groupNr uint64) bool {
+	return r.Bounded && groupNr > r.EndGroup
+}
+
+// subscribeFilter is the filter of a SUBSCRIBE message.
+type subscribeFilter struct {
+	filterType moqtransport.FilterType
+	start      moqtransport.Location
+	endGroup   uint64
+	// hasLocations is set if the start and end of an absolute filter are known.
+	hasLocations bool
+}
+
+// parseSubscribeFilter returns the filter of m. moqtransport only forwards
+// the filter type, so the start location and end group are read from the
+// SUBSCRIPTION_FILTER parameter if present (draft-16 and later).
+func parseSubscribeFilter(m *moqtransport.SubscribeMessage) subscribeFilter {
+	f := subscribeFilter{filterType: m.FilterType}
+	if f.filterType != moqtransport.FilterTypeAbsoluteStart && f.filterType != moqtransport.FilterTypeAbsoluteRange {
+		return f
+	}
+	if m.StartLocation != nil {
+		f.start = *m.StartLocation
+		if m.EndGroup != nil {
+			f.endGroup = *m.EndGroup
+		}
+		f.hasLocations = f.filterType == moqtransport.FilterTypeAbsoluteStart || m.EndGroup != nil
+		return f
+	}
+	p, ok := m.Parameters.GetParameter(subscriptionFilterParamKey)
+	if !ok {
+		return f
+	}
+	data := p.ValueBytes
+	var vals [4]uint64 // filter type, start group, start object, end group
+	nrVals := 3
+	if f.filterType == moqtransport.FilterTypeAbsoluteRange {
+		nrVals = 4
+	}
+	for i := range nrVals {
+		v, n, err := quicvarint.Parse(data)
+		if err != nil {
+			slog.Warn("malformed subscription filter parameter", "error", err)
+			return f
+		}
+		vals[i] = v
+		data = data[n:]
+	}
+	f.start = moqtransport.Location{Group: vals[1], Object: vals[2]}
+	f.endGroup = vals[3]
+	f.hasLocations = true
+	return f
+}
+
+// resolve returns the range of objects to publish for the filter.
+// next is the location following the latest published object, i.e. the
+// first object that has not yet been published, and objectsInGroup returns
+// the number of objects in a group.
+//
+// Past objects are only available via FETCH, so an absolute start before
+// next starts at next. An absolute filter without known locations is
+// treated as Largest Object.
+func (f subscribeFilter) resolve(next moqtransport.Location, objectsInGroup func(groupNr uint64) int) (SubscribeRange, error) {
+	var r SubscribeRange
+	switch {
+	case f.filterType == moqtransport.FilterTypeNextGroupStart:
+		r.Start = moqtransport.Location{Group: next.Group + 1}
+		if next.Object == 0 {
+			r.Start = next
+		}
+	case !f.hasLocations || f.filterType == moqtransport.FilterTypeLatestObject:
+		r.Start = next
+	default:
+		r.Start = f.start
+		if LocationLess(r.Start, next) {
+			r.Start = next
+		}
+		if f.filterType == moqtransport.FilterTypeAbsoluteRange {
+			if f.endGroup < f.start.Group || f.endGroup < r.Start.Group {
+				return r, errInvalidRange
+			}
+			r.EndGroup, r.Bounded = f.endGroup, true
+		}
+	}
+	if r.Start.Object > 0 && r.Start.Object >= uint64(objectsInGroup(r.Start.Group)) {
+		r.Start = moqtransport.Location{Group: r.Start.Group + 1}
+		if r.pastEnd(r.Start.Group) {
+			return r, errInvalidRange
+		}
+	}
+	return r, nil
+}
+
+// ResolveSubscribeFilter returns the range of objects to deliver for the
+// filter of m, where next is the first object that has not yet been
+// delivered. It is used by relays, which do not know the number of objects
+// in a group; an absolute start at the end of a group is delivered from the
+// next group that arrives.
+func ResolveSubscribeFilter(m *moqtransport.SubscribeMessage, next moqtransport.Location) (SubscribeRange, error) {
+	return parseSubscribeFilter(m).resolve(next, func(uint64) int { return math.MaxInt })
+}
+
+// liveEdge returns the location of the latest object of src published at
+// nowMS, or nil if there is none, and the location of the next object.
+func liveEdge(src mediaSource, nowMS int64) (largest *moqtransport.Location, next moqtransport.Location) {
+	loc, ok := largestLocation(src, nowMS)
+	if !ok {
+		return nil, moqtransport.Location{Group: uint64(nowMS) / uint64(internal.MoqGroupDurMS)}
+	}
+	return &loc, moqtransport.Location{Group: loc.Group, Object: loc.Object + 1}
+}
+
+// objectsInGroup returns the number of objects in a group of src.
+func objectsInGroup(src mediaSource) func(groupNr uint64) int {
+	return func(groupNr uint64) int {
+		return len(src.objectTimesMS(groupNr))
+	}
+}
+
+// acceptSubscription resolves the filter of m against the live edge of a
+// track, and accepts the subscription with largest as the largest location.
+// It returns the range to publish, or false if the subscription was
+// rejected or could not be accepted.
+func acceptSubscription(w *moqtransport.SubscribeResponseWriter, m *moqtransport.SubscribeMessage,
+	largest *moqtransport.Location, next moqtransport.Location,
+	objectsInGroup func(groupNr uint64) int) (SubscribeRange, bool) {
+	f := parseSubscribeFilter(m)
+	if !f.hasLocations && (f.filterType == moqtransport.FilterTypeAbsoluteStart ||
+		f.filterType == moqtransport.FilterTypeAbsoluteRange) {
+		slog.Warn("absolute subscription filter without locations, starting at the live edge",
+			"track", m.Track, "filterType", f.filterType)
+	}
+	r, err := f.resolve(next, objectsInGroup)
+	if err != nil {
+		slog.Warn("rejecting subscription", "track", m.Track, "filterType", f.filterType,
+			"start", f.start, "endGroup", f.endGroup, "next", next, "error", err)
+		if err := w.Reject(moqtransport.ErrorCodeSubscribeInvalidRange, err.Error()); err != nil {
+			slog.Error("failed to reject subscription", "error", err)
+		}
+		return r, false
+	}
+	var opts []moqtransport.SubscribeOKOption
+	if largest != nil {
+		opts = append(opts, moqtransport.WithLargestLocation(largest))
+	}
+	if err := w.Accept(opts...); err != nil {
+		slog.Error("failed to accept subscription", "error", err)
+		return r, false
+	}
+	return r, true
+}
+
+// endSubscription ends a bounded subscription whose last group has been
+// published with PUBLISH_DONE status SUBSCRIPTION_ENDED.
+func endSubscription(publisher moqtransport.Publisher, trackName string, rng SubscribeRange) {
+	slog.Info("subscription range published", "track", trackName, "start", rng.Start, "endGroup", rng.EndGroup)
+	err := publisher.CloseWithError(uint64(moqtransport.ErrorCodeSubscribeDoneSubscriptionEnded),
+		"end of subscription range")
+	if err != nil {
+		slog.Error("failed to end subscription", "track", trackName, "error", err)
+	}
+}
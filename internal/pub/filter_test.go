@@ -0,0 +1,213 @@
+package pub
+
+import (
+	"testing"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+	"github.com/quic-go/quic-go/quicvarint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubscribeFilter(t *testing.T) {
+	loc := func(g, o uint64) moqtransport.Location { return moqtransport.Location{Group: g, Object: o} }
+	filterParam := func(vals ...uint64) moqtransport.KVPList {
+		var b []byte
+		for _, v := range vals {
+			b = quicvarint.Append(b, v)
+		}
+		return moqtransport.KVPList{{Type: subscriptionFilterParamKey, ValueBytes: b}}
+	}
+	endGroup := uint64(12)
+
+	cases := []struct {
+		name string
+		m    moqtransport.SubscribeMessage
+		want subscribeFilter
+	}{
+		{
+			name: "largest object",
+			m:    moqtransport.SubscribeMessage{FilterType: moqtransport.FilterTypeLatestObject},
+			want: subscribeFilter{filterType: moqtransport.FilterTypeLatestObject},
+		},
+		{
+			name: "absolute start from parameter",
+			m: moqtransport.SubscribeMessage{
+				FilterType: moqtransport.FilterTypeAbsoluteStart,
+				Parameters: filterParam(uint64(moqtransport.FilterTypeAbsoluteStart), 10, 3),
+			},
+			want: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteStart, start: loc(10, 3),
+				hasLocations: true},
+		},
+		{
+			name: "absolute range from parameter",
+			m: moqtransport.SubscribeMessage{
+				FilterType: moqtransport.FilterTypeAbsoluteRange,
+				Parameters: filterParam(uint64(moqtransport.FilterTypeAbsoluteRange), 10, 3, 12),
+			},
+			want: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteRange, start: loc(10, 3),
+				endGroup: 12, hasLocations: true},
+		},
+		{
+			name: "absolute range from message fields",
+			m: moqtransport.SubscribeMessage{
+				FilterType:    moqtransport.FilterTypeAbsoluteRange,
+				StartLocation: &moqtransport.Location{Group: 10},
+				EndGroup:      &endGroup,
+			},
+			want: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteRange, start: loc(10, 0),
+				endGroup: 12, hasLocations: true},
+		},
+		{
+			name: "absolute start without locations (draft-14)",
+			m:    moqtransport.SubscribeMessage{FilterType: moqtransport.FilterTypeAbsoluteStart},
+			want: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteStart},
+		},
+		{
+			name: "truncated parameter",
+			m: moqtransport.SubscribeMessage{
+				FilterType: moqtransport.FilterTypeAbsoluteRange,
+				Parameters: filterParam(uint64(moqtransport.FilterTypeAbsoluteRange), 10, 3),
+			},
+			want: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteRange},
+		},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, parseSubscribeFilter(&c.m), c.name)
+	}
+}
+
+func TestSubscribeFilterResolve(t *testing.T) {
+	loc := func(g, o uint64) moqtransport.Location { return moqtransport.Location{Group: g, Object: o} }
+	objectsInGroup := func(uint64) int { return 5 }
+	next := loc(10, 3) // objects {10,0}-{10,2} are published
+
+	cases := []struct {
+		name    string
+		f       subscribeFilter
+		next    moqtransport.Location
+		want    SubscribeRange
+		wantErr bool
+	}{
+		{
+			name: "largest object starts mid-group",
+			f:    subscribeFilter{filterType: moqtransport.FilterTypeLatestObject},
+			want: SubscribeRange{Start: loc(10, 3)},
+		},
+		{
+			name: "largest object at end of group starts next group",
+			f:    subscribeFilter{filterType: moqtransport.FilterTypeLatestObject},
+			next: loc(10, 5),
+			want: SubscribeRange{Start: loc(11, 0)},
+		},
+		{
+			name: "next group start",
+			f:    subscribeFilter{filterType: moqtransport.FilterTypeNextGroupStart},
+			want: SubscribeRange{Start: loc(11, 0)},
+		},
+		{
+			name: "next group start before any object of the group",
+			f:    subscribeFilter{filterType: moqtransport.FilterTypeNextGroupStart},
+			next: loc(10, 0),
+			want: SubscribeRange{Start: loc(10, 0)},
+		},
+		{
+			name: "absolute start in the future",
+			f: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteStart, start: loc(12, 2),
+				hasLocations: true},
+			want: SubscribeRange{Start: loc(12, 2)},
+		},
+		{
+			name: "absolute start in the past starts at the live edge",
+			f: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteStart, start: loc(8, 0),
+				hasLocations: true},
+			want: SubscribeRange{Start: loc(10, 3)},
+		},
+		{
+			name: "absolute start without locations",
+			f:    subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteStart},
+			want: SubscribeRange{Start: loc(10, 3)},
+		},
+		{
+			name: "absolute range",
+			f: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteRange, start: loc(11, 1),
+				endGroup: 13, hasLocations: true},
+			want: SubscribeRange{Start: loc(11, 1), EndGroup: 13, Bounded: true},
+		},
+		{
+			name: "absolute range ending in the current group",
+			f: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteRange, start: loc(9, 0),
+				endGroup: 10, hasLocations: true},
+			want: SubscribeRange{Start: loc(10, 3), EndGroup: 10, Bounded: true},
+		},
+		{
+			name: "absolute range published completely",
+			f: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteRange, start: loc(8, 0),
+				endGroup: 9, hasLocations: true},
+			wantErr: true,
+		},
+		{
+			name: "absolute range with end before start",
+			f: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteRange, start: loc(14, 0),
+				endGroup: 12, hasLocations: true},
+			wantErr: true,
+		},
+		{
+			name: "absolute range whose last object is published",
+			f: subscribeFilter{filterType: moqtransport.FilterTypeAbsoluteRange, start: loc(10, 0),
+				endGroup: 10, hasLocations: true},
+			next:    loc(10, 5),
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		n := next
+		if c.next != (moqtransport.Location{}) {
+			n = c.next
+		}
+		got, err := c.f.resolve(n, objectsInGroup)
+		if c.wantErr {
+			assert.ErrorIs(t, err, errInvalidRange, c.name)
+			continue
+		}
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.want, got, c.name)
+	}
+}
+
+func TestSubscribeRange(t *testing.T) {
+	r := SubscribeRange{Start: moqtransport.Location{Group: 10, Object: 3}, EndGroup: 11, Bounded: true}
+	assert.False(t, r.pastEnd(11))
+	assert.True(t, r.pastEnd(12))
+	assert.False(t, SubscribeRange{}.pastEnd(12), "unbounded range never ends")
+}
+
+func TestMoqMILiveEdge(t *testing.T) {
+	asset, err := internal.LoadAsset("../../assets/test10s", 1, 1)
+	require.NoError(t, err)
+	video := asset.GetTrackByName("video_400kbps_avc")
+	require.NotNil(t, video)
+	audio := asset.GetTrackByName("audio_monotonic_128kbps_aac")
+	require.NotNil(t, audio)
+
+	// Video groups are GOPs with one object per sample.
+	gopLen := uint64(video.GopLength)
+	require.Positive(t, gopLen)
+	assert.Equal(t, int(gopLen), moqmiObjectsInGroup(video)(0))
+	const nowMS = 1745255189_500
+	sampleNr := uint64(nowMS) * uint64(video.TimeScale) / 1000 / uint64(video.SampleDur)
+	largest, next := moqmiLiveEdge(video, nowMS)
+	require.NotNil(t, largest)
+	assert.Equal(t, moqtransport.Location{Group: sampleNr / gopLen, Object: sampleNr % gopLen}, *largest)
+	assert.Equal(t, largest.Object+1, next.Object)
+
+	// Audio groups are single frames.
+	assert.Equal(t, 1, moqmiObjectsInGroup(audio)(0))
+	largest, next = moqmiLiveEdge(audio, nowMS)
+	require.NotNil(t, largest)
+	assert.Equal(t, uint64(0), largest.Object)
+	r, err := subscribeFilter{filterType: moqtransport.FilterTypeLatestObject}.resolve(next, moqmiObjectsInGroup(audio))
+	require.NoError(t, err)
+	assert.Equal(t, moqtransport.Location{Group: largest.Group + 1}, r.Start)
+}
@@ -0,0 +1,285 @@
+package pub
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+)
+
+// LateGroupMargin is how long after its wall-clock start time a group may
+// be started before it is counted as late.
+const LateGroupMargin = 200 * time.Millisecond
+
+// Metrics collects publisher metrics and serves them in the Prometheus text
+// exposition format. A nil *Metrics collects nothing.
+type Metrics struct {
+	// GroupCache, if set, has its counters written with the metrics.
+	GroupCache *internal.GroupCache
+
+	sessionsActive atomic.Int64
+	sessionsTotal  atomic.Uint64
+
+	mu      sync.Mutex
+	tracks  map[trackKey]*trackMetrics
+	fetches map[trackKey]uint64
+}
+
+// trackKey identifies the metrics of a track. packaging is empty for FETCH.
+type trackKey struct {
+	namespace string
+	track     string
+	packaging string
+}
+
+// trackMetrics are the metrics of the media subscriptions of a track.
+type trackMetrics struct {
+	subscriptionsActive atomic.Int64
+	subscriptionsTotal  atomic.Uint64
+	objects             atomic.Uint64
+	droppedObjects      atomic.Uint64
+	bytes               atomic.Uint64
+	groups              atomic.Uint64
+	lateGroups          atomic.Uint64
+	writeErrors         atomic.Uint64
+}
+
+// NewMetrics returns empty metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		tracks:  make(map[trackKey]*trackMetrics),
+		fetches: make(map[trackKey]uint64),
+	}
+}
+
+func (m *Metrics) sessionStarted() {
+	if m == nil {
+		return
+	}
+	m.sessionsActive.Add(1)
+	m.sessionsTotal.Add(1)
+}
+
+func (m *Metrics) sessionEnded() {
+	if m == nil {
+		return
+	}
+	m.sessionsActive.Add(-1)
+}
+
+// subscriptionStarted counts a media subscription to a track and returns
+// the metrics of the track, or nil if m is nil.
+func (m *Metrics) subscriptionStarted(namespace []string, track, packaging string) *trackMetrics {
+	if m == nil {
+		return nil
+	}
+	key := trackKey{namespace: strings.Join(namespace, "/"), track: track, packaging: packaging}
+	m.mu.Lock()
+	tm, ok := m.tracks[key]
+	if !ok {
+		tm = &trackMetrics{}
+		m.tracks[key] = tm
+	}
+	m.mu.Unlock()
+	tm.subscriptionsActive.Add(1)
+	tm.subscriptionsTotal.Add(1)
+	return tm
+}
+
+func (m *Metrics) fetchReceived(namespace []string, track string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetches[trackKey{namespace: strings.Join(namespace, "/"), track: track}]++
+}
+
+func (tm *trackMetrics) subscriptionEnded() {
+	if tm == nil {
+		return
+	}
+	tm.subscriptionsActive.Add(-1)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	keys := make([]trackKey, 0, len(m.tracks))
+	for key := range m.tracks {
+		keys = append(keys, key)
+	}
+	fetchKeys := make([]trackKey, 0, len(m.fetches))
+	fetches := make(map[trackKey]uint64, len(m.fetches))
+	for key, n := range m.fetches {
+		fetchKeys = append(fetchKeys, key)
+		fetches[key] = n
+	}
+	tracks := make(map[trackKey]*trackMetrics, len(m.tracks))
+	for key, tm := range m.tracks {
+		tracks[key] = tm
+	}
+	m.mu.Unlock()
+	slices.SortFunc(keys, compareTrackKeys)
+	slices.SortFunc(fetchKeys, compareTrackKeys)
+
+	var b strings.Builder
+	writeMetric(&b, "mlmpub_sessions_active", "gauge", "Number of active MoQ sessions.")
+	fmt.Fprintf(&b, "mlmpub_sessions_active %d\n", m.sessionsActive.Load())
+	writeMetric(&b, "mlmpub_sessions_total", "counter", "Number of MoQ sessions started.")
+	fmt.Fprintf(&b, "mlmpub_sessions_total %d\n", m.sessionsTotal.Load())
+
+	perTrack := []struct {
+		name, typ, help string
+		value           func(tm *trackMetrics) int64
+	}{
+		{"mlmpub_subscriptions_active", "gauge", "Number of active media subscriptions.",
+			func(tm *trackMetrics) int64 { return tm.subscriptionsActive.Load() }},
+		{"mlmpub_subscriptions_total", "counter", "Number of media subscriptions accepted.",
+			func(tm *trackMetrics) int64 { return int64(tm.subscriptionsTotal.Load()) }},
+		{"mlmpub_objects_written_total", "counter", "Number of media objects written.",
+			func(tm *trackMetrics) int64 { return int64(tm.objects.Load()) }},
+		{"mlmpub_objects_dropped_total", "counter", "Number of media objects dropped by fault injection.",
+			func(tm *trackMetrics) int64 { return int64(tm.droppedObjects.Load()) }},
+		{"mlmpub_bytes_written_total", "counter", "Number of media object payload bytes written.",
+			func(tm *trackMetrics) int64 { return int64(tm.bytes.Load()) }},
+		{"mlmpub_groups_started_total", "counter", "Number of media groups started.",
+			func(tm *trackMetrics) int64 { return int64(tm.groups.Load()) }},
+		{"mlmpub_groups_late_total", "counter",
+			fmt.Sprintf("Number of media groups started more than %s after their wall-clock start.", LateGroupMargin),
+			func(tm *trackMetrics) int64 { return int64(tm.lateGroups.Load()) }},
+		{"mlmpub_write_errors_total", "counter", "Number of failures to open, write or close a media subgroup.",
+			func(tm *trackMetrics) int64 { return int64(tm.writeErrors.Load()) }},
+	}
+	for _, tmd := range perTrack {
+		writeMetric(&b, tmd.name, tmd.typ, tmd.help)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s{namespace=\"%s\",track=\"%s\",packaging=\"%s\"} %d\n", tmd.name,
+				escapeLabel(key.namespace), escapeLabel(key.track), escapeLabel(key.packaging), tmd.value(tracks[key]))
+		}
+	}
+	writeMetric(&b, "mlmpub_fetches_total", "counter", "Number of FETCH requests received.")
+	for _, key := range fetchKeys {
+		fmt.Fprintf(&b, "mlmpub_fetches_total{namespace=\"%s\",track=\"%s\"} %d\n",
+			escapeLabel(key.namespace), escapeLabel(key.track), fetches[key])
+	}
+	if m.GroupCache != nil {
+		writeGroupCacheMetrics(&b, m.GroupCache.Stats())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeGroupCacheMetrics writes the counters of the group cache.
+func writeGroupCacheMetrics(b *strings.Builder, s internal.GroupCacheStats) {
+	for _, gm := range []struct {
+		name, typ, help string
+		value           uint64
+	}{
+		{"mlmpub_group_cache_hits_total", "counter", "Number of media groups found in the group cache.", s.Hits},
+		{"mlmpub_group_cache_misses_total", "counter", "Number of media groups generated for the group cache.", s.Misses},
+		{"mlmpub_group_cache_evictions_total", "counter", "Number of media groups evicted from the group cache.",
+			s.Evictions},
+		{"mlmpub_group_cache_groups", "gauge", "Number of media groups in the group cache.", uint64(s.Groups)},
+		{"mlmpub_group_cache_bytes", "gauge", "Number of object payload bytes in the group cache.", uint64(s.Bytes)},
+	} {
+		writeMetric(b, gm.name, gm.typ, gm.help)
+		fmt.Fprintf(b, "%s %d\n", gm.name, gm.value)
+	}
+}
+
+func compareTrackKeys(a, b trackKey) int {
+	return cmp.Or(cmp.Compare(a.namespace, b.namespace), cmp.Compare(a.track, b.track),
+		cmp.Compare(a.packaging, b.packaging))
+}
+
+func writeMetric(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes backslashes, quotes and newlines in a label value.
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// meteredPublisher is a Publisher whose media groups are metered.
+type meteredPublisher struct {
+	moqtransport.Publisher
+	tm      *trackMetrics
+	started bool // a group has been started
+}
+
+// openGroup opens a group and meters it.
+func (p *meteredPublisher) openGroup(trackName string, groupNr uint64, nrObjects int,
+	startMS int64) (groupWriter, error) {
+	sg, err := openGroup(p.Publisher, trackName, groupNr, nrObjects, startMS)
+	return p.meterGroup(sg, err, startMS)
+}
+
+// meterGroup meters a group opened with err, whose wall-clock start time is
+// startMS. The first group of a subscription is not counted as late, since
+// the subscription may start in the middle of it.
+func (p *meteredPublisher) meterGroup(sg groupWriter, err error, startMS int64) (groupWriter, error) {
+	if err != nil {
+		p.tm.writeErrors.Add(1)
+		return nil, err
+	}
+	p.tm.groups.Add(1)
+	if p.started && time.Now().UnixMilli()-startMS > LateGroupMargin.Milliseconds() {
+		p.tm.lateGroups.Add(1)
+	}
+	p.started = true
+	return &meteredGroup{groupWriter: sg, tm: p.tm}, nil
+}
+
+// meteredGroup counts the objects and bytes written to a group. An object
+// with payload of which no bytes were written has been dropped by fault
+// injection, and is counted as dropped instead.
+type meteredGroup struct {
+	groupWriter
+	tm *trackMetrics
+}
+
+func (g *meteredGroup) WriteObject(objectID uint64, payload []byte) (int, error) {
+	return g.WriteObjectWithHeaders(objectID, nil, payload)
+}
+
+func (g *meteredGroup) WriteObjectWithHeaders(objectID uint64, headers moqtransport.KVPList,
+	payload []byte) (int, error) {
+	n, err := g.groupWriter.WriteObjectWithHeaders(objectID, headers, payload)
+	if err != nil {
+		g.tm.writeErrors.Add(1)
+		return n, err
+	}
+	if n == 0 && len(payload) > 0 {
+		g.tm.droppedObjects.Add(1)
+		return n, nil
+	}
+	g.tm.objects.Add(1)
+	g.tm.bytes.Add(uint64(n))
+	return n, nil
+}
+
+func (g *meteredGroup) Close() error {
+	err := g.groupWriter.Close()
+	if err != nil {
+		g.tm.writeErrors.Add(1)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package pub
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGroup is a groupWriter that fails writes of object failObject and
+// drops object dropObject.
+type fakeGroup struct {
+	failObject uint64
+	dropObject uint64
+}
+
+func (g *fakeGroup) WriteObject(objectID uint64, payload []byte) (int, error) {
+	return g.WriteObjectWithHeaders(objectID, nil, payload)
+}
+
+func (g *fakeGroup) WriteObjectWithHeaders(objectID uint64, _ moqtransport.KVPList, payload []byte) (int, error) {
+	if objectID == g.failObject {
+		return 0, errors.New("write failed")
+	}
+	if objectID == g.dropObject {
+		return 0, nil
+	}
+	return len(payload), nil
+}
+
+func (g *fakeGroup) Close() error { return nil }
+
+func TestMetrics(t *testing.T) {
+	var nilMetrics *Metrics
+	nilMetrics.sessionStarted()
+	nilMetrics.fetchReceived([]string{"cmsf", "clear"}, "catalog")
+	nilMetrics.subscriptionStarted([]string{"cmsf"}, "video", "cmaf").subscriptionEnded()
+
+	m := NewMetrics()
+	m.GroupCache = internal.NewGroupCache(internal.DefaultGroupCacheBytes)
+	m.sessionStarted()
+	m.sessionStarted()
+	m.sessionEnded()
+	m.fetchReceived([]string{"cmsf", "clear"}, "catalog")
+	m.fetchReceived([]string{"cmsf", "clear"}, "catalog")
+	tm := m.subscriptionStarted([]string{"cmsf", "clear"}, "video_400kbps_avc", "cmaf")
+	m.subscriptionStarted([]string{"cmsf", "clear"}, "video_400kbps_avc", "cmaf").subscriptionEnded()
+	m.subscriptionStarted([]string{"msf/clear"}, `a"b\c`, "loc")
+
+	g := &meteredGroup{groupWriter: &fakeGroup{failObject: 2, dropObject: 3}, tm: tm}
+	for objectID := range uint64(4) {
+		_, _ = g.WriteObject(objectID, make([]byte, 100))
+	}
+	require.NoError(t, g.Close())
+
+	var buf bytes.Buffer
+	require.NoError(t, m.Write(&buf))
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE mlmpub_sessions_active gauge",
+		"mlmpub_sessions_active 1",
+		"mlmpub_sessions_total 2",
+		`mlmpub_subscriptions_active{namespace="cmsf/clear",track="video_400kbps_avc",packaging="cmaf"} 1`,
+		`mlmpub_subscriptions_total{namespace="cmsf/clear",track="video_400kbps_avc",packaging="cmaf"} 2`,
+		`mlmpub_objects_written_total{namespace="cmsf/clear",track="video_400kbps_avc",packaging="cmaf"} 2`,
+		`mlmpub_objects_dropped_total{namespace="cmsf/clear",track="video_400kbps_avc",packaging="cmaf"} 1`,
+		`mlmpub_bytes_written_total{namespace="cmsf/clear",track="video_400kbps_avc",packaging="cmaf"} 200`,
+		`mlmpub_write_errors_total{namespace="cmsf/clear",track="video_400kbps_avc",packaging="cmaf"} 1`,
+		`mlmpub_subscriptions_active{namespace="msf/clear",track="a\"b\\c",packaging="loc"} 1`,
+		`mlmpub_fetches_total{namespace="cmsf/clear",track="catalog"} 2`,
+		"# TYPE mlmpub_group_cache_hits_total counter",
+		"mlmpub_group_cache_bytes 0",
+	} {
+		assert.Contains(t, strings.Split(out, "\n"), line)
+	}
+}
+
+func TestMeteredLateGroups(t *testing.T) {
+	m := NewMetrics()
+	tm := m.subscriptionStarted([]string{"cmsf"}, "video", "cmaf")
+	p := &meteredPublisher{tm: tm}
+	nowMS := time.Now().UnixMilli()
+	for _, startMS := range []int64{nowMS - 500, nowMS, nowMS - 500} {
+		_, err := p.meterGroup(&fakeGroup{}, nil, startMS)
+		require.NoError(t, err)
+	}
+	_, err := p.meterGroup(nil, errors.New("open failed"), nowMS)
+	require.Error(t, err)
+	assert.Equal(t, uint64(3), tm.groups.Load())
+	assert.Equal(t, uint64(1), tm.writeErrors.Load())
+	assert.Equal(t, uint64(1), tm.lateGroups.Load(), "the first group of a subscription is never late")
+}
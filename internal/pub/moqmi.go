@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
-	"time"
 
 	"github.com/Eyevinn/moqlivemock/internal"
 	"github.com/Eyevinn/moqtransport"
@@ -18,203 +17,156 @@ import (
 type MoqMITrackMap map[string]string
 
 // PublishMoqMITrack publishes a single track using the moq-mi wire format
-// (draft-cenzano-moq-media-interop-03). It attaches moqmi extension headers
-// to every object and uses the codec-specific grouping rules:
+// (draft-cenzano-moq-media-interop-03) as generated by moqmiSource.
 //
-//   - Video (H.264 AVCC): one group per GOP (IDR-bounded), object 0 of each
-//     group carries the AVCDecoderConfigurationRecord in extension 0x0D.
-//   - Audio (AAC-LC, Opus): one group per audio frame, single object per group.
-//
-// Payloads are the codec bitstream as defined by moqmi (AVCC length-prefixed
-// NALUs for H.264, raw Opus packets, AAC raw_data_block).
+// Objects are paced to wall-clock time with sched, which may be nil.
+// Publishing starts at rng.Start and, for a bounded range, ends with
+// PUBLISH_DONE after rng.EndGroup.
 func PublishMoqMITrack(ctx context.Context, publisher moqtransport.Publisher,
-	asset *internal.Asset, assetTrackName, moqmiTrackName string) {
+	asset *internal.Asset, sched *Scheduler, assetTrackName, moqmiTrackName string, rng SubscribeRange) {
 	ct := asset.GetTrackByName(assetTrackName)
 	if ct == nil {
 		slog.Error("moqmi: asset track not found", "track", assetTrackName)
 		return
 	}
+	src, err := newMoqMISource(ct)
+	if err != nil {
+		slog.Error("moqmi: cannot publish track", "track", moqmiTrackName, "error", err)
+		return
+	}
+	publishSource(ctx, publisher, sched, moqmiTrackName, src, rng)
+}
+
+// moqmiSource generates the moq-mi objects of a track. It attaches moqmi
+// extension headers to every object and uses the codec-specific grouping
+// rules:
+//
+//   - Video (H.264 AVCC): one group per GOP (IDR-bounded), object 0 of each
+//     group carries the AVCDecoderConfigurationRecord in extension 0x0D.
+//   - Audio (AAC-LC, Opus): one group per audio frame, single object per group.
+//
+// Payloads are the codec bitstream as defined by moqmi (AVCC length-prefixed
+// NALUs for H.264, raw Opus packets, AAC raw_data_block). Groups are aligned
+// to wall-clock time, so subscribers joining separately land on the same
+// grouping, and the SeqID of an object is the number of its sample.
+type moqmiSource struct {
+	ct        *internal.ContentTrack
+	mediaType uint64 // moqmi media type of audio tracks
+	gopLen    uint64 // objects per group
+	extradata []byte // AVCDecoderConfigurationRecord of video tracks
+}
+
+// newMoqMISource returns the moq-mi source of ct, or an error if its codec or
+// timing is not supported.
+func newMoqMISource(ct *internal.ContentTrack) (*moqmiSource, error) {
+	if ct.SampleDur == 0 || ct.TimeScale == 0 {
+		return nil, fmt.Errorf("invalid track timing: timescale %d, sample duration %d", ct.TimeScale, ct.SampleDur)
+	}
+	src := &moqmiSource{ct: ct, gopLen: 1}
 	switch sd := ct.SpecData.(type) {
 	case *internal.AVCData:
-		publishMoqMIVideo(ctx, publisher, ct, sd, moqmiTrackName)
+		if ct.GopLength == 0 {
+			return nil, fmt.Errorf("unknown GOP length for video track")
+		}
+		extradata, err := sd.GenAVCDecoderConfigurationRecord()
+		if err != nil {
+			return nil, fmt.Errorf("build AVCDecoderConfigurationRecord: %w", err)
+		}
+		src.gopLen = uint64(ct.GopLength)
+		src.extradata = extradata
 	case *internal.AACData:
-		publishMoqMIAudio(ctx, publisher, ct, moqmi.MediaTypeAudioAACLC, moqmiTrackName)
+		src.mediaType = moqmi.MediaTypeAudioAACLC
 	case *internal.OpusData:
-		publishMoqMIAudio(ctx, publisher, ct, moqmi.MediaTypeAudioOpus, moqmiTrackName)
+		src.mediaType = moqmi.MediaTypeAudioOpus
 	default:
-		slog.Error("moqmi: unsupported codec for moq-mi", "track", assetTrackName,
-			"codec", ct.SpecData.Codec())
+		return nil, fmt.Errorf("unsupported codec %s for moq-mi", ct.SpecData.Codec())
 	}
+	return src, nil
 }
 
-func publishMoqMIVideo(ctx context.Context, publisher moqtransport.Publisher,
-	ct *internal.ContentTrack, avcData *internal.AVCData, moqmiTrackName string) {
-	extradata, err := avcData.GenAVCDecoderConfigurationRecord()
-	if err != nil {
-		slog.Error("moqmi: failed to build AVCDecoderConfigurationRecord",
-			"track", moqmiTrackName, "error", err)
-		return
-	}
-	gopLen := uint64(ct.GopLength)
-	if gopLen == 0 {
-		slog.Error("moqmi: unknown GOP length for video track", "track", moqmiTrackName)
-		return
+func (s *moqmiSource) objectTimesMS(groupNr uint64) []int64 {
+	timebase := uint64(s.ct.TimeScale)
+	sampleDur := uint64(s.ct.SampleDur)
+	times := make([]int64, 0, s.gopLen)
+	for sampleNr := groupNr * s.gopLen; sampleNr < (groupNr+1)*s.gopLen; sampleNr++ {
+		times = append(times, int64(sampleNr*sampleDur*1000/timebase))
 	}
-	timebase := uint64(ct.TimeScale)
-	sampleDur := uint64(ct.SampleDur)
-
-	// Align start with the next GOP boundary in wallclock time so multiple
-	// subscribers joining separately land on the same grouping.
-	now := time.Now().UnixMilli()
-	gopDurMS := gopLen * sampleDur * 1000 / timebase
-	if gopDurMS == 0 {
-		gopDurMS = 1000
-	}
-	currGopNr := uint64(now) / gopDurMS
-	groupNr := currGopNr + 1
-
-	slog.Info("moqmi: publishing video track",
-		"track", moqmiTrackName, "startGroup", groupNr, "gopLen", gopLen)
+	return times
+}
 
-	seqID := groupNr * gopLen
-	for {
-		if ctx.Err() != nil {
-			return
-		}
-		sg, err := publisher.OpenSubgroup(groupNr, 0, MediaPriority)
-		if err != nil {
-			slog.Error("moqmi: failed to open subgroup", "error", err)
-			return
-		}
-		startSample := groupNr * gopLen
-		endSample := startSample + gopLen
-		for objectID, sampleNr := uint64(0), startSample; sampleNr < endSample; objectID, sampleNr = objectID+1, sampleNr+1 {
-			if ctx.Err() != nil {
-				return
-			}
-			_, origNr := ct.CalcSample(sampleNr)
-			sample := ct.Samples[origNr]
-			pts := sampleNr * sampleDur
-			ptsMS := int64(pts * 1000 / timebase)
-			waitMS := ptsMS - time.Now().UnixMilli()
-			if waitMS > 0 {
-				select {
-				case <-ctx.Done():
-					return
-				case <-time.After(time.Duration(waitMS) * time.Millisecond):
-				}
-			}
+func (s *moqmiSource) genGroup(groupNr uint64) ([]mediaObject, error) {
+	timebase := uint64(s.ct.TimeScale)
+	sampleDur := uint64(s.ct.SampleDur)
+	objects := make([]mediaObject, 0, s.gopLen)
+	for sampleNr := groupNr * s.gopLen; sampleNr < (groupNr+1)*s.gopLen; sampleNr++ {
+		pts := sampleNr * sampleDur
+		if s.extradata != nil {
 			meta := moqmi.VideoMetadata{
-				SeqID:       seqID,
+				SeqID:       sampleNr,
 				PTS:         pts,
 				DTS:         pts,
 				Timebase:    timebase,
 				Duration:    sampleDur,
-				WallclockMS: uint64(time.Now().UnixMilli()),
+				WallclockMS: pts * 1000 / timebase,
 			}
-			var headers moqtransport.KVPList
-			if objectID == 0 {
-				headers = moqmi.VideoHeaders(meta, extradata)
-			} else {
-				headers = moqmi.VideoHeaders(meta, nil)
+			var extradata []byte
+			if sampleNr == groupNr*s.gopLen {
+				extradata = s.extradata
 			}
-			if _, err := sg.WriteObjectWithHeaders(objectID, headers, sample.Data); err != nil {
-				slog.Error("moqmi: failed to write video object",
-					"group", groupNr, "object", objectID, "error", err)
-				return
+			payload, err := s.ct.SampleData(sampleNr)
+			if err != nil {
+				return nil, err
 			}
-			seqID++
+			objects = append(objects, mediaObject{
+				headers: moqmi.VideoHeaders(meta, extradata),
+				payload: payload,
+			})
+			continue
 		}
-		if err := sg.Close(); err != nil {
-			slog.Error("moqmi: failed to close video subgroup", "error", err)
-			return
+		meta := moqmi.AudioMetadata{
+			SeqID:       sampleNr,
+			PTS:         pts,
+			Timebase:    timebase,
+			SampleFreq:  timebase,
+			NumChannels: audioChannels(s.ct.SpecData),
+			Duration:    sampleDur,
+			WallclockMS: pts * 1000 / timebase,
 		}
-		slog.Debug("moqmi: published video group", "track", moqmiTrackName,
-			"group", groupNr, "objects", gopLen)
-		groupNr++
+		headers := moqmi.AudioOpusHeaders(meta)
+		if s.mediaType == moqmi.MediaTypeAudioAACLC {
+			headers = moqmi.AudioAACHeaders(meta)
+		}
+		_, origNr := s.ct.CalcSample(sampleNr)
+		objects = append(objects, mediaObject{headers: headers, payload: s.ct.Samples[origNr].Data})
 	}
+	return objects, nil
 }
 
-func publishMoqMIAudio(ctx context.Context, publisher moqtransport.Publisher,
-	ct *internal.ContentTrack, mediaType uint64, moqmiTrackName string) {
+// moqmiLiveEdge returns the location of the latest moq-mi object of ct
+// published at nowMS, or nil if there is none, and the location of the next
+// object. Video groups are GOPs of one object per sample, and audio groups
+// are single frames.
+func moqmiLiveEdge(ct *internal.ContentTrack, nowMS int64) (largest *moqtransport.Location, next moqtransport.Location) {
 	timebase := uint64(ct.TimeScale)
 	sampleDur := uint64(ct.SampleDur)
 	if sampleDur == 0 {
-		slog.Error("moqmi: zero sample duration", "track", moqmiTrackName)
-		return
-	}
-
-	// Start on the next audio-frame boundary at or after now.
-	now := time.Now().UnixMilli()
-	framePTSUnits := uint64(now) * timebase / 1000
-	frameNr := framePTSUnits / sampleDur
-	if framePTSUnits%sampleDur != 0 {
-		frameNr++
+		return nil, next
 	}
+	nowUnits := uint64(nowMS) * timebase / 1000
+	sampleNr := nowUnits / sampleDur
+	groupLen := uint64(moqmiObjectsInGroup(ct)(0))
+	largest = &moqtransport.Location{Group: sampleNr / groupLen, Object: sampleNr % groupLen}
+	return largest, moqtransport.Location{Group: largest.Group, Object: largest.Object + 1}
+}
 
-	slog.Info("moqmi: publishing audio track",
-		"track", moqmiTrackName, "startFrame", frameNr,
-		"timebase", timebase, "frameDurUnits", sampleDur,
-		"mediaType", mediaType)
-
-	channels := audioChannels(ct.SpecData)
-
-	seqID := frameNr
-	for {
-		if ctx.Err() != nil {
-			return
-		}
-		pts := frameNr * sampleDur
-		ptsMS := int64(pts * 1000 / timebase)
-		waitMS := ptsMS - time.Now().UnixMilli()
-		if waitMS > 0 {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(time.Duration(waitMS) * time.Millisecond):
-			}
-		}
-		_, origNr := ct.CalcSample(frameNr)
-		sample := ct.Samples[origNr]
-
-		sg, err := publisher.OpenSubgroup(frameNr, 0, MediaPriority)
-		if err != nil {
-			slog.Error("moqmi: failed to open audio subgroup", "error", err)
-			return
-		}
-		meta := moqmi.AudioMetadata{
-			SeqID:       seqID,
-			PTS:         pts,
-			Timebase:    timebase,
-			SampleFreq:  timebase,
-			NumChannels: channels,
-			Duration:    sampleDur,
-			WallclockMS: uint64(time.Now().UnixMilli()),
-		}
-		var headers moqtransport.KVPList
-		switch mediaType {
-		case moqmi.MediaTypeAudioAACLC:
-			headers = moqmi.AudioAACHeaders(meta)
-		case moqmi.MediaTypeAudioOpus:
-			headers = moqmi.AudioOpusHeaders(meta)
-		default:
-			slog.Error("moqmi: unsupported audio media type", "type", mediaType)
-			_ = sg.Close()
-			return
-		}
-		if _, err := sg.WriteObjectWithHeaders(0, headers, sample.Data); err != nil {
-			slog.Error("moqmi: failed to write audio object",
-				"frame", frameNr, "error", err)
-			_ = sg.Close()
-			return
-		}
-		if err := sg.Close(); err != nil {
-			slog.Error("moqmi: failed to close audio subgroup", "error", err)
-			return
-		}
-		seqID++
-		frameNr++
+// moqmiObjectsInGroup returns the number of objects in a moq-mi group of ct:
+// the GOP length for video and one for audio.
+func moqmiObjectsInGroup(ct *internal.ContentTrack) func(groupNr uint64) int {
+	n := 1
+	if _, isVideo := ct.SpecData.(*internal.AVCData); isVideo && ct.GopLength > 0 {
+		n = int(ct.GopLength)
 	}
+	return func(uint64) int { return n }
 }
 
 // audioChannels returns the numeric channel count for an audio SpecData.
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport/moqmi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -97,3 +98,51 @@ func TestAudioChannels(t *testing.T) {
 	require.NotNil(t, avc)
 	assert.Equal(t, uint64(0), audioChannels(avc.SpecData))
 }
+
+func TestMoqMISource(t *testing.T) {
+	asset, err := internal.LoadAsset("../../assets/test10s", 1, 1)
+	require.NoError(t, err)
+	video := asset.GetTrackByName("video_400kbps_avc")
+	require.NotNil(t, video)
+	audio := asset.GetTrackByName("audio_monotonic_128kbps_aac")
+	require.NotNil(t, audio)
+	const groupNr = 1000
+
+	src, err := newMoqMISource(video)
+	require.NoError(t, err)
+	gopLen := uint64(video.GopLength)
+	times := src.objectTimesMS(groupNr)
+	require.Len(t, times, int(gopLen))
+	objects, err := src.genGroup(groupNr)
+	require.NoError(t, err)
+	require.Len(t, objects, int(gopLen))
+	for i, o := range objects {
+		sampleNr := groupNr*gopLen + uint64(i)
+		meta, ok, err := moqmi.ReadVideoMetadata(o.headers)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, sampleNr, meta.SeqID)
+		assert.Equal(t, uint64(times[i]), meta.WallclockMS)
+		_, hasExtradata := moqmi.ReadVideoExtradata(o.headers)
+		assert.Equal(t, i == 0, hasExtradata, "object %d", i)
+		data, err := video.SampleData(sampleNr)
+		require.NoError(t, err)
+		assert.Equal(t, data, o.payload)
+	}
+
+	src, err = newMoqMISource(audio)
+	require.NoError(t, err)
+	times = src.objectTimesMS(groupNr)
+	require.Len(t, times, 1)
+	objects, err = src.genGroup(groupNr)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	meta, ok, err := moqmi.ReadAudioAACMetadata(objects[0].headers)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uint64(groupNr), meta.SeqID)
+	assert.Equal(t, uint64(groupNr)*uint64(audio.SampleDur)*1000/uint64(audio.TimeScale), meta.WallclockMS)
+
+	_, err = newMoqMISource(asset.GetTrackByName("video_400kbps_hevc"))
+	assert.ErrorContains(t, err, "unsupported codec")
+}
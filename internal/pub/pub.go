@@ -2,10 +2,11 @@ package pub
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Eyevinn/moqlivemock/internal"
@@ -27,51 +28,118 @@ type NamespaceEntry struct {
 	// (e.g. "video0", "audio0") to asset track names. moqmi has no catalog, so
 	// this map provides the server-side binding to real asset tracks.
 	MoqMITracks MoqMITrackMap
+	// Live, if set, is the live catalog served on the catalog track. When nil,
+	// the Handler creates one from Catalog on first use. It must be set before
+	// the Handler is used.
+	Live *LiveCatalog
+	// Asset, if set, is the asset with the tracks of the namespace. When nil,
+	// the Handler's Asset is used.
+	Asset *internal.Asset
+	// Datagrams, if set, selects the tracks of the namespace sent as
+	// datagrams instead of the Handler's Datagrams.
+	Datagrams *DatagramTracks
 }
 
 // Handler handles MoQ publisher sessions. It serves catalogs and publishes
 // media tracks (video, audio, subtitles) to subscribers across multiple namespaces.
+// Each namespace can serve its own asset.
 type Handler struct {
 	Namespaces []NamespaceEntry
-	Asset      *internal.Asset
+	Asset      *internal.Asset // default asset of namespaces without their own
 	Logfh      io.Writer
+	// FetchWindow is how far back in time media FETCH can reach.
+	// Zero means DefaultFetchWindow.
+	FetchWindow time.Duration
+	// Faults, if set, injects faults into all published media groups.
+	// It can be changed at runtime with SetFaults.
+	Faults *FaultConfig
+	// Protocols are the application protocols offered to the peer when the
+	// Handler dials out, e.g. to a relay.
+	Protocols []string
+	// Metrics, if set, collects metrics of sessions, subscriptions and FETCH.
+	Metrics *Metrics
+	// GroupCache, if set, caches the generated CMAF and LOCMAF groups, so
+	// that they are shared by all subscriptions and FETCHes of a track.
+	GroupCache *internal.GroupCache
+	// Scheduler paces the media objects of all subscriptions. If nil, a
+	// Scheduler is created on first use.
+	Scheduler *Scheduler
+	// Datagrams, if set, selects the media tracks whose objects are sent as
+	// OBJECT_DATAGRAMs instead of on subgroup streams.
+	Datagrams *DatagramTracks
+	// AnnounceOnRequest, if set, announces namespaces only when they match a
+	// SUBSCRIBE_NAMESPACE prefix of the peer, instead of all at session start.
+	AnnounceOnRequest bool
+	// ClearKey, if set, is the license server whose policy the admin API
+	// changes.
+	ClearKey *ClearKeyServer
+
+	mu            sync.Mutex
+	live          map[*NamespaceEntry]*LiveCatalog // live catalogs created from NamespaceEntry.Catalog
+	faults        *FaultConfig                     // faults set by SetFaults
+	faultsSet     bool                             // faults replaces Faults
+	disabled      map[string]bool                  // disabled namespaces keyed by namespaceKey
+	sessions      map[uint64]*sessionInfo          // active sessions keyed by ID
+	lastSessionID uint64
+	sched         *Scheduler // Scheduler, or the one created on first use
 }
 
-// Handle runs a MoQ session on the given connection, announces all namespaces,
-// and serves subscriptions. The context controls the lifetime of publishing goroutines.
-func (h *Handler) Handle(ctx context.Context, conn moqtransport.Connection) {
+// Handle runs a MoQ session on the given connection, announces all enabled
+// namespaces (or those requested by SUBSCRIBE_NAMESPACE), and serves
+// subscriptions until ctx is cancelled or the connection is closed.
+// The connection may be accepted from a subscriber or dialed to a relay.
+// Each subscription is published until it is unsubscribed, its track ends or
+// the session ends, and then ends with PUBLISH_DONE if the connection is open.
+// If the session cannot be set up or the namespaces cannot be announced, the
+// connection is closed and an error is returned, so that a relay client can
+// reconnect.
+func (h *Handler) Handle(ctx context.Context, conn moqtransport.Connection) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(conn.Context(), cancel)
+	defer stop()
+	h.Metrics.sessionStarted()
+	defer h.Metrics.sessionEnded()
+	si := h.addSession(ctx, conn)
+	defer h.removeSession(si)
 	session := &moqtransport.Session{
-		Handler:             h.getHandler(),
-		SubscribeHandler:    h.getSubscribeHandler(ctx),
-		FetchHandler:        h.getFetchHandler(),
+		Handler:             h.getHandler(si),
+		SubscribeHandler:    h.getSubscribeHandler(ctx, si),
+		FetchHandler:        h.getFetchHandler(ctx),
 		InitialMaxRequestID: 100,
+		Protocols:           h.Protocols,
 		Qlogger:             qlog.NewQLOGHandler(h.Logfh, "MoQ QLOG", "MoQ QLOG", conn.Perspective().String(), moqt.Schema),
 	}
-	slog.Info("starting MoQ session", "perspective", conn.Perspective())
+	slog.Info("starting MoQ session", "session", si.id, "perspective", conn.Perspective())
 	err := session.Run(conn)
 	if err != nil {
 		slog.Error("MoQ Session initialization failed", "error", err)
-		err = conn.CloseWithError(0, "session initialization error")
-		if err != nil {
+		if err := conn.CloseWithError(0, "session initialization error"); err != nil {
 			slog.Error("failed to close connection", "error", err)
 		}
-		return
+		return fmt.Errorf("run MoQ session: %w", err)
 	}
-	for _, ns := range h.Namespaces {
-		slog.Info("announcing namespace", "namespace", ns.Namespace)
-		if err := session.Announce(ctx, ns.Namespace); err != nil {
-			slog.Error("failed to announce namespace", "namespace", ns.Namespace, "error", err)
-			return
+	h.mu.Lock()
+	si.session = session
+	h.mu.Unlock()
+	if err := h.announceWanted(si); err != nil {
+		slog.Error("failed to announce namespace", "error", err)
+		if err := conn.CloseWithError(0, "announce failed"); err != nil {
+			slog.Error("failed to close connection", "error", err)
 		}
-		slog.Info("namespace announced successfully", "namespace", ns.Namespace)
+		return err
 	}
-	// Announce interop test namespace for moq-interop-runner compatibility
-	slog.Info("announcing interop namespace", "namespace", interopNamespace)
-	if err := session.Announce(ctx, interopNamespace); err != nil {
-		slog.Warn("failed to announce interop namespace", "error", err)
+	if !h.AnnounceOnRequest {
+		// Announce interop test namespace for moq-interop-runner compatibility
+		slog.Info("announcing interop namespace", "namespace", interopNamespace)
+		if err := session.Announce(ctx, interopNamespace); err != nil {
+			slog.Warn("failed to announce interop namespace", "error", err)
+		}
 	}
-	// Block until context is cancelled to keep the session alive
+	// Block until the context is cancelled or the connection is closed to
+	// keep the session alive
 	<-ctx.Done()
+	return nil
 }
 
 // interopNamespace is the namespace used by the moq-interop-runner test cases.
@@ -81,7 +149,7 @@ func isInteropNamespace(ns []string) bool {
 	return tupleEqual(ns, interopNamespace)
 }
 
-func (h *Handler) getHandler() moqtransport.Handler {
+func (h *Handler) getHandler(si *sessionInfo) moqtransport.Handler {
 	return moqtransport.HandlerFunc(func(w moqtransport.ResponseWriter, r *moqtransport.Message) {
 		switch r.Method {
 		case moqtransport.MessageAnnounce:
@@ -98,12 +166,26 @@ func (h *Handler) getHandler() moqtransport.Handler {
 				slog.Error("failed to reject announcement", "error", err)
 			}
 			return
+		case moqtransport.MessageSubscribeAnnounces:
+			h.subscribeNamespace(w, si, r.Namespace)
+		case moqtransport.MessageUnsubscribeAnnounces:
+			h.unsubscribeNamespace(si, r.Namespace)
 		}
 	})
 }
 
-// findNamespace returns the NamespaceEntry matching the given namespace tuple, or nil.
+// findNamespace returns the enabled NamespaceEntry matching the given
+// namespace tuple, or nil.
 func (h *Handler) findNamespace(ns []string) *NamespaceEntry {
+	if !h.namespaceEnabled(ns) {
+		return nil
+	}
+	return h.findConfiguredNamespace(ns)
+}
+
+// findConfiguredNamespace returns the NamespaceEntry matching the given
+// namespace tuple, enabled or not, or nil.
+func (h *Handler) findConfiguredNamespace(ns []string) *NamespaceEntry {
 	for i := range h.Namespaces {
 		if tupleEqual(ns, h.Namespaces[i].Namespace) {
 			return &h.Namespaces[i]
@@ -112,8 +194,68 @@ func (h *Handler) findNamespace(ns []string) *NamespaceEntry {
 	return nil
 }
 
-// locationLess reports whether a precedes b in (group, object) order.
-func locationLess(a, b moqtransport.Location) bool {
+// assetOf returns the asset serving the tracks of the namespace entry.
+func (h *Handler) assetOf(ns *NamespaceEntry) *internal.Asset {
+	if ns.Asset != nil {
+		return ns.Asset
+	}
+	return h.Asset
+}
+
+// assets returns the distinct assets of all namespaces.
+func (h *Handler) assets() []*internal.Asset {
+	var assets []*internal.Asset
+	seen := make(map[*internal.Asset]bool)
+	for i := range h.Namespaces {
+		a := h.assetOf(&h.Namespaces[i])
+		if a != nil && !seen[a] {
+			seen[a] = true
+			assets = append(assets, a)
+		}
+	}
+	if h.Asset != nil && !seen[h.Asset] {
+		assets = append(assets, h.Asset)
+	}
+	return assets
+}
+
+// scheduler returns the Scheduler, creating it if not set.
+func (h *Handler) scheduler() *Scheduler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sched == nil {
+		h.sched = h.Scheduler
+		if h.sched == nil {
+			h.sched = NewScheduler()
+		}
+	}
+	return h.sched
+}
+
+// liveCatalog returns the live catalog of the namespace entry, creating it
+// from the entry's static Catalog if needed.
+func (h *Handler) liveCatalog(ns *NamespaceEntry) (*LiveCatalog, error) {
+	if ns.Live != nil {
+		return ns.Live, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if lc := h.live[ns]; lc != nil {
+		return lc, nil
+	}
+	lc, err := NewLiveCatalog(ns.Catalog)
+	if err != nil {
+		return nil, err
+	}
+	if h.live == nil {
+		h.live = make(map[*NamespaceEntry]*LiveCatalog)
+	}
+	h.live[ns] = lc
+	return lc, nil
+}
+
+// LocationLess reports whether a precedes b in (group, object) order.
+func LocationLess(a, b moqtransport.Location) bool {
 	if a.Group != b.Group {
 		return a.Group < b.Group
 	}
@@ -124,18 +266,19 @@ func locationLess(a, b moqtransport.Location) bool {
 // [start, end) range. An EndObject of 0 means "to the end of EndGroup" per the
 // FETCH semantics (draft-ietf-moq-transport §9.16.3).
 func locationInFetchRange(loc, start, end moqtransport.Location) bool {
-	if locationLess(loc, start) {
+	if LocationLess(loc, start) {
 		return false
 	}
 	if end.Object == 0 {
 		return loc.Group <= end.Group
 	}
-	return locationLess(loc, end)
+	return LocationLess(loc, end)
 }
 
-func (h *Handler) getFetchHandler() moqtransport.FetchHandler {
+func (h *Handler) getFetchHandler(ctx context.Context) moqtransport.FetchHandler {
 	return moqtransport.FetchHandlerFunc(
 		func(w *moqtransport.FetchResponseWriter, m *moqtransport.FetchMessage) {
+			h.Metrics.fetchReceived(m.Namespace, m.Track)
 			nsEntry := h.findNamespace(m.Namespace)
 			if nsEntry == nil {
 				slog.Warn("fetch: unknown namespace", "received", m.Namespace)
@@ -153,14 +296,19 @@ func (h *Handler) getFetchHandler() moqtransport.FetchHandler {
 				}
 				return
 			}
-			if m.Track != "catalog" {
-				err := w.Reject(uint64(moqtransport.ErrorCodeFetchTrackDoesNotExist), "only catalog is fetchable")
-				if err != nil {
+			lc, err := h.liveCatalog(nsEntry)
+			if err != nil {
+				slog.Error("failed to create live catalog", "error", err)
+				if err := w.Reject(uint64(moqtransport.ErrorCodeFetchInternal), "internal error"); err != nil {
 					slog.Error("failed to reject fetch", "error", err)
 				}
 				return
 			}
-			err := w.Accept()
+			if m.Track != "catalog" {
+				h.handleMediaFetch(ctx, w, m, nsEntry, lc)
+				return
+			}
+			err = w.Accept()
 			if err != nil {
 				slog.Error("failed to accept fetch", "error", err)
 				return
@@ -170,29 +318,26 @@ func (h *Handler) getFetchHandler() moqtransport.FetchHandler {
 				slog.Error("failed to get fetch stream", "error", err)
 				return
 			}
-			// The catalog is a single object at {group:0, object:0}. Honor the
-			// requested range (resolved by the transport for joining fetches):
-			// serve the object only when [StartLocation, EndLocation) covers
-			// {0,0}; any other group or object yields an empty response. This is
-			// what a relative joining FETCH (offset 0) against the catalog's
-			// largest location {0,0} asks for.
-			catalogLoc := moqtransport.Location{Group: 0, Object: 0}
-			if locationInFetchRange(catalogLoc, m.StartLocation, m.EndLocation) {
-				catalogJSON, err := json.Marshal(nsEntry.Catalog)
-				if err != nil {
-					slog.Error("failed to marshal catalog", "error", err)
-					return
+			// Only the current catalog group is retained: a full catalog at
+			// object 0 followed by deltas. Serve the objects that fall in the
+			// requested [StartLocation, EndLocation) range (resolved by the
+			// transport for joining fetches); a relative joining FETCH with
+			// offset 0 asks for the whole current group.
+			groupID, objects, _ := lc.snapshot()
+			served := 0
+			for objectID, payload := range objects {
+				loc := moqtransport.Location{Group: groupID, Object: uint64(objectID)}
+				if !locationInFetchRange(loc, m.StartLocation, m.EndLocation) {
+					continue
 				}
-				if _, err = fs.WriteObject(0, 0, 0, 0, catalogJSON); err != nil {
+				if _, err = fs.WriteObject(groupID, 0, uint64(objectID), 0, payload); err != nil {
 					slog.Error("failed to write catalog via fetch", "error", err)
 					return
 				}
-				slog.Info("served catalog via FETCH", "namespace", m.Namespace,
-					"fetchType", m.FetchType, "start", m.StartLocation, "end", m.EndLocation)
-			} else {
-				slog.Info("FETCH range excludes catalog object {0,0}; empty response",
-					"namespace", m.Namespace, "start", m.StartLocation, "end", m.EndLocation)
+				served++
 			}
+			slog.Info("served catalog via FETCH", "namespace", m.Namespace, "objects", served,
+				"fetchType", m.FetchType, "start", m.StartLocation, "end", m.EndLocation)
 			if err = fs.Close(); err != nil {
 				slog.Error("failed to close fetch stream", "error", err)
 				return
@@ -200,7 +345,66 @@ func (h *Handler) getFetchHandler() moqtransport.FetchHandler {
 		})
 }
 
-func (h *Handler) getSubscribeHandler(ctx context.Context) moqtransport.SubscribeHandler {
+// datagrams reports whether the track trackName of ns with content type
+// contentType is sent as datagrams.
+func (h *Handler) datagrams(ns *NamespaceEntry, trackName, contentType string) bool {
+	dt := h.Datagrams
+	if ns.Datagrams != nil {
+		dt = ns.Datagrams
+	}
+	return dt.selects(trackName, contentType)
+}
+
+// mediaPublisher returns the publisher for media published to w, which
+// sends datagrams if datagrams is set and otherwise injects the current
+// faults, and is metered if tm is not nil.
+func (h *Handler) mediaPublisher(w *moqtransport.SubscribeResponseWriter, tm *trackMetrics,
+	datagrams bool) moqtransport.Publisher {
+	var p moqtransport.Publisher = &faultPublisher{Publisher: w, faults: h.currentFaults}
+	if datagrams {
+		p = &datagramPublisher{Publisher: w}
+	}
+	if tm != nil {
+		p = &meteredPublisher{Publisher: p, tm: tm}
+	}
+	return p
+}
+
+// publish publishes src to the subscription m of si from rng.Start, with
+// the media publisher sending datagrams if datagrams is set. The
+// subscriptions of a track share a trackFeed of the Scheduler. The
+// subscription is metered and listed among the subscriptions of si while it
+// runs, and ends with PUBLISH_DONE when its context is done, its range has
+// been published or publishing fails. If lc is not nil, the subscription ends
+// when the track is removed from lc.
+func (h *Handler) publish(ctx context.Context, w *moqtransport.SubscribeResponseWriter,
+	m *moqtransport.SubscribeMessage, si *sessionInfo, packaging string, datagrams bool,
+	rng SubscribeRange, lc *LiveCatalog, src mediaSource) {
+	tm := h.Metrics.subscriptionStarted(m.Namespace, m.Track, packaging)
+	remove := h.addSubscription(si, m, packaging, rng.Start)
+	s := newSubscription(ctx, w.Context(), h.mediaPublisher(w, tm, datagrams), si.conn, m.Track)
+	var faults func() *FaultConfig
+	if !datagrams {
+		faults = h.currentFaults
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%t", namespaceKey(m.Namespace), m.Track, datagrams)
+	h.scheduler().publish(key, m.Track, src, faults, lc, &feedSubscription{
+		ctx:    s.ctx,
+		cancel: s.cancel,
+		p:      s,
+		rng:    rng,
+		stopped: func() {
+			go func() {
+				defer remove()
+				defer tm.subscriptionEnded()
+				defer s.cancel(nil)
+				s.end()
+			}()
+		},
+	})
+}
+
+func (h *Handler) getSubscribeHandler(ctx context.Context, si *sessionInfo) moqtransport.SubscribeHandler {
 	return moqtransport.SubscribeHandlerFunc(
 		func(w *moqtransport.SubscribeResponseWriter, m *moqtransport.SubscribeMessage) {
 			// Accept interop test subscriptions (control-plane only, no media)
@@ -239,91 +443,163 @@ func (h *Handler) getSubscribeHandler(ctx context.Context) moqtransport.Subscrib
 					}
 					return
 				}
-				if err := w.Accept(); err != nil {
-					slog.Error("failed to accept moq-mi subscription", "error", err)
+				ct := h.assetOf(nsEntry).GetTrackByName(assetTrack)
+				if ct == nil {
+					err := w.Reject(moqtransport.ErrorCodeSubscribeTrackDoesNotExist,
+						"unknown moq-mi track")
+					if err != nil {
+						slog.Error("failed to reject moq-mi subscription", "error", err)
+					}
 					return
 				}
+				src, err := newMoqMISource(ct)
+				if err != nil {
+					slog.Error("moqmi: cannot publish track", "track", m.Track, "error", err)
+					if err := w.Reject(moqtransport.ErrorCodeSubscribeInternal, "internal error"); err != nil {
+						slog.Error("failed to reject moq-mi subscription", "error", err)
+					}
+					return
+				}
+				largest, next := moqmiLiveEdge(ct, time.Now().UnixMilli())
+				rng, ok := acceptSubscription(w, m, largest, next, moqmiObjectsInGroup(ct))
+				if !ok {
+					return
+				}
+				datagrams := h.datagrams(nsEntry, m.Track, ct.ContentType)
 				slog.Info("got moq-mi subscription", "track", m.Track,
-					"assetTrack", assetTrack, "namespace", m.Namespace)
-				go PublishMoqMITrack(ctx, w, h.Asset, assetTrack, m.Track)
+					"assetTrack", assetTrack, "namespace", m.Namespace, "start", rng.Start, "datagrams", datagrams)
+				h.publish(ctx, w, m, si, nsEntry.Packaging, datagrams, rng, nil, src)
+				return
+			}
+			lc, err := h.liveCatalog(nsEntry)
+			if err != nil {
+				slog.Error("failed to create live catalog", "error", err)
+				if err := w.Reject(moqtransport.ErrorCodeSubscribeInternal, "internal error"); err != nil {
+					slog.Error("failed to reject subscription", "error", err)
+				}
 				return
 			}
 			if m.Track == "catalog" {
 				// Advertise the catalog's largest location so subscribers can
 				// resolve a relative Joining FETCH (offset 0) against this
-				// subscription per MSF draft-01 §5. The catalog is a single full
-				// object at {group:0, object:0}. We still write object 0 on the
-				// subscription below for backward compatibility with
-				// subscribe-only clients; joining clients dedupe it against the
-				// FETCH (objects <= largest are skipped on the subscription).
-				err := w.Accept(moqtransport.WithLargestLocation(&moqtransport.Location{Group: 0, Object: 0}))
+				// subscription per MSF draft-01 §5. PublishCatalog replays the
+				// current group from object 0 for subscribe-only clients;
+				// joining clients dedupe those objects against the FETCH
+				// (objects <= largest are skipped on the subscription). Later
+				// delta updates and new full-catalog groups follow live.
+				largest := lc.Largest()
+				err := w.Accept(moqtransport.WithLargestLocation(&largest))
 				if err != nil {
 					slog.Error("failed to accept subscription", "error", err)
 					return
 				}
-				sg, err := w.OpenSubgroup(0, 0, 0)
-				if err != nil {
-					slog.Error("failed to open subgroup", "error", err)
-					return
-				}
-				json, err := json.Marshal(nsEntry.Catalog)
-				if err != nil {
-					slog.Error("failed to marshal catalog", "error", err)
-					return
-				}
-				_, err = sg.WriteObject(0, json)
-				if err != nil {
-					slog.Error("failed to write catalog", "error", err)
-					return
-				}
-				err = sg.Close()
-				if err != nil {
-					slog.Error("failed to close subgroup", "error", err)
-					return
-				}
+				s := newSubscription(ctx, w.Context(), w, si.conn, m.Track)
+				go s.run(func(ctx context.Context, p moqtransport.Publisher) {
+					PublishCatalog(ctx, p, lc)
+				})
 				return
 			}
+			asset := h.assetOf(nsEntry)
 			// Check for subtitle tracks first
-			if st := h.Asset.GetSubtitleTrackByName(m.Track); st != nil {
-				err := w.Accept()
-				if err != nil {
-					slog.Error("failed to accept subscription", "error", err)
+			if st := asset.GetSubtitleTrackByName(m.Track); st != nil {
+				src := subtitleSource{st: st}
+				largest, next := liveEdge(src, time.Now().UnixMilli())
+				rng, ok := acceptSubscription(w, m, largest, next, objectsInGroup(src))
+				if !ok {
 					return
 				}
-				slog.Info("got subtitle subscription", "track", st.Name, "namespace", m.Namespace)
-				go PublishSubtitleTrack(ctx, w, st)
+				datagrams := h.datagrams(nsEntry, st.Name, "subtitle")
+				slog.Info("got subtitle subscription", "track", st.Name, "namespace", m.Namespace,
+					"start", rng.Start, "datagrams", datagrams)
+				h.publish(ctx, w, m, si, nsEntry.Packaging, datagrams, rng, nil, src)
 				return
 			}
 
-			// Check for video/audio tracks in this namespace's catalog
-			for _, track := range nsEntry.Catalog.Tracks {
+			// Check for video/audio tracks in the namespace's current catalog.
+			// Cloned tracks are served with their parent's media.
+			for _, track := range lc.Catalog().Tracks {
 				if m.Track == track.Name {
-					err := w.Accept()
-					if err != nil {
-						slog.Error("failed to accept subscription", "error", err)
+					src := h.mediaSourceFor(nsEntry, lc, track.Name)
+					if src == nil {
+						break
+					}
+					largest, next := liveEdge(src, time.Now().UnixMilli())
+					rng, ok := acceptSubscription(w, m, largest, next, objectsInGroup(src))
+					if !ok {
 						return
 					}
+					contentName := lc.ContentTrackName(track.Name)
+					var contentType string
+					if ct := asset.GetTrackByName(strings.TrimSuffix(contentName, internal.LocmafTrackSuffix)); ct != nil {
+						contentType = ct.ContentType
+					}
+					datagrams := h.datagrams(nsEntry, track.Name, contentType)
 					slog.Info("got subscription", "track", track.Name, "namespace", m.Namespace,
-						"packaging", nsEntry.Packaging)
+						"packaging", nsEntry.Packaging, "start", rng.Start, "datagrams", datagrams)
+					packaging := track.Packaging
 					if nsEntry.Packaging == "loc" {
-						go PublishLOCTrack(ctx, w, h.Asset, track.Name)
-					} else {
-						go PublishTrack(ctx, w, h.Asset, track.Name, track.Packaging)
+						packaging = "loc"
 					}
+					h.publish(ctx, w, m, si, packaging, datagrams, rng, lc, src)
 					return
 				}
 			}
 			// If we get here, the track was not found
-			err := w.Reject(moqtransport.ErrorCodeSubscribeTrackDoesNotExist, "unknown track")
+			err = w.Reject(moqtransport.ErrorCodeSubscribeTrackDoesNotExist, "unknown track")
 			if err != nil {
 				slog.Error("failed to reject subscription", "error", err)
 			}
 		})
 }
 
-// PublishTrack publishes media track data in MoQ groups, pacing delivery to wall-clock time.
-func PublishTrack(ctx context.Context, publisher moqtransport.Publisher,
-	asset *internal.Asset, trackName, packaging string) {
+// handleMediaFetch serves a FETCH of a media track: all objects in the
+// requested range that have already been published, as far back as the
+// FETCH window allows.
+func (h *Handler) handleMediaFetch(ctx context.Context, w *moqtransport.FetchResponseWriter,
+	m *moqtransport.FetchMessage, nsEntry *NamespaceEntry, lc *LiveCatalog) {
+	src := fetchSource(h.mediaSourceFor(nsEntry, lc, m.Track))
+	if src == nil {
+		err := w.Reject(uint64(moqtransport.ErrorCodeFetchTrackDoesNotExist), "unknown track")
+		if err != nil {
+			slog.Error("failed to reject fetch", "error", err)
+		}
+		return
+	}
+	if m.EndLocation.Group < m.StartLocation.Group ||
+		m.EndLocation.Object != 0 && !LocationLess(m.StartLocation, m.EndLocation) {
+		err := w.Reject(uint64(moqtransport.ErrorCodeFetchInvalidRange), "end before start")
+		if err != nil {
+			slog.Error("failed to reject fetch", "error", err)
+		}
+		return
+	}
+	first, last, ok := fetchGroupRange(src, m.StartLocation, m.EndLocation, time.Now().UnixMilli(), h.fetchWindow())
+	if !ok {
+		err := w.Reject(uint64(moqtransport.ErrorCodeFetchNoObjects), "no published objects in range")
+		if err != nil {
+			slog.Error("failed to reject fetch", "error", err)
+		}
+		return
+	}
+	if err := w.Accept(); err != nil {
+		slog.Error("failed to accept fetch", "error", err)
+		return
+	}
+	fs, err := w.FetchStream()
+	if err != nil {
+		slog.Error("failed to get fetch stream", "error", err)
+		return
+	}
+	slog.Info("got media FETCH", "track", m.Track, "namespace", m.Namespace,
+		"fetchType", m.FetchType, "start", m.StartLocation, "end", m.EndLocation)
+	go serveMediaFetch(ctx, fs, src, m.Track, first, last, m.StartLocation, m.EndLocation)
+}
+
+// PublishTrack publishes media track data in MoQ groups, pacing delivery to wall-clock time with sched.
+// The groups are taken from cache. Both may be nil.
+// Publishing starts at rng.Start and, for a bounded range, ends with PUBLISH_DONE after rng.EndGroup.
+func PublishTrack(ctx context.Context, publisher moqtransport.Publisher, asset *internal.Asset,
+	cache *internal.GroupCache, sched *Scheduler, trackName, packaging string, rng SubscribeRange) {
 
 	// LOCMAF variant tracks in a unified CMSF catalog are named
 	// <contentTrack>_locmaf; strip the suffix to find the content track.
@@ -333,38 +609,29 @@ func PublishTrack(ctx context.Context, publisher moqtransport.Publisher,
 		slog.Error("track not found", "track", trackName)
 		return
 	}
-	now := time.Now().UnixMilli()
-	currGroupNr := internal.CurrMoQGroupNr(ct, uint64(now), internal.MoqGroupDurMS)
-	groupNr := currGroupNr + 1 // Start stream on next group
-	slog.Info("publishing track", "track", trackName, "group", groupNr)
-	for {
-		if ctx.Err() != nil {
-			return
-		}
-		sg, err := publisher.OpenSubgroup(groupNr, 0, MediaPriority)
-		if err != nil {
-			slog.Error("failed to open subgroup", "error", err)
-			return
-		}
-		mg, err := internal.GenMoQGroup(ct, groupNr, ct.SampleBatch, internal.MoqGroupDurMS, packaging)
-		if err != nil {
-			slog.Error("failed to generate MoQ group", "track", ct.Name, "group", groupNr, "error", err)
-			return
-		}
-		slog.Info("writing MoQ group", "track", ct.Name, "group", groupNr, "objects", len(mg.MoQObjects))
-		err = internal.WriteMoQGroup(ctx, ct, mg, sg.WriteObject)
-		if err != nil {
-			slog.Error("failed to write MoQ group", "error", err)
-			return
-		}
-		err = sg.Close()
-		if err != nil {
-			slog.Error("failed to close subgroup", "error", err)
-			return
-		}
-		slog.Debug("published MoQ group", "track", ct.Name, "group", groupNr, "objects", len(mg.MoQObjects))
-		groupNr++
+	src := cmafSource{asset: asset, ct: ct, packaging: packaging, cache: cache}
+	publishSource(ctx, publisher, sched, trackName, src, rng)
+}
+
+// publishSource publishes src as the track trackName to publisher, pacing
+// delivery to wall-clock time with sched, which may be nil. It returns when
+// ctx is done, the range has been published or publishing fails.
+func publishSource(ctx context.Context, publisher moqtransport.Publisher, sched *Scheduler, trackName string,
+	src mediaSource, rng SubscribeRange) {
+	if sched == nil {
+		sched = NewScheduler()
 	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stopped := make(chan struct{})
+	sched.publish("", trackName, src, nil, nil, &feedSubscription{
+		ctx:     ctx,
+		cancel:  cancel,
+		p:       publisher,
+		rng:     rng,
+		stopped: func() { close(stopped) },
+	})
+	<-stopped
 }
 
 // LOC extension header property IDs from draft-ietf-moq-loc-02 §2.3.1.
@@ -373,181 +640,75 @@ const (
 )
 
 // PublishLOCTrack publishes LOC media track data (one raw frame per object) in MoQ groups,
-// pacing delivery to wall-clock time. Each object carries a LOC Timestamp property
+// pacing delivery to wall-clock time with sched, which may be nil. Each object carries a LOC Timestamp property
 // (draft-ietf-moq-loc-02 §2.3.1.1) with the sample presentation time in microseconds
-// since the Unix epoch.
-func PublishLOCTrack(ctx context.Context, publisher moqtransport.Publisher, asset *internal.Asset, trackName string) {
+// since the Unix epoch. Publishing starts at rng.Start and, for a bounded range, ends with
+// PUBLISH_DONE after rng.EndGroup.
+func PublishLOCTrack(ctx context.Context, publisher moqtransport.Publisher, asset *internal.Asset,
+	sched *Scheduler, trackName string, rng SubscribeRange) {
 	ct := asset.GetTrackByName(trackName)
 	if ct == nil {
 		slog.Error("track not found", "track", trackName)
 		return
 	}
-	timebase := uint64(ct.TimeScale)
-	sampleDur := uint64(ct.SampleDur)
-	if timebase == 0 || sampleDur == 0 {
-		slog.Error("LOC: invalid track timing", "track", trackName, "timescale", timebase, "sampleDur", sampleDur)
+	if ct.TimeScale == 0 || ct.SampleDur == 0 {
+		slog.Error("LOC: invalid track timing", "track", trackName, "timescale", ct.TimeScale,
+			"sampleDur", ct.SampleDur)
 		return
 	}
+	publishSource(ctx, publisher, sched, trackName, locSource{ct: ct, videoConfig: locVideoConfig(ct)}, rng)
+}
 
-	var videoConfig []byte
+// locVideoConfig returns the decoder configuration that is prepended to LOC
+// video keyframes, or nil if none is needed.
+func locVideoConfig(ct *internal.ContentTrack) []byte {
 	switch sd := ct.SpecData.(type) {
 	case *internal.AVCData:
-		videoConfig = sd.GenLOCVideoConfig()
+		return sd.GenLOCVideoConfig()
 	case *internal.HEVCData:
-		videoConfig = sd.GenLOCVideoConfig()
+		return sd.GenLOCVideoConfig()
 	case *internal.AV1Data:
 		// nil when keyframes already carry the sequence header OBU in-band
 		// (SVT-AV1/ffmpeg), otherwise the sequence header OBU to prepend.
-		videoConfig = sd.GenLOCVideoConfig()
-	}
-
-	now := time.Now().UnixMilli()
-	currGroupNr := internal.CurrMoQGroupNr(ct, uint64(now), internal.MoqGroupDurMS)
-	groupNr := currGroupNr + 1 // Start stream on next group
-	slog.Info("publishing LOC track", "track", trackName, "group", groupNr)
-	for {
-		if ctx.Err() != nil {
-			return
-		}
-		sg, err := publisher.OpenSubgroup(groupNr, 0, MediaPriority)
-		if err != nil {
-			slog.Error("failed to open subgroup", "error", err)
-			return
-		}
-		startNr, endNr := internal.CalcLOCGroupRange(ct, groupNr, internal.MoqGroupDurMS)
-		slog.Info("writing LOC group", "track", ct.Name, "group", groupNr, "objects", endNr-startNr)
-		objectID := uint64(0)
-		for sampleNr := startNr; sampleNr < endNr; sampleNr++ {
-			if ctx.Err() != nil {
-				_ = sg.Close()
-				return
-			}
-			_, origNr := ct.CalcSample(sampleNr)
-			sample := ct.Samples[origNr]
-
-			sampleTime := sampleNr * sampleDur
-			objTimeMS := int64(sampleTime * 1000 / timebase)
-			waitMS := objTimeMS - time.Now().UnixMilli()
-			if waitMS > 0 {
-				select {
-				case <-ctx.Done():
-					_ = sg.Close()
-					return
-				case <-time.After(time.Duration(waitMS) * time.Millisecond):
-				}
-			}
-
-			var payload []byte
-			if videoConfig != nil && sample.IsSync() {
-				payload = make([]byte, 0, len(videoConfig)+len(sample.Data))
-				payload = append(payload, videoConfig...)
-				payload = append(payload, sample.Data...)
-			} else {
-				payload = sample.Data
-			}
-
-			// Compute sampleTime * 1_000_000 / timebase without uint64 overflow.
-			// sampleTime can reach ~1.8e15 for wall-clock-anchored live streams, so a
-			// naive multiply overflows; split into quotient and fractional microseconds.
-			timestampUs := (sampleTime/timebase)*1_000_000 + (sampleTime%timebase)*1_000_000/timebase
-			headers := moqtransport.KVPList{
-				{Type: locPropTimestamp, ValueVarInt: timestampUs},
-			}
-			if _, err := sg.WriteObjectWithHeaders(objectID, headers, payload); err != nil {
-				slog.Error("failed to write LOC object", "track", ct.Name, "group", groupNr,
-					"object", objectID, "error", err)
-				_ = sg.Close()
-				return
-			}
-			objectID++
-		}
-		if err := sg.Close(); err != nil {
-			slog.Error("failed to close subgroup", "error", err)
-			return
-		}
-		slog.Debug("published LOC group", "track", ct.Name, "group", groupNr, "objects", objectID)
-		groupNr++
+		return sd.GenLOCVideoConfig()
 	}
+	return nil
 }
 
-// PublishSubtitleTrack publishes subtitle track data in MoQ groups, pacing delivery to wall-clock time.
-func PublishSubtitleTrack(ctx context.Context, publisher moqtransport.Publisher, st *internal.SubtitleTrack) {
-	now := time.Now().UnixMilli()
-	currGroupNr := internal.CurrSubtitleGroupNr(uint64(now), internal.MoqGroupDurMS)
-	groupNr := currGroupNr + 1 // Start stream on next group
-	slog.Info("publishing subtitle track", "track", st.Name, "group", groupNr)
-
-	for {
-		if ctx.Err() != nil {
-			return
-		}
-
-		sg, err := publisher.OpenSubgroup(groupNr, 0, MediaPriority)
-		if err != nil {
-			slog.Error("failed to open subgroup for subtitle", "error", err)
-			return
-		}
-
-		mg, err := internal.GenSubtitleGroup(st, groupNr, internal.MoqGroupDurMS)
-		if err != nil {
-			slog.Error("failed to generate subtitle group", "error", err)
-			return
-		}
-
-		slog.Info("writing MoQ subtitle group", "track", st.Name, "group", groupNr, "objects", len(mg.MoQObjects))
-
-		// Subtitle groups have 1 object - write it with proper timing
-		err = WriteSubtitleGroup(ctx, mg, groupNr, sg.WriteObject)
-		if err != nil {
-			slog.Error("failed to write subtitle MoQ group", "error", err)
-			return
-		}
-
-		err = sg.Close()
-		if err != nil {
-			slog.Error("failed to close subtitle subgroup", "error", err)
-			return
-		}
+// locObject returns the LOC properties and payload of the object carrying
+// sample sampleNr. videoConfig, if non-nil, is prepended to keyframes.
+func locObject(ct *internal.ContentTrack, videoConfig []byte, sampleNr uint64) (moqtransport.KVPList, []byte,
+	error) {
+	timebase := uint64(ct.TimeScale)
+	sampleTime := sampleNr * uint64(ct.SampleDur)
+	_, origNr := ct.CalcSample(sampleNr)
+	data, err := ct.SampleData(sampleNr)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload := data
+	if videoConfig != nil && ct.Samples[origNr].IsSync() {
+		payload = make([]byte, 0, len(videoConfig)+len(data))
+		payload = append(payload, videoConfig...)
+		payload = append(payload, data...)
+	}
 
-		slog.Debug("published subtitle MoQ group", "track", st.Name, "group", groupNr)
-		groupNr++
+	// Compute sampleTime * 1_000_000 / timebase without uint64 overflow.
+	// sampleTime can reach ~1.8e15 for wall-clock-anchored live streams, so a
+	// naive multiply overflows; split into quotient and fractional microseconds.
+	timestampUs := (sampleTime/timebase)*1_000_000 + (sampleTime%timebase)*1_000_000/timebase
+	headers := moqtransport.KVPList{
+		{Type: locPropTimestamp, ValueVarInt: timestampUs},
 	}
+	return headers, payload, nil
 }
 
-// WriteSubtitleGroup writes subtitle objects with appropriate timing.
-func WriteSubtitleGroup(ctx context.Context, moq *internal.MoQGroup, groupNr uint64, cb internal.ObjectWriter) error {
-	// Calculate when this group should be sent (at the start of the group)
-	groupStartTimeMS := int64(groupNr * uint64(internal.MoqGroupDurMS))
-
-	for nr, moqObj := range moq.MoQObjects {
-		if ctx.Err() != nil {
-			return ctx.Err()
-		}
-
-		now := time.Now().UnixMilli()
-		waitTime := groupStartTimeMS - now
-
-		if waitTime <= 0 {
-			// Already past time, send immediately
-			_, err := cb(uint64(nr), moqObj)
-			if err != nil {
-				return err
-			}
-			continue
-		}
-
-		// Wait until the start of the group period
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(time.Duration(waitTime) * time.Millisecond):
-			_, err := cb(uint64(nr), moqObj)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+// PublishSubtitleTrack publishes subtitle track data in MoQ groups, pacing delivery to wall-clock time
+// with sched, which may be nil.
+// Publishing starts at rng.Start and, for a bounded range, ends with PUBLISH_DONE after rng.EndGroup.
+func PublishSubtitleTrack(ctx context.Context, publisher moqtransport.Publisher, st *internal.SubtitleTrack,
+	sched *Scheduler, rng SubscribeRange) {
+	publishSource(ctx, publisher, sched, st.Name, subtitleSource{st: st}, rng)
 }
 
 func tupleEqual(a, b []string) bool {
@@ -0,0 +1,225 @@
+package pub
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+)
+
+// wheelSlots is the number of one-millisecond slots of the timing wheel of
+// a Scheduler, which spans a bit more than a second.
+const wheelSlots = 1024
+
+// Scheduler paces the media objects of all subscriptions to wall-clock
+// time. The subscriptions of a track share a trackFeed, whose goroutine
+// queues each object for the writers of all of them when it is due, so a
+// track takes one pending tick however many subscriptions it has. The feeds
+// wait for the due times of their objects on a timing wheel with
+// one-millisecond slots, run by a single goroutine that sleeps until the
+// earliest pending tick and then wakes up all feeds waiting for it at once,
+// so there is a single timer. The goroutine of the Scheduler only runs while
+// ticks are waited for.
+//
+// A nil *Scheduler waits with a timer per call.
+type Scheduler struct {
+	mu      sync.Mutex
+	slots   [wheelSlots][]*tick // pending ticks by due time modulo wheelSlots
+	ticks   map[int64]*tick     // pending ticks by due time
+	cursor  int64               // time up to which the ticks have been released
+	running bool                // the goroutine runs
+	wake    chan struct{}       // signals a tick earlier than the next wake-up
+	nextMS  int64               // due time of the next wake-up
+	stats   SchedulerStats
+
+	feedMu sync.Mutex
+	feeds  map[string]*trackFeed // feeds of shared tracks by key
+}
+
+// tick is the due time of objects, whose channel is closed when it is due.
+type tick struct {
+	dueMS   int64
+	ch      chan struct{}
+	waiters int
+}
+
+// SchedulerStats are the counters of a Scheduler.
+type SchedulerStats struct {
+	Waits   uint64 // waits for a due time by feeds and calls to Wait
+	Feeds   int    // tracks whose subscriptions share a feed
+	Ticks   uint64 // distinct due times waited for
+	Wakeups uint64 // wake-ups of the goroutine
+}
+
+// NewScheduler returns a Scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		ticks:  make(map[int64]*tick),
+		cursor: time.Now().UnixMilli(),
+		wake:   make(chan struct{}, 1),
+		feeds:  make(map[string]*trackFeed),
+	}
+}
+
+// Wait waits until the wall-clock time atMS in milliseconds since the
+// epoch, and returns the error of ctx if it is done first. It is an
+// internal.WaitFunc.
+func (s *Scheduler) Wait(ctx context.Context, atMS int64) error {
+	if s == nil {
+		return internal.WaitUntil(ctx, atMS)
+	}
+	t := s.at(atMS)
+	if t == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		s.cancel(t)
+		return ctx.Err()
+	case <-t.ch:
+		return nil
+	}
+}
+
+// at returns the tick at dueMS with one more waiter, or nil if it is due.
+func (s *Scheduler) at(dueMS int64) *tick {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dueMS <= time.Now().UnixMilli() {
+		return nil
+	}
+	s.stats.Waits++
+	if t, ok := s.ticks[dueMS]; ok {
+		t.waiters++
+		return t
+	}
+	s.stats.Ticks++
+	t := &tick{dueMS: dueMS, ch: make(chan struct{}), waiters: 1}
+	s.ticks[dueMS] = t
+	slot := dueMS % wheelSlots
+	s.slots[slot] = append(s.slots[slot], t)
+	switch {
+	case !s.running:
+		s.running = true
+		s.nextMS = dueMS
+		go s.run()
+	case dueMS < s.nextMS:
+		s.nextMS = dueMS
+		s.signal()
+	}
+	return t
+}
+
+// cancel removes a waiter of t, and t when it has no waiters left.
+func (s *Scheduler) cancel(t *tick) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ticks[t.dueMS] != t {
+		return // released
+	}
+	t.waiters--
+	if t.waiters > 0 {
+		return
+	}
+	delete(s.ticks, t.dueMS)
+	slot := t.dueMS % wheelSlots
+	s.slots[slot] = slices.DeleteFunc(s.slots[slot], func(st *tick) bool { return st == t })
+	if len(s.ticks) == 0 {
+		s.signal() // let the goroutine stop
+	}
+}
+
+// signal wakes the goroutine up. The mutex must be held.
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run releases the ticks as they become due, until none is pending.
+func (s *Scheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for woken := false; ; woken = true {
+		s.mu.Lock()
+		if woken {
+			s.stats.Wakeups++
+		}
+		nowMS := time.Now().UnixMilli()
+		s.release(nowMS)
+		if len(s.ticks) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		s.nextMS = s.next(nowMS)
+		d := time.Duration(s.nextMS-nowMS) * time.Millisecond
+		s.mu.Unlock()
+		timer.Reset(d)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// release closes the channels of the ticks due at nowMS. The mutex must be
+// held. If the wall clock went backwards since the previous call, ticks
+// may be due in slots before the cursor, so all slots are checked.
+func (s *Scheduler) release(nowMS int64) {
+	from := s.cursor + 1
+	if nowMS-s.cursor >= wheelSlots || nowMS < s.cursor {
+		from = nowMS - wheelSlots + 1 // all slots
+	}
+	for ms := from; ms <= nowMS; ms++ {
+		slot := ms % wheelSlots
+		pending := s.slots[slot][:0]
+		for _, t := range s.slots[slot] {
+			if t.dueMS > nowMS {
+				pending = append(pending, t)
+				continue
+			}
+			close(t.ch)
+			delete(s.ticks, t.dueMS)
+		}
+		clear(s.slots[slot][len(pending):])
+		s.slots[slot] = pending
+	}
+	s.cursor = nowMS
+}
+
+// next returns the due time of the earliest pending tick after nowMS. The
+// mutex must be held and a tick must be pending.
+func (s *Scheduler) next(nowMS int64) int64 {
+	for ms := nowMS + 1; ms <= nowMS+wheelSlots; ms++ {
+		if _, ok := s.ticks[ms]; ok {
+			return ms
+		}
+	}
+	// All ticks are more than a turn of the wheel away.
+	var nextMS int64
+	for dueMS := range s.ticks {
+		if nextMS == 0 || dueMS < nextMS {
+			nextMS = dueMS
+		}
+	}
+	return nextMS
+}
+
+// Stats returns the counters of the scheduler.
+func (s *Scheduler) Stats() SchedulerStats {
+	if s == nil {
+		return SchedulerStats{}
+	}
+	s.feedMu.Lock()
+	feeds := len(s.feeds)
+	s.feedMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Feeds = feeds
+	return stats
+}
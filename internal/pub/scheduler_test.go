@@ -0,0 +1,88 @@
+package pub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := NewScheduler()
+		startMS := time.Now().UnixMilli()
+
+		// Subscriptions waiting for the same object times share ticks,
+		// including ticks more than a turn of the wheel away.
+		offsets := []int64{40, 20, 1500, 60, 3000}
+		const subscribers = 10
+		var wg sync.WaitGroup
+		for range subscribers {
+			for _, offset := range offsets {
+				wg.Go(func() {
+					require.NoError(t, s.Wait(t.Context(), startMS+offset))
+					assert.Equal(t, startMS+offset, time.Now().UnixMilli(), "released when due")
+				})
+			}
+		}
+		wg.Wait()
+		stats := s.Stats()
+		assert.Equal(t, uint64(subscribers*len(offsets)), stats.Waits)
+		assert.Equal(t, uint64(len(offsets)), stats.Ticks)
+		assert.LessOrEqual(t, stats.Wakeups, uint64(2*len(offsets)+1))
+
+		// Due times do not wait.
+		require.NoError(t, s.Wait(t.Context(), time.Now().UnixMilli()))
+		assert.Equal(t, stats.Waits, s.Stats().Waits)
+
+		// A tick earlier than the next wake-up wakes the goroutine up.
+		nowMS := time.Now().UnixMilli()
+		wg.Go(func() { require.NoError(t, s.Wait(t.Context(), nowMS+500)) })
+		synctest.Wait()
+		require.NoError(t, s.Wait(t.Context(), nowMS+10))
+		assert.Equal(t, nowMS+10, time.Now().UnixMilli())
+		wg.Wait()
+
+		// Cancelled waits remove their ticks, and the goroutine stops
+		// when no ticks are waited for.
+		ctx, cancel := context.WithCancel(t.Context())
+		wg.Go(func() {
+			assert.ErrorIs(t, s.Wait(ctx, time.Now().UnixMilli()+1000), context.Canceled)
+		})
+		synctest.Wait()
+		cancel()
+		wg.Wait()
+		synctest.Wait()
+		s.mu.Lock()
+		assert.False(t, s.running)
+		assert.Empty(t, s.ticks)
+		s.mu.Unlock()
+	})
+}
+
+func TestSchedulerClockBackwards(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := NewScheduler()
+		// The wheel last ran 5s ahead of the current time, before the wall
+		// clock was stepped back.
+		s.cursor += 5000
+		atMS := time.Now().UnixMilli() + 100
+		require.NoError(t, s.Wait(t.Context(), atMS))
+		assert.Equal(t, atMS, time.Now().UnixMilli(), "released when due")
+		assert.LessOrEqual(t, s.Stats().Wakeups, uint64(2), "no busy wake-ups")
+	})
+}
+
+func TestNilScheduler(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var s *Scheduler
+		atMS := time.Now().UnixMilli() + 100
+		require.NoError(t, s.Wait(t.Context(), atMS))
+		assert.Equal(t, atMS, time.Now().UnixMilli())
+		assert.Equal(t, SchedulerStats{}, s.Stats())
+	})
+}
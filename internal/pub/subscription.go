@@ -0,0 +1,153 @@
+package pub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/Eyevinn/moqtransport"
+)
+
+// Causes of the end of a subscription, see subscription.
+var (
+	errUnsubscribed = errors.New("unsubscribed")
+	errTrackEnded   = errors.New("track ended")
+)
+
+// subscription is a subscription being published. Its context ends on
+// UNSUBSCRIBE, when the track ends and when the session ends, and the
+// subscription then ends with PUBLISH_DONE carrying the status of the cause
+// and the last published object in the reason phrase. Publishing stops as
+// soon as the context ends, and PUBLISH_DONE follows once the open subgroups
+// have been closed.
+type subscription struct {
+	moqtransport.Publisher
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	conn   moqtransport.Connection
+	track  string
+
+	mu   sync.Mutex
+	last *moqtransport.Location // last published object
+	done bool                   // PUBLISH_DONE has been sent
+}
+
+// newSubscription returns a subscription to track publishing to p on conn,
+// whose context is a child of the session context ctx. trackCtx is the
+// context of the subscription in moqtransport, which ends on UNSUBSCRIBE.
+func newSubscription(ctx, trackCtx context.Context, p moqtransport.Publisher, conn moqtransport.Connection,
+	track string) *subscription {
+	ctx, cancel := context.WithCancelCause(ctx)
+	s := &subscription{Publisher: p, ctx: ctx, cancel: cancel, conn: conn, track: track}
+	stop := context.AfterFunc(trackCtx, func() { s.failed(context.Cause(trackCtx)) })
+	context.AfterFunc(ctx, func() { stop() })
+	return s
+}
+
+// run runs publishFn with the context of s and s as publisher, and ends the
+// subscription when it returns.
+func (s *subscription) run(publishFn func(ctx context.Context, p moqtransport.Publisher)) {
+	defer s.cancel(nil)
+	publishFn(s.ctx, s)
+	s.end()
+}
+
+// end sends PUBLISH_DONE unless it has been sent or the session has ended.
+// The status is SUBSCRIPTION_ENDED after an UNSUBSCRIBE, TRACK_ENDED when
+// the track has ended, GOING_AWAY when the publisher stops, and
+// INTERNAL_ERROR when publishing failed.
+func (s *subscription) end() {
+	s.mu.Lock()
+	done, last := s.done, s.last
+	s.done = true
+	s.mu.Unlock()
+	if done || s.conn.Context().Err() != nil {
+		return
+	}
+	code, reason := moqtransport.ErrorCodeSubscribeDoneInternal, "publishing failed"
+	switch cause := context.Cause(s.ctx); {
+	case errors.Is(cause, errUnsubscribed):
+		code, reason = moqtransport.ErrorCodeSubscribeDoneSubscriptionEnded, "unsubscribed"
+	case errors.Is(cause, errTrackEnded):
+		code, reason = moqtransport.ErrorCodeSubscribeDoneTrackEnded, "track ended"
+	case cause != nil:
+		code, reason = moqtransport.ErrorCodeSubscribeDoneGoingAway, "publisher going away"
+	}
+	if last != nil {
+		reason = fmt.Sprintf("%s, last object %d/%d", reason, last.Group, last.Object)
+	}
+	slog.Info("ending subscription", "track", s.track, "status", code, "reason", reason)
+	if err := s.Publisher.CloseWithError(uint64(code), reason); err != nil {
+		slog.Error("failed to send PUBLISH_DONE", "track", s.track, "error", err)
+	}
+}
+
+// failed ends s with errUnsubscribed if err is due to an UNSUBSCRIBE.
+func (s *subscription) failed(err error) {
+	if errors.Is(err, moqtransport.ErrUnsusbcribed) {
+		slog.Info("subscriber unsubscribed", "track", s.track)
+		s.cancel(errUnsubscribed)
+	}
+}
+
+// published records the location of the last published object.
+func (s *subscription) published(groupNr, objectID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = &moqtransport.Location{Group: groupNr, Object: objectID}
+}
+
+// openGroup opens a media group with the publisher wrapped by s.
+func (s *subscription) openGroup(trackName string, groupNr uint64, nrObjects int,
+	startMS int64) (groupWriter, error) {
+	sg, err := openGroup(s.Publisher, trackName, groupNr, nrObjects, startMS)
+	if err != nil {
+		s.failed(err)
+		return nil, err
+	}
+	return &subscriptionGroup{groupWriter: sg, s: s, groupNr: groupNr}, nil
+}
+
+// OpenSubgroup opens a subgroup of a track that is not published by group,
+// such as the catalog.
+func (s *subscription) OpenSubgroup(groupID, subgroupID uint64, priority uint8,
+	opts ...moqtransport.SubgroupOption) (*moqtransport.Subgroup, error) {
+	sg, err := s.Publisher.OpenSubgroup(groupID, subgroupID, priority, opts...)
+	if err != nil {
+		s.failed(err)
+	}
+	return sg, err
+}
+
+// CloseWithError sends PUBLISH_DONE, e.g. at the end of a bounded range.
+func (s *subscription) CloseWithError(code uint64, reason string) error {
+	s.mu.Lock()
+	s.done = true
+	s.mu.Unlock()
+	return s.Publisher.CloseWithError(code, reason)
+}
+
+// subscriptionGroup records the objects written to a group of a subscription.
+type subscriptionGroup struct {
+	groupWriter
+	s       *subscription
+	groupNr uint64
+}
+
+func (g *subscriptionGroup) WriteObject(objectID uint64, payload []byte) (int, error) {
+	return g.WriteObjectWithHeaders(objectID, nil, payload)
+}
+
+func (g *subscriptionGroup) WriteObjectWithHeaders(objectID uint64, headers moqtransport.KVPList,
+	payload []byte) (int, error) {
+	n, err := g.groupWriter.WriteObjectWithHeaders(objectID, headers, payload)
+	if err != nil {
+		// Faults may open the subgroup on the first object.
+		g.s.failed(err)
+		return n, err
+	}
+	g.s.published(g.groupNr, objectID)
+	return n, nil
+}
@@ -0,0 +1,103 @@
+package pub
+
+import (
+	"context"
+	"testing"
+	"testing/synctest"
+
+	"github.com/Eyevinn/moqtransport"
+	"github.com/stretchr/testify/assert"
+)
+
+// donePublisher is a Publisher that records its PUBLISH_DONE.
+type donePublisher struct {
+	moqtransport.Publisher
+	code   uint64
+	reason string
+	closed int
+}
+
+func (p *donePublisher) CloseWithError(code uint64, reason string) error {
+	p.code, p.reason = code, reason
+	p.closed++
+	return nil
+}
+
+// ctxConn is a Connection with only a context.
+type ctxConn struct {
+	moqtransport.Connection
+	ctx context.Context
+}
+
+func (c *ctxConn) Context() context.Context { return c.ctx }
+
+func TestSubscriptionEnd(t *testing.T) {
+	cases := []struct {
+		desc       string
+		cause      error
+		connClosed bool
+		published  bool
+		wantClosed int
+		wantCode   moqtransport.ErrorCodeSubscribeDone
+		wantReason string
+	}{
+		{desc: "publishing failed", wantClosed: 1, wantCode: moqtransport.ErrorCodeSubscribeDoneInternal,
+			wantReason: "publishing failed"},
+		{desc: "unsubscribed", cause: errUnsubscribed, published: true, wantClosed: 1,
+			wantCode: moqtransport.ErrorCodeSubscribeDoneSubscriptionEnded, wantReason: "unsubscribed, last object 7/3"},
+		{desc: "track ended", cause: errTrackEnded, wantClosed: 1,
+			wantCode: moqtransport.ErrorCodeSubscribeDoneTrackEnded, wantReason: "track ended"},
+		{desc: "going away", cause: context.Canceled, wantClosed: 1,
+			wantCode: moqtransport.ErrorCodeSubscribeDoneGoingAway, wantReason: "publisher going away"},
+		{desc: "connection closed", cause: context.Canceled, connClosed: true, wantClosed: 0},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				connCtx, closeConn := context.WithCancel(t.Context())
+				defer closeConn()
+				p := &donePublisher{}
+				s := newSubscription(t.Context(), t.Context(), p, &ctxConn{ctx: connCtx}, "video")
+				if c.published {
+					s.published(7, 3)
+				}
+				if c.connClosed {
+					closeConn()
+				}
+				if c.cause != nil {
+					s.cancel(c.cause)
+				}
+				s.end()
+				s.end()
+				assert.Equal(t, c.wantClosed, p.closed)
+				if c.wantClosed > 0 {
+					assert.Equal(t, uint64(c.wantCode), p.code)
+					assert.Equal(t, c.wantReason, p.reason)
+				}
+			})
+		})
+	}
+}
+
+func TestSubscriptionCloseWithError(t *testing.T) {
+	p := &donePublisher{}
+	s := newSubscription(t.Context(), t.Context(), p, &ctxConn{ctx: t.Context()}, "video")
+	endSubscription(s, "video", SubscribeRange{Bounded: true})
+	s.end()
+	assert.Equal(t, 1, p.closed)
+	assert.Equal(t, uint64(moqtransport.ErrorCodeSubscribeDoneSubscriptionEnded), p.code)
+}
+
+func TestSubscriptionUnsubscribe(t *testing.T) {
+	trackCtx, cancel := context.WithCancelCause(t.Context())
+	s := newSubscription(t.Context(), trackCtx, &donePublisher{}, &ctxConn{ctx: t.Context()}, "video")
+	cancel(moqtransport.ErrUnsusbcribed)
+	<-s.ctx.Done()
+	assert.ErrorIs(t, context.Cause(s.ctx), errUnsubscribed)
+
+	trackCtx, cancel = context.WithCancelCause(t.Context())
+	s = newSubscription(t.Context(), trackCtx, &donePublisher{}, &ctxConn{ctx: t.Context()}, "video")
+	cancel(moqtransport.ErrSubscriptionDone)
+	s.end()
+	assert.NoError(t, s.ctx.Err(), "PUBLISH_DONE does not cancel the subscription")
+}
@@ -0,0 +1,444 @@
+// Package relay implements a MoQ relay. Publishers announce namespaces to the
+// relay with PUBLISH_NAMESPACE. Subscriptions to their tracks share a single
+// upstream subscription per track, whose objects are fanned out to all
+// subscribers and cached to serve FETCH. FETCHes that the cache cannot serve
+// are forwarded to the publisher.
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal/pub"
+	"github.com/Eyevinn/moqtransport"
+	"github.com/mengelbart/qlog"
+	"github.com/mengelbart/qlog/moqt"
+)
+
+const (
+	// DefaultCacheGroups is the number of groups cached per track if
+	// Relay.CacheGroups is zero.
+	DefaultCacheGroups = 10
+	// upstreamTimeout bounds how long a SUBSCRIBE or FETCH waits for the
+	// publisher's response.
+	upstreamTimeout = 5 * time.Second
+	// fetchIdleTimeout ends a forwarded FETCH when no object has arrived for
+	// this long. moqtransport does not signal the end of a FETCH, so this is
+	// the only way to detect it when the last object of the range is unknown.
+	fetchIdleTimeout = time.Second
+	// objectPriority is the publisher priority of relayed objects.
+	objectPriority = 128
+)
+
+// Relay accepts namespaces from publishers and serves subscriptions and
+// fetches of their tracks to subscribers. A session may both publish and
+// subscribe.
+type Relay struct {
+	// CacheGroups is the number of groups cached per track.
+	// Zero means DefaultCacheGroups.
+	CacheGroups int
+	Logfh       io.Writer
+
+	mu         sync.Mutex
+	publishers map[string]*publisher // by namespaceKey
+	tracks     map[trackKey]*track
+}
+
+// publisher is a session that has announced namespaces to the relay.
+type publisher struct {
+	session *moqtransport.Session
+	// ctx is cancelled when the session ends.
+	ctx context.Context
+}
+
+type trackKey struct {
+	namespace string
+	name      string
+}
+
+// namespaceKey returns a map key for a namespace tuple. Namespace elements
+// may contain "/", so they are joined with a NUL byte.
+func namespaceKey(ns []string) string {
+	return strings.Join(ns, "\x00")
+}
+
+// Handle runs a MoQ session on the given connection until ctx is cancelled
+// or the connection is closed.
+func (r *Relay) Handle(ctx context.Context, conn moqtransport.Connection) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(conn.Context(), cancel)
+	defer stop()
+
+	session := &moqtransport.Session{
+		InitialMaxRequestID: 100,
+	}
+	if r.Logfh != nil {
+		session.Qlogger = qlog.NewQLOGHandler(r.Logfh, "MoQ QLOG", "MoQ QLOG", conn.Perspective().String(), moqt.Schema)
+	}
+	p := &publisher{session: session, ctx: ctx}
+	session.Handler = r.getHandler(p)
+	session.SubscribeHandler = r.getSubscribeHandler(ctx)
+	session.FetchHandler = r.getFetchHandler(ctx)
+	slog.Info("starting relay session", "perspective", conn.Perspective())
+	err := session.Run(conn)
+	if err != nil {
+		slog.Error("MoQ Session initialization failed", "error", err)
+		err = conn.CloseWithError(0, "session initialization error")
+		if err != nil {
+			slog.Error("failed to close connection", "error", err)
+		}
+		return
+	}
+	<-ctx.Done()
+	r.removePublisher(p)
+	slog.Info("relay session ended")
+}
+
+func (r *Relay) cacheGroups() int {
+	if r.CacheGroups > 0 {
+		return r.CacheGroups
+	}
+	return DefaultCacheGroups
+}
+
+func (r *Relay) getHandler(p *publisher) moqtransport.Handler {
+	return moqtransport.HandlerFunc(func(w moqtransport.ResponseWriter, m *moqtransport.Message) {
+		switch m.Method {
+		case moqtransport.MessageAnnounce:
+			r.addNamespace(m.Namespace, p)
+			if err := w.Accept(); err != nil {
+				slog.Error("failed to accept announcement", "error", err)
+			}
+		case moqtransport.MessageUnannounce:
+			r.removeNamespace(m.Namespace, p)
+		}
+	})
+}
+
+// addNamespace makes p the publisher of ns. A namespace announced again
+// replaces the previous publisher, so that a restarted publisher takes
+// over before the connection of the old one has timed out.
+func (r *Relay) addNamespace(ns []string, p *publisher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.publishers == nil {
+		r.publishers = make(map[string]*publisher)
+	}
+	key := namespaceKey(ns)
+	if old := r.publishers[key]; old != nil && old != p {
+		slog.Info("replacing publisher of namespace", "namespace", ns)
+	} else {
+		slog.Info("accepting announcement", "namespace", ns)
+	}
+	r.publishers[key] = p
+}
+
+// removeNamespace removes ns if it was announced by p.
+func (r *Relay) removeNamespace(ns []string, p *publisher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := namespaceKey(ns)
+	if r.publishers[key] == p {
+		slog.Info("namespace unannounced", "namespace", ns)
+		delete(r.publishers, key)
+	}
+}
+
+// removePublisher removes all namespaces announced by p. Its tracks end
+// by themselves, since their upstream subscriptions use p.ctx.
+func (r *Relay) removePublisher(p *publisher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, pp := range r.publishers {
+		if pp == p {
+			slog.Info("removing namespace of ended session", "namespace", strings.Split(key, "\x00"))
+			delete(r.publishers, key)
+		}
+	}
+}
+
+// publisher returns the publisher of ns, or nil if ns has not been announced.
+func (r *Relay) publisher(ns []string) *publisher {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.publishers[namespaceKey(ns)]
+}
+
+// track returns the track with an upstream subscription to name in ns at p,
+// subscribing to it if needed.
+func (r *Relay) track(p *publisher, ns []string, name string) (*track, error) {
+	key := trackKey{namespace: namespaceKey(ns), name: name}
+	r.mu.Lock()
+	if r.tracks == nil {
+		r.tracks = make(map[trackKey]*track)
+	}
+	t := r.tracks[key]
+	created := t == nil
+	if created {
+		t = newTrack(ns, name, r.cacheGroups(), func(t *track) { r.removeTrack(key, t) })
+		r.tracks[key] = t
+	}
+	r.mu.Unlock()
+	if created {
+		t.start(p)
+	}
+	<-t.ready
+	return t, t.err
+}
+
+// cachedTrack returns the track of name in ns if it has an upstream
+// subscription, or nil.
+func (r *Relay) cachedTrack(ns []string, name string) *track {
+	r.mu.Lock()
+	t := r.tracks[trackKey{namespace: namespaceKey(ns), name: name}]
+	r.mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	<-t.ready
+	if t.err != nil {
+		return nil
+	}
+	return t
+}
+
+func (r *Relay) removeTrack(key trackKey, t *track) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tracks[key] == t {
+		delete(r.tracks, key)
+	}
+}
+
+// errorCode returns the error code of a REQUEST_ERROR from the publisher,
+// or fallback for other errors.
+func errorCode(err error, fallback uint64) uint64 {
+	var perr moqtransport.ProtocolError
+	if errors.As(err, &perr) {
+		return perr.Code()
+	}
+	return fallback
+}
+
+// getSubscribeHandler returns the handler of subscriptions. The upstream
+// SUBSCRIBE may take up to upstreamTimeout, and moqtransport calls the
+// handler from the control stream loop of the session, whose other messages
+// would wait meanwhile, so the subscription is answered from a goroutine.
+// The session may be the publisher of the namespace itself.
+func (r *Relay) getSubscribeHandler(ctx context.Context) moqtransport.SubscribeHandler {
+	return moqtransport.SubscribeHandlerFunc(
+		func(w *moqtransport.SubscribeResponseWriter, m *moqtransport.SubscribeMessage) {
+			p := r.publisher(m.Namespace)
+			if p == nil {
+				slog.Warn("subscription to unknown namespace", "namespace", m.Namespace, "track", m.Track)
+				err := w.Reject(moqtransport.ErrorCodeSubscribeTrackDoesNotExist, "unknown namespace")
+				if err != nil {
+					slog.Error("failed to reject subscription", "error", err)
+				}
+				return
+			}
+			w.Defer()
+			go r.subscribe(ctx, w, m, p)
+		})
+}
+
+// subscribe answers the subscription m to a track of p, subscribing
+// upstream if needed, and relays the track until the subscription ends.
+func (r *Relay) subscribe(ctx context.Context, w *moqtransport.SubscribeResponseWriter,
+	m *moqtransport.SubscribeMessage, p *publisher) {
+	var t *track
+	var s *subscriber
+	var largest *moqtransport.Location
+	for s == nil {
+		var err error
+		t, err = r.track(p, m.Namespace, m.Track)
+		if err != nil {
+			slog.Warn("upstream subscription failed", "namespace", m.Namespace, "track", m.Track,
+				"error", err)
+			code := moqtransport.ErrorCodeSubscribe(errorCode(err,
+				uint64(moqtransport.ErrorCodeSubscribeInternal)))
+			if err := w.Reject(code, err.Error()); err != nil {
+				slog.Error("failed to reject subscription", "error", err)
+			}
+			return
+		}
+		s, largest, err = t.addSubscriber(w, m)
+		if errors.Is(err, errTrackEnded) {
+			continue // the track ended meanwhile; subscribe upstream again
+		}
+		if err != nil {
+			slog.Warn("rejecting subscription", "namespace", m.Namespace, "track", m.Track,
+				"filterType", m.FilterType, "error", err)
+			if err := w.Reject(moqtransport.ErrorCodeSubscribeInvalidRange, err.Error()); err != nil {
+				slog.Error("failed to reject subscription", "error", err)
+			}
+			t.release()
+			return
+		}
+	}
+	var opts []moqtransport.SubscribeOKOption
+	if largest != nil {
+		opts = append(opts, moqtransport.WithLargestLocation(largest))
+	}
+	if err := w.Accept(opts...); err != nil {
+		slog.Error("failed to accept subscription", "error", err)
+		t.removeSubscriber(s)
+		return
+	}
+	slog.Info("relaying subscription", "namespace", m.Namespace, "track", m.Track, "start", s.rng.Start)
+	s.run(ctx, t)
+}
+
+// getFetchHandler returns the handler of FETCHes, which are answered from a
+// goroutine like subscriptions, see getSubscribeHandler.
+func (r *Relay) getFetchHandler(ctx context.Context) moqtransport.FetchHandler {
+	return moqtransport.FetchHandlerFunc(
+		func(w *moqtransport.FetchResponseWriter, m *moqtransport.FetchMessage) {
+			p := r.publisher(m.Namespace)
+			if p == nil {
+				slog.Warn("fetch of unknown namespace", "namespace", m.Namespace, "track", m.Track)
+				err := w.Reject(uint64(moqtransport.ErrorCodeFetchTrackDoesNotExist), "unknown namespace")
+				if err != nil {
+					slog.Error("failed to reject fetch", "error", err)
+				}
+				return
+			}
+			if m.EndLocation.Group < m.StartLocation.Group ||
+				m.EndLocation.Object != 0 && !pub.LocationLess(m.StartLocation, m.EndLocation) {
+				err := w.Reject(uint64(moqtransport.ErrorCodeFetchInvalidRange), "end before start")
+				if err != nil {
+					slog.Error("failed to reject fetch", "error", err)
+				}
+				return
+			}
+			w.Defer()
+			go r.fetch(ctx, w, m, p)
+		})
+}
+
+// fetch serves the FETCH m of a track of p from the cache if it holds the
+// whole range, and forwards it to p otherwise.
+func (r *Relay) fetch(ctx context.Context, w *moqtransport.FetchResponseWriter, m *moqtransport.FetchMessage,
+	p *publisher) {
+	if t := r.cachedTrack(m.Namespace, m.Track); t != nil {
+		objects, covered, largest := t.fetch(m.StartLocation, m.EndLocation)
+		if largest != nil && pub.LocationLess(*largest, m.StartLocation) {
+			err := w.Reject(uint64(moqtransport.ErrorCodeFetchNoObjects), "no published objects in range")
+			if err != nil {
+				slog.Error("failed to reject fetch", "error", err)
+			}
+			return
+		}
+		if covered {
+			fs, ok := acceptFetch(w)
+			if !ok {
+				return
+			}
+			slog.Info("serving FETCH from cache", "namespace", m.Namespace, "track", m.Track,
+				"start", m.StartLocation, "end", m.EndLocation, "objects", len(objects))
+			serveCachedFetch(fs, objects)
+			return
+		}
+	}
+	r.forwardFetch(ctx, w, m, p)
+}
+
+// acceptFetch accepts a FETCH and returns its stream.
+func acceptFetch(w *moqtransport.FetchResponseWriter) (*moqtransport.FetchStream, bool) {
+	if err := w.Accept(); err != nil {
+		slog.Error("failed to accept fetch", "error", err)
+		return nil, false
+	}
+	fs, err := w.FetchStream()
+	if err != nil {
+		slog.Error("failed to get fetch stream", "error", err)
+		return nil, false
+	}
+	return fs, true
+}
+
+func serveCachedFetch(fs *moqtransport.FetchStream, objects []*moqtransport.Object) {
+	for _, o := range objects {
+		_, err := fs.WriteObjectWithHeaders(o.GroupID, o.SubGroupID, o.ObjectID, objectPriority,
+			o.ExtensionHeaders, o.Payload)
+		if err != nil {
+			slog.Error("failed to write fetch object", "error", err)
+			return
+		}
+	}
+	if err := fs.Close(); err != nil {
+		slog.Error("failed to close fetch stream", "error", err)
+	}
+}
+
+// forwardFetch forwards a FETCH to the publisher as a standalone FETCH of
+// the same range and relays the objects.
+//
+// moqtransport cancels a FETCH that the peer rejected, which the peer treats
+// as a protocol violation. Ranges that are known to be empty are therefore
+// rejected by the relay before they reach the publisher.
+func (r *Relay) forwardFetch(ctx context.Context, w *moqtransport.FetchResponseWriter,
+	m *moqtransport.FetchMessage, p *publisher) {
+	fctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	rt, err := p.session.Fetch(fctx, m.Namespace, m.Track,
+		moqtransport.WithFetchStartLocation(m.StartLocation), moqtransport.WithFetchEndLocation(m.EndLocation))
+	cancel()
+	if err != nil {
+		slog.Warn("upstream fetch failed", "namespace", m.Namespace, "track", m.Track, "error", err)
+		if err := w.Reject(errorCode(err, uint64(moqtransport.ErrorCodeFetchInternal)), err.Error()); err != nil {
+			slog.Error("failed to reject fetch", "error", err)
+		}
+		return
+	}
+	fs, ok := acceptFetch(w)
+	if !ok {
+		_ = rt.Close()
+		return
+	}
+	slog.Info("forwarding FETCH", "namespace", m.Namespace, "track", m.Track,
+		"start", m.StartLocation, "end", m.EndLocation)
+	pipeFetch(ctx, fs, rt, m.EndLocation)
+}
+
+// pipeFetch writes the objects of an upstream FETCH to fs. It ends after the
+// last object of the range, or when no object has arrived for
+// fetchIdleTimeout.
+func pipeFetch(ctx context.Context, fs *moqtransport.FetchStream, rt *moqtransport.RemoteTrack,
+	end moqtransport.Location) {
+	defer func() {
+		if err := rt.Close(); err != nil {
+			slog.Debug("failed to close upstream fetch", "error", err)
+		}
+	}()
+	// An end object of 0 means the whole end group, whose last object is unknown.
+	last, knownEnd := moqtransport.Location{Group: end.Group, Object: end.Object - 1}, end.Object > 0
+	for {
+		rctx, cancel := context.WithTimeout(ctx, fetchIdleTimeout)
+		o, err := rt.ReadObject(rctx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			break
+		}
+		_, err = fs.WriteObjectWithHeaders(o.GroupID, o.SubGroupID, o.ObjectID, objectPriority,
+			o.ExtensionHeaders, o.Payload)
+		if err != nil {
+			slog.Error("failed to write fetch object", "error", err)
+			return
+		}
+		if knownEnd && !pub.LocationLess(moqtransport.Location{Group: o.GroupID, Object: o.ObjectID}, last) {
+			break
+		}
+	}
+	if err := fs.Close(); err != nil {
+		slog.Error("failed to close fetch stream", "error", err)
+	}
+}
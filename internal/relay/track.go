@@ -0,0 +1,420 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/Eyevinn/moqlivemock/internal/pub"
+	"github.com/Eyevinn/moqtransport"
+)
+
+const (
+	// subscriberBuffer is the number of objects queued per subscriber. A
+	// subscriber that falls further behind has the group it is in cut off,
+	// and continues at the start of a later group.
+	subscriberBuffer = 256
+	// openGroups is the number of most recent groups whose subgroups are kept
+	// open per subscriber, so that objects of a group that arrive after
+	// objects of the next group are still delivered.
+	openGroups = 2
+)
+
+// errTrackEnded is returned when subscribing to a track whose upstream
+// subscription has ended.
+var errTrackEnded = errors.New("track ended")
+
+// track is a track with an upstream subscription at a publisher. Objects
+// received from the publisher are cached and delivered to all subscribers.
+type track struct {
+	namespace   []string
+	name        string
+	cacheGroups int
+	onEnd       func(*track)
+
+	// ready is closed when the upstream subscription has been set up or has
+	// failed with err.
+	ready    chan struct{}
+	err      error
+	upstream *moqtransport.RemoteTrack
+	cancel   context.CancelFunc
+
+	mu          sync.Mutex
+	groups      []*cachedGroup // ascending group IDs
+	largest     *moqtransport.Location
+	subscribers map[*subscriber]struct{}
+	ended       bool
+}
+
+// cachedGroup is a cached group with its objects in ascending order.
+type cachedGroup struct {
+	id      uint64
+	objects []*moqtransport.Object
+}
+
+func newTrack(ns []string, name string, cacheGroups int, onEnd func(*track)) *track {
+	return &track{
+		namespace:   ns,
+		name:        name,
+		cacheGroups: cacheGroups,
+		onEnd:       onEnd,
+		ready:       make(chan struct{}),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// start subscribes to the track at p and starts reading objects.
+func (t *track) start(p *publisher) {
+	defer close(t.ready)
+	ctx, cancel := context.WithCancel(p.ctx)
+	sctx, scancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer scancel()
+	rt, err := p.session.Subscribe(sctx, t.namespace, t.name)
+	if err != nil {
+		cancel()
+		t.err = err
+		t.ended = true
+		t.onEnd(t)
+		return
+	}
+	slog.Info("subscribed upstream", "namespace", t.namespace, "track", t.name)
+	t.upstream, t.cancel = rt, cancel
+	if loc, ok := rt.LargestLocation(); ok {
+		t.largest = &loc
+	}
+	go t.read(ctx)
+}
+
+// read reads objects from the upstream subscription until it ends.
+func (t *track) read(ctx context.Context) {
+	for {
+		o, err := t.upstream.ReadObject(ctx)
+		if o != nil {
+			t.add(o)
+		}
+		if err != nil {
+			t.finish(err)
+			return
+		}
+	}
+}
+
+// finish ends all subscriptions when the upstream subscription has ended
+// with err.
+func (t *track) finish(err error) {
+	status := uint64(moqtransport.ErrorCodeSubscribeDoneGoingAway)
+	reason := "publisher session ended"
+	var done *moqtransport.ErrSubscribeDone
+	if errors.As(err, &done) {
+		status, reason = done.Status, done.Reason
+	}
+	t.mu.Lock()
+	if !t.ended {
+		slog.Info("upstream subscription ended", "namespace", t.namespace, "track", t.name,
+			"status", status, "reason", reason)
+		t.ended = true
+		for s := range t.subscribers {
+			s.end(status, reason)
+		}
+		clear(t.subscribers)
+	}
+	t.mu.Unlock()
+	t.onEnd(t)
+}
+
+// add caches o and delivers it to the subscribers whose range includes it.
+// Objects without payload are relayed like all others.
+func (t *track) add(o *moqtransport.Object) {
+	t.mu.Lock()
+	t.cache(o)
+	loc := moqtransport.Location{Group: o.GroupID, Object: o.ObjectID}
+	for s := range t.subscribers {
+		if s.rng.Bounded && o.GroupID > s.rng.EndGroup {
+			s.end(uint64(moqtransport.ErrorCodeSubscribeDoneSubscriptionEnded), "end of subscription range")
+			delete(t.subscribers, s)
+			continue
+		}
+		if pub.LocationLess(loc, s.rng.Start) {
+			continue
+		}
+		if s.skipping {
+			if o.GroupID <= s.skipGroup || o.ObjectID != 0 {
+				s.skipGroup = max(s.skipGroup, o.GroupID)
+				continue
+			}
+			slog.Info("relay subscriber caught up", "track", t.name, "group", o.GroupID)
+			s.skipping = false
+		}
+		select {
+		case s.objects <- o:
+		default:
+			slog.Warn("relay subscriber too slow, cutting off group", "track", t.name,
+				"group", o.GroupID, "object", o.ObjectID)
+			s.skipping, s.skipGroup = true, o.GroupID
+			s.cut(o.GroupID)
+		}
+	}
+	idle := t.idleLocked()
+	t.mu.Unlock()
+	if idle {
+		t.stop()
+	}
+}
+
+// cache adds o to the cached groups, and drops the oldest group if there
+// are more than cacheGroups.
+func (t *track) cache(o *moqtransport.Object) {
+	loc := moqtransport.Location{Group: o.GroupID, Object: o.ObjectID}
+	if t.largest == nil || pub.LocationLess(*t.largest, loc) {
+		t.largest = &loc
+	}
+	i := sort.Search(len(t.groups), func(i int) bool { return t.groups[i].id >= o.GroupID })
+	if i == len(t.groups) || t.groups[i].id != o.GroupID {
+		if i == 0 && len(t.groups) >= t.cacheGroups {
+			return // older than all cached groups
+		}
+		t.groups = append(t.groups, nil)
+		copy(t.groups[i+1:], t.groups[i:])
+		t.groups[i] = &cachedGroup{id: o.GroupID}
+		if len(t.groups) > t.cacheGroups {
+			t.groups = t.groups[1:]
+			i--
+		}
+	}
+	g := t.groups[i]
+	j := sort.Search(len(g.objects), func(j int) bool { return g.objects[j].ObjectID >= o.ObjectID })
+	if j < len(g.objects) && g.objects[j].ObjectID == o.ObjectID {
+		return // duplicate
+	}
+	g.objects = append(g.objects, nil)
+	copy(g.objects[j+1:], g.objects[j:])
+	g.objects[j] = o
+}
+
+// fetch returns the cached objects in the FETCH range [start, end), where
+// an end object of 0 means the whole end group. covered is set if the cache
+// holds all published objects from start on. largest is the location of the
+// largest object received, or nil if there is none.
+func (t *track) fetch(start, end moqtransport.Location) (objects []*moqtransport.Object, covered bool,
+	largest *moqtransport.Location) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.largest != nil {
+		loc := *t.largest
+		largest = &loc
+	}
+	if len(t.groups) == 0 {
+		return nil, false, largest
+	}
+	first := t.groups[0].objects[0]
+	if pub.LocationLess(start, moqtransport.Location{Group: first.GroupID, Object: first.ObjectID}) {
+		return nil, false, largest
+	}
+	for _, g := range t.groups {
+		for _, o := range g.objects {
+			loc := moqtransport.Location{Group: o.GroupID, Object: o.ObjectID}
+			if pub.LocationLess(loc, start) {
+				continue
+			}
+			if end.Object == 0 && loc.Group > end.Group || end.Object > 0 && !pub.LocationLess(loc, end) {
+				return objects, true, largest
+			}
+			objects = append(objects, o)
+		}
+	}
+	return objects, true, largest
+}
+
+// addSubscriber adds a subscriber for the filter of m, and returns it with
+// the largest location to report in SUBSCRIBE_OK.
+func (t *track) addSubscriber(publisher moqtransport.Publisher, m *moqtransport.SubscribeMessage) (
+	*subscriber, *moqtransport.Location, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ended {
+		return nil, nil, errTrackEnded
+	}
+	var next moqtransport.Location
+	var largest *moqtransport.Location
+	if t.largest != nil {
+		loc := *t.largest
+		largest = &loc
+		next = moqtransport.Location{Group: loc.Group, Object: loc.Object + 1}
+	}
+	rng, err := pub.ResolveSubscribeFilter(m, next)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &subscriber{
+		publisher: publisher,
+		track:     t.name,
+		rng:       rng,
+		objects:   make(chan *moqtransport.Object, subscriberBuffer),
+	}
+	t.subscribers[s] = struct{}{}
+	return s, largest, nil
+}
+
+// removeSubscriber removes s, and unsubscribes upstream if it was the last
+// subscriber.
+func (t *track) removeSubscriber(s *subscriber) {
+	t.mu.Lock()
+	delete(t.subscribers, s)
+	t.mu.Unlock()
+	t.release()
+}
+
+// release unsubscribes upstream if the track has no subscribers.
+func (t *track) release() {
+	t.mu.Lock()
+	idle := t.idleLocked()
+	t.mu.Unlock()
+	if idle {
+		t.stop()
+	}
+}
+
+// idleLocked marks the track as ended and returns true if it has no
+// subscribers left. t.mu must be held.
+func (t *track) idleLocked() bool {
+	if t.ended || len(t.subscribers) > 0 {
+		return false
+	}
+	t.ended = true
+	return true
+}
+
+// stop unsubscribes upstream.
+func (t *track) stop() {
+	slog.Info("no subscribers left, unsubscribing upstream", "namespace", t.namespace, "track", t.name)
+	t.onEnd(t)
+	t.cancel()
+	if err := t.upstream.Close(); err != nil {
+		slog.Error("failed to unsubscribe upstream", "track", t.name, "error", err)
+	}
+}
+
+// subscriber is a downstream subscription to a track.
+type subscriber struct {
+	publisher moqtransport.Publisher
+	track     string
+	rng       pub.SubscribeRange
+	// objects is closed when the subscription ends with status and reason.
+	objects chan *moqtransport.Object
+	once    sync.Once
+	status  uint64
+	reason  string
+	// skipping is set when the queue has overflowed, and objects are not
+	// queued until the start of a group after skipGroup. Guarded by the
+	// mutex of the track.
+	skipping  bool
+	skipGroup uint64
+
+	mu      sync.Mutex
+	cutOffs map[uint64]struct{} // groups that are not written any further
+}
+
+type subgroupKey struct {
+	group, subgroup uint64
+}
+
+// cut cuts off group groupNr: its queued objects are dropped, and its open
+// subgroups are closed, so that the subscriber gets no group with holes.
+func (s *subscriber) cut(groupNr uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cutOffs == nil {
+		s.cutOffs = make(map[uint64]struct{})
+	}
+	s.cutOffs[groupNr] = struct{}{}
+}
+
+// isCut reports whether group groupNr has been cut off, and forgets the
+// cut off groups before beforeGroup.
+func (s *subscriber) isCut(groupNr, beforeGroup uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for g := range s.cutOffs {
+		if g < beforeGroup {
+			delete(s.cutOffs, g)
+		}
+	}
+	_, ok := s.cutOffs[groupNr]
+	return ok
+}
+
+// end ends the subscription once its queued objects have been written.
+func (s *subscriber) end(status uint64, reason string) {
+	s.once.Do(func() {
+		s.status, s.reason = status, reason
+		close(s.objects)
+	})
+}
+
+// run writes the objects of s to its publisher until the subscription ends,
+// the subscriber goes away, or ctx is cancelled.
+func (s *subscriber) run(ctx context.Context, t *track) {
+	subgroups := make(map[subgroupKey]*moqtransport.Subgroup)
+	var closedBelow uint64 // subgroups of groups before this have been closed
+	// closeSubgroups closes the subgroups of the groups that match.
+	closeSubgroups := func(match func(group uint64) bool) {
+		for key, sg := range subgroups {
+			if match(key.group) {
+				if err := sg.Close(); err != nil {
+					slog.Debug("failed to close subgroup", "track", s.track, "group", key.group, "error", err)
+				}
+				delete(subgroups, key)
+			}
+		}
+	}
+	all := func(uint64) bool { return true }
+	defer closeSubgroups(all)
+	for {
+		var o *moqtransport.Object
+		var ok bool
+		select {
+		case <-ctx.Done():
+			t.removeSubscriber(s)
+			return
+		case o, ok = <-s.objects:
+		}
+		if !ok {
+			closeSubgroups(all)
+			if err := s.publisher.CloseWithError(s.status, s.reason); err != nil {
+				slog.Debug("failed to end subscription", "track", s.track, "error", err)
+			}
+			return
+		}
+		if s.isCut(o.GroupID, closedBelow) {
+			closeSubgroups(func(group uint64) bool { return group == o.GroupID })
+			continue
+		}
+		key := subgroupKey{group: o.GroupID, subgroup: o.SubGroupID}
+		sg := subgroups[key]
+		if sg == nil {
+			if o.GroupID < closedBelow {
+				slog.Debug("dropping late object", "track", s.track, "group", o.GroupID, "object", o.ObjectID)
+				continue
+			}
+			var err error
+			sg, err = s.publisher.OpenSubgroup(o.GroupID, o.SubGroupID, objectPriority)
+			if err != nil {
+				slog.Info("subscriber gone", "track", s.track, "error", err)
+				t.removeSubscriber(s)
+				return
+			}
+			subgroups[key] = sg
+			if o.GroupID+1 > openGroups && o.GroupID+1-openGroups > closedBelow {
+				closedBelow = o.GroupID + 1 - openGroups
+				closeSubgroups(func(group uint64) bool { return group < closedBelow })
+			}
+		}
+		if _, err := sg.WriteObjectWithHeaders(o.ObjectID, o.ExtensionHeaders, o.Payload); err != nil {
+			slog.Info("subscriber gone", "track", s.track, "error", err)
+			t.removeSubscriber(s)
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/Eyevinn/moqtransport"
+	"github.com/quic-go/quic-go/quicvarint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testObject(group, object uint64) *moqtransport.Object {
+	return &moqtransport.Object{GroupID: group, ObjectID: object, Payload: []byte{byte(object)}}
+}
+
+func locations(objects []*moqtransport.Object) []moqtransport.Location {
+	locs := make([]moqtransport.Location, 0, len(objects))
+	for _, o := range objects {
+		locs = append(locs, moqtransport.Location{Group: o.GroupID, Object: o.ObjectID})
+	}
+	return locs
+}
+
+func TestTrackCache(t *testing.T) {
+	loc := func(g, o uint64) moqtransport.Location { return moqtransport.Location{Group: g, Object: o} }
+	tr := newTrack([]string{"ns"}, "video", 3, func(*track) {})
+
+	// The upstream subscription starts mid-group.
+	for _, l := range []moqtransport.Location{loc(10, 2), loc(10, 3), loc(11, 0), loc(11, 1), loc(12, 0)} {
+		tr.cache(testObject(l.Group, l.Object))
+	}
+	tr.cache(testObject(11, 1)) // duplicates are ignored
+	tr.cache(testObject(12, 2))
+	tr.cache(testObject(12, 1)) // objects are kept in order
+	assert.Equal(t, loc(12, 2), *tr.largest)
+
+	objects, covered, largest := tr.fetch(loc(11, 0), loc(12, 0))
+	assert.True(t, covered)
+	assert.Equal(t, []moqtransport.Location{loc(11, 0), loc(11, 1), loc(12, 0), loc(12, 1), loc(12, 2)},
+		locations(objects), "end object 0 is the whole end group")
+	assert.Equal(t, loc(12, 2), *largest)
+
+	objects, covered, _ = tr.fetch(loc(10, 3), loc(11, 1))
+	assert.True(t, covered)
+	assert.Equal(t, []moqtransport.Location{loc(10, 3), loc(11, 0)}, locations(objects))
+
+	_, covered, _ = tr.fetch(loc(10, 0), loc(11, 0))
+	assert.False(t, covered, "the start of group 10 was never received")
+
+	// A fourth group evicts the oldest one.
+	tr.cache(testObject(13, 0))
+	require.Len(t, tr.groups, 3)
+	assert.Equal(t, uint64(11), tr.groups[0].id)
+	_, covered, _ = tr.fetch(loc(10, 3), loc(11, 0))
+	assert.False(t, covered)
+	tr.cache(testObject(9, 0))
+	assert.Equal(t, uint64(11), tr.groups[0].id, "groups older than the cache are not cached")
+}
+
+func TestTrackDelivery(t *testing.T) {
+	tr := newTrack([]string{"ns"}, "video", 3, func(*track) {})
+	tr.largest = &moqtransport.Location{Group: 10, Object: 2}
+
+	live, _, err := tr.addSubscriber(nil, &moqtransport.SubscribeMessage{
+		FilterType: moqtransport.FilterTypeLatestObject,
+	})
+	require.NoError(t, err)
+	nextGroup, largest, err := tr.addSubscriber(nil, &moqtransport.SubscribeMessage{
+		FilterType: moqtransport.FilterTypeNextGroupStart,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, moqtransport.Location{Group: 10, Object: 2}, *largest)
+	absoluteRange := func(startGroup, startObject, endGroup uint64) *moqtransport.SubscribeMessage {
+		var b []byte
+		for _, v := range []uint64{uint64(moqtransport.FilterTypeAbsoluteRange), startGroup, startObject, endGroup} {
+			b = quicvarint.Append(b, v)
+		}
+		return &moqtransport.SubscribeMessage{
+			FilterType: moqtransport.FilterTypeAbsoluteRange,
+			Parameters: moqtransport.KVPList{{Type: 0x21, ValueBytes: b}},
+		}
+	}
+	bounded, _, err := tr.addSubscriber(nil, absoluteRange(11, 1, 11))
+	require.NoError(t, err)
+	_, _, err = tr.addSubscriber(nil, absoluteRange(11, 1, 9))
+	assert.Error(t, err, "range ending before the live edge")
+
+	for _, o := range []*moqtransport.Object{testObject(10, 3), testObject(11, 0), testObject(11, 1),
+		{GroupID: 11, ObjectID: 2}, testObject(12, 0)} {
+		tr.add(o)
+	}
+	received := func(s *subscriber) []moqtransport.Location {
+		var locs []moqtransport.Location
+		for {
+			select {
+			case o, ok := <-s.objects:
+				if !ok {
+					return locs
+				}
+				locs = append(locs, moqtransport.Location{Group: o.GroupID, Object: o.ObjectID})
+			default:
+				return locs
+			}
+		}
+	}
+	assert.Equal(t, []moqtransport.Location{{Group: 10, Object: 3}, {Group: 11}, {Group: 11, Object: 1},
+		{Group: 11, Object: 2}, {Group: 12}}, received(live), "objects without payload are relayed")
+	assert.Equal(t, []moqtransport.Location{{Group: 11}, {Group: 11, Object: 1}, {Group: 11, Object: 2},
+		{Group: 12}}, received(nextGroup))
+	assert.Equal(t, []moqtransport.Location{{Group: 11, Object: 1}, {Group: 11, Object: 2}}, received(bounded))
+	assert.Equal(t, uint64(moqtransport.ErrorCodeSubscribeDoneSubscriptionEnded), bounded.status,
+		"the bounded subscription ends after its end group")
+	assert.Len(t, tr.subscribers, 2)
+}
+
+func TestTrackSlowSubscriber(t *testing.T) {
+	const nrGroups, groupLen = 6, 4
+	tr := newTrack([]string{"ns"}, "video", 3, func(*track) {})
+	tr.largest = &moqtransport.Location{Group: 0, Object: 0}
+	s, _, err := tr.addSubscriber(nil, &moqtransport.SubscribeMessage{
+		FilterType: moqtransport.FilterTypeNextGroupStart,
+	})
+	require.NoError(t, err)
+	s.objects = make(chan *moqtransport.Object, 2)
+
+	// The subscriber reads one object for every two that arrive in the first
+	// groups, and then keeps up. Like run, it does not write the objects of
+	// groups that have been cut off.
+	written := make(map[uint64][]uint64)
+	read := func() {
+		o := <-s.objects
+		if !s.isCut(o.GroupID, 0) {
+			written[o.GroupID] = append(written[o.GroupID], o.ObjectID)
+		}
+	}
+	n := 0
+	for g := uint64(1); g <= nrGroups; g++ {
+		for o := uint64(0); o < groupLen; o++ {
+			tr.add(testObject(g, o))
+			if n++; g > 2 || n%2 == 0 {
+				read()
+			}
+		}
+	}
+	for len(s.objects) > 0 {
+		read()
+	}
+
+	complete := 0
+	for g := uint64(1); g <= nrGroups; g++ {
+		objects := written[g]
+		for i, o := range objects {
+			assert.Equal(t, uint64(i), o, "group %d is written from its start without holes", g)
+		}
+		if s.isCut(g, 0) {
+			assert.Less(t, len(objects), groupLen, "group %d is cut off", g)
+			continue
+		}
+		assert.Len(t, objects, groupLen, "group %d is complete", g)
+		complete++
+	}
+	assert.NotEmpty(t, s.cutOffs, "the subscriber fell behind")
+	assert.Positive(t, complete, "the subscriber resumes at a group start")
+}
@@ -0,0 +1,482 @@
+package sub
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+	"github.com/Eyevinn/mp4ff/bits"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// Defaults of ThroughputRule.
+const (
+	DefaultMaxLateness    = 500 * time.Millisecond
+	DefaultUpSwitchGroups = 4
+	DefaultSafetyFactor   = 0.8
+)
+
+const (
+	// abrHandoverTimeout is how long a new subscription waits for the
+	// previous one to reach the switch group before the previous one is
+	// closed anyway.
+	abrHandoverTimeout = 2 * time.Second
+)
+
+// Rendition is a video track that ABR can switch to.
+type Rendition struct {
+	Name    string
+	Bitrate int // bits per second, from the catalog
+}
+
+// GroupStats are the arrival statistics of a received group.
+type GroupStats struct {
+	Group   uint64
+	Objects int
+	Bytes   int
+	// Arrival is the time from the arrival of the first object to the
+	// arrival of the last object of the group.
+	Arrival time.Duration
+	// Lateness is the largest lateness of an object of the group, i.e. the
+	// arrival time minus the wall-clock time of the end of its media.
+	Lateness time.Duration
+}
+
+// Throughput returns the arrival throughput of the group in bits per second.
+func (s GroupStats) Throughput() float64 {
+	return float64(8*s.Bytes) / max(s.Arrival, time.Millisecond).Seconds()
+}
+
+// ABRRule decides which rendition to receive.
+type ABRRule interface {
+	// Next returns the index in renditions, which are sorted by ascending
+	// bitrate, of the rendition to receive after a group of renditions[current]
+	// has been received with stats.
+	Next(renditions []Rendition, current int, stats GroupStats) int
+}
+
+// ThroughputRule is the default ABRRule. It switches down as soon as a group
+// arrives late, to the highest rendition that fits the measured throughput,
+// and switches up one step after a number of groups in a row arrived in time.
+type ThroughputRule struct {
+	// MaxLateness is the lateness above which a group is late.
+	// Zero means DefaultMaxLateness.
+	MaxLateness time.Duration
+	// UpSwitchGroups is the number of groups in a row that must arrive in
+	// time before switching up. Zero means DefaultUpSwitchGroups.
+	UpSwitchGroups int
+	// SafetyFactor scales the measured throughput when choosing a lower
+	// rendition. Zero means DefaultSafetyFactor.
+	SafetyFactor float64
+
+	inTime int
+}
+
+// Next implements ABRRule.
+func (r *ThroughputRule) Next(renditions []Rendition, current int, stats GroupStats) int {
+	maxLateness := cmp.Or(r.MaxLateness, DefaultMaxLateness)
+	upSwitchGroups := cmp.Or(r.UpSwitchGroups, DefaultUpSwitchGroups)
+	safetyFactor := cmp.Or(r.SafetyFactor, DefaultSafetyFactor)
+	if stats.Lateness > maxLateness {
+		r.inTime = 0
+		budget := safetyFactor * stats.Throughput()
+		for i := current - 1; i > 0; i-- {
+			if float64(renditions[i].Bitrate) <= budget {
+				return i
+			}
+		}
+		return 0
+	}
+	r.inTime++
+	if r.inTime >= upSwitchGroups && current < len(renditions)-1 {
+		r.inTime = 0
+		return current + 1
+	}
+	return current
+}
+
+// abrState is the state of adaptive bitrate switching of the video track.
+type abrState struct {
+	ctx        context.Context
+	rule       ABRRule
+	renditions []*internal.Track // ascending bitrate
+	trackID    uint32            // track ID of the combined init segment
+
+	mu        sync.Mutex
+	current   int  // index of the rendition being output
+	switching bool // a switch to another rendition is in progress
+}
+
+// abrReader is the ABR state of one video subscription. A subscription that
+// replaces prev takes over at the first group that prev has not started to
+// output, so that the output stays continuous.
+type abrReader struct {
+	state *abrState
+	index int // index of the rendition
+
+	// prev is the reader of the previous rendition and prevClose closes its
+	// subscription. Both are nil once the handover is done.
+	prev      *abrReader
+	prevClose func() error
+	from      uint64 // first group to output
+	fromSet   bool
+
+	mu      sync.Mutex
+	started bool   // an object has been output
+	group   uint64 // group of the last object output
+	stopAt  uint64 // first group not to output, as the next reader takes over
+	done    chan struct{}
+	once    sync.Once
+
+	stats        *GroupStats // stats of the group being received, nil if not measured
+	firstArrival time.Time
+	measureFrom  uint64 // first group to measure
+	measureSet   bool
+}
+
+func newABRReader(state *abrState, index int, prev *abrReader, prevClose func() error) *abrReader {
+	return &abrReader{
+		state:     state,
+		index:     index,
+		prev:      prev,
+		prevClose: prevClose,
+		stopAt:    math.MaxUint64,
+		done:      make(chan struct{}),
+	}
+}
+
+// subscribed is called when the subscription of r has been accepted with
+// largest location loc. If r replaces prev, prev stops before the group
+// that r starts with.
+func (r *abrReader) subscribed(loc moqtransport.Location, ok bool) {
+	if r.prev != nil && ok {
+		r.from, r.fromSet = r.prev.handover(loc.Group+1), true
+	}
+}
+
+// handover makes r stop before group, or before the group after its last
+// output object if that is later, and returns the group where the next
+// reader takes over.
+func (r *abrReader) handover(group uint64) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started && r.group >= group {
+		group = r.group + 1
+	}
+	r.stopAt = group
+	return group
+}
+
+// admit reports whether an object of group should be output, and records it.
+func (r *abrReader) admit(group uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if group >= r.stopAt {
+		return false
+	}
+	r.started, r.group = true, group
+	return true
+}
+
+// switchingAway reports whether the next reader has taken over.
+func (r *abrReader) switchingAway() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopAt != math.MaxUint64
+}
+
+// finish signals that r does not output any more objects.
+func (r *abrReader) finish() {
+	r.once.Do(func() { close(r.done) })
+}
+
+// abrAction is what to do with a received video object.
+type abrAction int
+
+const (
+	abrWrite abrAction = iota
+	abrSkip
+	abrStop
+)
+
+// alternates returns the tracks that ABR can switch between for the selected
+// video track: the tracks in its altGroup with the same packaging, codec
+// family and protection, sorted by ascending bitrate.
+func (h *Handler) alternates(selected *internal.Track) []*internal.Track {
+	if selected.AltGroup == nil || selected.Packaging != "cmaf" && selected.Packaging != "locmaf" {
+		return nil
+	}
+	var tracks []*internal.Track
+	for i := range h.catalog.Tracks {
+		t := &h.catalog.Tracks[i]
+		if t.Role != "video" || t.AltGroup == nil || *t.AltGroup != *selected.AltGroup ||
+			t.Packaging != selected.Packaging || codecFamily(t.Codec) != codecFamily(selected.Codec) ||
+			len(t.ContentProtectionRefIDs) != len(selected.ContentProtectionRefIDs) {
+			continue
+		}
+		tracks = append(tracks, t)
+	}
+	slices.SortStableFunc(tracks, func(a, b *internal.Track) int { return trackBitrate(a) - trackBitrate(b) })
+	return tracks
+}
+
+// codecFamily returns the sample entry type of an RFC 6381 codec string,
+// e.g. "avc1" for "avc1.64001e".
+func codecFamily(codec string) string {
+	family, _, _ := strings.Cut(codec, ".")
+	return family
+}
+
+func trackBitrate(t *internal.Track) int {
+	if t.Bitrate == nil {
+		return 0
+	}
+	return *t.Bitrate
+}
+
+// setupABR sets up ABR for the selected video track with init data
+// initData, and returns the init data to output. If the track has
+// alternates, that is an init segment with the sample entries of all
+// alternates, and ABR is enabled. Otherwise initData is returned.
+func (h *Handler) setupABR(ctx context.Context, selected *internal.Track, initData string) string {
+	renditions := h.alternates(selected)
+	if len(renditions) < 2 {
+		slog.Warn("ABR disabled, no alternate video tracks", "track", selected.Name)
+		return initData
+	}
+	inits := make([]string, 0, len(renditions))
+	current := 0
+	for i, t := range renditions {
+		if t == selected {
+			inits = append(inits, initData)
+			current = i
+			continue
+		}
+		data, err := h.trackInit(t)
+		if err != nil {
+			slog.Warn("ABR disabled", "track", t.Name, "error", err)
+			return initData
+		}
+		inits = append(inits, data)
+	}
+	combined, trackID, err := combineInits(inits)
+	if err != nil {
+		slog.Warn("ABR disabled, cannot combine init segments", "error", err)
+		return initData
+	}
+	names := make([]string, 0, len(renditions))
+	for _, t := range renditions {
+		names = append(names, t.Name)
+	}
+	slog.Info("ABR enabled", "renditions", names, "start", selected.Name)
+	h.abr = &abrState{
+		ctx:        ctx,
+		rule:       h.ABR,
+		renditions: renditions,
+		trackID:    trackID,
+		current:    current,
+	}
+	return combined
+}
+
+// combineInits returns an init segment, base64 encoded like the catalog
+// init data, with the sample entries of all inits in order, so that sample
+// description index i+1 refers to inits[i]. The track ID is the one of the
+// first init.
+func combineInits(inits []string) (string, uint32, error) {
+	var combined *mp4.InitSegment
+	for _, initData := range inits {
+		init, err := parseCMAFInit(initData)
+		if err != nil {
+			return "", 0, err
+		}
+		if combined == nil {
+			combined = init
+			continue
+		}
+		stsd := init.Moov.Trak.Mdia.Minf.Stbl.Stsd
+		if len(stsd.Children) == 0 {
+			return "", 0, fmt.Errorf("no sample entry in init segment")
+		}
+		combined.Moov.Trak.Mdia.Minf.Stbl.Stsd.AddChild(stsd.Children[0])
+	}
+	if combined == nil {
+		return "", 0, fmt.Errorf("no init segments")
+	}
+	var buf bytes.Buffer
+	if err := combined.Encode(&buf); err != nil {
+		return "", 0, err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), combined.Moov.Trak.Tkhd.TrackID, nil
+}
+
+// switchChunk sets the track ID and sample description index of a CMAF
+// chunk, and returns the rewritten chunk and the end time of its media in
+// timescale units.
+func switchChunk(chunk []byte, trackID, sampleDescriptionIndex uint32) ([]byte, uint64, error) {
+	f, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(chunk))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(f.Segments) != 1 || len(f.Segments[0].Fragments) != 1 {
+		return nil, 0, fmt.Errorf("expected 1 fragment")
+	}
+	traf := f.Segments[0].Fragments[0].Moof.Traf
+	traf.Tfhd.TrackID = trackID
+	traf.Tfhd.Flags |= mp4.TfhdSampleDescriptionIndexPresentFlag
+	traf.Tfhd.SampleDescriptionIndex = sampleDescriptionIndex
+	end := traf.Tfdt.BaseMediaDecodeTime() + traf.Trun.Duration(traf.Tfhd.DefaultSampleDuration)
+	var buf bytes.Buffer
+	buf.Grow(len(chunk) + 4)
+	if err := f.Segments[0].Encode(&buf); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), end, nil
+}
+
+// abrObject handles a video object received by r at arrival. The payload is
+// rewritten for the combined init segment, the arrival is measured, and at
+// group boundaries the ABR rule is asked whether to switch rendition.
+// It returns what to do with the object.
+func (h *Handler) abrObject(readCtx context.Context, r *abrReader, groupID uint64, payload *[]byte,
+	arrival time.Time) abrAction {
+	if r.prev != nil {
+		if !r.fromSet {
+			r.from, r.fromSet = r.prev.handover(groupID), true
+		}
+		if groupID < r.from {
+			return abrSkip
+		}
+		timer := time.NewTimer(abrHandoverTimeout)
+		select {
+		case <-r.prev.done:
+		case <-timer.C:
+			slog.Warn("ABR handover timed out", "track", r.state.renditions[r.prev.index].Name)
+		case <-readCtx.Done():
+		}
+		timer.Stop()
+		if err := r.prevClose(); err != nil {
+			slog.Error("failed to close subscription", "error", err)
+		}
+		r.prev, r.prevClose = nil, nil
+		r.state.mu.Lock()
+		r.state.current, r.state.switching = r.index, false
+		r.state.mu.Unlock()
+		slog.Info("ABR switched", "track", r.state.renditions[r.index].Name, "group", r.from)
+	}
+	if groupID < r.from {
+		return abrSkip
+	}
+	if !r.admit(groupID) {
+		return abrStop
+	}
+	track := r.state.renditions[r.index]
+	out, end, err := switchChunk(*payload, r.state.trackID, uint32(r.index+1))
+	if err != nil {
+		slog.Error("failed to rewrite video chunk", "track", track.Name, "error", err)
+		return abrStop
+	}
+	h.measure(r, groupID, len(*payload), end, arrival)
+	*payload = out
+	return abrWrite
+}
+
+// measure adds an object to the stats of its group. When a new group
+// starts, the stats of the previous group are passed to the ABR rule.
+func (h *Handler) measure(r *abrReader, groupID uint64, size int, end uint64, arrival time.Time) {
+	track := r.state.renditions[r.index]
+	timescale := 1
+	if track.Timescale != nil && *track.Timescale > 0 {
+		timescale = *track.Timescale
+	}
+	lateness := arrival.Sub(time.UnixMilli(int64(end * 1000 / uint64(timescale))))
+	if r.stats != nil && groupID < r.stats.Group {
+		// An object of an earlier group arrives late.
+		r.stats.Lateness = max(r.stats.Lateness, lateness)
+		return
+	}
+	if r.stats != nil && groupID > r.stats.Group {
+		stats := *r.stats
+		r.stats = nil
+		h.abrDecide(r, stats)
+	}
+	if !r.measureSet {
+		// The first group is not measured, since its objects may have been
+		// queued during the subscription or the handover.
+		r.measureFrom, r.measureSet = groupID+1, true
+	}
+	if groupID < r.measureFrom {
+		return
+	}
+	if r.stats == nil {
+		r.stats = &GroupStats{Group: groupID}
+		r.firstArrival = arrival
+	}
+	r.stats.Objects++
+	r.stats.Bytes += size
+	r.stats.Arrival = arrival.Sub(r.firstArrival)
+	r.stats.Lateness = max(r.stats.Lateness, lateness)
+}
+
+// abrDecide asks the ABR rule which rendition to receive after a group of
+// r with stats, and starts a switch if it is another one.
+func (h *Handler) abrDecide(r *abrReader, stats GroupStats) {
+	a := r.state
+	if r.switchingAway() {
+		return
+	}
+	a.mu.Lock()
+	if a.switching || a.current != r.index {
+		a.mu.Unlock()
+		return
+	}
+	renditions := make([]Rendition, 0, len(a.renditions))
+	for _, t := range a.renditions {
+		renditions = append(renditions, Rendition{Name: t.Name, Bitrate: trackBitrate(t)})
+	}
+	next := a.rule.Next(renditions, r.index, stats)
+	slog.Debug("ABR group stats", "track", renditions[r.index].Name, "group", stats.Group,
+		"objects", stats.Objects, "bytes", stats.Bytes, "throughputKbps", int(stats.Throughput()/1000),
+		"latenessMS", stats.Lateness.Milliseconds())
+	if next == r.index || next < 0 || next >= len(renditions) {
+		a.mu.Unlock()
+		return
+	}
+	a.switching = true
+	a.mu.Unlock()
+	slog.Info("ABR switching", "from", renditions[r.index].Name, "to", renditions[next].Name)
+	go h.switchVideo(r, next)
+}
+
+// switchVideo subscribes to rendition to. The new subscription takes over
+// from the subscription of prev at a group boundary.
+func (h *Handler) switchVideo(prev *abrReader, to int) {
+	a := prev.state
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.abr != a {
+		return // the video track was replaced after a catalog update
+	}
+	track := a.renditions[to]
+	r := newABRReader(a, to, prev, h.closers["video"])
+	closeFn, err := h.subscribeAndRead(a.ctx, h.session, h.Namespace, track.Name, "video", r)
+	if err != nil {
+		slog.Error("ABR failed to subscribe", "track", track.Name, "error", err)
+		a.mu.Lock()
+		a.switching = false
+		a.mu.Unlock()
+		return
+	}
+	h.selected["video"] = track.Name
+	h.closers["video"] = closeFn
+}
@@ -0,0 +1,82 @@
+package sub
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/mp4ff/bits"
+	"github.com/Eyevinn/mp4ff/mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThroughputRule(t *testing.T) {
+	renditions := []Rendition{{"low", 400_000}, {"mid", 600_000}, {"high", 900_000}}
+	inTime := GroupStats{Bytes: 75_000, Arrival: 900 * time.Millisecond, Lateness: 50 * time.Millisecond}
+	r := &ThroughputRule{UpSwitchGroups: 2}
+
+	assert.Equal(t, 0, r.Next(renditions, 0, inTime))
+	assert.Equal(t, 1, r.Next(renditions, 0, inTime), "up one step after 2 groups in time")
+	assert.Equal(t, 1, r.Next(renditions, 1, inTime))
+	assert.Equal(t, 2, r.Next(renditions, 1, inTime))
+	assert.Equal(t, 2, r.Next(renditions, 2, inTime))
+	assert.Equal(t, 2, r.Next(renditions, 2, inTime), "no rendition above the highest")
+
+	// 75 kB arriving over 1.2s is 500 kbps, of which 400 kbps fits.
+	late := GroupStats{Bytes: 75_000, Arrival: 1200 * time.Millisecond, Lateness: time.Second}
+	assert.Equal(t, 0, r.Next(renditions, 2, late))
+	// 75 kB arriving over 0.6s is 1 Mbps, so switch down one step.
+	late.Arrival = 600 * time.Millisecond
+	assert.Equal(t, 1, r.Next(renditions, 2, late))
+	assert.Equal(t, 1, r.Next(renditions, 1, inTime), "lateness restarts the up-switch count")
+	assert.Equal(t, 0, r.Next(renditions, 0, late))
+}
+
+func TestABRChunks(t *testing.T) {
+	asset, err := internal.LoadAsset(filepath.Join("..", "..", "assets", "test10s"), 1, 1)
+	require.NoError(t, err)
+	catalog, err := asset.GenCMAFCatalogEntry("cmsf/clear", internal.ProtectionNone, 0)
+	require.NoError(t, err)
+	h := &Handler{catalog: catalog}
+
+	renditions := h.alternates(catalog.GetTrackByName("video_600kbps_avc"))
+	var names []string
+	for _, tr := range renditions {
+		names = append(names, tr.Name)
+	}
+	assert.Equal(t, []string{"video_400kbps_avc", "video_600kbps_avc", "video_900kbps_avc"}, names)
+	assert.Len(t, h.alternates(catalog.GetTrackByName("video_400kbps_hevc"+internal.LocmafTrackSuffix)), 3)
+	assert.Nil(t, h.alternates(catalog.GetTrackByName("audio_monotonic_128kbps_aac")))
+
+	var inits []string
+	for _, tr := range renditions {
+		initData, ok := catalog.InitDataFor(tr)
+		require.True(t, ok)
+		inits = append(inits, initData)
+	}
+	combined, trackID, err := combineInits(inits)
+	require.NoError(t, err)
+	init, err := parseCMAFInit(combined)
+	require.NoError(t, err)
+	stsd := init.Moov.Trak.Mdia.Minf.Stbl.Stsd
+	require.Len(t, stsd.Children, 3)
+	for i, tr := range renditions {
+		entry := stsd.Children[i].(*mp4.VisualSampleEntryBox)
+		assert.Equal(t, *tr.Width, int(entry.Width))
+	}
+
+	ct := asset.GetTrackByName("video_600kbps_avc")
+	group, err := internal.GenMoQGroup(ct, 100, 1, internal.MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	chunk, end, err := switchChunk(group.MoQObjects[1], trackID, 2)
+	require.NoError(t, err)
+	f, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(chunk))
+	require.NoError(t, err)
+	traf := f.Segments[0].Fragments[0].Moof.Traf
+	assert.Equal(t, uint32(2), traf.Tfhd.SampleDescriptionIndex)
+	assert.Equal(t, trackID, traf.Tfhd.TrackID)
+	assert.Equal(t, traf.Tfdt.BaseMediaDecodeTime()+uint64(ct.SampleDur), end)
+	assert.Equal(t, 100*uint64(ct.TimeScale)+2*uint64(ct.SampleDur), end, "end of the second sample of group 100")
+}
@@ -3,10 +3,15 @@ package sub
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/Eyevinn/moqlivemock/internal"
 	"github.com/Eyevinn/mp4ff/mp4"
@@ -14,9 +19,71 @@ import (
 
 // CENC holds decryption state for encrypted tracks.
 type CENC struct {
-	Key           []byte
 	DecryptInfo   map[string]mp4.DecryptInfo // keyed by track name
 	ProtectedMoov map[string]*mp4.MoovBox    // keyed by track name for locmaf rebuild
+
+	mu          sync.Mutex
+	defaultKIDs map[string]mp4.UUID // keyed by track name, from the tenc box
+	keys        map[string][]byte   // keyed by hex KID
+	laURL       string              // license server for KIDs not in keys
+	token       string              // bearer token for license requests
+}
+
+// addKeys stores the keys of a ClearKey response. c.mu must be held.
+func (c *CENC) addKeys(keys []keyInfo) error {
+	if c.keys == nil {
+		c.keys = make(map[string][]byte)
+	}
+	for _, k := range keys {
+		kid, err := base64.RawURLEncoding.DecodeString(k.Kid)
+		if err != nil {
+			return fmt.Errorf("unable to base64URL-decode kid in ClearKey response: %w", err)
+		}
+		key, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return fmt.Errorf("unable to base64URL-decode key in ClearKey response: %w", err)
+		}
+		c.keys[hex.EncodeToString(kid)] = key
+	}
+	return nil
+}
+
+// fragmentKey returns the key to decrypt payload of trackName with. If the
+// fragment signals a KID with a seig sample group, that key is used.
+// Otherwise, the default key of the track is used. A license is requested
+// for keys that are not yet known, without holding c.mu, so that tracks with
+// known keys are not held up by the request.
+func (c *CENC) fragmentKey(trackName string, payload []byte) ([]byte, error) {
+	kid, ok := internal.FragmentKID(payload)
+	c.mu.Lock()
+	if !ok {
+		kid = c.defaultKIDs[trackName]
+	}
+	kidHex := hex.EncodeToString(kid)
+	key, ok := c.keys[kidHex]
+	laURL, token := c.laURL, c.token
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if laURL == "" || len(kid) == 0 {
+		return nil, fmt.Errorf("no key for kid %s", kidHex)
+	}
+	slog.Info("requesting new key", "kid", kidHex)
+	keys, err := requestClearKey(laURL, token, []string{base64.RawURLEncoding.EncodeToString(kid)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ClearKey: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.addKeys(keys); err != nil {
+		return nil, err
+	}
+	key, ok = c.keys[kidHex]
+	if !ok {
+		return nil, fmt.Errorf("no key for kid %s in ClearKey response", kidHex)
+	}
+	return key, nil
 }
 
 type clearKeyRequest struct {
@@ -34,14 +101,23 @@ type clearKeyResponse struct {
 }
 
 // requestClearKey makes a POST request to a ClearKey server and returns the response.
-func requestClearKey(laurl string, kids []string) ([]keyInfo, error) {
+// If token is set, it is sent as a bearer token.
+func requestClearKey(laurl, token string, kids []string) ([]keyInfo, error) {
 	slog.Info("requesting clearkey license")
 	reqBody, err := json.Marshal(clearKeyRequest{Kids: kids})
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.Post(laurl, "application/json", bytes.NewBuffer(reqBody))
+	req, err := http.NewRequest(http.MethodPost, laurl, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +134,32 @@ func requestClearKey(laurl string, kids []string) ([]keyInfo, error) {
 	return ckResp.Keys, nil
 }
 
+// requestLicenseToken gets a bearer token for license requests from the
+// authorization service at authzURL, authorizing with secret as bearer token.
+func requestLicenseToken(authzURL, secret string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, authzURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	token, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
 // decryptInit decrypts the (Base64-encoded) init data, requests the ClearKey
 // license server, stores protection information in the Handler and returns the
 // decrypted init data Base64-encoded.
@@ -69,20 +171,22 @@ func (h *Handler) decryptInit(track *internal.Track, initData string) (string, e
 	if err != nil {
 		return "", fmt.Errorf("failed to base64 decode init data: %w", err)
 	}
-	decryptedInitBytes, _, decryptInfo, err := internal.DecryptInit(initDataBytes)
+	decryptedInitBytes, kid, decryptInfo, err := internal.DecryptInit(initDataBytes)
 	if err != nil {
 		return "", fmt.Errorf("failed to decrypt init: %w", err)
 	}
 	var clearKeyRefID string
-	for i, id := range track.ContentProtectionRefIDs {
-		if h.catalog.ContentProtections[i].DRMSystem.SystemID == internal.CommonSystemID {
-			clearKeyRefID = id
+	for _, id := range track.ContentProtectionRefIDs {
+		for _, cp := range h.catalog.ContentProtections {
+			if cp.RefID == id && cp.DRMSystem != nil && cp.DRMSystem.SystemID == internal.CommonSystemID {
+				clearKeyRefID = id
+			}
 		}
 	}
 	if clearKeyRefID == "" {
 		return "", fmt.Errorf("ClearKey not supported for track")
 	}
-	err = h.setClearKeyDecryptionKey(clearKeyRefID)
+	err = h.setClearKeyDecryptionKey(track.Name, clearKeyRefID, kid)
 	if err != nil {
 		return "", fmt.Errorf("failed to set ClearKey decryption key: %w", err)
 	}
@@ -92,12 +196,24 @@ func (h *Handler) decryptInit(track *internal.Track, initData string) (string, e
 	return decryptedInit, nil
 }
 
-func (h *Handler) decryptPayload(payload []byte, trackName string) ([]byte, error) {
-	return internal.DecryptFragment(payload, h.cenc.DecryptInfo[trackName], h.cenc.Key)
+// payloadDecrypter returns a function decrypting the media payloads of
+// trackName with the current decryption state.
+func (h *Handler) payloadDecrypter(trackName string) func(payload []byte) ([]byte, error) {
+	decryptInfo, cenc := h.cenc.DecryptInfo[trackName], h.cenc
+	return func(payload []byte) ([]byte, error) {
+		key, err := cenc.fragmentKey(trackName, payload)
+		if err != nil {
+			return nil, err
+		}
+		return internal.DecryptFragment(payload, decryptInfo, key)
+	}
 }
 
-// setClearKeyDecryptionKey parses the ContentProtections array and makes a ClearKey request.
-func (h *Handler) setClearKeyDecryptionKey(refID string) error {
+// setClearKeyDecryptionKey parses the ContentProtections array and makes a ClearKey
+// request for the default KIDs and defaultKID, the KID of the init segment of trackName.
+// With key rotation, the catalog lists the KIDs of the current and next key period,
+// and all keys in the response are stored.
+func (h *Handler) setClearKeyDecryptionKey(trackName, refID string, defaultKID mp4.UUID) error {
 	var clearKeyProtection internal.ContentProtection
 	for _, cp := range h.catalog.ContentProtections {
 		if refID == cp.RefID {
@@ -115,20 +231,47 @@ func (h *Handler) setClearKeyDecryptionKey(refID string) error {
 	if clearKeyProtection.RefID == "" {
 		return fmt.Errorf("failed to find contentProtection with refID %s", refID)
 	}
-	keys, err := requestClearKey(clearKeyProtection.DRMSystem.LaURL.URL, kids)
+	if defaultKID != nil {
+		if b64 := base64.RawURLEncoding.EncodeToString(defaultKID); !slices.Contains(kids, b64) {
+			kids = append(kids, b64)
+		}
+	}
+	laURL := clearKeyProtection.DRMSystem.LaURL.URL
+	token := h.LicenseToken
+	if token == "" && clearKeyProtection.DRMSystem.AuthzURL != nil {
+		var err error
+		token, err = requestLicenseToken(clearKeyProtection.DRMSystem.AuthzURL.URL, h.AuthzSecret)
+		if err != nil {
+			return fmt.Errorf("failed to fetch license token: %w", err)
+		}
+	}
+	keys, err := requestClearKey(laURL, token, kids)
 	if err != nil {
 		return fmt.Errorf("failed to fetch ClearKey: %w", err)
 	}
 	if len(keys) == 0 {
 		return fmt.Errorf("no keys found in ClearKey response")
 	}
-	if len(keys) > 1 {
-		return fmt.Errorf("too many keys in ClearKey response: got %d, want 1", len(keys))
+	h.cenc.mu.Lock()
+	defer h.cenc.mu.Unlock()
+	if err := h.cenc.addKeys(keys); err != nil {
+		return err
 	}
-	key, err := base64.RawURLEncoding.DecodeString(keys[0].K)
-	if err != nil {
-		return fmt.Errorf("unable to base64URL-decode key in ClearKey response: %w", err)
+	h.cenc.laURL = laURL
+	h.cenc.token = token
+	if h.cenc.defaultKIDs == nil {
+		h.cenc.defaultKIDs = make(map[string]mp4.UUID)
+	}
+	h.cenc.defaultKIDs[trackName] = defaultKID
+	if _, ok := h.cenc.keys[hex.EncodeToString(defaultKID)]; !ok {
+		if len(keys) > 1 {
+			return fmt.Errorf("no key for default kid %s in ClearKey response", hex.EncodeToString(defaultKID))
+		}
+		key, err := base64.RawURLEncoding.DecodeString(keys[0].K)
+		if err != nil {
+			return fmt.Errorf("unable to base64URL-decode key in ClearKey response: %w", err)
+		}
+		h.cenc.keys[hex.EncodeToString(defaultKID)] = key
 	}
-	h.cenc.Key = key
 	return nil
 }
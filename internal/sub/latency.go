@@ -0,0 +1,473 @@
+package sub
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Eyevinn/moqtransport"
+	"github.com/Eyevinn/moqtransport/moqmi"
+)
+
+// LatencyMeter measures the latency of received objects, i.e. the arrival
+// time minus the wall-clock media time of the first sample of the object.
+// Since the publisher aligns media time to wall clock, this is the latency
+// from capture to reception, including the duration of the object itself.
+// It also measures interarrival jitter, the time until each group has been
+// received, and the skew between the video and audio latency.
+// A LatencyMeter is safe for concurrent use.
+type LatencyMeter struct {
+	mu          sync.Mutex
+	start       time.Time
+	tracks      map[string]*trackLatency
+	order       []string                 // track names in order of first object
+	lastLatency map[string]time.Duration // latency of the latest object keyed by media type
+	skew        durationStats
+	windowSkew  durationStats
+}
+
+// trackLatency is the measurement state of one track.
+type trackLatency struct {
+	mediaType   string
+	objects     int
+	bytes       int
+	latency     durationStats
+	window      durationStats // latency since the last summary
+	jitter      float64       // RFC 3550 interarrival jitter in seconds
+	prevArrival time.Time
+	prevMedia   time.Time
+
+	groups     int
+	group      uint64
+	groupStart time.Time // media time of the first object of group
+	groupLast  time.Time // arrival of the latest object of group
+	completion durationStats
+}
+
+// NewLatencyMeter returns a LatencyMeter starting now.
+func NewLatencyMeter() *LatencyMeter {
+	return &LatencyMeter{
+		start:       time.Now(),
+		tracks:      make(map[string]*trackLatency),
+		lastLatency: make(map[string]time.Duration),
+	}
+}
+
+// Record records an object of size bytes in group of track, whose media
+// starts at mediaTime and that arrived at arrival.
+func (m *LatencyMeter) Record(track, mediaType string, group uint64, size int, mediaTime, arrival time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tl, ok := m.tracks[track]
+	if !ok {
+		tl = &trackLatency{mediaType: mediaType}
+		m.tracks[track] = tl
+		m.order = append(m.order, track)
+	}
+	latency := arrival.Sub(mediaTime)
+	tl.objects++
+	tl.bytes += size
+	tl.latency.add(latency)
+	tl.window.add(latency)
+	if !tl.prevArrival.IsZero() {
+		d := arrival.Sub(tl.prevArrival) - mediaTime.Sub(tl.prevMedia)
+		tl.jitter += (math.Abs(d.Seconds()) - tl.jitter) / 16
+	}
+	tl.prevArrival, tl.prevMedia = arrival, mediaTime
+
+	switch {
+	case tl.groups == 0 || group > tl.group:
+		if tl.groups > 0 {
+			tl.completion.add(tl.groupLast.Sub(tl.groupStart))
+		}
+		tl.groups++
+		tl.group, tl.groupStart, tl.groupLast = group, mediaTime, arrival
+	case group == tl.group:
+		tl.groupLast = arrival
+	}
+
+	m.lastLatency[mediaType] = latency
+	video, okVideo := m.lastLatency["video"]
+	audio, okAudio := m.lastLatency["audio"]
+	if okVideo && okAudio && (mediaType == "video" || mediaType == "audio") {
+		m.skew.add(video - audio)
+		m.windowSkew.add(video - audio)
+	}
+}
+
+// LogSummary logs the latency of each track and the A/V skew since the
+// previous summary.
+func (m *LatencyMeter) LogSummary() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range m.order {
+		tl := m.tracks[name]
+		if tl.window.n == 0 {
+			slog.Warn("latency: no objects received", "track", name)
+			continue
+		}
+		w := tl.window.summary()
+		slog.Info("latency", "track", name, "objects", w.Count,
+			"meanMS", w.Mean, "minMS", w.Min, "maxMS", w.Max,
+			"jitterMS", roundMS(tl.jitter*1000), "groups", tl.groups)
+		tl.window = durationStats{}
+	}
+	if m.windowSkew.n > 0 {
+		s := m.windowSkew.summary()
+		slog.Info("latency: A/V skew (video minus audio)", "meanMS", s.Mean, "minMS", s.Min, "maxMS", s.Max)
+		m.windowSkew = durationStats{}
+	}
+}
+
+// Totals returns the number of objects and bytes recorded so far.
+func (m *LatencyMeter) Totals() (objects, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tl := range m.tracks {
+		objects += tl.objects
+		bytes += tl.bytes
+	}
+	return objects, bytes
+}
+
+// LatencyReport is the report of a LatencyMeter. All durations are in
+// milliseconds.
+type LatencyReport struct {
+	Start     time.Time            `json:"start"`
+	DurationS float64              `json:"durationS"`
+	Tracks    []TrackLatencyReport `json:"tracks"`
+	// AVSkew is the video latency minus the audio latency, sampled at
+	// every video and audio object.
+	AVSkew *DurationSummary `json:"avSkewMS,omitempty"`
+}
+
+// TrackLatencyReport is the latency report of one track.
+type TrackLatencyReport struct {
+	Track     string          `json:"track"`
+	MediaType string          `json:"mediaType"`
+	Objects   int             `json:"objects"`
+	Bytes     int             `json:"bytes"`
+	Latency   DurationSummary `json:"latencyMS"`
+	JitterMS  float64         `json:"jitterMS"`
+	Groups    int             `json:"groups"`
+	// GroupCompletion is the time from the media start of a group until its
+	// last object was received, for all groups but the latest one.
+	GroupCompletion DurationSummary `json:"groupCompletionMS"`
+}
+
+// DurationSummary summarizes a set of durations in milliseconds.
+type DurationSummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	Max   float64 `json:"max"`
+}
+
+// Report returns the report of all objects recorded so far.
+func (m *LatencyMeter) Report() LatencyReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := LatencyReport{
+		Start:     m.start,
+		DurationS: roundMS(time.Since(m.start).Seconds()),
+		Tracks:    make([]TrackLatencyReport, 0, len(m.order)),
+	}
+	for _, name := range m.order {
+		tl := m.tracks[name]
+		r.Tracks = append(r.Tracks, TrackLatencyReport{
+			Track:           name,
+			MediaType:       tl.mediaType,
+			Objects:         tl.objects,
+			Bytes:           tl.bytes,
+			Latency:         tl.latency.summary(),
+			JitterMS:        roundMS(tl.jitter * 1000),
+			Groups:          tl.groups,
+			GroupCompletion: tl.completion.summary(),
+		})
+	}
+	if m.skew.n > 0 {
+		s := m.skew.summary()
+		r.AVSkew = &s
+	}
+	return r
+}
+
+// MergedReport returns the report of the objects recorded by all meters,
+// e.g. those of the clients of a load test, which record without sharing a
+// lock. The latency and group completion of a track are over the objects
+// and groups of all meters, while the jitter, which is defined per
+// receiver, is the mean of the jitter of the meters with the track.
+func MergedReport(meters []*LatencyMeter) LatencyReport {
+	merged := NewLatencyMeter()
+	jitterN := make(map[string]int)
+	for i, m := range meters {
+		m.mu.Lock()
+		if i == 0 || m.start.Before(merged.start) {
+			merged.start = m.start
+		}
+		for _, name := range m.order {
+			tl := m.tracks[name]
+			mtl, ok := merged.tracks[name]
+			if !ok {
+				mtl = &trackLatency{mediaType: tl.mediaType}
+				merged.tracks[name] = mtl
+				merged.order = append(merged.order, name)
+			}
+			mtl.objects += tl.objects
+			mtl.bytes += tl.bytes
+			mtl.latency.merge(&tl.latency)
+			mtl.jitter += tl.jitter
+			jitterN[name]++
+			mtl.groups += tl.groups
+			mtl.completion.merge(&tl.completion)
+		}
+		merged.skew.merge(&m.skew)
+		m.mu.Unlock()
+	}
+	for name, n := range jitterN {
+		merged.tracks[name].jitter /= float64(n)
+	}
+	return merged.Report()
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r LatencyReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the report as CSV with one row per track.
+func (r LatencyReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"track", "mediaType", "objects", "bytes",
+		"latencyMinMS", "latencyMeanMS", "latencyP50MS", "latencyP95MS", "latencyMaxMS",
+		"jitterMS", "groups", "groupCompletionMeanMS", "groupCompletionMaxMS"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, t := range r.Tracks {
+		row := []string{t.Track, t.MediaType, strconv.Itoa(t.Objects), strconv.Itoa(t.Bytes),
+			f(t.Latency.Min), f(t.Latency.Mean), f(t.Latency.P50), f(t.Latency.P95), f(t.Latency.Max),
+			f(t.JitterMS), strconv.Itoa(t.Groups), f(t.GroupCompletion.Mean), f(t.GroupCompletion.Max)}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// durationStats accumulates durations. Percentiles are estimated from a
+// histogram with logarithmic buckets of relative width bucketGrowth, which
+// bounds the memory of long runs. Each bucket keeps the sum of its values,
+// so the estimate is the mean of the bucket and exact if all of its values
+// are equal.
+type durationStats struct {
+	n        int
+	sum      time.Duration
+	min, max time.Duration
+	buckets  map[int]histBucket
+}
+
+// histBucket is a bucket of the histogram of a durationStats.
+type histBucket struct {
+	n   int
+	sum time.Duration
+}
+
+// bucketGrowth is the relative width of a histogram bucket. Durations from
+// a microsecond to an hour fit in about 2200 buckets of each sign.
+const bucketGrowth = 0.01
+
+var logBucketGrowth = math.Log1p(bucketGrowth)
+
+// bucketIndex returns the index of the histogram bucket of d. Indexes are
+// ordered like the durations, with 0 for durations below a microsecond and
+// negative indexes for negative durations.
+func bucketIndex(d time.Duration) int {
+	us := math.Abs(float64(d) / float64(time.Microsecond))
+	if us < 1 {
+		return 0
+	}
+	i := 1 + int(math.Log(us)/logBucketGrowth)
+	if d < 0 {
+		return -i
+	}
+	return i
+}
+
+func (s *durationStats) add(d time.Duration) {
+	if s.n == 0 || d < s.min {
+		s.min = d
+	}
+	if s.n == 0 || d > s.max {
+		s.max = d
+	}
+	s.n++
+	s.sum += d
+	if s.buckets == nil {
+		s.buckets = make(map[int]histBucket)
+	}
+	i := bucketIndex(d)
+	b := s.buckets[i]
+	b.n++
+	b.sum += d
+	s.buckets[i] = b
+}
+
+// merge adds the durations of o to s.
+func (s *durationStats) merge(o *durationStats) {
+	if o.n == 0 {
+		return
+	}
+	if s.n == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.n == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.n += o.n
+	s.sum += o.sum
+	if s.buckets == nil {
+		s.buckets = make(map[int]histBucket, len(o.buckets))
+	}
+	for i, ob := range o.buckets {
+		b := s.buckets[i]
+		b.n += ob.n
+		b.sum += ob.sum
+		s.buckets[i] = b
+	}
+}
+
+// percentile returns an estimate of the p-th percentile, the value at rank
+// (n-1)*p/100 of the sorted values.
+func (s *durationStats) percentile(p int) time.Duration {
+	rank := (s.n - 1) * p / 100
+	for _, i := range slices.Sorted(maps.Keys(s.buckets)) {
+		b := s.buckets[i]
+		if rank < b.n {
+			return b.sum / time.Duration(b.n)
+		}
+		rank -= b.n
+	}
+	return s.max
+}
+
+func (s *durationStats) summary() DurationSummary {
+	if s.n == 0 {
+		return DurationSummary{}
+	}
+	return DurationSummary{
+		Count: s.n,
+		Min:   durationMS(s.min),
+		Mean:  durationMS(s.sum / time.Duration(s.n)),
+		P50:   durationMS(s.percentile(50)),
+		P95:   durationMS(s.percentile(95)),
+		Max:   durationMS(s.max),
+	}
+}
+
+func durationMS(d time.Duration) float64 {
+	return roundMS(float64(d) / float64(time.Millisecond))
+}
+
+// roundMS rounds to microsecond precision when v is in milliseconds.
+func roundMS(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// cmafMediaTime returns the media time of the first sample of a CMAF chunk,
+// read from the tfdt box of its first track fragment, given the timescale.
+func cmafMediaTime(chunk []byte, timescale int) (time.Time, bool) {
+	if timescale <= 0 {
+		return time.Time{}, false
+	}
+	data, ok := childBox(chunk, "moof")
+	if !ok {
+		return time.Time{}, false
+	}
+	if data, ok = childBox(data, "traf"); !ok {
+		return time.Time{}, false
+	}
+	if data, ok = childBox(data, "tfdt"); !ok || len(data) < 8 {
+		return time.Time{}, false
+	}
+	var bmdt uint64
+	if data[0] == 1 {
+		if len(data) < 12 {
+			return time.Time{}, false
+		}
+		bmdt = binary.BigEndian.Uint64(data[4:12])
+	} else {
+		bmdt = uint64(binary.BigEndian.Uint32(data[4:8]))
+	}
+	sec, rest := bmdt/uint64(timescale), bmdt%uint64(timescale)
+	return time.Unix(int64(sec), int64(rest*uint64(time.Second)/uint64(timescale))), true
+}
+
+// childBox returns the payload of the first box of type boxType in data.
+func childBox(data []byte, boxType string) ([]byte, bool) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		hdrLen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, false
+			}
+			size, hdrLen = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < hdrLen || size > uint64(len(data)) {
+			return nil, false
+		}
+		if string(data[4:8]) == boxType {
+			return data[hdrLen:size], true
+		}
+		data = data[size:]
+	}
+	return nil, false
+}
+
+// moqMIWallclock returns the wall-clock time of a moq-mi object from its
+// media metadata extension header.
+func moqMIWallclock(headers moqtransport.KVPList) (time.Time, bool) {
+	mt, ok := moqmi.MediaType(headers)
+	if !ok {
+		return time.Time{}, false
+	}
+	var wallclockMS uint64
+	var present bool
+	var err error
+	switch mt {
+	case moqmi.MediaTypeVideoH264AVCC:
+		var meta moqmi.VideoMetadata
+		meta, present, err = moqmi.ReadVideoMetadata(headers)
+		wallclockMS = meta.WallclockMS
+	case moqmi.MediaTypeAudioAACLC:
+		var meta moqmi.AudioMetadata
+		meta, present, err = moqmi.ReadAudioAACMetadata(headers)
+		wallclockMS = meta.WallclockMS
+	case moqmi.MediaTypeAudioOpus:
+		var meta moqmi.AudioMetadata
+		meta, present, err = moqmi.ReadAudioOpusMetadata(headers)
+		wallclockMS = meta.WallclockMS
+	}
+	if err != nil || !present || wallclockMS == 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(wallclockMS)), true
+}
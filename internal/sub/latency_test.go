@@ -0,0 +1,142 @@
+package sub
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyMeter(t *testing.T) {
+	m := NewLatencyMeter()
+	t0 := time.Unix(1000, 0)
+	ms := time.Millisecond
+	// Video objects of 40ms, arriving 50ms after their media start, except
+	// the third one that arrives 20ms late.
+	m.Record("video", "video", 1000, 100, t0, t0.Add(50*ms))
+	m.Record("audio", "audio", 1000, 10, t0, t0.Add(30*ms))
+	m.Record("video", "video", 1000, 100, t0.Add(40*ms), t0.Add(90*ms))
+	m.Record("video", "video", 1001, 100, t0.Add(80*ms), t0.Add(150*ms))
+	m.Record("video", "video", 1000, 100, t0.Add(120*ms), t0.Add(170*ms))
+
+	r := m.Report()
+	require.Len(t, r.Tracks, 2)
+	v := r.Tracks[0]
+	assert.Equal(t, "video", v.Track)
+	assert.Equal(t, 4, v.Objects)
+	assert.Equal(t, 400, v.Bytes)
+	assert.Equal(t, DurationSummary{Count: 4, Min: 50, Mean: 55, P50: 50, P95: 50, Max: 70}, v.Latency)
+	// D is 0, +20ms and -20ms, so J = 20/16 and then J += (20 - J)/16.
+	j := 20.0 / 16
+	j += (20 - j) / 16
+	assert.InDelta(t, j, v.JitterMS, 0.001)
+	assert.Equal(t, 2, v.Groups)
+	assert.Equal(t, DurationSummary{Count: 1, Min: 90, Mean: 90, P50: 90, P95: 90, Max: 90}, v.GroupCompletion,
+		"a late object of an earlier group does not complete it again")
+	require.NotNil(t, r.AVSkew)
+	assert.Equal(t, 4, r.AVSkew.Count)
+	assert.Equal(t, 20.0, r.AVSkew.Min)
+	assert.Equal(t, 40.0, r.AVSkew.Max)
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteJSON(&buf))
+	var decoded LatencyReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, r.Tracks, decoded.Tracks)
+
+	buf.Reset()
+	require.NoError(t, r.WriteCSV(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[1], "video,video,4,400,50,55,50,50,70,"), lines[1])
+}
+
+func TestMergedReport(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	ms := time.Millisecond
+	// Two clients with steady arrivals each, but at different latencies, so
+	// that mixing their objects in one meter would give a large jitter.
+	a, b := NewLatencyMeter(), NewLatencyMeter()
+	for i := range 3 {
+		media := t0.Add(time.Duration(i) * 40 * ms)
+		a.Record("video", "video", 1000+uint64(i), 100, media, media.Add(50*ms))
+		b.Record("video", "video", 1000+uint64(i), 100, media, media.Add(150*ms))
+	}
+	b.Record("audio", "audio", 1000, 10, t0, t0.Add(30*ms))
+
+	r := MergedReport([]*LatencyMeter{a, b})
+	require.Len(t, r.Tracks, 2)
+	v := r.Tracks[0]
+	assert.Equal(t, "video", v.Track)
+	assert.Equal(t, 6, v.Objects)
+	assert.Equal(t, 600, v.Bytes)
+	assert.Equal(t, DurationSummary{Count: 6, Min: 50, Mean: 100, P50: 50, P95: 150, Max: 150}, v.Latency)
+	assert.Equal(t, 0.0, v.JitterMS, "jitter is per client")
+	assert.Equal(t, 6, v.Groups)
+	assert.Equal(t, 4, v.GroupCompletion.Count)
+	assert.Equal(t, "audio", r.Tracks[1].Track)
+	assert.Equal(t, 1, r.Tracks[1].Objects)
+	require.NotNil(t, r.AVSkew)
+	assert.Equal(t, 1, r.AVSkew.Count)
+	assert.Equal(t, 120.0, r.AVSkew.Min)
+}
+
+func TestDurationStats(t *testing.T) {
+	var s durationStats
+	// A million latencies from -10ms to 10s, uniform in the logarithm.
+	const n = 1_000_000
+	values := make([]time.Duration, 0, n)
+	for i := range n {
+		d := time.Duration(float64(10*time.Microsecond) * math.Pow(1e6, float64(i)/n))
+		if i%10 == 0 && d < 10*time.Millisecond {
+			d = -d
+		}
+		values = append(values, d)
+		s.add(d)
+	}
+	// ln(1e6)/ln(1.01) positive and ln(1e3)/ln(1.01) negative buckets.
+	assert.LessOrEqual(t, len(s.buckets), 2100, "memory is bounded")
+	slices.Sort(values)
+	for _, p := range []int{0, 5, 50, 95, 100} {
+		want := values[(n-1)*p/100]
+		assert.InEpsilon(t, want, s.percentile(p), bucketGrowth, "p%d", p)
+	}
+	sum := s.summary()
+	assert.Equal(t, n, sum.Count)
+	assert.Equal(t, durationMS(values[0]), sum.Min)
+	assert.Equal(t, durationMS(values[n-1]), sum.Max)
+
+	// Merging the stats of two halves gives the stats of all values.
+	var even, odd durationStats
+	for i, d := range values {
+		if i%2 == 0 {
+			even.add(d)
+		} else {
+			odd.add(d)
+		}
+	}
+	even.merge(&odd)
+	assert.Equal(t, s.summary(), even.summary())
+}
+
+func TestCMAFMediaTime(t *testing.T) {
+	asset, err := internal.LoadAsset(filepath.Join("..", "..", "assets", "test10s"), 1, 1)
+	require.NoError(t, err)
+	ct := asset.GetTrackByName("video_400kbps_avc")
+	group, err := internal.GenMoQGroup(ct, 100, 1, internal.MoqGroupDurMS, "cmaf")
+	require.NoError(t, err)
+	mediaTime, ok := cmafMediaTime(group.MoQObjects[1], int(ct.TimeScale))
+	require.True(t, ok)
+	assert.Equal(t, time.Unix(100, int64(ct.SampleDur)*int64(time.Second)/int64(ct.TimeScale)), mediaTime)
+
+	_, ok = cmafMediaTime([]byte{0, 0, 0, 8, 'f', 'r', 'e', 'e'}, int(ct.TimeScale))
+	assert.False(t, ok)
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 	"log/slog"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/Eyevinn/moqtransport"
 	"github.com/Eyevinn/moqtransport/moqmi"
@@ -23,14 +25,7 @@ func IsMoqMINamespace(ns []string) bool {
 // handleMoqMI subscribes directly to a fixed set of moq-mi track names
 // (video0, audio0) without fetching a catalog, parses the moq-mi extension
 // headers on each object, and writes raw payloads to configured outputs.
-func (h *Handler) handleMoqMI(ctx context.Context, conn moqtransport.Connection) {
-	session, err := h.startSession(conn)
-	if err != nil {
-		slog.Error("moq-mi: session init failed", "error", err)
-		_ = conn.CloseWithError(0, "session initialization error")
-		return
-	}
-
+func (h *Handler) handleMoqMI(ctx context.Context, conn moqtransport.Connection, session *moqtransport.Session) {
 	slog.Info("moq-mi: subscribing to fixed track names", "namespace", h.Namespace)
 
 	anySubscribed := false
@@ -63,26 +58,38 @@ func (h *Handler) handleMoqMI(ctx context.Context, conn moqtransport.Connection)
 // written to h.Outs[mediaType] when configured.
 func (h *Handler) subscribeMoqMI(ctx context.Context, s *moqtransport.Session,
 	trackName, mediaType string) (func() error, error) {
-	rs, err := s.Subscribe(ctx, h.Namespace, trackName)
+	// Start at a group boundary so that video starts with an IDR frame.
+	rs, err := s.Subscribe(ctx, h.Namespace, trackName,
+		moqtransport.WithFilterType(moqtransport.FilterTypeNextGroupStart))
 	if err != nil {
 		return nil, fmt.Errorf("subscribe %s: %w", trackName, err)
 	}
 	slog.Info("moq-mi: subscribed", "track", trackName, "mediaType", mediaType)
 	out := h.Outs[mediaType]
+	var ended atomic.Bool // ended by PUBLISH_DONE
 	go func() {
 		var lastSeq uint64
 		var haveSeq bool
 		for {
 			o, err := rs.ReadObject(ctx)
-			if err != nil {
-				if err == io.EOF {
+			if err != nil && o == nil {
+				switch {
+				case err == io.EOF:
 					slog.Info("moq-mi: read EOF", "track", trackName)
-				} else {
+				case publishDone(trackName, err):
+					ended.Store(true)
+				default:
 					slog.Warn("moq-mi: read ended", "track", trackName, "error", err)
 				}
 				return
 			}
+			arrival := time.Now()
 			logMoqMIObject(trackName, o, &lastSeq, &haveSeq)
+			if h.Latency != nil {
+				if mediaTime, ok := moqMIWallclock(o.ExtensionHeaders); ok {
+					h.Latency.Record(trackName, mediaType, o.GroupID, len(o.Payload), mediaTime, arrival)
+				}
+			}
 			if out != nil {
 				if _, werr := out.Write(o.Payload); werr != nil {
 					slog.Error("moq-mi: write payload failed", "track", trackName, "error", werr)
@@ -91,7 +98,12 @@ func (h *Handler) subscribeMoqMI(ctx context.Context, s *moqtransport.Session,
 			}
 		}
 	}()
-	return rs.Close, nil
+	return func() error {
+		if ended.Load() {
+			return nil
+		}
+		return rs.Close()
+	}, nil
 }
 
 // logMoqMIObject parses moqmi extension headers on a received object and logs
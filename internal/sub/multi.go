@@ -0,0 +1,303 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqtransport"
+)
+
+// Subscription selects tracks of a namespace to subscribe to, each written
+// to an output of its own.
+type Subscription struct {
+	Namespace []string
+	// Track is a regular expression matching the names of the video, audio
+	// and subtitle tracks to subscribe to. Empty matches all of them.
+	Track string
+	// Output returns the output of a matched track. If nil, or if it returns
+	// a nil writer, the objects of the track are read but not written.
+	Output func(trackName string) (io.Writer, error)
+}
+
+// ParseSubscription parses a subscription given as
+// ns=namespace,track=regexp[,out=output]. The namespace is split into tuple
+// elements at white space. Commas within braces belong to the value, so the
+// track expression may contain quantifiers such as {1,3}. The output is
+// returned as is, since the caller creates the outputs.
+func ParseSubscription(value string) (Subscription, string, error) {
+	var s Subscription
+	var out string
+	for _, kv := range splitOutsideBraces(value) {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			return s, "", fmt.Errorf("bad subscription parameter %q, want key=value", kv)
+		}
+		switch key {
+		case "ns":
+			s.Namespace = strings.Fields(val)
+		case "track":
+			if _, err := regexp.Compile(val); err != nil {
+				return s, "", fmt.Errorf("bad track expression: %w", err)
+			}
+			s.Track = val
+		case "out":
+			out = val
+		default:
+			return s, "", fmt.Errorf("unknown subscription parameter %q", key)
+		}
+	}
+	if len(s.Namespace) == 0 {
+		return s, "", fmt.Errorf("subscription %q has no ns", value)
+	}
+	return s, out, nil
+}
+
+// splitOutsideBraces splits value at the commas that are not within braces.
+func splitOutsideBraces(value string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range value {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth = max(depth-1, 0)
+		case ',':
+			if depth == 0 {
+				parts = append(parts, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, value[start:])
+}
+
+// trackPattern is a compiled Subscription of the namespace of a Handler.
+type trackPattern struct {
+	re     *regexp.Regexp
+	output func(trackName string) (io.Writer, error)
+}
+
+// roleMediaTypes maps catalog roles to the media types of the outputs.
+var roleMediaTypes = map[string]string{
+	"video":            "video",
+	"signlanguage":     "video",
+	"audio":            "audio",
+	"audiodescription": "audio",
+	"subtitle":         "subs",
+}
+
+// moqMITracks are the fixed track names of moq-mi namespaces, which have no
+// catalog, and their media types.
+var moqMITracks = []struct{ name, mediaType string }{
+	{"video0", "video"},
+	{"audio0", "audio"},
+}
+
+// validateSubscriptions checks that the Subscriptions have a namespace and a
+// valid track expression.
+func (h *Handler) validateSubscriptions() error {
+	for i, s := range h.Subscriptions {
+		if len(s.Namespace) == 0 {
+			return fmt.Errorf("subscription %d has no namespace", i)
+		}
+		if _, err := regexp.Compile(s.Track); err != nil {
+			return fmt.Errorf("subscription %d: bad track expression: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// runSubscriptions retrieves the catalog of each namespace of the
+// Subscriptions on session, and subscribes to all matching tracks. Each
+// namespace is handled by a Handler of its own, which also follows the
+// catalog updates of the namespace.
+func (h *Handler) runSubscriptions(ctx context.Context, conn moqtransport.Connection, session *moqtransport.Session) {
+	var handlers []*Handler
+	for _, s := range h.Subscriptions {
+		var nh *Handler
+		for _, prev := range handlers {
+			if tupleEqual(prev.Namespace, s.Namespace) {
+				nh = prev
+			}
+		}
+		if nh == nil {
+			nh = h.namespaceHandler(s.Namespace)
+			handlers = append(handlers, nh)
+		}
+		nh.patterns = append(nh.patterns, trackPattern{
+			re:     regexp.MustCompile(s.Track),
+			output: s.Output,
+		})
+	}
+	subscribed := 0
+	for _, nh := range handlers {
+		if err := nh.subscribePatterns(ctx, session); err != nil {
+			slog.Error("failed to subscribe to namespace", "namespace", nh.Namespace, "error", err)
+			continue
+		}
+		subscribed++
+	}
+	if subscribed == 0 {
+		if err := conn.CloseWithError(0, errNoMatchingTracks.Error()); err != nil {
+			slog.Error("failed to close connection", "error", err)
+		}
+	}
+}
+
+// namespaceHandler returns a Handler for the subscriptions of ns, with the
+// catalog settings of h.
+func (h *Handler) namespaceHandler(ns []string) *Handler {
+	return &Handler{
+		Namespace:    ns,
+		Outs:         map[string]io.Writer{"catalog": h.Outs["catalog"]},
+		Logfh:        h.Logfh,
+		UseFetch:     h.UseFetch,
+		CatalogMode:  h.CatalogMode,
+		CatalogTrack: h.CatalogTrack,
+		Latency:      h.Latency,
+		LicenseToken: h.LicenseToken,
+		AuthzSecret:  h.AuthzSecret,
+		OnSubscribe:  h.OnSubscribe,
+		trackSubs:    make(map[string]func() error),
+		trackOuts:    make(map[string]io.Writer),
+	}
+}
+
+// subscribePatterns subscribes to the tracks of Namespace matching its
+// patterns, after retrieving the catalog. moq-mi namespaces have no catalog,
+// so their fixed track names are matched instead.
+func (h *Handler) subscribePatterns(ctx context.Context, session *moqtransport.Session) error {
+	if IsMoqMINamespace(h.Namespace) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for _, t := range moqMITracks {
+			out, ok := h.patternOutput(t.name)
+			if !ok {
+				continue
+			}
+			th := h.trackHandler(t.mediaType, out)
+			closeFn, err := th.subscribeMoqMI(ctx, session, t.name, t.mediaType)
+			h.subscribed(t.name, err)
+			if err != nil {
+				slog.Error("failed to subscribe to track", "namespace", h.Namespace, "track", t.name, "error", err)
+				continue
+			}
+			h.trackSubs[t.name] = closeFn
+		}
+		if len(h.trackSubs) == 0 {
+			return errNoMatchingTracks
+		}
+		return nil
+	}
+	if err := h.retrieveCatalog(ctx, session); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.session = session
+	h.reconcilePatterns(ctx)
+	if len(h.trackSubs) == 0 {
+		return errNoMatchingTracks
+	}
+	return nil
+}
+
+// reconcilePatterns makes the subscriptions follow the catalog: tracks no
+// longer in the catalog are unsubscribed, and matching tracks not yet
+// subscribed to are. The mutex must be held.
+func (h *Handler) reconcilePatterns(ctx context.Context) {
+	for name, closeFn := range h.trackSubs {
+		if h.catalog.GetTrackByName(name) != nil {
+			continue
+		}
+		slog.Info("subscribed track removed from catalog", "namespace", h.Namespace, "track", name)
+		if err := closeFn(); err != nil {
+			slog.Error("failed to close subscription", "track", name, "error", err)
+		}
+		delete(h.trackSubs, name)
+	}
+	for i := range h.catalog.Tracks {
+		track := &h.catalog.Tracks[i]
+		mediaType := roleMediaTypes[track.Role]
+		if mediaType == "" || h.trackSubs[track.Name] != nil {
+			continue
+		}
+		out, ok := h.patternOutput(track.Name)
+		if !ok {
+			continue
+		}
+		closeFn, err := h.subscribeTrack(ctx, track, mediaType, out)
+		h.subscribed(track.Name, err)
+		if err != nil {
+			slog.Error("failed to subscribe to track", "namespace", h.Namespace, "track", track.Name, "error", err)
+			continue
+		}
+		h.trackSubs[track.Name] = closeFn
+		slog.Info("subscribed to track", "namespace", h.Namespace, "track", track.Name, "mediaType", mediaType)
+	}
+}
+
+// subscribed reports the result of a subscription to OnSubscribe, if set.
+func (h *Handler) subscribed(track string, err error) {
+	if h.OnSubscribe != nil {
+		h.OnSubscribe(h.Namespace, track, err)
+	}
+}
+
+// patternOutput returns the output of the first pattern matching trackName,
+// and whether any pattern matched. An output is only created once per track.
+func (h *Handler) patternOutput(trackName string) (io.Writer, bool) {
+	for _, p := range h.patterns {
+		if !p.re.MatchString(trackName) {
+			continue
+		}
+		if out, ok := h.trackOuts[trackName]; ok || p.output == nil {
+			return out, true
+		}
+		out, err := p.output(trackName)
+		if err != nil {
+			slog.Error("failed to create output", "track", trackName, "error", err)
+			return nil, false
+		}
+		h.trackOuts[trackName] = out
+		return out, true
+	}
+	return nil, false
+}
+
+// subscribeTrack subscribes to track and writes it to out, with a Handler
+// of its own for the outputs and decryption state of the track.
+func (h *Handler) subscribeTrack(ctx context.Context, track *internal.Track, mediaType string,
+	out io.Writer) (func() error, error) {
+	th := h.trackHandler(mediaType, out)
+	initData, err := th.trackInit(track)
+	if err != nil {
+		return nil, err
+	}
+	th.setupOutput(track, mediaType, initData)
+	return th.subscribeAndRead(ctx, h.session, h.Namespace, track.Name, mediaType, nil)
+}
+
+// trackHandler returns a Handler writing mediaType to out, sharing the
+// catalog of h.
+func (h *Handler) trackHandler(mediaType string, out io.Writer) *Handler {
+	th := &Handler{
+		Namespace:    h.Namespace,
+		Outs:         map[string]io.Writer{},
+		Logfh:        h.Logfh,
+		Latency:      h.Latency,
+		LicenseToken: h.LicenseToken,
+		AuthzSecret:  h.AuthzSecret,
+		catalog:      h.catalog,
+	}
+	if out != nil {
+		th.Outs[mediaType] = out
+	}
+	return th
+}
@@ -0,0 +1,43 @@
+package sub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubscription(t *testing.T) {
+	cases := []struct {
+		desc    string
+		value   string
+		ns      []string
+		track   string
+		out     string
+		wantErr bool
+	}{
+		{desc: "namespace only", value: "ns=cmsf/clear", ns: []string{"cmsf/clear"}},
+		{desc: "tuple namespace", value: "ns=demo bbb,track=^video", ns: []string{"demo", "bbb"}, track: "^video"},
+		{desc: "output", value: "ns=cmsf/clear,track=^audio_,out=audio.mp4", ns: []string{"cmsf/clear"},
+			track: "^audio_", out: "audio.mp4"},
+		{desc: "quantifier", value: "ns=cmsf/clear,track=^video_{1,3}x,out=video.mp4",
+			ns: []string{"cmsf/clear"}, track: "^video_{1,3}x", out: "video.mp4"},
+		{desc: "no namespace", value: "track=^video_", wantErr: true},
+		{desc: "no value", value: "ns=cmsf/clear,video", wantErr: true},
+		{desc: "unknown key", value: "ns=cmsf/clear,name=video", wantErr: true},
+		{desc: "bad expression", value: "ns=cmsf/clear,track=(video", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			s, out, err := ParseSubscription(c.value)
+			if c.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.ns, s.Namespace)
+			assert.Equal(t, c.track, s.Track)
+			assert.Equal(t, c.out, out)
+		})
+	}
+}
@@ -35,8 +35,17 @@ func NewCmafMux(w io.Writer) *CmafMux {
 	}
 }
 
+// HasInit reports whether an init segment has been added for contentType.
+func (m *CmafMux) HasInit(contentType string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inits[contentType] != nil
+}
+
 // AddInit adds an init segment for the given content type (video or audio).
 func (m *CmafMux) AddInit(initData string, contentType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.inits[contentType] != nil {
 		return fmt.Errorf("init already added for %s", contentType)
 	}
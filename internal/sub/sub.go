@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Eyevinn/locmaf"
 	"github.com/Eyevinn/moqlivemock/internal"
@@ -38,11 +42,44 @@ type Handler struct {
 	Discover     bool     // Discovery mode: list namespaces and exit
 	CatalogTrack string   // Catalog track name (default "catalog")
 	Protocols    []string // Application protocols offered to the peer (ALPN / WT subprotocol)
+	// ABR, if set, switches the video track between the alternates of the
+	// selected track at group boundaries.
+	ABR ABRRule
+	// Latency, if set, measures the latency of all received media objects.
+	Latency *LatencyMeter
+	// LicenseToken, if set, is sent as bearer token in ClearKey license
+	// requests. Otherwise, a token is fetched from the authzURL of the
+	// ClearKey DRM system if the catalog announces one.
+	LicenseToken string
+	// AuthzSecret is the shared secret sent as bearer token to the authzURL
+	// to get the license token.
+	AuthzSecret string
+	// DiscoverPrefix, if set, is sent in a SUBSCRIBE_NAMESPACE, and the
+	// announced namespaces matching it are listed.
+	DiscoverPrefix []string
+	// DiscoverSelect, with DiscoverPrefix, selects the first matching
+	// namespace as Namespace and subscribes to its tracks.
+	DiscoverSelect bool
+	// Subscriptions, if set, are used instead of Namespace and the track
+	// name filters. All tracks matching a subscription are subscribed to.
+	Subscriptions []Subscription
+	// OnSubscribe, if set, is called with the result of each subscription
+	// to a track matched by Subscriptions.
+	OnSubscribe func(namespace []string, track string, err error)
 
+	mu         sync.Mutex // protects the catalog and track selection state below
 	catalog    *internal.Catalog
 	mux        *CmafMux
 	cenc       *CENC
 	locWriters map[string]interface{ Write([]byte) error } // LOC output writers keyed by media type
+	session    *moqtransport.Session                       // set once the initial tracks are subscribed
+	selected   map[string]string                           // subscribed track name keyed by media type
+	closers    map[string]func() error                     // subscription close functions keyed by media type
+	abr        *abrState                                   // set if ABR is active for the video track
+	discovered chan []string                               // namespaces matching DiscoverPrefix
+	patterns   []trackPattern                              // Subscriptions of Namespace, if set
+	trackSubs  map[string]func() error                     // close functions of pattern subscriptions keyed by track name
+	trackOuts  map[string]io.Writer                        // outputs of pattern subscriptions keyed by track name
 }
 
 // RunWithConn sets up the mux (if Outs["mux"] is set) and runs the subscriber
@@ -57,10 +94,23 @@ func (h *Handler) RunWithConn(ctx context.Context, conn moqtransport.Connection)
 	if h.Discover {
 		return h.runDiscover(ctx, conn)
 	}
-	if IsMoqMINamespace(h.Namespace) {
-		h.handleMoqMI(ctx, conn)
+	if len(h.DiscoverPrefix) > 0 {
+		return h.runDiscoverPrefix(ctx, conn)
+	}
+	if err := h.validateSubscriptions(); err != nil {
+		return err
+	}
+	session, err := h.startSession(conn)
+	if err != nil {
+		slog.Error("MoQ Session initialization failed", "error", err)
+		err = conn.CloseWithError(0, "session initialization error")
+		if err != nil {
+			slog.Error("failed to close connection", "error", err)
+		}
+	} else if len(h.Subscriptions) > 0 {
+		h.runSubscriptions(ctx, conn, session)
 	} else {
-		h.handle(ctx, conn)
+		h.run(ctx, conn, session)
 	}
 	<-ctx.Done()
 	slog.Info("end of RunWithConn")
@@ -84,10 +134,55 @@ func (h *Handler) runDiscover(ctx context.Context, conn moqtransport.Connection)
 	return nil
 }
 
+// runDiscoverPrefix subscribes to the namespaces matching DiscoverPrefix. The
+// announced namespaces are listed until ctx is done or, if DiscoverSelect is
+// set, the first one is selected and its tracks are subscribed to.
+func (h *Handler) runDiscoverPrefix(ctx context.Context, conn moqtransport.Connection) error {
+	h.discovered = make(chan []string, 16)
+	session, err := h.startSession(conn)
+	if err != nil {
+		return fmt.Errorf("session init: %w", err)
+	}
+	slog.Info("sending SUBSCRIBE_NAMESPACE", "prefix", h.DiscoverPrefix)
+	if err := session.SubscribeAnnouncements(ctx, h.DiscoverPrefix); err != nil {
+		return fmt.Errorf("SUBSCRIBE_NAMESPACE %v: %w", h.DiscoverPrefix, err)
+	}
+	slog.Info("SUBSCRIBE_NAMESPACE accepted, waiting for namespace announcements...", "prefix", h.DiscoverPrefix)
+	if !h.DiscoverSelect {
+		<-ctx.Done()
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("no namespace announced for prefix %v: %w", h.DiscoverPrefix, ctx.Err())
+	case ns := <-h.discovered:
+		slog.Info("selected namespace", "namespace", ns)
+		h.Namespace = ns
+	}
+	h.run(ctx, conn, session)
+	<-ctx.Done()
+	slog.Info("end of RunWithConn")
+	return ctx.Err()
+}
+
+// run retrieves the tracks of Namespace on the running session, with or
+// without a catalog depending on the namespace.
+func (h *Handler) run(ctx context.Context, conn moqtransport.Connection, session *moqtransport.Session) {
+	if IsMoqMINamespace(h.Namespace) {
+		h.handleMoqMI(ctx, conn, session)
+	} else {
+		h.handle(ctx, conn, session)
+	}
+}
+
 func (h *Handler) getHandler() moqtransport.Handler {
 	return moqtransport.HandlerFunc(func(w moqtransport.ResponseWriter, r *moqtransport.Message) {
 		switch r.Method {
 		case moqtransport.MessageAnnounce:
+			if h.discovered != nil {
+				h.handleDiscovered(w, r.Namespace)
+				return
+			}
 			if h.AcceptAny || h.Discover {
 				slog.Info("discovered namespace", "namespace", r.Namespace)
 				err := w.Accept()
@@ -96,8 +191,14 @@ func (h *Handler) getHandler() moqtransport.Handler {
 				}
 				return
 			}
-			if !tupleEqual(r.Namespace, h.Namespace) {
-				slog.Warn("got unexpected announcement namespace",
+			if !h.wantsNamespace(r.Namespace) {
+				// With Subscriptions, the other namespaces of the publisher
+				// are expected, and would flood the logs of mlmload.
+				level := slog.LevelWarn
+				if len(h.Subscriptions) > 0 {
+					level = slog.LevelDebug
+				}
+				slog.Log(context.Background(), level, "got unexpected announcement namespace",
 					"received", r.Namespace,
 					"expected", h.Namespace)
 				err := w.Reject(0, "non-matching namespace")
@@ -115,6 +216,40 @@ func (h *Handler) getHandler() moqtransport.Handler {
 	})
 }
 
+// wantsNamespace reports whether ns is the Namespace or that of one of the
+// Subscriptions.
+func (h *Handler) wantsNamespace(ns []string) bool {
+	if tupleEqual(ns, h.Namespace) {
+		return true
+	}
+	for _, s := range h.Subscriptions {
+		if tupleEqual(ns, s.Namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDiscovered accepts an announced namespace matching DiscoverPrefix
+// and passes it on to runDiscoverPrefix. Other namespaces are rejected.
+func (h *Handler) handleDiscovered(w moqtransport.ResponseWriter, ns []string) {
+	if !internal.NamespaceHasPrefix(ns, h.DiscoverPrefix) {
+		slog.Warn("got announcement not matching prefix", "namespace", ns, "prefix", h.DiscoverPrefix)
+		if err := w.Reject(0, "non-matching namespace"); err != nil {
+			slog.Error("failed to reject announcement", "error", err)
+		}
+		return
+	}
+	slog.Info("discovered namespace", "namespace", ns, "prefix", h.DiscoverPrefix)
+	if err := w.Accept(); err != nil {
+		slog.Error("failed to accept announcement", "error", err)
+	}
+	select {
+	case h.discovered <- ns:
+	default:
+	}
+}
+
 func (h *Handler) getSubscribeHandler() moqtransport.SubscribeHandler {
 	return moqtransport.SubscribeHandlerFunc(
 		func(w *moqtransport.SubscribeResponseWriter, m *moqtransport.SubscribeMessage) {
@@ -141,17 +276,34 @@ func (h *Handler) startSession(conn moqtransport.Connection) (*moqtransport.Sess
 	return session, nil
 }
 
-func (h *Handler) handle(ctx context.Context, conn moqtransport.Connection) {
-	session, err := h.startSession(conn)
-	if err != nil {
-		slog.Error("MoQ Session initialization failed", "error", err)
-		err = conn.CloseWithError(0, "session initialization error")
+func (h *Handler) handle(ctx context.Context, conn moqtransport.Connection, session *moqtransport.Session) {
+	if err := h.retrieveCatalog(ctx, session); err != nil {
+		slog.Error("failed to retrieve catalog", "error", err)
+		err = conn.CloseWithError(0, "internal error")
 		if err != nil {
 			slog.Error("failed to close connection", "error", err)
 		}
 		return
 	}
+	if err := h.subscribeTracks(ctx, session); err != nil {
+		slog.Error("failed to subscribe to tracks", "error", err)
+		reason := "internal error"
+		if errors.Is(err, errNoMatchingTracks) {
+			reason = err.Error()
+		}
+		if err := conn.CloseWithError(0, reason); err != nil {
+			slog.Error("failed to close connection", "error", err)
+		}
+		return
+	}
+	<-ctx.Done()
+}
 
+var errNoMatchingTracks = errors.New("no matching tracks found")
+
+// retrieveCatalog retrieves the catalog of Namespace as set by CatalogMode,
+// and keeps reading catalog updates in the background, if the mode allows.
+func (h *Handler) retrieveCatalog(ctx context.Context, session *moqtransport.Session) error {
 	mode := h.CatalogMode
 	if h.UseFetch {
 		mode = "fetch"
@@ -159,6 +311,7 @@ func (h *Handler) handle(ctx context.Context, conn moqtransport.Connection) {
 	if mode == "" {
 		mode = "joining"
 	}
+	var err error
 	switch mode {
 	case "joining":
 		err = h.joiningCatalog(ctx, session, h.Namespace)
@@ -170,232 +323,294 @@ func (h *Handler) handle(ctx context.Context, conn moqtransport.Connection) {
 		err = fmt.Errorf("unknown catalog mode %q (want joining, subscribe, or fetch)", mode)
 	}
 	if err != nil {
-		slog.Error("failed to retrieve catalog", "error", err, "mode", mode)
-		err = conn.CloseWithError(0, "internal error")
-		if err != nil {
-			slog.Error("failed to close connection", "error", err)
-		}
-		return
+		return fmt.Errorf("%s catalog of %v: %w", mode, h.Namespace, err)
 	}
-	videoTrack := ""
-	audioTrack := ""
-	subsTrack := ""
+	return nil
+}
+
+// subscribeTracks selects the first matching catalog track per media type,
+// sets up its output and subscribes to it. Afterwards, catalog updates are
+// handled by reconcileTracks.
+func (h *Handler) subscribeTracks(ctx context.Context, session *moqtransport.Session) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	isLOC := false
+	h.selected = make(map[string]string)
+	h.closers = make(map[string]func() error)
 	for i := range h.catalog.Tracks {
 		track := &h.catalog.Tracks[i]
 		if track.Packaging == "loc" {
 			isLOC = true
 		}
-
-		// Resolve the init data the track references in the catalog
-		// InitDataList. Empty for LOC tracks (in-band config).
-		initData, _ := h.catalog.InitDataFor(track)
-
-		var protectedMoov *mp4.MoovBox
-		if track.Packaging == "locmaf" {
-			// "locmaf" is LOCMAF v0.2, which ships uncompressed CMAF init
-			// in the catalog — no translation needed downstream, but we
-			// still need to extract the moov for CENC tracks so the
-			// decrypt pipeline can pick up the tenc defaults / KID.
-			if track.LocmafVersion != "" && track.LocmafVersion != locmaf.Version {
-				slog.Error("unsupported locmaf version", "version", track.LocmafVersion,
-					"supported", []string{locmaf.Version})
-				return
+		initData, err := h.trackInit(track)
+		if err != nil {
+			return fmt.Errorf("track %s: %w", track.Name, err)
+		}
+		for _, mediaType := range mediaTypes {
+			if h.selected[mediaType] != "" || !h.wantTrack(track, mediaType) {
+				continue
 			}
-			if len(track.ContentProtectionRefIDs) > 0 {
-				init, perr := parseCMAFInit(initData)
-				if perr != nil {
-					slog.Error("failed to parse v0.2 locmaf init", "error", perr)
-					return
-				}
-				protectedMoov = init.Moov
+			h.selected[mediaType] = track.Name
+			if filter := h.nameFilter(mediaType); filter != "" {
+				slog.Info("selected "+track.Role+" track based on substring match",
+					"trackName", track.Name, "substring", filter)
+			}
+			if mediaType == "video" && h.ABR != nil {
+				initData = h.setupABR(ctx, track, initData)
 			}
+			h.setupOutput(track, mediaType, initData)
+		}
+	}
+	if isLOC {
+		slog.Info("catalog uses LOC packaging")
+	}
+	for _, mediaType := range mediaTypes {
+		trackName := h.selected[mediaType]
+		if trackName == "" {
+			continue
+		}
+		var ar *abrReader
+		if mediaType == "video" && h.abr != nil {
+			ar = newABRReader(h.abr, h.abr.current, nil, nil)
+		}
+		closeFn, err := h.subscribeAndRead(ctx, session, h.Namespace, trackName, mediaType, ar)
+		if err != nil {
+			return fmt.Errorf("subscribe to %s track %s: %w", mediaType, trackName, err)
 		}
+		h.closers[mediaType] = closeFn
+	}
+	if len(h.selected) == 0 {
+		return errNoMatchingTracks
+	}
+	// From now on, catalog updates may change the selected tracks.
+	h.session = session
+	return nil
+}
+
+// mediaTypes are the output media types in subscription order.
+var mediaTypes = []string{"video", "audio", "subs"}
+
+// nameFilter returns the track-name substring filter for mediaType.
+func (h *Handler) nameFilter(mediaType string) string {
+	switch mediaType {
+	case "video":
+		return h.VideoName
+	case "audio":
+		return h.AudioName
+	case "subs":
+		return h.SubsName
+	}
+	return ""
+}
 
-		// If track is encrypted, the init data needs to be adjusted
+// wantTrack reports whether track can be selected for mediaType: the catalog
+// role matches and the name contains the media type's filter, if any.
+// Subtitles are only selected when filtered by name or written to an output.
+func (h *Handler) wantTrack(track *internal.Track, mediaType string) bool {
+	role := mediaType
+	if mediaType == "subs" {
+		role = "subtitle"
+	}
+	if track.Role != role {
+		return false
+	}
+	filter := h.nameFilter(mediaType)
+	if filter == "" {
+		return mediaType != "subs" || h.Outs["subs"] != nil
+	}
+	return strings.Contains(track.Name, filter)
+}
+
+// trackInit returns the init data the track references in the catalog
+// InitDataList (empty for LOC tracks, which carry config in-band). For
+// protected tracks, the protection state is recorded and the returned init
+// data is decrypted. An error means that the track cannot be received.
+func (h *Handler) trackInit(track *internal.Track) (string, error) {
+	initData, _ := h.catalog.InitDataFor(track)
+
+	var protectedMoov *mp4.MoovBox
+	if track.Packaging == "locmaf" {
+		// LOCMAF ships uncompressed CMAF init in the catalog — no
+		// translation needed downstream, but we still need to extract the
+		// moov for CENC tracks so the decrypt pipeline can pick up the tenc
+		// defaults / KID.
+		if track.LocmafVersion != "" && track.LocmafVersion != locmaf.Version {
+			return "", fmt.Errorf("unsupported locmaf version %s, supported %v",
+				track.LocmafVersion, []string{locmaf.Version})
+		}
 		if len(track.ContentProtectionRefIDs) > 0 {
-			if h.cenc == nil {
-				h.cenc = &CENC{
-					DecryptInfo: make(map[string]mp4.DecryptInfo),
-				}
-			}
-			if protectedMoov != nil {
-				if h.cenc.ProtectedMoov == nil {
-					h.cenc.ProtectedMoov = make(map[string]*mp4.MoovBox)
-				}
-				h.cenc.ProtectedMoov[track.Name] = protectedMoov
+			init, err := parseCMAFInit(initData)
+			if err != nil {
+				return "", fmt.Errorf("failed to parse locmaf init: %w", err)
 			}
-			decrypted, derr := h.decryptInit(track, initData)
-			if derr != nil {
-				slog.Error("failed to decrypt init data", "error", derr)
-			} else {
-				initData = decrypted
+			protectedMoov = init.Moov
+		}
+	}
+
+	// If track is encrypted, the init data needs to be adjusted
+	if len(track.ContentProtectionRefIDs) > 0 {
+		if h.cenc == nil {
+			h.cenc = &CENC{
+				DecryptInfo: make(map[string]mp4.DecryptInfo),
 			}
 		}
-		// Select video track
-		if track.Role == "video" {
-			if h.VideoName != "" {
-				if videoTrack == "" && strings.Contains(track.Name, h.VideoName) {
-					videoTrack = track.Name
-					slog.Info("selected video track based on substring match", "trackName", track.Name, "substring", h.VideoName)
-				}
-			} else if videoTrack == "" {
-				videoTrack = track.Name
+		if protectedMoov != nil {
+			if h.cenc.ProtectedMoov == nil {
+				h.cenc.ProtectedMoov = make(map[string]*mp4.MoovBox)
 			}
+			h.cenc.ProtectedMoov[track.Name] = protectedMoov
+		}
+		decrypted, err := h.decryptInit(track, initData)
+		if err != nil {
+			slog.Error("failed to decrypt init data", "error", err)
+		} else {
+			initData = decrypted
+		}
+	}
+	return initData, nil
+}
 
-			if videoTrack == track.Name {
-				if track.Packaging == "loc" {
-					// LOC: set up AnnexB video writer
-					if h.Outs["video"] != nil {
-						h.initLOCWriter("video", &LOCVideoWriter{W: h.Outs["video"]})
-					}
-					if h.mux != nil {
-						slog.Warn("LOC-to-fMP4 mux not supported, use -videoout/-audioout for LOC")
-					}
-				} else {
-					// CMAF: write init segment and set up mux
-					if h.Outs["video"] != nil {
-						err = unpackWrite(initData, h.Outs["video"])
-						if err != nil {
-							slog.Error("failed to write init data", "error", err)
-						}
-					}
-					if h.mux != nil {
-						err = h.mux.AddInit(initData, "video")
-						if err != nil {
-							slog.Error("failed to add init data", "error", err)
-						}
-					}
-				}
+// setupOutput prepares the outputs of mediaType for receiving track: LOC
+// writers for LOC packaging, otherwise the CMAF init segment is written to
+// the output and added to the mux.
+func (h *Handler) setupOutput(track *internal.Track, mediaType, initData string) {
+	if mediaType == "subs" {
+		if h.Outs["subs"] != nil {
+			if err := unpackWrite(initData, h.Outs["subs"]); err != nil {
+				slog.Error("failed to write subtitle init data", "error", err)
 			}
 		}
-
-		// Select audio track
-		if track.Role == "audio" {
-			if h.AudioName != "" {
-				if audioTrack == "" && strings.Contains(track.Name, h.AudioName) {
-					audioTrack = track.Name
-					slog.Info("selected audio track based on substring match", "trackName", track.Name, "substring", h.AudioName)
+		return
+	}
+	if track.Packaging == "loc" {
+		if h.Outs[mediaType] != nil {
+			switch {
+			case mediaType == "video":
+				// LOC: set up AnnexB video writer
+				h.initLOCWriter("video", &LOCVideoWriter{W: h.Outs["video"]})
+			case strings.HasPrefix(track.Codec, "mp4a"):
+				sr := 0
+				if track.SampleRate != nil {
+					sr = *track.SampleRate
 				}
-			} else if audioTrack == "" {
-				audioTrack = track.Name
-			}
-
-			if audioTrack == track.Name {
-				if track.Packaging == "loc" {
-					// LOC: set up audio writer based on codec
-					if h.Outs["audio"] != nil {
-						if strings.HasPrefix(track.Codec, "mp4a") {
-							sr := 0
-							if track.SampleRate != nil {
-								sr = *track.SampleRate
-							}
-							aacW, aacErr := NewLOCAACWriter(h.Outs["audio"], track.Codec, sr, track.ChannelConfig)
-							if aacErr != nil {
-								slog.Error("failed to create LOC AAC writer", "error", aacErr)
-							} else {
-								h.initLOCWriter("audio", aacW)
-							}
-						} else {
-							// Opus or other: raw output
-							h.initLOCWriter("audio", &LOCOpusWriter{W: h.Outs["audio"]})
-						}
-					}
-					if h.mux != nil {
-						slog.Warn("LOC-to-fMP4 mux not supported, use -videoout/-audioout for LOC")
-					}
+				aacW, err := NewLOCAACWriter(h.Outs["audio"], track.Codec, sr, track.ChannelConfig)
+				if err != nil {
+					slog.Error("failed to create LOC AAC writer", "error", err)
 				} else {
-					// CMAF: write init segment and set up mux
-					if h.Outs["audio"] != nil {
-						err = unpackWrite(initData, h.Outs["audio"])
-						if err != nil {
-							slog.Error("failed to write init data", "error", err)
-						}
-					}
-					if h.mux != nil {
-						err = h.mux.AddInit(initData, "audio")
-						if err != nil {
-							slog.Error("failed to add init data", "error", err)
-						}
-					}
+					h.initLOCWriter("audio", aacW)
 				}
+			default:
+				// Opus or other: raw output
+				h.initLOCWriter("audio", &LOCOpusWriter{W: h.Outs["audio"]})
 			}
 		}
+		if h.mux != nil {
+			slog.Warn("LOC-to-fMP4 mux not supported, use -videoout/-audioout for LOC")
+		}
+		return
+	}
+	// CMAF: write init segment and set up mux
+	if h.Outs[mediaType] != nil {
+		if err := unpackWrite(initData, h.Outs[mediaType]); err != nil {
+			slog.Error("failed to write init data", "error", err)
+		}
+	}
+	if h.mux != nil && !h.mux.HasInit(mediaType) {
+		if err := h.mux.AddInit(initData, mediaType); err != nil {
+			slog.Error("failed to add init data", "error", err)
+		}
+	}
+}
 
-		// Select subtitle track (wvtt or stpp codec)
-		if track.Role == "subtitle" {
-			if h.SubsName != "" {
-				if subsTrack == "" && strings.Contains(track.Name, h.SubsName) {
-					subsTrack = track.Name
-					slog.Info("selected subtitle track based on substring match", "trackName", track.Name, "substring", h.SubsName)
-				}
-			} else if subsTrack == "" && h.Outs["subs"] != nil {
-				subsTrack = track.Name
+// reconcileTracks updates the subscriptions after a catalog update. A
+// selected track that is no longer in the catalog is unsubscribed and
+// replaced by the first matching track, if any, and a media type without a
+// track subscribes to a newly added matching track.
+func (h *Handler) reconcileTracks(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.session == nil {
+		return // initial track selection not done yet
+	}
+	if h.patterns != nil {
+		h.reconcilePatterns(ctx)
+		return
+	}
+	for _, mediaType := range mediaTypes {
+		if cur := h.selected[mediaType]; cur != "" {
+			if h.catalog.GetTrackByName(cur) != nil {
+				continue
 			}
-
-			if subsTrack == track.Name && h.Outs["subs"] != nil {
-				err = unpackWrite(initData, h.Outs["subs"])
-				if err != nil {
-					slog.Error("failed to write subtitle init data", "error", err)
+			slog.Info("selected track removed from catalog", "mediaType", mediaType, "track", cur)
+			if closeFn := h.closers[mediaType]; closeFn != nil {
+				if err := closeFn(); err != nil {
+					slog.Error("failed to close subscription", "track", cur, "error", err)
 				}
 			}
-		}
-	}
-	if isLOC {
-		slog.Info("catalog uses LOC packaging")
-	}
-	if videoTrack != "" {
-		_, err := h.subscribeAndRead(ctx, session, h.Namespace, videoTrack, "video")
-		if err != nil {
-			slog.Error("failed to subscribe to video track", "error", err)
-			err = conn.CloseWithError(0, "internal error")
-			if err != nil {
-				slog.Error("failed to close connection", "error", err)
+			delete(h.selected, mediaType)
+			delete(h.closers, mediaType)
+			if mediaType == "video" && h.abr != nil {
+				slog.Info("ABR stopped after catalog update")
+				h.abr = nil
 			}
-			return
 		}
-	}
-	if audioTrack != "" {
-		_, err := h.subscribeAndRead(ctx, session, h.Namespace, audioTrack, "audio")
-		if err != nil {
-			slog.Error("failed to subscribe to audio track", "error", err)
-			err = conn.CloseWithError(0, "internal error")
-			if err != nil {
-				slog.Error("failed to close connection", "error", err)
+		var track *internal.Track
+		for i := range h.catalog.Tracks {
+			if h.wantTrack(&h.catalog.Tracks[i], mediaType) {
+				track = &h.catalog.Tracks[i]
+				break
 			}
-			return
 		}
-	}
-	if subsTrack != "" {
-		_, err := h.subscribeAndRead(ctx, session, h.Namespace, subsTrack, "subs")
+		if track == nil {
+			continue
+		}
+		initData, err := h.trackInit(track)
 		if err != nil {
-			slog.Error("failed to subscribe to subtitle track", "error", err)
-			err = conn.CloseWithError(0, "internal error")
-			if err != nil {
-				slog.Error("failed to close connection", "error", err)
-			}
-			return
+			slog.Error("failed to prepare track", "track", track.Name, "error", err)
+			continue
 		}
-	}
-	if audioTrack == "" && videoTrack == "" && subsTrack == "" {
-		slog.Error("no matching tracks found")
-		err = conn.CloseWithError(0, "no matching tracks found")
+		h.setupOutput(track, mediaType, initData)
+		closeFn, err := h.subscribeAndRead(ctx, h.session, h.Namespace, track.Name, mediaType, nil)
 		if err != nil {
-			slog.Error("failed to close connection", "error", err)
+			slog.Error("failed to subscribe to track", "track", track.Name, "error", err)
+			continue
 		}
-		return
+		h.selected[mediaType] = track.Name
+		h.closers[mediaType] = closeFn
+		slog.Info("subscribed to track after catalog update", "mediaType", mediaType, "track", track.Name)
 	}
-	<-ctx.Done()
 }
 
-// applyCatalog parses a catalog object payload, stores it as the current
-// catalog, logs it, and writes it to the "catalog" output if configured.
+// applyCatalog parses a catalog object payload and updates the current
+// catalog: a full catalog replaces it, a delta update (draft-ietf-moq-msf-01
+// Section 5.1.6) is applied to it. The resulting full catalog is logged and
+// written to the "catalog" output if configured.
 func (h *Handler) applyCatalog(payload []byte, label string) error {
 	var cat internal.Catalog
 	if err := json.Unmarshal(payload, &cat); err != nil {
 		return err
 	}
-	h.catalog = &cat
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cat.IsDelta() {
+		if h.catalog == nil {
+			return fmt.Errorf("delta update without a full catalog")
+		}
+		for _, op := range cat.DeltaUpdate {
+			names := make([]string, 0, len(op.Tracks))
+			for _, t := range op.Tracks {
+				names = append(names, t.Name)
+			}
+			slog.Info("catalog delta", "op", op.Op, "tracks", names)
+		}
+		next, err := h.catalog.ApplyDelta(&cat)
+		if err != nil {
+			return fmt.Errorf("apply delta update: %w", err)
+		}
+		h.catalog = next
+	} else {
+		h.catalog = &cat
+	}
 	if slog.Default().Enabled(context.Background(), slog.LevelInfo) {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", label, h.catalog.String())
 	}
@@ -446,25 +661,33 @@ func (h *Handler) joiningCatalog(ctx context.Context, s *moqtransport.Session, n
 		rs.Close()
 		return err
 	}
-	// Read the complete catalog from the joining fetch. Object 0 of the group is
-	// the full catalog. mlmpub publishes a single static catalog object (no
-	// deltas), so one read suffices. We deliberately do not drain to EOF:
+	// Read the catalog group from the joining fetch: object 0 is the full
+	// catalog, followed by any delta updates up to the largest location. We
+	// stop at the largest location rather than draining to EOF, since
 	// moqtransport's RemoteTrack.ReadObject has no fetch-complete signal and
-	// would block once the buffer empties. Draining deltas is future work that
-	// needs a fetch-done signal in moqtransport.
-	o, rerr := rt.ReadObject(ctx)
-	if rerr != nil {
-		rt.Close()
-		rs.Close()
-		return fmt.Errorf("joining fetch: reading catalog: %w", rerr)
-	}
-	if aerr := h.applyCatalog(o.Payload, "catalog (joining fetch)"); aerr != nil {
-		rt.Close()
-		rs.Close()
-		return aerr
+	// would block once the buffer empties.
+	for {
+		o, rerr := rt.ReadObject(ctx)
+		if rerr != nil {
+			rt.Close()
+			rs.Close()
+			return fmt.Errorf("joining fetch: reading catalog: %w", rerr)
+		}
+		label := "catalog (joining fetch)"
+		if o.ObjectID > 0 {
+			label = "catalog update (joining fetch)"
+		}
+		if aerr := h.applyCatalog(o.Payload, label); aerr != nil {
+			rt.Close()
+			rs.Close()
+			return aerr
+		}
+		slog.Info("fetched catalog object via joining fetch",
+			"groupID", o.GroupID, "objectID", o.ObjectID, "payloadLength", len(o.Payload))
+		if o.GroupID == largest.Group && o.ObjectID == largest.Object || afterLocation(o, largest) {
+			break
+		}
 	}
-	slog.Info("fetched catalog via joining fetch",
-		"groupID", o.GroupID, "objectID", o.ObjectID, "payloadLength", len(o.Payload))
 	rt.Close()
 
 	// Continue reading catalog updates on the subscription, skipping anything
@@ -490,6 +713,7 @@ func (h *Handler) joiningCatalog(ctx context.Context, s *moqtransport.Session, n
 			}
 			slog.Info("received catalog update",
 				"groupID", o.GroupID, "objectID", o.ObjectID, "payloadLength", len(o.Payload))
+			h.reconcileTracks(ctx)
 		}
 	}()
 	return nil
@@ -556,36 +780,16 @@ func (h *Handler) subscribeToCatalog(ctx context.Context, s *moqtransport.Sessio
 				}
 				return
 			}
-			var cat internal.Catalog
-			err = json.Unmarshal(o.Payload, &cat)
-			if err != nil {
-				slog.Error("failed to unmarshal catalog update", "error", err)
+			if err := h.applyCatalog(o.Payload, "catalog update"); err != nil {
+				slog.Error("failed to apply catalog update", "error", err)
 				continue
 			}
-			h.catalog = &cat
 			slog.Info("received catalog update",
 				"groupID", o.GroupID,
 				"subGroupID", o.SubGroupID,
 				"payloadLength", len(o.Payload),
 			)
-			if slog.Default().Enabled(context.Background(), slog.LevelInfo) {
-				fmt.Fprintf(os.Stderr, "catalog update: %s\n", h.catalog.String())
-			}
-			if h.Outs["catalog"] != nil {
-				indented, err := json.MarshalIndent(&cat, "", "  ")
-				if err != nil {
-					slog.Error("failed to marshal catalog update", "error", err)
-				} else {
-					_, err = h.Outs["catalog"].Write(indented)
-					if err != nil {
-						slog.Error("failed to write catalog update", "error", err)
-					}
-					_, err = h.Outs["catalog"].Write([]byte("\n"))
-					if err != nil {
-						slog.Error("failed to write catalog update newline", "error", err)
-					}
-				}
-			}
+			h.reconcileTracks(ctx)
 		}
 	}()
 
@@ -637,9 +841,14 @@ func (h *Handler) fetchCatalog(ctx context.Context, s *moqtransport.Session, nam
 	return nil
 }
 
+// subscribeAndRead subscribes to a track and writes its objects to the
+// outputs of mediaType until the returned close function is called. If ar
+// is set, the objects are handled by ABR.
 func (h *Handler) subscribeAndRead(ctx context.Context, s *moqtransport.Session, namespace []string,
-	trackname, mediaType string) (close func() error, err error) {
-	rs, err := s.Subscribe(ctx, namespace, trackname)
+	trackname, mediaType string, ar *abrReader) (close func() error, err error) {
+	// Start at a group boundary so that the output starts with a sync sample.
+	rs, err := s.Subscribe(ctx, namespace, trackname,
+		moqtransport.WithFilterType(moqtransport.FilterTypeNextGroupStart))
 	if err != nil {
 		return nil, err
 	}
@@ -647,6 +856,9 @@ func (h *Handler) subscribeAndRead(ctx context.Context, s *moqtransport.Session,
 	if track == nil {
 		return nil, fmt.Errorf("track %s not found", trackname)
 	}
+	if ar != nil {
+		ar.subscribed(rs.LargestLocation())
+	}
 	var moov *mp4.MoovBox
 	if track.Packaging == "locmaf" {
 		if h.cenc != nil && h.cenc.ProtectedMoov != nil && h.cenc.ProtectedMoov[trackname] != nil {
@@ -660,17 +872,33 @@ func (h *Handler) subscribeAndRead(ctx context.Context, s *moqtransport.Session,
 			moov = init.Moov
 		}
 	}
+	var decrypt func([]byte) ([]byte, error)
+	if h.cenc != nil {
+		decrypt = h.payloadDecrypter(trackname)
+	}
+	lw, isLOC := h.locWriters[mediaType]
+	// The read loop has its own context so that no more objects are written
+	// to the outputs once the subscription is closed.
+	readCtx, cancel := context.WithCancel(ctx)
+	var ended atomic.Bool // ended by PUBLISH_DONE
 	go func() {
+		if ar != nil {
+			defer ar.finish()
+		}
 		locmafState := locmaf.NewState()
 		for {
-			o, err := rs.ReadObject(ctx)
-			if err != nil {
+			// Objects received before a PUBLISH_DONE are returned with an
+			// error, and the status of the PUBLISH_DONE after them.
+			o, err := rs.ReadObject(readCtx)
+			if err != nil && o == nil {
 				if err == io.EOF {
 					slog.Info("got last object")
 					return
 				}
+				ended.Store(publishDone(trackname, err))
 				return
 			}
+			arrival := time.Now()
 			locTsUs, hasLOCTs := locTimestampMicros(o.ExtensionHeaders)
 			if o.ObjectID == 0 {
 				locmafState = locmaf.NewState()
@@ -713,16 +941,29 @@ func (h *Handler) subscribeAndRead(ctx context.Context, s *moqtransport.Session,
 				}
 			}
 
-			if h.cenc != nil {
-				o.Payload, err = h.decryptPayload(o.Payload, trackname)
+			if decrypt != nil {
+				o.Payload, err = decrypt(o.Payload)
 				if err != nil {
 					slog.Error("failed to decrypt payload", "error", err)
 					return
 				}
 			}
 
+			if h.Latency != nil {
+				h.recordLatency(track, mediaType, o, locTsUs, hasLOCTs, arrival)
+			}
+
+			if ar != nil {
+				switch h.abrObject(readCtx, ar, o.GroupID, &o.Payload, arrival) {
+				case abrSkip:
+					continue
+				case abrStop:
+					return
+				}
+			}
+
 			// Route through LOC writers if available, otherwise CMAF path
-			if lw, ok := h.locWriters[mediaType]; ok {
+			if isLOC {
 				err = lw.Write(o.Payload)
 				if err != nil {
 					slog.Error("failed to write LOC sample", "error", err)
@@ -748,11 +989,47 @@ func (h *Handler) subscribeAndRead(ctx context.Context, s *moqtransport.Session,
 	}()
 	cleanup := func() error {
 		slog.Info("cleanup: closing subscription to track", "namespace", namespace, "trackname", trackname)
+		cancel()
+		if ended.Load() {
+			return nil
+		}
 		return rs.Close()
 	}
 	return cleanup, nil
 }
 
+// publishDone reports whether err from reading a track is the end of the
+// subscription by PUBLISH_DONE, and logs its status. Such a subscription
+// must not be unsubscribed, since the publisher no longer knows it.
+func publishDone(trackName string, err error) bool {
+	var done *moqtransport.ErrSubscribeDone
+	if !errors.As(err, &done) {
+		return false
+	}
+	slog.Info("subscription ended by publisher", "track", trackName, "status", done.Status,
+		"reason", done.Reason)
+	return true
+}
+
+// recordLatency records the latency of an object of track, using the LOC
+// timestamp if present and otherwise the tfdt of a CMAF payload.
+func (h *Handler) recordLatency(track *internal.Track, mediaType string, o *moqtransport.Object,
+	locTsUs uint64, hasLOCTs bool, arrival time.Time) {
+	var mediaTime time.Time
+	switch {
+	case hasLOCTs:
+		mediaTime = time.UnixMicro(int64(locTsUs))
+	case track.Timescale != nil:
+		var ok bool
+		if mediaTime, ok = cmafMediaTime(o.Payload, *track.Timescale); !ok {
+			return
+		}
+	default:
+		return
+	}
+	h.Latency.Record(track.Name, mediaType, o.GroupID, len(o.Payload), mediaTime, arrival)
+}
+
 func (h *Handler) initLOCWriter(mediaType string, w interface{ Write([]byte) error }) {
 	if h.locWriters == nil {
 		h.locWriters = make(map[string]interface{ Write([]byte) error })
@@ -11,6 +11,7 @@ import (
 
 	"github.com/Eyevinn/locmaf"
 	"github.com/Eyevinn/moqlivemock/internal"
+	"github.com/Eyevinn/moqlivemock/internal/pub"
 	"github.com/Eyevinn/mp4ff/bits"
 	"github.com/Eyevinn/mp4ff/mp4"
 	"github.com/stretchr/testify/require"
@@ -82,6 +83,189 @@ func TestDecryptInitUpdatesCatalogTrack(t *testing.T) {
 	require.Equal(t, "avc1", f.Init.Moov.Trak.Mdia.Minf.Stbl.Stsd.Children[0].Type())
 }
 
+// TestPayloadDecrypterKeyRotation checks that fragments signaling rotated
+// keys are decrypted, and that the key of a KID not listed in the catalog is
+// requested when it is first needed.
+func TestPayloadDecrypterKeyRotation(t *testing.T) {
+	const (
+		kidStr = "39112233445566778899aabbccddeeff"
+		ivStr  = "41112233445566778899aabbccddeeff"
+	)
+
+	var requestedKids [][]string
+	licenseServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req clearKeyRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requestedKids = append(requestedKids, req.Kids)
+		var resp clearKeyResponse
+		for _, kid := range req.Kids {
+			resp.Keys = append(resp.Keys, keyInfo{Kty: "oct", K: kid, Kid: kid})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+	defer licenseServer.Close()
+
+	eccp, err := internal.ParseCENCflags("cenc", kidStr, "", ivStr, licenseServer.URL)
+	require.NoError(t, err)
+	require.NoError(t, eccp.GenerateRotationKeys(3))
+	require.NoError(t, eccp.SetKeyRotation(1))
+	asset, err := internal.LoadAssetWithProtection(filepath.Join("..", "..", "assets", "test10s"), 1, 1, nil, eccp)
+	require.NoError(t, err)
+	catalog, err := asset.GenCMAFCatalogEntry("cmsf/eccp-cenc", internal.ProtectionECCP, 0)
+	require.NoError(t, err)
+	require.Len(t, catalog.ContentProtections[0].DefaultKIDs, 2)
+
+	const trackName = "video_400kbps_avc_eccp"
+	encryptedTrack := catalog.GetTrackByName(trackName)
+	require.NotNil(t, encryptedTrack)
+	h := &Handler{
+		catalog: catalog,
+		cenc:    &CENC{DecryptInfo: make(map[string]mp4.DecryptInfo)},
+	}
+	encryptedInit, ok := catalog.InitDataFor(encryptedTrack)
+	require.True(t, ok)
+	_, err = h.decryptInit(encryptedTrack, encryptedInit)
+	require.NoError(t, err)
+	require.Len(t, requestedKids, 1)
+	require.Len(t, requestedKids[0], 2)
+
+	decrypt := h.payloadDecrypter(trackName)
+	clear := asset.GetTrackByName("video_400kbps_avc")
+	protected := asset.GetTrackByName(trackName)
+	for groupNr := uint64(0); groupNr < 3; groupNr++ {
+		nr := groupNr * internal.MoqGroupDurMS * uint64(protected.TimeScale) / 1000 / uint64(protected.SampleDur)
+		chunk, err := protected.GenCMAFChunk(0, nr, nr+1)
+		require.NoError(t, err)
+		want, err := clear.GenCMAFChunk(0, nr, nr+1)
+		require.NoError(t, err)
+		got, err := decrypt(chunk)
+		require.NoError(t, err)
+		_, wantMdat := decodeFragment(t, want)
+		_, gotMdat := decodeFragment(t, got)
+		require.Equal(t, wantMdat.Data, gotMdat.Data, "group %d", groupNr)
+	}
+	require.Len(t, requestedKids, 2, "the third key is requested once")
+	require.Len(t, requestedKids[1], 1)
+}
+
+// TestPayloadDecrypterKeyTiers checks that audio and video tracks encrypted
+// with different keys each decrypt with the default key of their own init
+// segment, with a license server returning only the requested keys.
+func TestPayloadDecrypterKeyTiers(t *testing.T) {
+	const (
+		kidStr = "39112233445566778899aabbccddeeff"
+		keyStr = "40112233445566778899aabbccddeeff"
+		ivStr  = "41112233445566778899aabbccddeeff"
+	)
+
+	var eccp *internal.DRMInfo
+	licenseServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req clearKeyRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		var resp clearKeyResponse
+		for _, kid := range req.Kids {
+			kidBytes, err := base64.RawURLEncoding.DecodeString(kid)
+			require.NoError(t, err)
+			if key, ok := eccp.ClearKey(kidBytes); ok {
+				resp.Keys = append(resp.Keys, keyInfo{Kty: "oct", K: base64.RawURLEncoding.EncodeToString(key), Kid: kid})
+			}
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+	defer licenseServer.Close()
+
+	eccp, err := internal.ParseCENCflags("cbcs", kidStr, keyStr, ivStr, licenseServer.URL)
+	require.NoError(t, err)
+	require.NoError(t, eccp.SetKeyTiers([]string{"audio", "video"}))
+	asset, err := internal.LoadAssetWithProtection(filepath.Join("..", "..", "assets", "test10s"), 1, 1, nil, eccp)
+	require.NoError(t, err)
+	catalog, err := asset.GenCMAFCatalogEntry("cmsf/eccp-cbcs", internal.ProtectionECCP, 0)
+	require.NoError(t, err)
+	require.Len(t, catalog.ContentProtections, 2)
+
+	h := &Handler{
+		catalog: catalog,
+		cenc:    &CENC{DecryptInfo: make(map[string]mp4.DecryptInfo)},
+	}
+	for _, name := range []string{"video_400kbps_avc", "audio_monotonic_128kbps_aac"} {
+		encryptedTrack := catalog.GetTrackByName(name + "_eccp")
+		require.NotNil(t, encryptedTrack)
+		encryptedInit, ok := catalog.InitDataFor(encryptedTrack)
+		require.True(t, ok)
+		_, err = h.decryptInit(encryptedTrack, encryptedInit)
+		require.NoError(t, err)
+	}
+	for _, name := range []string{"video_400kbps_avc", "audio_monotonic_128kbps_aac"} {
+		chunk, err := asset.GetTrackByName(name+"_eccp").GenCMAFChunk(0, 0, 1)
+		require.NoError(t, err)
+		want, err := asset.GetTrackByName(name).GenCMAFChunk(0, 0, 1)
+		require.NoError(t, err)
+		got, err := h.payloadDecrypter(name + "_eccp")(chunk)
+		require.NoError(t, err)
+		_, wantMdat := decodeFragment(t, want)
+		_, gotMdat := decodeFragment(t, got)
+		require.Equal(t, wantMdat.Data, gotMdat.Data, name)
+	}
+}
+
+// TestDecryptInitLicenseToken checks that the subscriber gets a bearer
+// token from the authzURL in the catalog with the shared secret, or uses a
+// configured one, for license requests to a server requiring a token.
+func TestDecryptInitLicenseToken(t *testing.T) {
+	const (
+		kidStr = "39112233445566778899aabbccddeeff"
+		keyStr = "40112233445566778899aabbccddeeff"
+		ivStr  = "41112233445566778899aabbccddeeff"
+	)
+
+	var licenseServer *pub.ClearKeyServer
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clearkey", func(w http.ResponseWriter, r *http.Request) { licenseServer.ServeHTTP(w, r) })
+	mux.HandleFunc("/clearkey/token", func(w http.ResponseWriter, r *http.Request) {
+		licenseServer.TokenHandler().ServeHTTP(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	eccp, err := internal.ParseCENCflags("cbcs", kidStr, keyStr, ivStr, server.URL+"/clearkey")
+	require.NoError(t, err)
+	licenseServer = pub.NewClearKeyServer(eccp, pub.ClearKeyPolicy{Token: "secret", AuthzSecret: "shared"})
+	asset, err := internal.LoadAssetWithProtection(filepath.Join("..", "..", "assets", "test10s"), 1, 1, nil, eccp)
+	require.NoError(t, err)
+	name := "video_400kbps_avc_eccp"
+
+	newHandler := func(token string) *Handler {
+		catalog, err := asset.GenCMAFCatalogEntry("cmsf/eccp-cbcs", internal.ProtectionECCP, 0)
+		require.NoError(t, err)
+		return &Handler{
+			catalog:      catalog,
+			cenc:         &CENC{DecryptInfo: make(map[string]mp4.DecryptInfo)},
+			LicenseToken: token,
+		}
+	}
+	decryptInit := func(h *Handler) error {
+		track := h.catalog.GetTrackByName(name)
+		require.NotNil(t, track)
+		initData, ok := h.catalog.InitDataFor(track)
+		require.True(t, ok)
+		_, err := h.decryptInit(track, initData)
+		return err
+	}
+
+	require.Error(t, decryptInit(newHandler("")), "no authzURL in catalog")
+	require.Error(t, decryptInit(newHandler("wrong")))
+	require.NoError(t, decryptInit(newHandler("secret")))
+
+	eccp.SetAuthzURL(server.URL + "/clearkey/token")
+	h := newHandler("")
+	cp := h.catalog.ContentProtections[0]
+	require.Equal(t, server.URL+"/clearkey/token", cp.DRMSystem.AuthzURL.URL)
+	require.Error(t, decryptInit(h), "the token is not served without the shared secret")
+	h.AuthzSecret = "shared"
+	require.NoError(t, decryptInit(h))
+	require.Equal(t, "secret", h.cenc.token)
+}
+
 // TestDecompressLocmafObjectRoundTrip covers the mlmsub-side wrapper
 // `decompressLocmafObject`. The single-sample track matters: its size
 // is never on the wire and must derive from the mdat-payload length
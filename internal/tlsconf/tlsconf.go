@@ -0,0 +1,78 @@
+// Package tlsconf builds the TLS configurations of the MoQ servers.
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"time"
+)
+
+// nextProtos are the ALPN protocols offered by the servers: MoQ over raw QUIC
+// (draft-16 and draft-14) and HTTP/3 for WebTransport.
+var nextProtos = []string{"moqt-16", "moq-00", "h3"}
+
+// Server returns a server TLS configuration with the certificate and key
+// in certFile and keyFile. If they cannot be loaded, it falls back to a
+// generated self-signed certificate, see Generate.
+func Server(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   nextProtos,
+		}, nil
+	}
+	slog.Warn("failed to generate TLS config from cert file and key, generating in memory certs", "error", err)
+	tlsConfig, err := Generate()
+	if err != nil {
+		return nil, fmt.Errorf("generate in-memory TLS config: %w", err)
+	}
+	return tlsConfig, nil
+}
+
+// Generate returns a server TLS configuration with a self-signed
+// certificate for localhost that meets the WebTransport fingerprint
+// requirements (ECDSA, valid for at most 14 days).
+func Generate() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		Issuer:                pkix.Name{CommonName: "localhost"}, // self-signed
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(14 * 24 * time.Hour), // 14 days max for WebTransport
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:              []string{"localhost", "127.0.0.1"},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(certDER)
+	slog.Info("Generated WebTransport-compatible certificate",
+		"algorithm", "ECDSA",
+		"validity_days", 14,
+		"self_signed", true,
+		"fingerprint", hex.EncodeToString(fingerprint[:]))
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certDER}, PrivateKey: key}},
+		NextProtos:   nextProtos,
+	}, nil
+}
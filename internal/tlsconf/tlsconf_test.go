@@ -0,0 +1,23 @@
+package tlsconf
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	tlsConfig, err := Server(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	require.NoError(t, err, "missing files fall back to a generated certificate")
+	assert.Equal(t, nextProtos, tlsConfig.NextProtos)
+	require.Len(t, tlsConfig.Certificates, 1)
+	cert, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	assert.NoError(t, cert.VerifyHostname("localhost"))
+	assert.LessOrEqual(t, cert.NotAfter.Sub(cert.NotBefore), 14*24*time.Hour)
+}
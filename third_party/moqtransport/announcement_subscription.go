@@ -0,0 +1,11 @@
+package moqtransport
+
+type announcementSubscriptionResponse struct {
+	err error
+}
+
+type announcementSubscription struct {
+	requestID uint64
+	namespace []string
+	response  chan announcementSubscriptionResponse
+}
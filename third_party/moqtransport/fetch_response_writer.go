@@ -0,0 +1,35 @@
+package moqtransport
+
+import "sync/atomic"
+
+// FetchResponseWriter implements ResponseWriter and FetchPublisher for FETCH messages.
+type FetchResponseWriter struct {
+	id         uint64
+	session    *Session
+	localTrack *localTrack
+	handled    atomic.Bool
+}
+
+// Accept implements ResponseWriter.
+func (f *FetchResponseWriter) Accept() error {
+	f.handled.Store(true)
+	return f.session.acceptFetch(f.id)
+}
+
+// Reject implements ResponseWriter.
+func (f *FetchResponseWriter) Reject(code uint64, reason string) error {
+	f.handled.Store(true)
+	return f.session.rejectFetch(f.id, code, reason)
+}
+
+// Defer defers the response to the FETCH, so that Accept or Reject can be
+// called after the FetchHandler has returned, e.g. from a goroutine. The
+// session does not wait for the response.
+func (f *FetchResponseWriter) Defer() {
+	f.handled.Store(true)
+}
+
+// FetchStream returns a FetchStream for writing objects.
+func (f *FetchResponseWriter) FetchStream() (*FetchStream, error) {
+	return f.localTrack.getFetchStream()
+}
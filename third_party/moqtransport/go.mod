@@ -0,0 +1,25 @@
+module github.com/Eyevinn/moqtransport
+
+go 1.25.0
+
+require (
+	github.com/mengelbart/qlog v0.1.0
+	github.com/quic-go/quic-go v0.60.0
+	github.com/quic-go/webtransport-go v0.11.0
+	github.com/stretchr/testify v1.11.1
+	go.uber.org/goleak v1.3.0
+	go.uber.org/mock v0.5.2
+	golang.org/x/sync v0.20.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dunglas/httpsfv v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/crypto v0.51.0 // indirect
+	golang.org/x/net v0.55.0 // indirect
+	golang.org/x/sys v0.45.0 // indirect
+	golang.org/x/text v0.37.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,56 @@
+package wire
+
+import (
+	"log/slog"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+type PublishErrorMessage struct {
+	RequestID    uint64
+	ErrorCode    uint64
+	ReasonPhrase string
+}
+
+func (m *PublishErrorMessage) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("type", "subscribe_error"),
+		slog.Uint64("request_id", m.RequestID),
+		slog.Uint64("error_code", m.ErrorCode),
+		slog.String("reason", m.ReasonPhrase),
+		slog.Any("reason_bytes", []byte(m.ReasonPhrase)),
+	)
+}
+
+func (m PublishErrorMessage) Type() controlMessageType {
+	return messageTypeSubscribeError
+}
+
+func (m *PublishErrorMessage) Append(buf []byte) []byte {
+	buf = quicvarint.Append(buf, m.RequestID)
+	buf = quicvarint.Append(buf, uint64(m.ErrorCode))
+	buf = appendVarIntBytes(buf, []byte(m.ReasonPhrase))
+	return buf
+}
+
+func (m *PublishErrorMessage) parse(_ Version, data []byte) (err error) {
+	var n int
+	m.RequestID, n, err = quicvarint.Parse(data)
+	if err != nil {
+		return err
+	}
+	data = data[n:]
+
+	m.ErrorCode, n, err = quicvarint.Parse(data)
+	if err != nil {
+		return err
+	}
+	data = data[n:]
+
+	reasonPhrase, _, err := parseVarIntBytes(data)
+	if err != nil {
+		return err
+	}
+	m.ReasonPhrase = string(reasonPhrase)
+	return nil
+}
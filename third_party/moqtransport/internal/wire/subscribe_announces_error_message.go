@@ -0,0 +1,54 @@
+package wire
+
+import (
+	"log/slog"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// TODO: Add tests
+type SubscribeAnnouncesErrorMessage struct {
+	RequestID    uint64
+	ErrorCode    uint64
+	ReasonPhrase string
+}
+
+func (m *SubscribeAnnouncesErrorMessage) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("type", "subscribe_announces_error"),
+		slog.Uint64("error_code", m.ErrorCode),
+		slog.String("reason", m.ReasonPhrase),
+	)
+}
+
+func (m SubscribeAnnouncesErrorMessage) Type() controlMessageType {
+	return messageTypeSubscribeAnnouncesError
+}
+
+func (m *SubscribeAnnouncesErrorMessage) Append(buf []byte) []byte {
+	buf = quicvarint.Append(buf, m.RequestID)
+	buf = quicvarint.Append(buf, m.ErrorCode)
+	return appendVarIntBytes(buf, []byte(m.ReasonPhrase))
+}
+
+func (m *SubscribeAnnouncesErrorMessage) parse(_ Version, data []byte) (err error) {
+	var n int
+	m.RequestID, n, err = quicvarint.Parse(data)
+	if err != nil {
+		return err
+	}
+	data = data[n:]
+
+	m.ErrorCode, n, err = quicvarint.Parse(data)
+	if err != nil {
+		return err
+	}
+	data = data[n:]
+
+	reasonPhrase, _, err := parseVarIntBytes(data)
+	if err != nil {
+		return err
+	}
+	m.ReasonPhrase = string(reasonPhrase)
+	return nil
+}
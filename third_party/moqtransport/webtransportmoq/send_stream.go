@@ -0,0 +1,33 @@
+package webtransportmoq
+
+import (
+	"github.com/Eyevinn/moqtransport"
+	"github.com/quic-go/webtransport-go"
+)
+
+var _ moqtransport.SendStream = (*SendStream)(nil)
+
+type SendStream struct {
+	stream *webtransport.SendStream
+}
+
+// Write implements moqtransport.SendStream.
+func (s *SendStream) Write(p []byte) (n int, err error) {
+	return s.stream.Write(p)
+}
+
+// Reset implements moqtransport.SendStream
+func (s *SendStream) Reset(code uint32) {
+	s.stream.CancelWrite(webtransport.StreamErrorCode(code))
+}
+
+// Close implements moqtransport.SendStream.
+func (s *SendStream) Close() error {
+	return s.stream.Close()
+}
+
+// StreamID implements moqtransport.SendStream.
+// webtransport-go v0.10+ no longer exposes the stream ID.
+func (s *SendStream) StreamID() uint64 {
+	return 0
+}